@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The latest publication time that --as-of lets a release have, or the zero
+// time to allow any release
+var asOf time.Time
+
+// Parses an --as-of date, like 2023-01-01, or a time, like
+// 2023-01-01T12:00:00Z. Dates are taken to be in UTC and include the whole day
+func parseAsOf(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid --as-of: %s, expected a date like 2023-01-01", value)
+}
+
+// Drops the releases published after asOf. A release that its source has no
+// publication time for can't be shown to have existed by then, so it is
+// dropped too
+func publishedAsOf(releases []release) []release {
+	if asOf.IsZero() {
+		return releases
+	}
+	kept := []release{}
+	for _, rel := range releases {
+		if !rel.lastModified.IsZero() && !rel.lastModified.After(asOf) {
+			kept = append(kept, rel)
+		}
+	}
+	return kept
+}
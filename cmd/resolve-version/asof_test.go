@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAsOf(t *testing.T) {
+	cutoff, err := parseAsOf("2023-01-01")
+	if assert.Nil(t, err) {
+		assert.Equal(t, cutoff, time.Date(2023, 1, 1, 23, 59, 59, 999999999, time.UTC))
+	}
+
+	// times with an offset are compared in UTC
+	cutoff, err = parseAsOf("2023-01-01T09:00:00+09:00")
+	if assert.Nil(t, err) {
+		assert.Equal(t, cutoff, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+
+	_, err = parseAsOf("01/01/2023")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Invalid --as-of: 01/01/2023, expected a date like 2023-01-01")
+	}
+}
+
+func TestResolveAsOf(t *testing.T) {
+	defer func(original time.Time) { asOf = original }(asOf)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	published := map[string]time.Time{
+		"16.18.1": time.Date(2022, 11, 4, 17, 0, 0, 0, time.UTC),
+		"16.19.0": time.Date(2022, 12, 13, 20, 0, 0, 0, time.UTC),
+		"18.12.1": time.Date(2022, 11, 4, 18, 0, 0, 0, time.UTC),
+		"18.13.0": time.Date(2023, 1, 5, 23, 0, 0, 0, time.UTC),
+		// the last minute of 2022-12-31 in UTC, but 2023-01-01 in Tokyo
+		"16.19.1": time.Date(2022, 12, 31, 23, 59, 0, 0, time.UTC),
+	}
+	objects := []s3Object{}
+	for version, lastModified := range published {
+		obj := genNodeS3ObjectList([]string{version}, []string{}, "linux-x64")[0]
+		obj.LastModified = lastModified
+		objects = append(objects, obj)
+	}
+	sources := []source{staticSource{releases: parseObjects(objects)}}
+
+	cases := []struct {
+		asOf        string
+		requirement string
+		version     string
+	}{
+		{"", "16.x", "16.19.1"},
+		{"", "*", "18.13.0"},
+		{"2022-12-01", "16.x", "16.18.1"},
+		{"2022-12-01", "*", "18.12.1"},
+		{"2022-12-31", "16.x", "16.19.1"},
+		{"2022-12-30", "16.x", "16.19.0"},
+		{"2023-01-05", "*", "18.13.0"},
+		{"2022-11-04T17:30:00Z", "*", "16.18.1"},
+		{"2022-11-01", "*", ""},
+	}
+	for _, c := range cases {
+		asOf = time.Time{}
+		if c.asOf != "" {
+			var err error
+			asOf, err = parseAsOf(c.asOf)
+			if !assert.Nil(t, err) {
+				continue
+			}
+		}
+		result, err := resolveFromSources(sources, "node", c.requirement)
+		if assert.Nil(t, err) && assert.Equal(t, result.matched, c.version != "", c.asOf+" "+c.requirement) && result.matched {
+			assert.Equal(t, result.release.version.String(), c.version, c.asOf+" "+c.requirement)
+		}
+	}
+
+	// releases without a publication time are never known to be old enough
+	asOf, _ = parseAsOf("2030-01-01")
+	assert.Len(t, publishedAsOf(genReleasesFromArray([]string{"1.22.0"})), 0)
+}
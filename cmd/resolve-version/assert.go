@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// Parses the version given to --assert, which has to be a whole version since
+// it's compared exactly
+func parseAssertedVersion(value string) (semver.Version, error) {
+	version, err := semver.Make(strings.TrimPrefix(strings.TrimSpace(value), "v"))
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("--assert expects a version, like 18.20.4, got %s", value)
+	}
+	return version, nil
+}
+
+// Fails unless the release is exactly the version --assert expects, so that
+// a CI gate notices as soon as a requirement starts resolving to something
+// else, like after a surprise publish
+func assertResolvedVersion(binary string, versionRequirement string, rel release, expected semver.Version) error {
+	if rel.version.Equals(expected) {
+		return nil
+	}
+	direction := "upgrade"
+	if rel.version.LT(expected) {
+		direction = "downgrade"
+	}
+	return fmt.Errorf("Expected %s %s to resolve to %s, but it resolved to %s, a %s %s",
+		binary, versionRequirement, expected.String(), rel.version.String(), versionJump(expected, rel.version), direction)
+}
+
+// Parses the version given to --current. Only its major is compared, so a
+// bare major like 18 is enough
+func parseCurrentVersion(value string) (semver.Version, error) {
+	version, err := semver.ParseTolerant(strings.TrimSpace(value))
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("--current expects a version, like 18.17.0, got %s", value)
+	}
+	return version, nil
+}
+
+// Describes a resolution to a higher major than the --current one, which a
+// loose range like >=18 can do as soon as a new major is published, or
+// returns "" when the release is in the current major or an older one
+func majorUpgradeMessage(binary string, versionRequirement string, rel release, current semver.Version) string {
+	if rel.version.Major <= current.Major {
+		return ""
+	}
+	return fmt.Sprintf("%s %s resolved to %s, a major upgrade from the current %s", binary, versionRequirement, rel.version.String(), current.String())
+}
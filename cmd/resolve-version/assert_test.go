@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveAssert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "node-version")
+	sources := []source{staticSource{releases: genReleasesFromArray([]string{"18.20.4", "18.20.5", "20.11.0"})}}
+
+	// a matching assertion resolves as usual
+	assert.Nil(t, resolveWithSources(sources, "node", "18.20.5", options{outputFile: path, assert: "18.20.5"}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "18.20.5 https://heroku.com\n")
+	assert.Nil(t, resolveWithSources(sources, "node", "18", options{outputFile: path, assert: "v18.20.5"}))
+
+	// and a mismatch fails with the difference, without writing anything
+	assert.Nil(t, os.Remove(path))
+	err = resolveWithSources(sources, "node", "18", options{outputFile: path, assert: "18.20.4"})
+	assert.EqualError(t, err, "Expected node 18 to resolve to 18.20.4, but it resolved to 18.20.5, a patch upgrade")
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	err = resolveWithSources(sources, "node", "18", options{outputFile: path, assert: "20.11.0"})
+	assert.EqualError(t, err, "Expected node 18 to resolve to 20.11.0, but it resolved to 18.20.5, a major downgrade")
+
+	err = resolveWithSources(sources, "node", "18", options{outputFile: path, assert: "18"})
+	assert.EqualError(t, err, "--assert expects a version, like 18.20.4, got 18")
+}
+
+func TestResolveMajorUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "node-version")
+	releases := genReleasesFromArray([]string{"18.17.0", "18.20.4", "20.11.0"})
+	sources := []source{staticSource{releases: releases}}
+	current, err := parseCurrentVersion("18.17.0")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the same major, or a newer minor of it, isn't an upgrade to warn about
+	assert.Equal(t, majorUpgradeMessage("node", "18.17.0", releases[0], current), "")
+	assert.Equal(t, majorUpgradeMessage("node", "^18", releases[1], current), "")
+	assert.Equal(t, majorUpgradeMessage("node", ">=18", releases[2], current), "node >=18 resolved to 20.11.0, a major upgrade from the current 18.17.0")
+	older, _ := parseCurrentVersion("v20")
+	assert.Equal(t, majorUpgradeMessage("node", "18.x", releases[1], older), "")
+
+	// a major upgrade only warns, unless --fail-on-major is set
+	assert.Nil(t, resolveWithSources(sources, "node", ">=18", options{outputFile: path, current: "18.17.0"}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "20.11.0 https://heroku.com\n")
+	assert.Nil(t, resolveWithSources(sources, "node", "^18", options{outputFile: path, current: "18.17.0", failOnMajor: true}))
+
+	err = resolveWithSources(sources, "node", ">=18", options{outputFile: path, current: "18.17.0", failOnMajor: true})
+	assert.EqualError(t, err, "node >=18 resolved to 20.11.0, a major upgrade from the current 18.17.0, and --fail-on-major is set")
+	contents, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "18.20.4 https://heroku.com\n")
+
+	err = resolveWithSources(sources, "node", ">=18", options{outputFile: path, failOnMajor: true})
+	assert.EqualError(t, err, "--fail-on-major requires --current")
+	err = resolveWithSources(sources, "node", ">=18", options{outputFile: path, current: "lts"})
+	assert.EqualError(t, err, "--current expects a version, like 18.17.0, got lts")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// A binary and the requirement to resolve it with, as given to --resolve
+type binaryRequirement struct {
+	binary             string
+	versionRequirement string
+}
+
+// Collects repeated --resolve binary=requirement flags
+type requirementList []binaryRequirement
+
+func (l *requirementList) String() string {
+	parts := []string{}
+	for _, req := range *l {
+		parts = append(parts, fmt.Sprintf("%s=%s", req.binary, req.versionRequirement))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (l *requirementList) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected BINARY=VERSION_REQUIREMENT, got %q", value)
+	}
+	if len(*l) >= maxBatchRequirements {
+		return fmt.Errorf("at most %d requirements can be resolved at once", maxBatchRequirements)
+	}
+	*l = append(*l, binaryRequirement{binary: parts[0], versionRequirement: parts[1]})
+	return nil
+}
+
+type binaryResult struct {
+	binary string
+	result matchResult
+}
+
+type binaryEntry struct {
+	Binary  string `json:"binary"`
+	Version string `json:"version,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// A requirement that couldn't be resolved with --best-effort
+type binaryFailure struct {
+	binary string
+	err    error
+}
+
+// Returns the flags that only apply to resolving a single requirement, and that
+// --resolve would otherwise accept and then ignore
+func singleResolveFlags(opts options) []string {
+	flags := []string{}
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"--channel", opts.channel != "" && opts.channel != "release"},
+		{"--print-url-only", opts.printURLOnly},
+		{"--separator", opts.separator != "" && opts.separator != "SPACE"},
+		{"--with-headers", opts.withHeaders},
+		{"--assert", opts.assert != ""},
+		{"--require-signed", opts.requireSigned},
+		{"--with-bundled-npm", opts.withBundledNpm},
+		{"--include-metadata", opts.includeMetadata},
+		{"--any-channel", opts.anyChannel},
+		{"--nearest-on-missing", opts.nearestOnMissing},
+		{"--verify-download", opts.verifyDownload != ""},
+		{"--verify-url", opts.verifyURL},
+		{"--current", opts.current != ""},
+		{"--fail-on-major", opts.failOnMajor},
+		// a single platform is fine, it's used for every node in the batch
+		{"--platform with several platforms", len(opts.platforms) > 1},
+		// every binary in a batch needs a prefix of its own
+		{"--shell-prefix", opts.shellPrefix != ""},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return flags
+}
+
+// Fails when --resolve is combined with flags it doesn't apply, so that a
+// batch never prints something other than what was asked for
+func checkBatchOptions(opts options) error {
+	if flags := singleResolveFlags(opts); len(flags) > 0 {
+		return fmt.Errorf("%s can't be used with --resolve", strings.Join(flags, ", "))
+	}
+	return nil
+}
+
+// Resolves several binaries in one invocation, printing one line per binary.
+// With --fail-fast the first binary that can't be resolved stops the rest from
+// being resolved. Otherwise every binary is attempted, the ones that resolved
+// are printed, and the failures are reported at the end
+//
+// Every binary is listed on its own, even though node and yarn share a
+// bucket. A single listing of the whole bucket can't be narrowed to the
+// requested major of node the way listForRequirement narrows it, so it is
+// several times slower in the common case, and only saves a page when all of
+// node has to be listed anyway. See benchmarkBatchListing
+func resolveBatch(reqs requirementList, opts options) {
+	sourcesFor := func(binary string) []source {
+		return sourcesFor(binary, opts.source)
+	}
+
+	var results []binaryResult
+	var failures []binaryFailure
+	if opts.failFast {
+		var err error
+		results, err = resolveRequirements(reqs, sourcesFor)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		results, failures = resolveAllRequirements(reqs, sourcesFor)
+	}
+
+	if opts.minNodeForYarn {
+		if err := checkYarnNodeCompatibility(results); err != nil {
+			if opts.strict {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+		}
+	}
+
+	if err := printBatchResults(results, failures, batchOutput(opts, failures)); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, r := range results {
+		reportUsage(r.binary, r.result.release)
+	}
+
+	if len(failures) > 0 {
+		waitForTelemetry()
+		reportBatchFailures(os.Stderr, failures, len(reqs), opts)
+		os.Exit(1)
+	}
+}
+
+// Returns the options to print the results of a batch with. --output-file is
+// only written when every binary resolved, so that a build never picks up a
+// partial set. Otherwise what did resolve goes to stdout instead
+func batchOutput(opts options, failures []binaryFailure) options {
+	if len(failures) > 0 {
+		opts.outputFile = ""
+	}
+	return opts
+}
+
+// Explains what couldn't be resolved on stderr, so that the JSON on stdout
+// stays valid. The JSON already includes each error
+func reportBatchFailures(out io.Writer, failures []binaryFailure, total int, opts options) {
+	if !opts.json {
+		for _, failure := range failures {
+			fmt.Fprintln(out, failure.err)
+		}
+	}
+	fmt.Fprintf(out, "Could not resolve %d of %d binaries\n", len(failures), total)
+}
+
+// Resolves every requirement, stopping at the first one that fails
+func resolveRequirements(reqs requirementList, sourcesFor func(string) []source) ([]binaryResult, error) {
+	results := []binaryResult{}
+	for _, req := range reqs {
+		result, err := resolveRequirement(req, sourcesFor)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Attempts every requirement, returning the ones that resolved and the ones
+// that didn't
+func resolveAllRequirements(reqs requirementList, sourcesFor func(string) []source) ([]binaryResult, []binaryFailure) {
+	results := []binaryResult{}
+	failures := []binaryFailure{}
+	for _, req := range reqs {
+		result, err := resolveRequirement(req, sourcesFor)
+		if err != nil {
+			failures = append(failures, binaryFailure{binary: req.binary, err: err})
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, failures
+}
+
+func resolveRequirement(req binaryRequirement, sourcesFor func(string) []source) (binaryResult, error) {
+	sources := sourcesFor(req.binary)
+	if len(sources) == 0 {
+		return binaryResult{}, fmt.Errorf("Unknown binary: %s", req.binary)
+	}
+
+	result, err := resolveFromSources(sources, req.binary, normalizeRequirement(req.versionRequirement))
+	if err != nil {
+		return binaryResult{}, err
+	}
+	if !result.matched {
+		if result.capped != nil {
+			return binaryResult{}, fmt.Errorf("No result, %s", cappedMessage(req.binary, req.versionRequirement, *result.capped))
+		}
+		if len(result.closest) > 0 {
+			return binaryResult{}, fmt.Errorf("No result for %s %s, the closest versions are %s", req.binary, req.versionRequirement, describeReleases(result.closest))
+		}
+		return binaryResult{}, fmt.Errorf("No result for %s %s", req.binary, req.versionRequirement)
+	}
+	return binaryResult{binary: req.binary, result: result}, nil
+}
+
+// Prints the resolved binaries. Failures are only included in JSON output,
+// where they can't be mistaken for a resolved binary
+func printBatchResults(results []binaryResult, failures []binaryFailure, opts options) error {
+	if opts.json {
+		entries := make([]binaryEntry, len(results))
+		for i, r := range results {
+			entries[i] = binaryEntry{Binary: r.binary, Version: r.result.release.version.String(), URL: r.result.release.url}
+		}
+		for _, f := range failures {
+			entries = append(entries, binaryEntry{Binary: f.binary, Error: f.err.Error()})
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeOutput(append(data, '\n'), opts)
+	}
+
+	var out strings.Builder
+	for _, r := range results {
+		if opts.env {
+			out.WriteString(envExports(envFormat{export: opts.shell}, r.binary, r.result.release, false))
+			continue
+		}
+		fmt.Fprintf(&out, "%s %s %s\n", r.binary, r.result.release.version.String(), r.result.release.url)
+	}
+	return writeOutput([]byte(out.String()), opts)
+}
+
+// The oldest version of node that each major version of yarn supports
+var yarnMinimumNode = []struct {
+	yarn string
+	node string
+}{
+	{yarn: "<2.0.0", node: ">=4.0.0"},
+	{yarn: ">=2.0.0 <3.0.0", node: ">=10.19.0"},
+	{yarn: ">=3.0.0 <4.0.0", node: ">=12.0.0"},
+	{yarn: ">=4.0.0", node: ">=18.12.0"},
+}
+
+// Returns an error if both node and yarn were resolved to versions that are
+// known not to work together
+func checkYarnNodeCompatibility(results []binaryResult) error {
+	var node, yarn *semver.Version
+	for i := range results {
+		switch results[i].binary {
+		case "node":
+			node = &results[i].result.release.version
+		case "yarn":
+			yarn = &results[i].result.release.version
+		}
+	}
+	if node == nil || yarn == nil {
+		return nil
+	}
+
+	for _, row := range yarnMinimumNode {
+		if !semver.MustParseRange(row.yarn)(*yarn) {
+			continue
+		}
+		if !semver.MustParseRange(row.node)(*node) {
+			return fmt.Errorf("yarn %s requires node %s, but node resolved to %s", yarn.String(), row.node, node.String())
+		}
+	}
+	return nil
+}
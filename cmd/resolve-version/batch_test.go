@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequirementList(t *testing.T) {
+	var reqs requirementList
+	assert.Nil(t, reqs.Set("node=12.x"))
+	assert.Nil(t, reqs.Set("yarn=>=1.19 <2"))
+	assert.Equal(t, reqs, requirementList{
+		{binary: "node", versionRequirement: "12.x"},
+		{binary: "yarn", versionRequirement: ">=1.19 <2"},
+	})
+	assert.Equal(t, reqs.String(), "node=12.x yarn=>=1.19 <2")
+
+	assert.NotNil(t, reqs.Set("node"))
+	assert.NotNil(t, reqs.Set("=12.x"))
+}
+
+func genYarnReleasesFromArray(versions []string) []release {
+	releases := genReleasesFromArray(versions)
+	for i := range releases {
+		releases[i].binary = "yarn"
+		releases[i].platform = ""
+	}
+	return releases
+}
+
+func testSourcesFor(binary string) []source {
+	switch binary {
+	case "node":
+		return []source{staticSource{releases: genReleasesFromArray([]string{"8.16.0", "10.15.3", "12.13.0"})}}
+	case "yarn":
+		return []source{staticSource{releases: genYarnReleasesFromArray([]string{"1.19.1", "2.4.3", "3.6.4", "4.0.2"})}}
+	}
+	return nil
+}
+
+func TestResolveRequirements(t *testing.T) {
+	results, err := resolveRequirements(requirementList{
+		{binary: "node", versionRequirement: "10.x"},
+		{binary: "yarn", versionRequirement: "latest"},
+	}, testSourcesFor)
+	if assert.Nil(t, err) && assert.Len(t, results, 2) {
+		assert.Equal(t, results[0].binary, "node")
+		assert.Equal(t, results[0].result.release.version.String(), "10.15.3")
+		assert.Equal(t, results[1].binary, "yarn")
+		assert.Equal(t, results[1].result.release.version.String(), "4.0.2")
+	}
+
+	_, err = resolveRequirements(requirementList{{binary: "node", versionRequirement: "99.x"}}, testSourcesFor)
+	assert.Equal(t, err.Error(), "No result for node 99.x")
+
+	_, err = resolveRequirements(requirementList{{binary: "node", versionRequirement: "10.15.4"}}, testSourcesFor)
+	assert.Equal(t, err.Error(), "No result for node 10.15.4, the closest versions are 10.15.3 and 12.13.0")
+
+	_, err = resolveRequirements(requirementList{{binary: "bun", versionRequirement: "1.x"}}, testSourcesFor)
+	assert.Equal(t, err.Error(), "Unknown binary: bun")
+}
+
+func TestCheckYarnNodeCompatibility(t *testing.T) {
+	cases := []struct {
+		node       string
+		yarn       string
+		compatible bool
+	}{
+		{node: "8.16.0", yarn: "1.19.1", compatible: true},
+		{node: "10.15.3", yarn: "2.4.3", compatible: false},
+		{node: "12.13.0", yarn: "2.4.3", compatible: true},
+		{node: "10.15.3", yarn: "3.6.4", compatible: false},
+		{node: "12.13.0", yarn: "3.6.4", compatible: true},
+		{node: "12.13.0", yarn: "4.0.2", compatible: false},
+	}
+
+	for _, c := range cases {
+		results, err := resolveRequirements(requirementList{
+			{binary: "node", versionRequirement: c.node},
+			{binary: "yarn", versionRequirement: c.yarn},
+		}, testSourcesFor)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		err = checkYarnNodeCompatibility(results)
+		if c.compatible {
+			assert.Nil(t, err, "node %s with yarn %s", c.node, c.yarn)
+		} else {
+			assert.NotNil(t, err, "node %s with yarn %s", c.node, c.yarn)
+		}
+	}
+
+	results, _ := resolveRequirements(requirementList{{binary: "node", versionRequirement: "10.15.3"}, {binary: "yarn", versionRequirement: "3.6.4"}}, testSourcesFor)
+	assert.Equal(t, checkYarnNodeCompatibility(results).Error(), "yarn 3.6.4 requires node >=12.0.0, but node resolved to 10.15.3")
+
+	// the check only applies when both binaries are resolved together
+	results, _ = resolveRequirements(requirementList{{binary: "yarn", versionRequirement: "4.0.2"}}, testSourcesFor)
+	assert.Nil(t, checkYarnNodeCompatibility(results))
+}
+
+func TestCheckBatchOptions(t *testing.T) {
+	// the defaults, and flags that apply to every binary, are fine
+	assert.Nil(t, checkBatchOptions(options{channel: "release", separator: "SPACE"}))
+	assert.Nil(t, checkBatchOptions(options{json: true, env: true, shell: true, strict: true, failFast: true, minNodeForYarn: true}))
+	assert.Nil(t, checkBatchOptions(options{platforms: []string{"darwin-arm64"}}))
+
+	cases := map[string]options{
+		"--channel":          {channel: "nightly"},
+		"--print-url-only":   {printURLOnly: true},
+		"--separator":        {separator: "TAB"},
+		"--with-headers":     {withHeaders: true},
+		"--assert":           {assert: "20.11.1"},
+		"--require-signed":   {requireSigned: true},
+		"--with-bundled-npm": {withBundledNpm: true},
+		"--include-metadata": {includeMetadata: true},
+		"--any-channel":      {anyChannel: true},
+		"--verify-url":       {verifyURL: true},
+		"--current":          {current: "18.0.0"},
+		"--shell-prefix":     {shellPrefix: "NODE"},
+
+		"--platform with several platforms": {platforms: []string{"linux-x64", "darwin-arm64"}},
+	}
+	for flag, opts := range cases {
+		assert.EqualError(t, checkBatchOptions(opts), flag+" can't be used with --resolve", flag)
+	}
+
+	// and every one that's set is named
+	err := checkBatchOptions(options{channel: "nightly", printURLOnly: true, assert: "20.11.1"})
+	assert.EqualError(t, err, "--channel, --print-url-only, --assert can't be used with --resolve")
+}
+
+func TestFailFastAndBestEffort(t *testing.T) {
+	yarn := &countingSource{releases: genYarnReleasesFromArray([]string{"1.19.1"})}
+	sourcesFor := func(binary string) []source {
+		if binary == "yarn" {
+			return []source{yarn}
+		}
+		return testSourcesFor(binary)
+	}
+	reqs := requirementList{
+		{binary: "node", versionRequirement: "99.x"},
+		{binary: "bun", versionRequirement: "1.x"},
+		{binary: "yarn", versionRequirement: "1.x"},
+	}
+
+	// fail-fast stops before yarn is ever listed
+	_, err := resolveRequirements(reqs, sourcesFor)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "No result for node 99.x")
+	}
+	assert.Equal(t, yarn.lists, 0)
+
+	// best-effort resolves yarn and reports both failures
+	results, failures := resolveAllRequirements(reqs, sourcesFor)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, results[0].binary, "yarn")
+		assert.Equal(t, results[0].result.release.version.String(), "1.19.1")
+	}
+	if assert.Len(t, failures, 2) {
+		assert.Equal(t, failures[0].binary, "node")
+		assert.Equal(t, failures[0].err.Error(), "No result for node 99.x")
+		assert.Equal(t, failures[1].binary, "bun")
+		assert.Equal(t, failures[1].err.Error(), "Unknown binary: bun")
+	}
+	assert.Equal(t, yarn.lists, 1)
+}
+
+func TestPrintBatchResultsWithFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	results, failures := resolveAllRequirements(requirementList{
+		{binary: "node", versionRequirement: "12.x"},
+		{binary: "yarn", versionRequirement: "9.x"},
+	}, testSourcesFor)
+
+	// failures are left out of the text output
+	assert.Nil(t, printBatchResults(results, failures, options{outputFile: path}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "node 12.13.0 https://heroku.com\n")
+
+	assert.Nil(t, printBatchResults(results, failures, options{outputFile: path, json: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), `[
+  {
+    "binary": "node",
+    "version": "12.13.0",
+    "url": "https://heroku.com"
+  },
+  {
+    "binary": "yarn",
+    "error": "No result for yarn 9.x"
+  }
+]
+`)
+}
+
+// A bucket laid out like heroku-nodebin, with 5000 node builds, 150 of yarn and
+// 300 of pnpm, served 1000 keys per page with 20ms of latency per request
+func newBatchBenchmarkServer() *httptest.Server {
+	keys := genNodeKeys(5000)
+	for i := 0; i < 150; i++ {
+		keys = append(keys, fmt.Sprintf("yarn/release/yarn-v1.%d.%d.tar.gz", i/10, i%10))
+	}
+	for i := 0; i < 300; i++ {
+		keys = append(keys, fmt.Sprintf("pnpm/release/pnpm-v%d.%d.%d.tar.gz", i/100, (i/10)%10, i%10))
+	}
+	sort.Strings(keys)
+
+	handler := s3ListingHandler(keys, 1000)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		handler(w, r)
+	}))
+}
+
+// Compares resolving node and yarn with a listing of each against a single
+// listing of the whole bucket that is split by binary afterwards. The separate
+// listings came out ~5x faster when node narrows to a major (~55ms vs
+// ~290ms), and a shared listing only came out ~5% ahead when all of node has
+// to be listed anyway (~280ms vs ~295ms), so resolveBatch lists each binary
+// separately
+func benchmarkBatchListing(b *testing.B, nodeRequirement string, shared bool) {
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	server := newBatchBenchmarkServer()
+	defer server.Close()
+	src := s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !shared {
+			sourcesFor := func(binary string) []source { return []source{src} }
+			reqs := requirementList{{binary: "node", versionRequirement: nodeRequirement}, {binary: "yarn", versionRequirement: "1.x"}}
+			if _, err := resolveRequirements(reqs, sourcesFor); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+
+		all, err := src.List("")
+		if err != nil {
+			b.Fatal(err)
+		}
+		byBinary := map[string][]release{}
+		for _, rel := range all {
+			byBinary[rel.binary] = append(byBinary[rel.binary], rel)
+		}
+		if _, err := resolveNode(byBinary["node"], "linux-x64", nodeRequirement); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := resolveYarn(byBinary["yarn"], "1.x"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBatchSeparateListings(b *testing.B) {
+	benchmarkBatchListing(b, "18.x", false)
+}
+
+func BenchmarkBatchSharedListing(b *testing.B) {
+	benchmarkBatchListing(b, "18.x", true)
+}
+
+func BenchmarkBatchSeparateFullListings(b *testing.B) {
+	benchmarkBatchListing(b, ">=0", false)
+}
+
+func BenchmarkBatchSharedFullListing(b *testing.B) {
+	benchmarkBatchListing(b, ">=0", true)
+}
+
+func TestBestEffortOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	results, failures := resolveAllRequirements(requirementList{
+		{binary: "node", versionRequirement: "12.x"},
+		{binary: "yarn", versionRequirement: "9.x"},
+	}, testSourcesFor)
+	opts := options{outputFile: path, json: true}
+
+	// a run that fails doesn't write --output-file, and what did resolve is
+	// printed instead
+	out := captureStdout(t, func() {
+		assert.Nil(t, printBatchResults(results, failures, batchOutput(opts, failures)))
+	})
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// with the summary on stderr, so that stdout is still only JSON
+	var stderr bytes.Buffer
+	reportBatchFailures(&stderr, failures, 2, opts)
+	assert.Equal(t, stderr.String(), "Could not resolve 1 of 2 binaries\n")
+	var entries []binaryEntry
+	if assert.Nil(t, json.Unmarshal([]byte(out), &entries)) {
+		assert.Equal(t, entries, []binaryEntry{
+			{Binary: "node", Version: "12.13.0", URL: "https://heroku.com"},
+			{Binary: "yarn", Error: "No result for yarn 9.x"},
+		})
+	}
+
+	stderr.Reset()
+	reportBatchFailures(&stderr, failures, 2, options{})
+	assert.Equal(t, stderr.String(), "No result for yarn 9.x\nCould not resolve 1 of 2 binaries\n")
+
+	// and one that succeeds still writes it
+	assert.Equal(t, batchOutput(opts, nil).outputFile, path)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long an open breaker fails requests before letting one through to see
+// whether the source has recovered
+const breakerCooldown = 30 * time.Second
+
+// Replaced in tests so that the cooldown doesn't need to pass
+var breakerNow = time.Now
+
+// Wraps a transport so that once a run of consecutive requests has failed, any
+// further requests fail immediately instead of each waiting out their own
+// timeouts. This bounds how long resolving several binaries can take while a
+// source is down. Any successful request resets the count.
+//
+// Once breakerCooldown has passed, a single trial request is let through. If
+// it succeeds the breaker closes again, otherwise it stays open for another
+// cooldown. Without this, --follow and --serve, which keep running, could
+// never reach a source again after it had been down for a moment.
+//
+// A 503 SlowDown from S3 means the bucket is up but rate limiting, which
+// getS3Listing already backs off from, so it neither counts as a failure nor
+// resets the count. Otherwise its retries alone would open the breaker
+type breakerTransport struct {
+	transport http.RoundTripper
+	threshold int
+
+	mu       sync.Mutex
+	failures int
+	// when the breaker last opened, or the last trial request failed
+	openedAt time.Time
+	// whether a trial request is in flight, so that only one is let through
+	trial bool
+}
+
+func (b *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.mu.Lock()
+	failures := b.failures
+	isTrial := false
+	if failures >= b.threshold {
+		if b.trial || breakerNow().Sub(b.openedAt) < breakerCooldown {
+			b.mu.Unlock()
+			return nil, fmt.Errorf("Not requesting %s after %d consecutive failed requests", req.URL.Host, failures)
+		}
+		b.trial = true
+		isTrial = true
+	}
+	b.mu.Unlock()
+
+	resp, err := b.transport.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusServiceUnavailable {
+		// error responses are small, so the body is read to check it and
+		// then put back for the caller
+		body, readErr := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if readErr == nil && isSlowDown(resp, body) {
+			if isTrial {
+				b.mu.Lock()
+				b.trial = false
+				b.mu.Unlock()
+			}
+			return resp, nil
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if isTrial {
+		b.trial = false
+	}
+	if err != nil || resp.StatusCode >= 500 {
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openedAt = breakerNow()
+		}
+	} else {
+		b.failures = 0
+	}
+	return resp, err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerTransport(t *testing.T) {
+	requests := 0
+	status := 500
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client, err := newHTTPClient(clientConfig{breakerThreshold: 3})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// a success resets the count of failures
+	for _, s := range []int{500, 500, 200, 500, 500} {
+		status = s
+		resp, err := client.Get(server.URL)
+		if assert.Nil(t, err) {
+			assert.Equal(t, resp.StatusCode, s)
+		}
+	}
+	assert.Equal(t, requests, 5)
+
+	// the third consecutive failure opens the breaker
+	status = 500
+	_, err = client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, requests, 6)
+
+	// after which nothing reaches the server, even if it has recovered
+	status = 200
+	_, err = client.Get(server.URL)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "after 3 consecutive failed requests")
+	}
+	assert.Equal(t, requests, 6)
+}
+
+func TestBreakerRecovers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(original func() time.Time) { breakerNow = original }(breakerNow)
+	breakerNow = func() time.Time { return now }
+
+	requests := 0
+	status := 500
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client, err := newHTTPClient(clientConfig{breakerThreshold: 2})
+	if !assert.Nil(t, err) {
+		return
+	}
+	get := func() (int, error) {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	get()
+	get()
+	_, err = get()
+	assert.NotNil(t, err)
+	assert.Equal(t, requests, 2)
+
+	// after the cooldown a single request is let through, and a failure
+	// opens the breaker for another cooldown
+	now = now.Add(breakerCooldown)
+	code, err := get()
+	assert.Nil(t, err)
+	assert.Equal(t, code, 500)
+	assert.Equal(t, requests, 3)
+	_, err = get()
+	assert.NotNil(t, err)
+	assert.Equal(t, requests, 3)
+
+	// and once the source has recovered, a trial request closes it
+	status = 200
+	now = now.Add(breakerCooldown)
+	for i := 0; i < 3; i++ {
+		code, err = get()
+		if assert.Nil(t, err) {
+			assert.Equal(t, code, 200)
+		}
+	}
+	assert.Equal(t, requests, 6)
+}
+
+func TestBreakerThresholdFromEnv(t *testing.T) {
+	defer os.Unsetenv("NODE_RESOLVE_MAX_FAILURES")
+
+	config, err := clientConfigFromEnv(false)
+	assert.Nil(t, err)
+	assert.Equal(t, config.breakerThreshold, 5)
+
+	os.Setenv("NODE_RESOLVE_MAX_FAILURES", "0")
+	config, err = clientConfigFromEnv(false)
+	assert.Nil(t, err)
+	client, err := newHTTPClient(config)
+	assert.Nil(t, err)
+	_, isBreaker := client.Transport.(*breakerTransport)
+	assert.False(t, isBreaker)
+
+	os.Setenv("NODE_RESOLVE_MAX_FAILURES", "-1")
+	_, err = clientConfigFromEnv(false)
+	assert.NotNil(t, err)
+}
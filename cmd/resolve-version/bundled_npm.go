@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmorrell/semver"
+)
+
+// Finds the npm that ships with a release of node, from the npm field of the
+// nodejs.org release index, and where to download it from the npm registry,
+// so that the exact bundled npm can be installed for --with-bundled-npm
+func bundledNpm(rel release) (release, error) {
+	distURL := nodeDistURL
+	if rel.stage == "nightly" {
+		distURL = nodeNightlyURL
+	}
+	index, err := fetchNodejsOrgIndex(distURL)
+	if err != nil {
+		return release{}, err
+	}
+
+	for _, entry := range index {
+		if entry.Version != "v"+rel.version.String() {
+			continue
+		}
+		version, err := semver.Make(entry.Npm)
+		if err != nil {
+			break
+		}
+		return release{
+			binary:  "npm",
+			stage:   "release",
+			url:     fmt.Sprintf("%s/npm/-/npm-%s.tgz", npmRegistryURL(), version.String()),
+			version: version,
+		}, nil
+	}
+	return release{}, fmt.Errorf("Could not find the npm bundled with node %s", rel.version.String())
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveWithBundledNpm(t *testing.T) {
+	server := newNodejsOrgServer()
+	defer server.Close()
+	defer func(original string) { nodeDistURL = original }(nodeDistURL)
+	nodeDistURL = server.URL
+	os.Setenv("NODE_RESOLVE_NPM_REGISTRY", "https://registry.example.com/")
+	defer os.Unsetenv("NODE_RESOLVE_NPM_REGISTRY")
+
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node-version")
+
+	// node is resolved from the bucket, and its npm from the release index
+	sources := []source{staticSource{releases: genReleasesFromArray([]string{"12.12.0", "12.13.0"})}}
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.x", options{outputFile: path, withBundledNpm: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "12.13.0 https://heroku.com\n6.12.0 https://registry.example.com/npm/-/npm-6.12.0.tgz\n")
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.12.0", options{outputFile: path, withBundledNpm: true, json: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.JSONEq(t, string(contents), `{
+		"version": "12.12.0",
+		"url": "https://heroku.com",
+		"npmVersion": "6.11.3",
+		"npmUrl": "https://registry.example.com/npm/-/npm-6.11.3.tgz"
+	}`)
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.x", options{outputFile: path, withBundledNpm: true, env: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "NODE_VERSION='12.13.0'\nNODE_URL='https://heroku.com'\nNPM_VERSION='6.12.0'\nNPM_URL='https://registry.example.com/npm/-/npm-6.12.0.tgz'\n")
+
+	// a version that isn't in the index is an error rather than a guess
+	missing := []source{staticSource{releases: genReleasesFromArray([]string{"12.14.0"})}}
+	err = resolveWithSources(missing, "node", "12.x", options{outputFile: path, withBundledNpm: true})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not find the npm bundled with node 12.14.0")
+	}
+
+	err = resolveWithSources(sources, "yarn", "1.x", options{outputFile: path, withBundledNpm: true})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "--with-bundled-npm is only supported for node, not yarn")
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The on-disk cache of S3 listings, or nil when caching is disabled
+var listingCache *diskCache
+
+// Caches the objects listed under a bucket and prefix, so that builds on the
+// same machine don't all list the whole bucket
+type diskCache struct {
+	dir string
+	ttl time.Duration
+	// how long after a full listing an expired one is brought up to date by
+	// only listing the keys after its last one, or 0 to always list it all
+	fullTTL time.Duration
+	// whether a cached listing is checked against the first page of a fresh
+	// one before it's used. See validateCachedS3Objects
+	validate bool
+}
+
+type cacheEntry struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// the endpoints the bucket was listed through, which NODE_BINARIES_ENDPOINT
+	// and NODE_BINARIES_REGION decide, so that a listing of a mirror is never
+	// used for the bucket on S3 with the same name, or the other way round
+	Endpoints []string  `json:"endpoints"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	// when the whole prefix was last listed, rather than only what was added
+	// after the last key
+	ListedAt time.Time  `json:"listedAt"`
+	Objects  []s3Object `json:"objects"`
+	// the listingHash of Objects, which listings cached before it was added
+	// don't have
+	Hash string `json:"hash,omitempty"`
+}
+
+// Reads the cache settings from the environment:
+//
+//	NODE_RESOLVE_CACHE_DIR  a directory to cache S3 listings in, caching is
+//	                        disabled when it isn't set
+//	NODE_RESOLVE_CACHE_TTL  how long a cached listing is used for, defaults to 1h
+//	NODE_RESOLVE_CACHE_FULL_TTL
+//	                        how long an expired listing is refreshed by only
+//	                        listing the keys after its last one, before the
+//	                        whole prefix is listed again. Disabled by default
+//	NODE_RESOLVE_CACHE_VALIDATE
+//	                        set to check a cached listing against the first
+//	                        page of a fresh one before it's used
+func diskCacheFromEnv() (*diskCache, error) {
+	dir := os.Getenv("NODE_RESOLVE_CACHE_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+
+	cache := &diskCache{dir: dir, ttl: time.Hour}
+	if ttl := os.Getenv("NODE_RESOLVE_CACHE_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("Invalid NODE_RESOLVE_CACHE_TTL: %s", ttl)
+		}
+		cache.ttl = d
+	}
+	if fullTTL := os.Getenv("NODE_RESOLVE_CACHE_FULL_TTL"); fullTTL != "" {
+		d, err := time.ParseDuration(fullTTL)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("Invalid NODE_RESOLVE_CACHE_FULL_TTL: %s", fullTTL)
+		}
+		cache.fullTTL = d
+	}
+	cache.validate = os.Getenv("NODE_RESOLVE_CACHE_VALIDATE") != ""
+	return cache, nil
+}
+
+// Staging builds are replaced far more often than releases, so a listing that
+// staging builds are resolved from is only cached for this long
+const stagingCacheTTL = time.Minute
+
+// Shortens the cache TTL to stagingCacheTTL when ranges can resolve to staging
+// builds, through --include-staging or NODE_RESOLVE_STAGES, so that a stale
+// listing doesn't hide the staging build that was just uploaded. Staging
+// builds are replaced under the same key, which a refresh of only the keys
+// after the last one would never see, so those listings are always listed in
+// full
+func (c *diskCache) limitForStages(stages []string) {
+	for _, stage := range stages {
+		if stage != "staging" {
+			continue
+		}
+		if c.ttl > stagingCacheTTL {
+			c.ttl = stagingCacheTTL
+		}
+		c.fullTTL = 0
+	}
+}
+
+func (c *diskCache) path(endpoints []string, bucketName string, prefix string) string {
+	name := fmt.Sprintf("%s-%s-%s.json", bucketName, strings.Replace(prefix, "/", "_", -1), endpointsHash(endpoints))
+	return filepath.Join(c.dir, name)
+}
+
+// Shortens the endpoints a bucket is listed through into something that can
+// go in a file name
+func endpointsHash(endpoints []string) string {
+	h := sha256.Sum256([]byte(strings.Join(endpoints, "\n")))
+	return hex.EncodeToString(h[:6])
+}
+
+func sameEndpoints(a []string, b []string) bool {
+	return strings.Join(a, "\n") == strings.Join(b, "\n")
+}
+
+// Returns the cached listing if there is one that hasn't expired
+func (c *diskCache) load(endpoints []string, bucketName string, prefix string) ([]s3Object, bool) {
+	entry, ok := c.loadEntry(endpoints, bucketName, prefix)
+	if !ok || time.Since(entry.FetchedAt) > c.ttl {
+		return nil, false
+	}
+	return entry.Objects, true
+}
+
+// Returns the cached listing whether or not it has expired
+func (c *diskCache) loadEntry(endpoints []string, bucketName string, prefix string) (cacheEntry, bool) {
+	data, err := ioutil.ReadFile(c.path(endpoints, bucketName, prefix))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return cacheEntry{}, false
+	}
+	if entry.Bucket != bucketName || entry.Prefix != prefix || !sameEndpoints(entry.Endpoints, endpoints) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Whether an expired listing can be brought up to date with only the keys
+// after its last one. S3 lists keys in lexical order, which isn't version
+// order, so a new node-v18.20.0 sorts before an existing node-v18.9.0 and is
+// only seen by the next full listing. That's why this is opt-in
+func (c *diskCache) refreshable(entry cacheEntry) bool {
+	return c.fullTTL > 0 && len(entry.Objects) > 0 && time.Since(entry.ListedAt) <= c.fullTTL
+}
+
+func (c *diskCache) store(endpoints []string, bucketName string, prefix string, objects []s3Object) error {
+	now := time.Now().UTC()
+	return c.storeEntry(cacheEntry{Bucket: bucketName, Prefix: prefix, Endpoints: endpoints, FetchedAt: now, ListedAt: now, Objects: objects})
+}
+
+func (c *diskCache) storeEntry(entry cacheEntry) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	entry.Hash = listingHash(entry.Objects)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(entry.Endpoints, entry.Bucket, entry.Prefix), data)
+}
+
+// Adds the objects listed after the last cached key to an expired listing
+func refreshCachedS3Objects(endpoints []string, entry cacheEntry) ([]s3Object, error) {
+	last := ""
+	for _, obj := range entry.Objects {
+		if obj.Key > last {
+			last = obj.Key
+		}
+	}
+
+	added, err := listS3ObjectsAfter(endpoints, entry.Bucket, entry.Prefix, last)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := append(append([]s3Object{}, entry.Objects...), added...)
+	entry.Objects = objects
+	entry.FetchedAt = time.Now().UTC()
+	if err := listingCache.storeEntry(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not cache the listing of %s: %s\n", entry.Bucket, err)
+	}
+	return objects, nil
+}
+
+// Hashes everything about a listing that resolving depends on, so that two
+// listings with the same hash resolve every requirement the same way
+func listingHash(objects []s3Object) string {
+	h := sha256.New()
+	for _, obj := range objects {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%s\n", obj.Key, obj.ETag, obj.LastModified.UTC().Format(time.RFC3339Nano), obj.Size, obj.StorageClass)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Checks a cached listing against the first page of a fresh one, with
+// NODE_RESOLVE_CACHE_VALIDATE. Matching the same listing always gives the same
+// result, so a listing that's unchanged is reused rather than caching what each
+// requirement resolved to, which would have to be keyed on every option that
+// affects matching too.
+//
+// When the whole listing fits in one page, as the listing of a single major
+// usually does, that page is the listing, so it's never stale: an unchanged one
+// is reused whatever its age, and a changed one is replaced by the page without
+// listing anything else. The check costs the same single request either way.
+//
+// A listing of several pages can't be checked with one request. A change to
+// its first page still means it's listed again right away, but a change that
+// only shows up on a later page isn't seen until the listing expires, as it
+// would be without this. That's the tradeoff for not listing every page on
+// every build
+func validateCachedS3Objects(endpoints []string, entry cacheEntry) ([]s3Object, bool, error) {
+	first, err := fetchS3Result(endpoints, entry.Bucket, map[string]string{"prefix": entry.Prefix})
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now().UTC()
+	if !first.IsTruncated {
+		hash := entry.Hash
+		if hash == "" {
+			hash = listingHash(entry.Objects)
+		}
+		if listingHash(first.Contents) != hash {
+			entry.Objects = first.Contents
+			entry.ListedAt = now
+		}
+		entry.FetchedAt = now
+		if err := listingCache.storeEntry(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not cache the listing of %s: %s\n", entry.Bucket, err)
+		}
+		return entry.Objects, true, nil
+	}
+
+	n := len(first.Contents)
+	unchanged := n <= len(entry.Objects) && listingHash(first.Contents) == listingHash(entry.Objects[:n])
+	if unchanged && time.Since(entry.FetchedAt) <= listingCache.ttl {
+		return entry.Objects, true, nil
+	}
+	return nil, false, nil
+}
+
+// Lists a bucket through the cache when caching is enabled. A cache that
+// can't be written to only costs the next build a fresh listing, so this
+// warns rather than failing
+func listCachedS3Objects(endpoints []string, bucketName string, prefix string) ([]s3Object, error) {
+	if listingCache != nil {
+		if entry, ok := listingCache.loadEntry(endpoints, bucketName, prefix); ok {
+			fresh := time.Since(entry.FetchedAt) <= listingCache.ttl
+			if listingCache.validate {
+				// a listing that changed is listed in full, since the change
+				// may be anywhere in it rather than only after its last key
+				objects, ok, err := validateCachedS3Objects(endpoints, entry)
+				if err != nil && fresh {
+					fmt.Fprintf(os.Stderr, "Warning: could not check the cached listing of %s, using it anyway: %s\n", bucketName, err)
+					return entry.Objects, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					return objects, nil
+				}
+			} else if fresh {
+				return entry.Objects, nil
+			} else if listingCache.refreshable(entry) {
+				return refreshCachedS3Objects(endpoints, entry)
+			}
+		}
+	}
+
+	objects, err := listS3ObjectsFromEndpoints(endpoints, bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if listingCache != nil {
+		if err := listingCache.store(endpoints, bucketName, prefix, objects); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not cache the listing of %s: %s\n", bucketName, err)
+		}
+	}
+	return objects, nil
+}
+
+// The binaries refresh-cache lists when it isn't given one
+var refreshCacheBinaries = []string{"node", "yarn"}
+
+// Lists each binary from src again and overwrites its cached listing, whether or not it
+// has expired, so that operators can warm a cache before builds need it or
+// correct one that has gone stale. Narrower listings of the binary that are
+// already cached, like the single major of node a requirement was narrowed
+// to, are listed again as well
+func refreshCache(src source, binaries []string) error {
+	if listingCache == nil {
+		return fmt.Errorf("refresh-cache requires NODE_RESOLVE_CACHE_DIR to be set")
+	}
+	s3, ok := src.(s3Source)
+	if !ok {
+		return fmt.Errorf("refresh-cache only caches S3 listings, but NODE_BINARIES_DIR is set")
+	}
+	if len(binaries) == 0 {
+		binaries = refreshCacheBinaries
+	}
+
+	for _, binary := range binaries {
+		if binary != "node" && binary != "yarn" {
+			return fmt.Errorf("Unknown binary: %s, expected node or yarn", binary)
+		}
+		for _, prefix := range listingCache.cachedPrefixes(s3.listEndpoints(), s3.bucketName, binary) {
+			prefix = listingPrefix(prefix)
+			count, err := refreshCachedPrefix(s3, prefix)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Cached %d objects under %s in %s\n", count, prefix, listingCache.path(s3.listEndpoints(), s3.bucketName, prefix))
+		}
+	}
+	return nil
+}
+
+// Returns binary and every narrower prefix under it that has a cached listing
+// of the bucket through endpoints
+func (c *diskCache) cachedPrefixes(endpoints []string, bucketName string, binary string) []string {
+	prefixes := []string{binary}
+	// file names replace the slashes in a prefix, so the prefix itself is
+	// read from the entry
+	for _, listing := range c.listings(fmt.Sprintf("%s-%s_*.json", bucketName, binary)) {
+		if listing.entry.Bucket == bucketName && sameEndpoints(listing.entry.Endpoints, endpoints) && strings.HasPrefix(listing.entry.Prefix, binary+"/") {
+			prefixes = append(prefixes, listing.entry.Prefix)
+		}
+	}
+	return prefixes
+}
+
+// A listing in the cache directory, and the file it's stored in
+type cachedListing struct {
+	path  string
+	size  int64
+	entry cacheEntry
+}
+
+// Returns the cached listings in files matching pattern, skipping anything
+// else that is in the directory
+func (c *diskCache) listings(pattern string) []cachedListing {
+	paths, err := filepath.Glob(filepath.Join(c.dir, pattern))
+	if err != nil {
+		return nil
+	}
+
+	listings := []cachedListing{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry cacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Bucket == "" {
+			continue
+		}
+		listings = append(listings, cachedListing{path: path, size: int64(len(data)), entry: entry})
+	}
+	return listings
+}
+
+// Lists the whole prefix and replaces whatever was cached for it
+func refreshCachedPrefix(s3 s3Source, prefix string) (int, error) {
+	endpoints := s3.listEndpoints()
+	objects, err := listS3ObjectsFromEndpoints(endpoints, s3.bucketName, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if err := listingCache.store(endpoints, s3.bucketName, prefix, objects); err != nil {
+		return 0, fmt.Errorf("Could not cache the listing of %s: %s", prefix, err)
+	}
+	return len(objects), nil
+}
+
+// Runs cache info, clear, or prune:
+//
+//	info   lists each cached bucket and prefix with its age and size
+//	clear  removes every cached listing
+//	prune  removes the listings that have expired and can't be refreshed
+//	       by only listing the keys after their last one
+func cacheCommand(command string, out io.Writer) error {
+	if command != "info" && command != "clear" && command != "prune" {
+		return fmt.Errorf("Unknown cache command: %s, expected info, clear, or prune", command)
+	}
+	if listingCache == nil {
+		return fmt.Errorf("cache %s requires NODE_RESOLVE_CACHE_DIR to be set", command)
+	}
+
+	listings := listingCache.listings("*.json")
+	if command == "info" {
+		if len(listings) == 0 {
+			fmt.Fprintf(out, "No cached listings in %s\n", listingCache.dir)
+			return nil
+		}
+		for _, listing := range listings {
+			age := time.Since(listing.entry.FetchedAt).Round(time.Second)
+			status := ""
+			if age > listingCache.ttl {
+				status = ", expired"
+			}
+			fmt.Fprintf(out, "%s %s: %d objects, %d bytes, fetched %s ago%s\n", listing.entry.Bucket, listing.entry.Prefix, len(listing.entry.Objects), listing.size, age, status)
+		}
+		return nil
+	}
+
+	removed := 0
+	for _, listing := range listings {
+		if command == "prune" && (time.Since(listing.entry.FetchedAt) <= listingCache.ttl || listingCache.refreshable(listing.entry)) {
+			continue
+		}
+		if err := os.Remove(listing.path); err != nil {
+			return err
+		}
+		removed++
+	}
+	fmt.Fprintf(out, "Removed %d of %d cached listings from %s\n", removed, len(listings), listingCache.dir)
+	return nil
+}
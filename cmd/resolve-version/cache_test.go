@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskCacheFromEnv(t *testing.T) {
+	defer os.Unsetenv("NODE_RESOLVE_CACHE_DIR")
+	defer os.Unsetenv("NODE_RESOLVE_CACHE_TTL")
+	defer os.Unsetenv("NODE_RESOLVE_CACHE_FULL_TTL")
+
+	os.Unsetenv("NODE_RESOLVE_CACHE_DIR")
+	cache, err := diskCacheFromEnv()
+	assert.Nil(t, err)
+	assert.Nil(t, cache)
+
+	os.Setenv("NODE_RESOLVE_CACHE_DIR", "/tmp/resolve-version")
+	cache, err = diskCacheFromEnv()
+	if assert.Nil(t, err) {
+		assert.Equal(t, cache, &diskCache{dir: "/tmp/resolve-version", ttl: time.Hour})
+	}
+
+	os.Setenv("NODE_RESOLVE_CACHE_TTL", "10m")
+	cache, err = diskCacheFromEnv()
+	if assert.Nil(t, err) {
+		assert.Equal(t, cache.ttl, 10*time.Minute)
+	}
+
+	os.Setenv("NODE_RESOLVE_CACHE_FULL_TTL", "24h")
+	cache, err = diskCacheFromEnv()
+	if assert.Nil(t, err) {
+		assert.Equal(t, cache.fullTTL, 24*time.Hour)
+	}
+
+	os.Setenv("NODE_RESOLVE_CACHE_FULL_TTL", "-1h")
+	_, err = diskCacheFromEnv()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Invalid NODE_RESOLVE_CACHE_FULL_TTL: -1h")
+	}
+	os.Unsetenv("NODE_RESOLVE_CACHE_FULL_TTL")
+
+	os.Setenv("NODE_RESOLVE_CACHE_VALIDATE", "1")
+	cache, err = diskCacheFromEnv()
+	if assert.Nil(t, err) {
+		assert.True(t, cache.validate)
+	}
+	os.Unsetenv("NODE_RESOLVE_CACHE_VALIDATE")
+
+	os.Setenv("NODE_RESOLVE_CACHE_TTL", "soon")
+	_, err = diskCacheFromEnv()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Invalid NODE_RESOLVE_CACHE_TTL: soon")
+	}
+}
+
+func TestDiskCacheLimitForStages(t *testing.T) {
+	cache := &diskCache{dir: "/tmp/resolve-version", ttl: time.Hour, fullTTL: 24 * time.Hour}
+	cache.limitForStages([]string{"release", "rc"})
+	assert.Equal(t, cache.ttl, time.Hour)
+	assert.Equal(t, cache.fullTTL, 24*time.Hour)
+
+	cache.limitForStages(withStaging([]string{"release"}))
+	assert.Equal(t, cache.ttl, stagingCacheTTL)
+	assert.Equal(t, cache.fullTTL, time.Duration(0))
+
+	// a TTL that is already shorter is left alone
+	cache = &diskCache{dir: "/tmp/resolve-version", ttl: 10 * time.Second}
+	cache.limitForStages([]string{"release", "staging"})
+	assert.Equal(t, cache.ttl, 10*time.Second)
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	cache := &diskCache{dir: dir, ttl: time.Hour}
+	endpoints := s3Endpoints("heroku-nodebin", "")
+	_, ok := cache.load(endpoints, "heroku-nodebin", "node")
+	assert.False(t, ok)
+
+	objects := []s3Object{{Key: "node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz", ETag: `"abcdef"`, Size: 100}}
+	assert.Nil(t, cache.store(endpoints, "heroku-nodebin", "node", objects))
+
+	cached, ok := cache.load(endpoints, "heroku-nodebin", "node")
+	assert.True(t, ok)
+	assert.Equal(t, cached, objects)
+
+	// each prefix is cached separately
+	_, ok = cache.load(endpoints, "heroku-nodebin", "yarn")
+	assert.False(t, ok)
+
+	// and expired listings aren't used
+	cache.ttl = 0
+	_, ok = cache.load(endpoints, "heroku-nodebin", "node")
+	assert.False(t, ok)
+}
+
+func TestDiskCacheEndpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original *diskCache) { listingCache = original }(listingCache)
+	listingCache = &diskCache{dir: dir, ttl: time.Hour}
+
+	serve := func(keys []string) *httptest.Server {
+		return httptest.NewServer(s3ListingHandler(keys, 10))
+	}
+	s3 := serve(genNodeKeys(5))
+	defer s3.Close()
+	mirror := serve(genNodeKeys(3))
+	defer mirror.Close()
+
+	// a bucket of the same name listed through another endpoint, like a
+	// mirror, is cached separately
+	objects, err := listCachedS3Objects([]string{s3.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 5)
+	objects, err = listCachedS3Objects([]string{mirror.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 3)
+	cached, ok := listingCache.load([]string{s3.URL}, "heroku-nodebin", "node")
+	assert.True(t, ok)
+	assert.Len(t, cached, 5)
+
+	// as it is for another region
+	_, ok = listingCache.load(s3Endpoints("heroku-nodebin", "eu-west-1"), "heroku-nodebin", "node")
+	assert.False(t, ok)
+	assert.NotEqual(t, listingCache.path(s3Endpoints("heroku-nodebin", "eu-west-1"), "heroku-nodebin", "node"), listingCache.path(s3Endpoints("heroku-nodebin", ""), "heroku-nodebin", "node"))
+
+	// and an entry listed through other endpoints isn't used, even when it's
+	// in the file those endpoints would use
+	data, err := ioutil.ReadFile(listingCache.path([]string{s3.URL}, "heroku-nodebin", "node"))
+	if assert.Nil(t, err) {
+		assert.Nil(t, ioutil.WriteFile(listingCache.path([]string{mirror.URL}, "heroku-nodebin", "node"), data, 0644))
+		_, ok = listingCache.load([]string{mirror.URL}, "heroku-nodebin", "node")
+		assert.False(t, ok)
+	}
+}
+
+func TestListCachedS3Objects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original *diskCache) { listingCache = original }(listingCache)
+	listingCache = &diskCache{dir: dir, ttl: time.Hour}
+
+	server := httptest.NewServer(s3ListingHandler(genNodeKeys(25), 10))
+	objects, err := listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 25)
+
+	// once cached, the bucket isn't listed again
+	server.Close()
+	cached, err := listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Equal(t, cached, objects)
+}
+
+func TestListCachedS3ObjectsRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original *diskCache) { listingCache = original }(listingCache)
+	listingCache = &diskCache{dir: dir, ttl: time.Hour, fullTTL: 24 * time.Hour}
+
+	keys := genNodeKeys(25)
+	var startAfter []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startAfter = append(startAfter, r.URL.Query().Get("start-after"))
+		s3ListingHandler(keys, 10)(w, r)
+	}))
+	defer server.Close()
+
+	objects, err := listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 25)
+
+	// once the listing expires, only the keys after the last cached one are
+	// listed, and they're added to what was cached
+	keys = genNodeKeys(30)
+	listingCache.ttl = 0
+	startAfter = nil
+	objects, err = listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	if assert.Nil(t, err) && assert.Len(t, objects, 30) {
+		assert.Equal(t, objects[29].Key, "node/release/linux-x64/node-v0.2.9-linux-x64.tar.gz")
+	}
+	assert.Equal(t, startAfter, []string{"node/release/linux-x64/node-v0.2.4-linux-x64.tar.gz"})
+
+	listingCache.ttl = time.Hour
+	cached, ok := listingCache.load([]string{server.URL}, "heroku-nodebin", "node")
+	assert.True(t, ok)
+	assert.Equal(t, cached, objects)
+
+	// and once the last full listing is too old, the whole prefix is listed
+	listingCache.ttl = 0
+	listingCache.fullTTL = time.Nanosecond
+	startAfter = nil
+	_, err = listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Equal(t, startAfter, []string{"", "", ""})
+}
+
+func TestListCachedS3ObjectsValidateOnePage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original *diskCache) { listingCache = original }(listingCache)
+	listingCache = &diskCache{dir: dir, ttl: time.Hour, validate: true}
+
+	keys := genNodeKeys(5)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		s3ListingHandler(keys, 10)(w, r)
+	}))
+	defer server.Close()
+
+	objects, err := listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 5)
+	entry, _ := listingCache.loadEntry([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Equal(t, entry.Hash, listingHash(objects))
+
+	// an unchanged listing is reused, even once it has expired, after
+	// checking the one page it has
+	listingCache.ttl = 0
+	requests = 0
+	cached, err := listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Equal(t, cached, objects)
+	assert.Equal(t, requests, 1)
+
+	// and a changed one is replaced by that page, without another request
+	keys = append(keys, "node/release/linux-x64/node-v0.0.10-linux-x64.tar.gz")
+	sort.Strings(keys)
+	listingCache.ttl = time.Hour
+	requests = 0
+	changed, err := listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	if assert.Nil(t, err) && assert.Len(t, changed, 6) {
+		assert.Equal(t, changed[2].Key, "node/release/linux-x64/node-v0.0.10-linux-x64.tar.gz")
+	}
+	assert.Equal(t, requests, 1)
+	cached, ok := listingCache.load([]string{server.URL}, "heroku-nodebin", "node")
+	assert.True(t, ok)
+	assert.Equal(t, cached, changed)
+
+	// a listing that can't be checked is still used until it expires
+	server.Close()
+	cached, err = listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Equal(t, cached, changed)
+	listingCache.ttl = 0
+	_, err = listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.NotNil(t, err)
+}
+
+func TestListCachedS3ObjectsValidatePages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original *diskCache) { listingCache = original }(listingCache)
+	listingCache = &diskCache{dir: dir, ttl: time.Hour, validate: true}
+
+	keys := genNodeKeys(25)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		s3ListingHandler(keys, 10)(w, r)
+	}))
+	defer server.Close()
+
+	objects, err := listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 25)
+
+	// an unchanged first page means the cache is used
+	requests = 0
+	cached, err := listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Equal(t, cached, objects)
+	assert.Equal(t, requests, 1)
+
+	// but a change that's only on a later page isn't seen until it expires
+	keys = append(keys, "node/release/linux-x64/node-v0.2.1-linux-x64-debug.tar.gz")
+	sort.Strings(keys)
+	requests = 0
+	cached, err = listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, cached, 25)
+	assert.Equal(t, requests, 1)
+
+	listingCache.ttl = 0
+	requests = 0
+	objects, err = listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 26)
+	assert.Equal(t, requests, 4)
+
+	// while a change to the first page is listed again right away
+	keys = append(keys, "node/release/linux-x64/node-v0.0.1-linux-x64-debug.tar.gz")
+	sort.Strings(keys)
+	listingCache.ttl = time.Hour
+	requests = 0
+	objects, err = listCachedS3Objects([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 27)
+	assert.Equal(t, requests, 4)
+}
+
+func TestRefreshCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original *diskCache) { listingCache = original }(listingCache)
+	listingCache = &diskCache{dir: dir, ttl: time.Hour}
+
+	keys := genNodeKeys(25)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3ListingHandler(keys, 10)(w, r)
+	}))
+	defer server.Close()
+	src := s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}
+
+	_, err = listCachedS3Objects(src.listEndpoints(), "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	_, err = listCachedS3Objects(src.listEndpoints(), "heroku-nodebin", "node/release/linux-x64/node-v0.1.")
+	assert.Nil(t, err)
+
+	// the cached listings haven't expired, but both are listed again
+	keys = append(genNodeKeys(30), "node/release/linux-x64/node-v0.1.99-linux-x64.tar.gz")
+	sort.Strings(keys)
+	assert.Nil(t, refreshCache(src, []string{"node"}))
+
+	cached, ok := listingCache.load(src.listEndpoints(), "heroku-nodebin", "node")
+	if assert.True(t, ok) && assert.Len(t, cached, 31) {
+		assert.Equal(t, cached[30].Key, "node/release/linux-x64/node-v0.2.9-linux-x64.tar.gz")
+	}
+	cached, ok = listingCache.load(src.listEndpoints(), "heroku-nodebin", "node/release/linux-x64/node-v0.1.")
+	if assert.True(t, ok) && assert.Len(t, cached, 11) {
+		assert.Equal(t, cached[10].Key, "node/release/linux-x64/node-v0.1.99-linux-x64.tar.gz")
+	}
+	_, ok = listingCache.loadEntry(src.listEndpoints(), "heroku-nodebin", "yarn")
+	assert.False(t, ok)
+
+	assert.EqualError(t, refreshCache(src, []string{"pnpm"}), "Unknown binary: pnpm, expected node or yarn")
+	assert.EqualError(t, refreshCache(localDirSource{dir: dir}, nil), "refresh-cache only caches S3 listings, but NODE_BINARIES_DIR is set")
+
+	listingCache = nil
+	assert.EqualError(t, refreshCache(src, nil), "refresh-cache requires NODE_RESOLVE_CACHE_DIR to be set")
+}
+
+func TestCacheCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original *diskCache) { listingCache = original }(listingCache)
+	listingCache = &diskCache{dir: dir, ttl: time.Hour}
+
+	var out bytes.Buffer
+	assert.Nil(t, cacheCommand("info", &out))
+	assert.Equal(t, out.String(), "No cached listings in "+dir+"\n")
+
+	now := time.Now().UTC()
+	objects := []s3Object{{Key: "node/release/linux-x64/node-v18.0.0-linux-x64.tar.gz"}, {Key: "node/release/linux-x64/node-v18.1.0-linux-x64.tar.gz"}}
+	assert.Nil(t, listingCache.storeEntry(cacheEntry{Bucket: "heroku-nodebin", Prefix: "node", FetchedAt: now.Add(-2 * time.Hour), ListedAt: now.Add(-2 * time.Hour), Objects: objects}))
+	assert.Nil(t, listingCache.storeEntry(cacheEntry{Bucket: "heroku-nodebin", Prefix: "yarn", FetchedAt: now.Add(-5 * time.Minute), ListedAt: now, Objects: []s3Object{}}))
+	// anything else in the directory is left alone
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "notes.json"), []byte(`{"hello": "world"}`), 0644))
+
+	nodeSize := fileSize(t, listingCache.path(nil, "heroku-nodebin", "node"))
+	yarnSize := fileSize(t, listingCache.path(nil, "heroku-nodebin", "yarn"))
+	out.Reset()
+	assert.Nil(t, cacheCommand("info", &out))
+	assert.Equal(t, out.String(), fmt.Sprintf("heroku-nodebin node: 2 objects, %d bytes, fetched 2h0m0s ago, expired\nheroku-nodebin yarn: 0 objects, %d bytes, fetched 5m0s ago\n", nodeSize, yarnSize))
+
+	// an expired listing that can still be refreshed isn't pruned
+	listingCache.fullTTL = 24 * time.Hour
+	out.Reset()
+	assert.Nil(t, cacheCommand("prune", &out))
+	assert.Equal(t, out.String(), "Removed 0 of 2 cached listings from "+dir+"\n")
+
+	listingCache.fullTTL = 0
+	out.Reset()
+	assert.Nil(t, cacheCommand("prune", &out))
+	assert.Equal(t, out.String(), "Removed 1 of 2 cached listings from "+dir+"\n")
+	_, ok := listingCache.loadEntry(nil, "heroku-nodebin", "node")
+	assert.False(t, ok)
+	_, ok = listingCache.loadEntry(nil, "heroku-nodebin", "yarn")
+	assert.True(t, ok)
+
+	out.Reset()
+	assert.Nil(t, cacheCommand("clear", &out))
+	assert.Equal(t, out.String(), "Removed 1 of 1 cached listings from "+dir+"\n")
+	_, ok = listingCache.loadEntry(nil, "heroku-nodebin", "yarn")
+	assert.False(t, ok)
+	_, err = os.Stat(filepath.Join(dir, "notes.json"))
+	assert.Nil(t, err)
+
+	assert.EqualError(t, cacheCommand("purge", &out), "Unknown cache command: purge, expected info, clear, or prune")
+	listingCache = nil
+	assert.EqualError(t, cacheCommand("info", &out), "cache info requires NODE_RESOLVE_CACHE_DIR to be set")
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if !assert.Nil(t, err) {
+		return 0
+	}
+	return info.Size()
+}
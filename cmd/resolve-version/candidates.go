@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Lists every release a requirement for binary could resolve to on this
+// platform, from each of its sources, before the requirement or the stage
+// preference narrows them down. Builds for the platform's fallback are
+// included when node could fall back to them
+func listCandidates(binary string, sources []source, platform string) ([]release, error) {
+	platforms := map[string]bool{platform: true, "": true}
+	if fallback, ok := platformFallbacks[platform]; ok && usePlatformFallbacks {
+		platforms[fallback] = true
+	}
+
+	candidates := []release{}
+	for _, src := range sources {
+		releases, err := listMatching(src, binary, func(rel release) bool {
+			return platforms[rel.platform]
+		})
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, releases...)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].version.LT(candidates[j].version)
+	})
+	return candidates, nil
+}
+
+// Writes the candidates for --candidates-json as a JSON array of releases, in
+// the same form as the release in --json output
+func printCandidates(binary string, opts options) error {
+	sources := sourcesFor(binary, opts.source)
+	if len(sources) == 0 {
+		return fmt.Errorf("Unknown binary: %s", binary)
+	}
+
+	candidates, err := listCandidates(binary, sources, getPlatform())
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(candidates, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutput(append(data, '\n'), opts)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListCandidates(t *testing.T) {
+	objects := []s3Object{
+		{Key: "node/release/linux-x64/node-v18.20.4-linux-x64.tar.gz", ETag: `"abc"`, Size: 100, LastModified: time.Date(2024, 7, 8, 0, 0, 0, 0, time.UTC)},
+		{Key: "node/release/darwin-x64/node-v18.20.4-darwin-x64.tar.gz", ETag: `"def"`, Size: 200},
+		{Key: "node/staging/linux-x64/node-v20.0.0-linux-x64.tar.gz", ETag: `"ghi"`, Size: 300},
+		{Key: "node/release/linux-x64/node-v16.20.2-linux-x64.tar.gz", ETag: `"jkl"`, Size: 400},
+	}
+	releases := []release{}
+	for _, obj := range objects {
+		rel, err := releaseFromObject(obj)
+		if !assert.Nil(t, err) {
+			return
+		}
+		releases = append(releases, rel)
+	}
+
+	// every stage is included, but only builds for the platform
+	candidates, err := listCandidates("node", []source{staticSource{releases: releases}}, "linux-x64")
+	if !assert.Nil(t, err) {
+		return
+	}
+	data, err := json.Marshal(candidates)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(data), `[
+		{"binary": "node", "version": "16.20.2", "major": 16, "minor": 20, "patch": 2, "stage": "release", "platform": "linux-x64",
+		 "url": "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v16.20.2-linux-x64.tar.gz", "size": 400, "etag": "jkl"},
+		{"binary": "node", "version": "18.20.4", "major": 18, "minor": 20, "patch": 4, "stage": "release", "platform": "linux-x64",
+		 "url": "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.20.4-linux-x64.tar.gz", "size": 100, "etag": "abc",
+		 "lastModified": "2024-07-08T00:00:00Z"},
+		{"binary": "node", "version": "20.0.0", "major": 20, "minor": 0, "patch": 0, "stage": "staging", "platform": "linux-x64",
+		 "url": "https://s3.amazonaws.com/heroku-nodebin/node/staging/linux-x64/node-v20.0.0-linux-x64.tar.gz", "size": 300, "etag": "ghi"}
+	]`)
+
+	// darwin-arm64 includes the x64 builds it can fall back to, unless --strict
+	candidates, err = listCandidates("node", []source{staticSource{releases: releases}}, "darwin-arm64")
+	if assert.Nil(t, err) && assert.Len(t, candidates, 1) {
+		assert.Equal(t, candidates[0].platform, "darwin-x64")
+	}
+	defer func(original bool) { usePlatformFallbacks = original }(usePlatformFallbacks)
+	usePlatformFallbacks = false
+	candidates, err = listCandidates("node", []source{staticSource{releases: releases}}, "darwin-arm64")
+	assert.Nil(t, err)
+	assert.Len(t, candidates, 0)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/jmorrell/semver"
+)
+
+// Matches the versions at or below --ceiling, or nil when it isn't set. This
+// is ANDed onto the requirement, or the --range that stands in for it, so
+// that a floor and a ceiling that come from different places don't have to be
+// composed into one requirement, which breaks once either has a ||
+var versionCeiling semver.Range
+
+// Parses --ceiling with the same --semver-mode as requirements
+func parseCeiling(value string) (semver.Range, error) {
+	if value == "" {
+		return nil, nil
+	}
+	r, err := parseRequirement(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --ceiling: %s (%s)", value, err)
+	}
+	return r, nil
+}
+
+// Whether --ceiling rules out a version
+func aboveCeiling(version semver.Version) bool {
+	return versionCeiling != nil && !versionCeiling(version)
+}
+
+var boundVersionRegex = regexp.MustCompile(`\d+(\.\d+){0,2}`)
+
+// Fails when no version could satisfy both the requirement and the ceiling,
+// like a --range of >=20 under a --ceiling of <19, so that this is reported
+// as a mistake rather than as no release matching.
+//
+// Ranges are opaque functions, so this probes them with the versions either
+// side of every version either one mentions. Where two ranges overlap, the
+// overlap starts or ends at one of those, or takes in all of them
+func checkCeiling(versionRequirement string, ceiling string) error {
+	requirement, err := parseRequirement(versionRequirement)
+	if err != nil {
+		return err
+	}
+	below, err := parseCeiling(ceiling)
+	if err != nil {
+		return err
+	}
+
+	for _, probe := range boundProbes(versionRequirement + " " + ceiling) {
+		if requirement(probe) && below(probe) {
+			return nil
+		}
+	}
+	return fmt.Errorf("The --range %s is above the --ceiling %s, so no version can satisfy both", versionRequirement, ceiling)
+}
+
+// Returns the versions either side of each version mentioned in s, where a
+// partial version like 18 or 18.2 stands for the first and last releases it
+// covers
+func boundProbes(s string) []semver.Version {
+	probes := []semver.Version{{}, {Major: 1 << 31}}
+	for _, match := range boundVersionRegex.FindAllString(s, -1) {
+		v, err := semver.ParseTolerant(match)
+		if err != nil {
+			continue
+		}
+		probes = append(probes,
+			v,
+			semver.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1},
+			semver.Version{Major: v.Major, Minor: v.Minor + 1},
+			semver.Version{Major: v.Major + 1},
+			semver.Version{Major: v.Major, Minor: v.Minor, Patch: 1 << 31},
+			semver.Version{Major: v.Major, Minor: 1 << 31},
+		)
+		if v.Patch > 0 {
+			probes = append(probes, semver.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch - 1})
+		}
+		if v.Minor > 0 {
+			probes = append(probes, semver.Version{Major: v.Major, Minor: v.Minor - 1, Patch: 1 << 31})
+		}
+		if v.Major > 0 {
+			probes = append(probes, semver.Version{Major: v.Major - 1, Minor: 1 << 31})
+		}
+	}
+	return probes
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCeiling(t *testing.T) {
+	r, err := parseCeiling("")
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+
+	r, err = parseCeiling("<19")
+	if assert.Nil(t, err) {
+		assert.True(t, r(semver.MustParse("18.20.4")))
+		assert.False(t, r(semver.MustParse("19.0.0")))
+	}
+
+	_, err = parseCeiling("<nineteen")
+	assert.EqualError(t, err, "Invalid --ceiling: <nineteen (Could not get version from string: \"<nineteen\")")
+}
+
+func TestCheckCeiling(t *testing.T) {
+	cases := []struct {
+		versionRange string
+		ceiling      string
+		overlaps     bool
+	}{
+		{">=16", "<19", true},
+		{"16.x", "<=16.0.0", true},
+		{"^16 || ^20", "<17", true},
+		{">=18.2.1", "<18.2.2", true},
+		{"*", "<1", true},
+		{">=19", "<19", false},
+		{">=20", "<19", false},
+		{"18.2.1", "<18.2.1", false},
+		{"^20 || ^22", "<=18", false},
+		{">18", "18.x", false},
+	}
+	for _, c := range cases {
+		err := checkCeiling(c.versionRange, c.ceiling)
+		if c.overlaps {
+			assert.Nil(t, err, c.versionRange+" "+c.ceiling)
+		} else {
+			assert.EqualError(t, err, "The --range "+c.versionRange+" is above the --ceiling "+c.ceiling+", so no version can satisfy both", c.versionRange+" "+c.ceiling)
+		}
+	}
+}
+
+func TestResolveNodeCeiling(t *testing.T) {
+	defer func(original semver.Range) { versionCeiling = original }(versionCeiling)
+	releases := genReleasesFromArray([]string{"16.20.2", "18.19.1", "18.20.4", "19.9.0", "20.15.1"})
+
+	var err error
+	versionCeiling, err = parseCeiling("<19")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	cases := []struct {
+		requirement string
+		version     string
+	}{
+		// the highest release under both bounds, though newer ones exist
+		{">=16", "18.20.4"},
+		{"*", "18.20.4"},
+		{"16.x", "16.20.2"},
+		{"18.19.1", "18.19.1"},
+		{"19.9.0", ""},
+	}
+	for _, c := range cases {
+		result, err := resolveNode(releases, "linux-x64", c.requirement)
+		if !assert.Nil(t, err, c.requirement) {
+			continue
+		}
+		assert.Equal(t, result.matched, c.version != "", c.requirement)
+		if result.matched {
+			assert.Equal(t, result.release.version.String(), c.version, c.requirement)
+		}
+	}
+}
+
+func TestResolveWithSourcesCeiling(t *testing.T) {
+	defer func(original semver.Range) { versionCeiling = original }(versionCeiling)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+	sources := []source{staticSource{releases: genReleasesFromArray([]string{"16.20.2", "18.20.4", "20.15.1"})}}
+
+	var err error
+	versionCeiling, err = parseCeiling("<19")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// disjoint bounds are reported rather than resolving to nothing
+	err = resolveWithSources(sources, "node", ">=20", options{ceiling: "<19"})
+	assert.EqualError(t, err, "The --range >=20 is above the --ceiling <19, so no version can satisfy both")
+}
+
+func TestClosestReleasesCeiling(t *testing.T) {
+	defer func(original semver.Range) { versionCeiling = original }(versionCeiling)
+	releases := genReleasesFromArray([]string{"18.19.0", "18.19.2", "18.20.4"})
+
+	var err error
+	versionCeiling, err = parseCeiling("<18.19.2")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// 18.19.2 would be the nearest, but it's above the ceiling
+	result, err := resolveNode(releases, "linux-x64", "18.19.1")
+	if assert.Nil(t, err) && assert.False(t, result.matched) {
+		assert.Equal(t, describeReleases(result.closest), "18.19.0")
+		nearest, ok := nearestRelease(result.closest, semver.MustParse("18.19.1"))
+		if assert.True(t, ok) {
+			assert.Equal(t, nearest.version.String(), "18.19.0")
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jmorrell/semver"
+)
+
+// One side of a --compare
+type compareSide struct {
+	Requirement string `json:"requirement"`
+	Version     string `json:"version"`
+	URL         string `json:"url"`
+}
+
+type comparisonEntry struct {
+	Binary string      `json:"binary"`
+	From   compareSide `json:"from"`
+	To     compareSide `json:"to"`
+	// major, minor, patch, prerelease, or none when both resolve the same
+	Jump      string `json:"jump"`
+	Downgrade bool   `json:"downgrade"`
+}
+
+// Classifies the change from one version to another by the most significant
+// part that differs
+func versionJump(from semver.Version, to semver.Version) string {
+	switch {
+	case from.Major != to.Major:
+		return "major"
+	case from.Minor != to.Minor:
+		return "minor"
+	case from.Patch != to.Patch:
+		return "patch"
+	case !from.EQ(to):
+		return "prerelease"
+	}
+	return "none"
+}
+
+// Resolves both requirements and describes how the versions they select differ
+func compareRequirements(sources []source, binary string, from string, to string) (comparisonEntry, error) {
+	sides := []compareSide{}
+	var versions []semver.Version
+	for _, versionRequirement := range []string{from, to} {
+		result, err := resolveFromSources(sources, binary, normalizeRequirement(versionRequirement))
+		if err != nil {
+			return comparisonEntry{}, err
+		}
+		if !result.matched {
+			return comparisonEntry{}, fmt.Errorf("No result for %s %s", binary, versionRequirement)
+		}
+		sides = append(sides, compareSide{Requirement: versionRequirement, Version: result.release.version.String(), URL: result.release.url})
+		versions = append(versions, result.release.version)
+	}
+
+	return comparisonEntry{
+		Binary:    binary,
+		From:      sides[0],
+		To:        sides[1],
+		Jump:      versionJump(versions[0], versions[1]),
+		Downgrade: versions[1].LT(versions[0]),
+	}, nil
+}
+
+// Previews what moving a binary from one requirement to another would select
+func compare(binary string, from string, to string, opts options) error {
+	if to == "" {
+		return errors.New("--compare requires --with")
+	}
+	sources := sourcesFor(binary, opts.source)
+	if len(sources) == 0 {
+		return fmt.Errorf("Unknown binary: %s", binary)
+	}
+
+	entry, err := compareRequirements(sources, binary, from, to)
+	if err != nil {
+		return err
+	}
+	return printComparison(entry, opts)
+}
+
+func printComparison(entry comparisonEntry, opts options) error {
+	if opts.json {
+		data, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeOutput(append(data, '\n'), opts)
+	}
+
+	out := ""
+	for _, side := range []compareSide{entry.From, entry.To} {
+		out += fmt.Sprintf("%s %s resolves to %s %s\n", entry.Binary, side.Requirement, side.Version, side.URL)
+	}
+	switch {
+	case entry.Jump == "none":
+		out += fmt.Sprintf("Both resolve to %s\n", entry.From.Version)
+	case entry.Downgrade:
+		out += fmt.Sprintf("%s downgrade from %s to %s\n", entry.Jump, entry.From.Version, entry.To.Version)
+	default:
+		out += fmt.Sprintf("%s upgrade from %s to %s\n", entry.Jump, entry.From.Version, entry.To.Version)
+	}
+	return writeOutput([]byte(out), opts)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionJump(t *testing.T) {
+	cases := []struct {
+		from string
+		to   string
+		jump string
+	}{
+		{"18.17.0", "20.5.0", "major"},
+		{"20.5.0", "18.17.0", "major"},
+		{"18.12.0", "18.17.1", "minor"},
+		{"18.17.0", "18.17.1", "patch"},
+		{"18.0.0-rc.1", "18.0.0", "prerelease"},
+		{"18.17.0", "18.17.0", "none"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, versionJump(semver.MustParse(c.from), semver.MustParse(c.to)), c.jump, c.from+" to "+c.to)
+	}
+}
+
+func TestCompareRequirements(t *testing.T) {
+	sources := []source{staticSource{releases: genReleasesFromArray([]string{"18.12.0", "18.17.0", "18.17.1", "20.5.0"})}}
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	entry, err := compareRequirements(sources, "node", "18", "20")
+	if assert.Nil(t, err) {
+		assert.Equal(t, entry.From, compareSide{Requirement: "18", Version: "18.17.1", URL: "https://heroku.com"})
+		assert.Equal(t, entry.To, compareSide{Requirement: "20", Version: "20.5.0", URL: "https://heroku.com"})
+		assert.Equal(t, entry.Jump, "major")
+		assert.False(t, entry.Downgrade)
+	}
+
+	entry, err = compareRequirements(sources, "node", "18.17.x", "~18.12")
+	if assert.Nil(t, err) {
+		assert.Equal(t, entry.Jump, "minor")
+		assert.True(t, entry.Downgrade)
+	}
+
+	entry, err = compareRequirements(sources, "node", "18.17.0", "18.17.x")
+	if assert.Nil(t, err) {
+		assert.Equal(t, entry.Jump, "patch")
+	}
+
+	_, err = compareRequirements(sources, "node", "18", "22")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "No result for node 22")
+	}
+}
+
+func TestPrintComparison(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "comparison")
+
+	entry := comparisonEntry{
+		Binary: "node",
+		From:   compareSide{Requirement: "18", Version: "18.17.1", URL: "https://heroku.com/18"},
+		To:     compareSide{Requirement: "20", Version: "20.5.0", URL: "https://heroku.com/20"},
+		Jump:   "major",
+	}
+	assert.Nil(t, printComparison(entry, options{outputFile: path}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "node 18 resolves to 18.17.1 https://heroku.com/18\n"+
+		"node 20 resolves to 20.5.0 https://heroku.com/20\n"+
+		"major upgrade from 18.17.1 to 20.5.0\n")
+
+	assert.Nil(t, printComparison(entry, options{outputFile: path, json: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.JSONEq(t, string(contents), `{
+		"binary": "node",
+		"from": {"requirement": "18", "version": "18.17.1", "url": "https://heroku.com/18"},
+		"to": {"requirement": "20", "version": "20.5.0", "url": "https://heroku.com/20"},
+		"jump": "major",
+		"downgrade": false
+	}`)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The most versions __complete suggests, since a shell can't show more than
+// a screenful of them usefully
+const maxCompletions = 50
+
+// Returns the released versions that start with partial, like 18. for
+// 18.0.0 and 18.1.0, oldest first. Only the newest ones are kept when there
+// are more than maxCompletions
+func completions(releases []release, partial string) []string {
+	partial = strings.TrimPrefix(partial, "v")
+
+	seen := map[string]bool{}
+	matching := []release{}
+	for _, rel := range releases {
+		v := rel.version.String()
+		if strings.HasPrefix(v, partial) && !seen[v] {
+			seen[v] = true
+			matching = append(matching, rel)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].version.LT(matching[j].version)
+	})
+	if len(matching) > maxCompletions {
+		matching = matching[len(matching)-maxCompletions:]
+	}
+
+	out := make([]string, len(matching))
+	for i, rel := range matching {
+		out[i] = rel.version.String()
+	}
+	return out
+}
+
+// Prints the completions of a partial version for shell completion scripts,
+// one per line. This is the hidden __complete BINARY [PARTIAL] command
+func complete(binary string, partial string, opts options) error {
+	sources := sourcesFor(binary, opts.source)
+	if len(sources) == 0 {
+		return fmt.Errorf("Unknown binary: %s", binary)
+	}
+
+	platform := getPlatform()
+	releases, err := listMatching(sources[0], binary, func(rel release) bool {
+		return (rel.platform == platform || rel.platform == "") && rel.stage == "release"
+	})
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	for _, version := range completions(releases, partial) {
+		fmt.Fprintln(&out, version)
+	}
+	_, err = os.Stdout.WriteString(out.String())
+	return err
+}
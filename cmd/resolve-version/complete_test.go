@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletions(t *testing.T) {
+	releases := genReleasesFromArray([]string{"18.10.0", "16.20.2", "18.1.0", "18.0.0", "180.0.0", "18.2.0-rc.1", "20.0.0"})
+	// a second build of the same version is only suggested once
+	releases = append(releases, genReleasesFromArray([]string{"18.1.0"})...)
+
+	assert.Equal(t, completions(releases, "18."), []string{"18.0.0", "18.1.0", "18.2.0-rc.1", "18.10.0"})
+	assert.Equal(t, completions(releases, "v18.1"), []string{"18.1.0", "18.10.0"})
+	assert.Equal(t, completions(releases, "18"), []string{"18.0.0", "18.1.0", "18.2.0-rc.1", "18.10.0", "180.0.0"})
+	assert.Equal(t, completions(releases, "19"), []string{})
+	assert.Len(t, completions(releases, ""), 7)
+
+	// only the newest suggestions are kept
+	many := []string{}
+	for i := 0; i < 60; i++ {
+		many = append(many, fmt.Sprintf("18.%d.0", i))
+	}
+	suggested := completions(genReleasesFromArray(many), "18.")
+	if assert.Len(t, suggested, maxCompletions) {
+		assert.Equal(t, suggested[0], "18.10.0")
+		assert.Equal(t, suggested[maxCompletions-1], "18.59.0")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The file defaults are read from when NODE_RESOLVE_CONFIG isn't set
+const configFileName = ".resolve-version.toml"
+
+// Flags whose setting in a config file is ignored when the env var they
+// mirror is set, since the environment takes precedence over the config file
+var configEnvOverrides = map[string]string{
+	"force-ipv4": "NODE_RESOLVE_IP",
+	"force-ipv6": "NODE_RESOLVE_IP",
+	"trace-http": "NODE_RESOLVE_TRACE_HTTP",
+}
+
+// Flags that have to be passed explicitly every time, so that a config file
+// can't quietly turn them on for every run
+var commandLineOnly = map[string]bool{
+	"insecure": true,
+}
+
+type configSetting struct {
+	key   string
+	value string
+	line  int
+}
+
+// Returns the config file to read defaults from, and whether it was asked for
+// explicitly, in which case it's an error for it not to exist
+func configPath() (string, bool) {
+	if path := os.Getenv("NODE_RESOLVE_CONFIG"); path != "" {
+		return path, true
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(home, configFileName), false
+}
+
+// Parses a config file of key = value lines, the flat subset of TOML:
+//
+//	# comments and blank lines are ignored
+//	platform = "linux-arm64"
+//	strict = true
+//	NODE_RESOLVE_CACHE_DIR = "/var/cache/resolve-version"
+//
+// Strings are quoted as in Go, and anything else is used as it's written
+func parseConfig(data []byte) ([]configSetting, error) {
+	settings := []configSetting{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value, err := parseConfigValue(strings.TrimSpace(line[eq+1:]))
+		if key == "" || err != nil {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		settings = append(settings, configSetting{key: key, value: value, line: i + 1})
+	}
+	return settings, nil
+}
+
+func parseConfigValue(value string) (string, error) {
+	if !strings.HasPrefix(value, `"`) {
+		if comment := strings.Index(value, "#"); comment >= 0 {
+			value = strings.TrimSpace(value[:comment])
+		}
+		return value, nil
+	}
+
+	// the closing quote is the first one that isn't escaped
+	for end := 1; end < len(value); end++ {
+		if value[end] == '\\' {
+			end++
+			continue
+		}
+		if value[end] != '"' {
+			continue
+		}
+		rest := strings.TrimSpace(value[end+1:])
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			break
+		}
+		return strconv.Unquote(value[:end+1])
+	}
+	return "", fmt.Errorf("Unterminated string: %s", value)
+}
+
+// Sets defaults from the config file before the command line is parsed, so
+// that the precedence is flags, then env vars, then the config file, then
+// the built-in defaults. Keys are either the name of a flag, without its
+// dashes, or the name of an env var, which is only set when it isn't already
+func loadConfig(fs *flag.FlagSet) error {
+	path, explicit := configPath()
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	settings, err := parseConfig(data)
+	if err != nil {
+		return fmt.Errorf("Invalid config file %s, %s", path, err)
+	}
+	for _, setting := range settings {
+		if strings.ToUpper(setting.key) == setting.key {
+			if _, ok := os.LookupEnv(setting.key); !ok {
+				os.Setenv(setting.key, setting.value)
+			}
+			continue
+		}
+
+		if fs.Lookup(setting.key) == nil {
+			return fmt.Errorf("Unknown setting in config file %s, line %d: %s", path, setting.line, setting.key)
+		}
+		if commandLineOnly[setting.key] {
+			return fmt.Errorf("--%s can only be set on the command line, not in config file %s", setting.key, path)
+		}
+		if env, ok := configEnvOverrides[setting.key]; ok && os.Getenv(env) != "" {
+			continue
+		}
+		if err := fs.Set(setting.key, setting.value); err != nil {
+			return fmt.Errorf("Invalid %s in config file %s, line %d: %s", setting.key, path, setting.line, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	settings, err := parseConfig([]byte(`
+# defaults for this machine
+platform = "linux-arm64"
+strict = true # no fallbacks
+separator = "a \"quoted\" # value"
+NODE_RESOLVE_CACHE_DIR="/var/cache/resolve-version"
+`))
+	if assert.Nil(t, err) {
+		assert.Equal(t, settings, []configSetting{
+			{key: "platform", value: "linux-arm64", line: 3},
+			{key: "strict", value: "true", line: 4},
+			{key: "separator", value: `a "quoted" # value`, line: 5},
+			{key: "NODE_RESOLVE_CACHE_DIR", value: "/var/cache/resolve-version", line: 6},
+		})
+	}
+
+	for _, config := range []string{"strict", "= true", `platform = "linux-x64`, `platform = "linux-x64" arm64`} {
+		_, err := parseConfig([]byte("\n" + config))
+		if assert.NotNil(t, err, config) {
+			assert.Equal(t, err.Error(), "line 2: expected key = value", config)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("NODE_RESOLVE_CONFIG")
+	defer os.Unsetenv("NODE_RESOLVE_CACHE_DIR")
+	defer os.Unsetenv("NODE_RESOLVE_TRACE_HTTP")
+
+	path := filepath.Join(dir, "config.toml")
+	os.Setenv("NODE_RESOLVE_CONFIG", path)
+
+	newFlags := func() (*flag.FlagSet, *options) {
+		opts := &options{}
+		fs := flag.NewFlagSet("resolve-version", flag.ContinueOnError)
+		fs.BoolVar(&opts.strict, "strict", false, "")
+		fs.StringVar(&opts.source, "source", "s3", "")
+		fs.BoolVar(&opts.traceHTTP, "trace-http", false, "")
+		return fs, opts
+	}
+
+	// a config file that was asked for has to exist
+	fs, _ := newFlags()
+	assert.NotNil(t, loadConfig(fs))
+
+	ioutil.WriteFile(path, []byte("strict = true\nsource = \"nodejs-org\"\ntrace-http = true\nNODE_RESOLVE_CACHE_DIR = \"/tmp/from-config\"\n"), 0644)
+	os.Unsetenv("NODE_RESOLVE_CACHE_DIR")
+	fs, opts := newFlags()
+	if assert.Nil(t, loadConfig(fs)) {
+		assert.True(t, opts.strict)
+		assert.Equal(t, opts.source, "nodejs-org")
+		assert.True(t, opts.traceHTTP)
+		assert.Equal(t, os.Getenv("NODE_RESOLVE_CACHE_DIR"), "/tmp/from-config")
+	}
+
+	// flags override the config file
+	assert.Nil(t, fs.Parse([]string{"--strict=false", "--source", "s3"}))
+	assert.False(t, opts.strict)
+	assert.Equal(t, opts.source, "s3")
+
+	// and so does the environment
+	os.Setenv("NODE_RESOLVE_CACHE_DIR", "/tmp/from-env")
+	os.Setenv("NODE_RESOLVE_TRACE_HTTP", "1")
+	fs, opts = newFlags()
+	if assert.Nil(t, loadConfig(fs)) {
+		assert.False(t, opts.traceHTTP)
+		assert.Equal(t, os.Getenv("NODE_RESOLVE_CACHE_DIR"), "/tmp/from-env")
+	}
+
+	ioutil.WriteFile(path, []byte("# typo\nstirct = true\n"), 0644)
+	fs, _ = newFlags()
+	err = loadConfig(fs)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Unknown setting in config file "+path+", line 2: stirct")
+	}
+
+	ioutil.WriteFile(path, []byte("insecure = true\n"), 0644)
+	fs, _ = newFlags()
+	fs.Bool("insecure", false, "")
+	err = loadConfig(fs)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "--insecure can only be set on the command line, not in config file "+path)
+	}
+
+	ioutil.WriteFile(path, []byte("strict = maybe\n"), 0644)
+	fs, _ = newFlags()
+	err = loadConfig(fs)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Invalid strict in config file "+path+", line 1: ")
+	}
+
+	ioutil.WriteFile(path, []byte("strict\n"), 0644)
+	fs, _ = newFlags()
+	err = loadConfig(fs)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Invalid config file "+path+", line 1: expected key = value")
+	}
+
+	// the default config file doesn't have to exist
+	os.Unsetenv("NODE_RESOLVE_CONFIG")
+	defer func(home string) { os.Setenv("HOME", home) }(os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+	fs, _ = newFlags()
+	assert.Nil(t, loadConfig(fs))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// The env vars that --constraints-from-env reads a requirement from
+var constraintEnvVars = map[string]string{
+	"node": "NODE_VERSION",
+	"yarn": "YARN_VERSION",
+	"pnpm": "PNPM_VERSION",
+	"npm":  "NPM_VERSION",
+}
+
+// What lib/binaries.sh installs when nothing asks for a version
+var defaultConstraints = map[string]string{
+	"node": "12.x",
+	"yarn": "1.x",
+}
+
+type packageJSON struct {
+	Engines map[string]string `json:"engines"`
+}
+
+// Finds the requirement for a binary that wasn't given one as an argument,
+// and where it came from. The first of these that is set wins:
+//
+//	NODE_VERSION, YARN_VERSION, PNPM_VERSION, or NPM_VERSION
+//	engines in the package.json at packagePath, if there is one
+//	the buildpack's default
+func constraintFor(binary string, packagePath string) (string, string, error) {
+	envVar, ok := constraintEnvVars[binary]
+	if !ok {
+		return "", "", fmt.Errorf("Unknown binary: %s", binary)
+	}
+	if requirement := os.Getenv(envVar); requirement != "" {
+		return requirement, envVar, nil
+	}
+
+	data, err := ioutil.ReadFile(packagePath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
+	}
+	if err == nil {
+		var pkg packageJSON
+		if err := json.Unmarshal(data, &pkg); err != nil {
+			return "", "", fmt.Errorf("Could not parse %s: %s", packagePath, err)
+		}
+		if requirement := pkg.Engines[binary]; requirement != "" {
+			return requirement, fmt.Sprintf("engines.%s in %s", binary, packagePath), nil
+		}
+	}
+
+	if requirement, ok := defaultConstraints[binary]; ok {
+		return requirement, "the default", nil
+	}
+	return "", "", fmt.Errorf("No requirement for %s in %s or %s", binary, envVar, packagePath)
+}
+
+// Adds the requirement from constraintFor to "BINARY" arguments. Arguments
+// that already have a requirement, or aren't a binary, are left as they are
+func withConstraintFromEnv(args []string, packagePath string) ([]string, error) {
+	if len(args) != 1 {
+		return args, nil
+	}
+	if _, ok := constraintEnvVars[args[0]]; !ok {
+		return args, nil
+	}
+
+	versionRequirement, from, err := constraintFor(args[0], packagePath)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Using %s %s from %s\n", args[0], versionRequirement, from)
+	return []string{args[0], versionRequirement}, nil
+}
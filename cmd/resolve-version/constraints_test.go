@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstraintPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	packagePath := filepath.Join(dir, "package.json")
+	defer os.Unsetenv("NODE_VERSION")
+	defer os.Unsetenv("YARN_VERSION")
+	defer os.Unsetenv("NPM_VERSION")
+
+	// the default, without an env var or a package.json
+	os.Unsetenv("NODE_VERSION")
+	args, err := withConstraintFromEnv([]string{"node"}, packagePath)
+	assert.Nil(t, err)
+	assert.Equal(t, args, []string{"node", "12.x"})
+
+	// engines in package.json over the default
+	assert.Nil(t, ioutil.WriteFile(packagePath, []byte(`{"engines": {"node": "18.x", "npm": "9.x"}}`), 0644))
+	requirement, from, err := constraintFor("node", packagePath)
+	assert.Nil(t, err)
+	assert.Equal(t, requirement, "18.x")
+	assert.Equal(t, from, "engines.node in "+packagePath)
+
+	// the env var over package.json
+	os.Setenv("NODE_VERSION", "20.x")
+	args, err = withConstraintFromEnv([]string{"node"}, packagePath)
+	assert.Nil(t, err)
+	assert.Equal(t, args, []string{"node", "20.x"})
+
+	// an explicit argument over all of them
+	args, err = withConstraintFromEnv([]string{"node", "10.x"}, packagePath)
+	assert.Nil(t, err)
+	assert.Equal(t, args, []string{"node", "10.x"})
+
+	// a package.json without engines for the binary falls through to the default
+	requirement, from, err = constraintFor("yarn", packagePath)
+	assert.Nil(t, err)
+	assert.Equal(t, requirement, "1.x")
+	assert.Equal(t, from, "the default")
+
+	os.Setenv("YARN_VERSION", "1.22.x")
+	requirement, from, err = constraintFor("yarn", packagePath)
+	assert.Nil(t, err)
+	assert.Equal(t, requirement, "1.22.x")
+	assert.Equal(t, from, "YARN_VERSION")
+
+	// npm has no default
+	requirement, _, err = constraintFor("npm", packagePath)
+	assert.Nil(t, err)
+	assert.Equal(t, requirement, "9.x")
+	_, _, err = constraintFor("npm", filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+
+	// other commands are left alone
+	args, err = withConstraintFromEnv([]string{"list"}, packagePath)
+	assert.Nil(t, err)
+	assert.Equal(t, args, []string{"list"})
+}
+
+func TestConstraintInvalidPackageJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	packagePath := filepath.Join(dir, "package.json")
+	assert.Nil(t, ioutil.WriteFile(packagePath, []byte(`{"engines": `), 0644))
+
+	os.Unsetenv("NODE_VERSION")
+	_, _, err = constraintFor("node", packagePath)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Could not parse "+packagePath)
+	}
+}
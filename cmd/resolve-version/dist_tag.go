@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Where nodejs.org publishes release candidates
+var nodeRCURL = "https://nodejs.org/download/rc"
+
+// The dist-tags --dist-tag understands, with the nodejs.org index that each
+// is looked up in. lts is the newest release of an LTS line
+var nodeDistTags = map[string]func() string{
+	"latest":  func() string { return nodeDistURL },
+	"lts":     func() string { return nodeDistURL },
+	"rc":      func() string { return nodeRCURL },
+	"nightly": func() string { return nodeNightlyURL },
+}
+
+// Returns the version of node that a dist-tag currently points to, according
+// to the nodejs.org release index, so that it can be resolved like any other
+// exact version
+func distTagVersion(tag string) (string, error) {
+	distURL, ok := nodeDistTags[tag]
+	if !ok {
+		return "", fmt.Errorf("Unknown dist-tag: %s, expected latest, lts, rc, or nightly", tag)
+	}
+
+	index, err := fetchNodejsOrgIndex(distURL())
+	if err != nil {
+		return "", err
+	}
+
+	var newest *release
+	releases := parseNodejsOrgIndexAt(distURL(), index)
+	for i, rel := range releases {
+		if tag == "lts" && rel.lts == "" {
+			continue
+		}
+		if newest == nil || rel.version.GT(newest.version) {
+			newest = &releases[i]
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("No version of node is tagged %s", tag)
+	}
+	return newest.version.String(), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistTagVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dist/index.json":
+			fmt.Fprint(w, nodejsOrgIndexFixture)
+		case "/rc/index.json":
+			fmt.Fprint(w, `[{"version": "v14.0.0-rc.1", "date": "2020-04-14", "files": ["linux-x64"], "lts": false}]`)
+		case "/nightly/index.json":
+			fmt.Fprint(w, `[]`)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	defer func(dist, rc, nightly string) { nodeDistURL, nodeRCURL, nodeNightlyURL = dist, rc, nightly }(nodeDistURL, nodeRCURL, nodeNightlyURL)
+	nodeDistURL, nodeRCURL, nodeNightlyURL = server.URL+"/dist", server.URL+"/rc", server.URL+"/nightly"
+
+	cases := map[string]string{
+		"latest": "13.0.1",
+		"lts":    "12.13.0",
+		"rc":     "14.0.0-rc.1",
+	}
+	for tag, version := range cases {
+		v, err := distTagVersion(tag)
+		if assert.Nil(t, err, tag) {
+			assert.Equal(t, v, version, tag)
+		}
+	}
+
+	_, err := distTagVersion("nightly")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "No version of node is tagged nightly")
+	}
+	_, err = distTagVersion("next")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Unknown dist-tag: next, expected latest, lts, rc, or nightly")
+	}
+
+	// the tagged version is then resolved exactly, like any other
+	v, _ := distTagVersion("lts")
+	sources := []source{staticSource{releases: genReleasesFromArray([]string{"12.13.0", "13.0.1"})}}
+	result, err := resolveFromSources(sources, "node", v)
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "12.13.0")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+)
+
+// An ETag is the MD5 of the object, unless the object was uploaded in parts,
+// in which case it has a -N suffix and can't be checked against the contents
+var md5ETagRegex = regexp.MustCompile("^[0-9a-f]{32}$")
+
+// Checks a downloaded tarball against what was listed for the release, to
+// catch downloads that were truncated or corrupted. Releases from sources that
+// don't list a size or an MD5 ETag are only checked for what they do list
+//
+// This is unexported because package main can't be imported, so there is no
+// library to export it from. The compile script uses --verify-download instead
+func verifyDownload(path string, rel release) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Could not open download: %s", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if rel.size > 0 && info.Size() != rel.size {
+		return fmt.Errorf("Downloaded %s is %d bytes, expected %d", path, info.Size(), rel.size)
+	}
+
+	if !md5ETagRegex.MatchString(rel.etag) {
+		return nil
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != rel.etag {
+		return fmt.Errorf("Downloaded %s has MD5 %s, expected %s", path, sum, rel.etag)
+	}
+	return nil
+}
+
+// Checks that the tarball of a release can be fetched, and that it is the size
+// the listing says it is. A mirror serving a different Content-Length is
+// serving a different artifact than the one that was listed
+func verifyURL(rel release) error {
+	resp, err := httpClient.Head(rel.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Could not fetch %s: status code %d", rel.url, resp.StatusCode)
+	}
+	if rel.size > 0 && resp.ContentLength >= 0 && resp.ContentLength != rel.size {
+		return fmt.Errorf("%s is served with a Content-Length of %d, but was listed as %d bytes", rel.url, resp.ContentLength, rel.size)
+	}
+	return nil
+}
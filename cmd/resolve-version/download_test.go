@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.tar.gz")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("node tarball"), 0644))
+
+	// printf "node tarball" | md5sum
+	etag := "03a62f29395321b34b16952b4b5be39e"
+	rel := parseObjects([]s3Object{{
+		Key:  "node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz",
+		ETag: `"` + etag + `"`,
+		Size: 12,
+	}})[0]
+	assert.Equal(t, rel.size, int64(12))
+	assert.Equal(t, rel.etag, etag)
+	assert.Nil(t, verifyDownload(path, rel))
+
+	// a truncated download
+	assert.Nil(t, ioutil.WriteFile(path, []byte("node tar"), 0644))
+	err = verifyDownload(path, rel)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Downloaded "+path+" is 8 bytes, expected 12")
+	}
+
+	// a corrupted one of the right size
+	assert.Nil(t, ioutil.WriteFile(path, []byte("node tarbal!"), 0644))
+	err = verifyDownload(path, rel)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "expected "+etag)
+	}
+
+	// multipart ETags aren't an MD5 of the contents, so only the size is checked
+	rel.etag = etag + "-2"
+	assert.Nil(t, verifyDownload(path, rel))
+
+	// and sources that don't list either can't be checked at all
+	assert.Nil(t, verifyDownload(path, release{}))
+
+	assert.NotNil(t, verifyDownload(filepath.Join(dir, "missing"), rel))
+}
+
+func TestVerifyURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/node-v12.13.0-linux-x64.tar.gz":
+			w.Header().Set("Content-Length", "100")
+		case "/truncated.tar.gz":
+			w.Header().Set("Content-Length", "60")
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	rel := release{url: server.URL + "/node-v12.13.0-linux-x64.tar.gz", size: 100}
+	assert.Nil(t, verifyURL(rel))
+
+	// sources that don't list a size are only checked for a 200
+	assert.Nil(t, verifyURL(release{url: server.URL + "/truncated.tar.gz"}))
+
+	err := verifyURL(release{url: server.URL + "/truncated.tar.gz", size: 100})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), server.URL+"/truncated.tar.gz is served with a Content-Length of 60, but was listed as 100 bytes")
+	}
+
+	err = verifyURL(release{url: server.URL + "/missing.tar.gz", size: 100})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not fetch "+server.URL+"/missing.tar.gz: status code 404")
+	}
+
+	// the size listed in the bucket is what is compared
+	objects := genNodeS3ObjectList([]string{"12.13.0"}, []string{}, "linux-x64")
+	listed := parseObjects(objects)
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, listed[0].size, int64(objects[0].Size))
+	}
+}
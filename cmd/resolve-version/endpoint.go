@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// An S3-compatible object store that heroku-nodebin is mirrored to, like MinIO
+// or Cloudflare R2, or nil to list the bucket on S3 itself
+var binariesEndpoint *s3CompatibleEndpoint
+
+type s3CompatibleEndpoint struct {
+	base *url.URL
+	// path-style addresses the bucket as https://host/bucket, which is what
+	// most self-hosted stores expect. Otherwise it's addressed as
+	// https://bucket.host, like S3 does
+	pathStyle bool
+}
+
+// Reads the S3-compatible store to list releases from out of the environment:
+//
+//	NODE_BINARIES_ENDPOINT    the URL of the store, like https://minio.example.com
+//	NODE_BINARIES_ADDRESSING  path to address the bucket as https://host/bucket,
+//	                          the default, or virtual for https://bucket.host
+//
+// Releases are then downloaded from the store too, unless
+// NODE_RESOLVE_URL_TEMPLATE says otherwise
+func binariesEndpointFromEnv() (*s3CompatibleEndpoint, error) {
+	raw := os.Getenv("NODE_BINARIES_ENDPOINT")
+	addressing := os.Getenv("NODE_BINARIES_ADDRESSING")
+	if raw == "" {
+		if addressing != "" {
+			return nil, fmt.Errorf("NODE_BINARIES_ADDRESSING requires NODE_BINARIES_ENDPOINT to be set")
+		}
+		return nil, nil
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" || base.RawQuery != "" {
+		return nil, fmt.Errorf("Invalid NODE_BINARIES_ENDPOINT: %s, expected a URL like https://minio.example.com", raw)
+	}
+
+	endpoint := &s3CompatibleEndpoint{base: base, pathStyle: true}
+	switch addressing {
+	case "", "path":
+	case "virtual":
+		endpoint.pathStyle = false
+	default:
+		return nil, fmt.Errorf("Invalid NODE_BINARIES_ADDRESSING: %s, expected path or virtual", addressing)
+	}
+	return endpoint, nil
+}
+
+// Returns the URL that the bucket is listed at
+func (e *s3CompatibleEndpoint) bucketURL(bucketName string) string {
+	// built by hand rather than with url.URL.String, which would escape the
+	// braces when this is called with {bucket} by urlTemplate
+	host, path := e.base.Host, e.base.EscapedPath()
+	if e.pathStyle {
+		path += "/" + bucketName
+	} else {
+		host = bucketName + "." + host
+	}
+	return e.base.Scheme + "://" + host + path
+}
+
+// Returns the NODE_RESOLVE_URL_TEMPLATE that downloads releases from the
+// store
+func (e *s3CompatibleEndpoint) urlTemplate() string {
+	return e.bucketURL("{bucket}") + "/{key}"
+}
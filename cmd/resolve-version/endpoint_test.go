@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinariesEndpointFromEnv(t *testing.T) {
+	defer os.Unsetenv("NODE_BINARIES_ENDPOINT")
+	defer os.Unsetenv("NODE_BINARIES_ADDRESSING")
+
+	endpoint, err := binariesEndpointFromEnv()
+	assert.Nil(t, err)
+	assert.Nil(t, endpoint)
+
+	os.Setenv("NODE_BINARIES_ENDPOINT", "https://minio.example.com/")
+	endpoint, err = binariesEndpointFromEnv()
+	if assert.Nil(t, err) {
+		assert.Equal(t, endpoint.bucketURL("heroku-nodebin"), "https://minio.example.com/heroku-nodebin")
+		assert.Equal(t, endpoint.urlTemplate(), "https://minio.example.com/{bucket}/{key}")
+	}
+
+	os.Setenv("NODE_BINARIES_ENDPOINT", "https://abc123.r2.cloudflarestorage.com")
+	os.Setenv("NODE_BINARIES_ADDRESSING", "virtual")
+	endpoint, err = binariesEndpointFromEnv()
+	if assert.Nil(t, err) {
+		assert.Equal(t, endpoint.bucketURL("heroku-nodebin"), "https://heroku-nodebin.abc123.r2.cloudflarestorage.com")
+		assert.Equal(t, endpoint.urlTemplate(), "https://{bucket}.abc123.r2.cloudflarestorage.com/{key}")
+	}
+
+	os.Setenv("NODE_BINARIES_ADDRESSING", "dns")
+	_, err = binariesEndpointFromEnv()
+	assert.EqualError(t, err, "Invalid NODE_BINARIES_ADDRESSING: dns, expected path or virtual")
+
+	os.Setenv("NODE_BINARIES_ENDPOINT", "minio.example.com")
+	os.Setenv("NODE_BINARIES_ADDRESSING", "path")
+	_, err = binariesEndpointFromEnv()
+	assert.EqualError(t, err, "Invalid NODE_BINARIES_ENDPOINT: minio.example.com, expected a URL like https://minio.example.com")
+
+	os.Unsetenv("NODE_BINARIES_ENDPOINT")
+	_, err = binariesEndpointFromEnv()
+	assert.EqualError(t, err, "NODE_BINARIES_ADDRESSING requires NODE_BINARIES_ENDPOINT to be set")
+}
+
+func TestResolveFromMinIO(t *testing.T) {
+	defer func(original *s3CompatibleEndpoint) { binariesEndpoint = original }(binariesEndpoint)
+	defer func(original string) { urlTemplate = original }(urlTemplate)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	// two pages of a path-style listing, as MinIO serves them
+	pages := []string{}
+	for _, name := range []string{"testdata/minio-list-objects-page-1.xml", "testdata/minio-list-objects-page-2.xml"} {
+		fixture, err := ioutil.ReadFile(name)
+		if !assert.Nil(t, err) {
+			return
+		}
+		pages = append(pages, string(fixture))
+	}
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Query().Get("continuation-token") == "MTdhNjQ1ZDEtYmFlMS00ZDk1LWI1YzMtNWNjYjVkMWIxYmI5OjE3MjA0NzIxNjQ=" {
+			w.Write([]byte(pages[1]))
+			return
+		}
+		w.Write([]byte(pages[0]))
+	}))
+	defer server.Close()
+
+	os.Setenv("NODE_BINARIES_ENDPOINT", server.URL)
+	defer os.Unsetenv("NODE_BINARIES_ENDPOINT")
+	var err error
+	binariesEndpoint, err = binariesEndpointFromEnv()
+	if !assert.Nil(t, err) {
+		return
+	}
+	urlTemplate = binariesEndpoint.urlTemplate()
+
+	// >=18 can't be narrowed to a major, so the whole node prefix is listed
+	result, err := resolveFromSources([]source{defaultSource()}, "node", ">=18")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "20.15.1")
+		assert.Equal(t, result.release.url, server.URL+"/heroku-nodebin/node/release/linux-x64/node-v20.15.1-linux-x64.tar.gz")
+		assert.Equal(t, result.release.etag, "e56d4cc5de9e101b6bc1fe05e5776c85")
+	}
+	assert.Equal(t, paths, []string{"/heroku-nodebin", "/heroku-nodebin"})
+
+	releases, err := defaultSource().List("node")
+	if assert.Nil(t, err) && assert.Len(t, releases, 4) {
+		assert.Equal(t, releases[0].url, server.URL+"/heroku-nodebin/node/release/linux-x64/node-v18.19.1-linux-x64.tar.gz")
+	}
+
+	// and the URLs a mirror resolves to can be looked up again
+	rel, err := releaseByURL(server.URL+"/heroku-nodebin/node/release/linux-x64/node-v18.20.4-linux-x64.tar.gz", false)
+	if assert.Nil(t, err) {
+		assert.Equal(t, rel.version.String(), "18.20.4")
+		assert.Equal(t, rel.platform, "linux-x64")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var envNameRegex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// What a --shell-prefix has to look like to be the start of a variable name
+var shellPrefixRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// How --env and --shell write assignments
+type envFormat struct {
+	// exports each variable, for --shell
+	export bool
+	// what variable names start with, for --shell-prefix, instead of the
+	// name of the binary
+	prefix string
+}
+
+func envFormatFor(opts options) envFormat {
+	return envFormat{export: opts.shell, prefix: opts.shellPrefix}
+}
+
+// Writes one assignment, like NODE_VERSION='12.13.0', or with export in front
+// of it for --shell
+func (f envFormat) assign(out *strings.Builder, binary string, name string, value string) {
+	prefix := f.prefix
+	if prefix == "" {
+		prefix = envNameRegex.ReplaceAllString(strings.ToUpper(binary), "_")
+	}
+	if f.export {
+		out.WriteString("export ")
+	}
+	fmt.Fprintf(out, "%s_%s=%s\n", prefix, name, shellQuote(value))
+}
+
+// Checks a --shell-prefix, which is written into the output unquoted
+func validateShellPrefix(prefix string) error {
+	if !shellPrefixRegex.MatchString(prefix) {
+		return fmt.Errorf("Invalid --shell-prefix: %s, expected the start of a shell variable name, like NODE", prefix)
+	}
+	return nil
+}
+
+// Renders a resolved release as assignments a shell can eval, named after the
+// binary so that several binaries can share one block:
+//
+//	NODE_VERSION='12.13.0'
+//	NODE_URL='https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz'
+func envExports(f envFormat, binary string, rel release, withHeaders bool) string {
+	var out strings.Builder
+	f.assign(&out, binary, "VERSION", rel.version.String())
+	f.assign(&out, binary, "URL", rel.url)
+	if withHeaders {
+		f.assign(&out, binary, "HEADERS_URL", headersURL(rel))
+	}
+	return out.String()
+}
+
+// Renders the S3 metadata of a release for --include-metadata, like
+//
+//	NODE_ETAG='abcdef'
+//	NODE_STORAGE_CLASS='STANDARD'
+//	NODE_SIZE='100'
+func envMetadata(f envFormat, binary string, rel release) string {
+	var out strings.Builder
+	f.assign(&out, binary, "ETAG", rel.etag)
+	f.assign(&out, binary, "STORAGE_CLASS", rel.storageClass)
+	f.assign(&out, binary, "SIZE", fmt.Sprint(rel.size))
+	return out.String()
+}
+
+// Renders the hash a packageManager field pins for --with-integrity, like
+//
+//	YARN_INTEGRITY='sha224.953c8233f7a92884eee2de69a1b92d1f2ec1655e66d08071ba9a02fa'
+func envIntegrity(f envFormat, binary string, integrity string) string {
+	var out strings.Builder
+	f.assign(&out, binary, "INTEGRITY", integrity)
+	return out.String()
+}
+
+// Single quotes s for a POSIX shell. Nothing is special inside single quotes,
+// so the only thing to escape is a single quote itself
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
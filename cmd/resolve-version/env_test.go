@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, shellQuote("12.13.0"), "'12.13.0'")
+	assert.Equal(t, shellQuote(""), "''")
+	assert.Equal(t, shellQuote("it's $HOME `id`"), `'it'\''s $HOME `+"`id`"+`'`)
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to eval the quoted value with")
+	}
+	value := "a'b\"c $HOME `id` \\n;"
+	out, err := exec.Command(sh, "-c", "eval \"X=$0\"; printf %s \"$X\"", shellQuote(value)).Output()
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), value)
+}
+
+func TestResolveEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "env")
+
+	sources := []source{staticSource{releases: parseObjects(genNodeS3ObjectList([]string{"12.13.0"}, []string{}, "linux-x64"))}}
+	assert.Nil(t, resolveWithSources(sources, "node", "12.x", options{outputFile: path, env: true, withHeaders: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "NODE_VERSION='12.13.0'\n"+
+		"NODE_URL='https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz'\n"+
+		"NODE_HEADERS_URL='https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-headers.tar.gz'\n")
+
+	err = resolveWithSources(sources, "node", "12.x", options{outputFile: path, env: true, json: true})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "--env can't be used with --json or --print-url-only")
+	}
+
+	// every binary in a batch gets its own prefix
+	results, failures := resolveAllRequirements(requirementList{
+		{binary: "node", versionRequirement: "12.x"},
+		{binary: "yarn", versionRequirement: "1.x"},
+	}, testSourcesFor)
+	assert.Len(t, failures, 0)
+	assert.Nil(t, printBatchResults(results, failures, options{outputFile: path, env: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "NODE_VERSION='12.13.0'\n"+
+		"NODE_URL='https://heroku.com'\n"+
+		"YARN_VERSION='1.19.1'\n"+
+		"YARN_URL='https://heroku.com'\n")
+}
+
+func TestResolveShell(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "env")
+
+	// a mirror's URL can contain anything a shell would otherwise expand
+	url := "https://mirror.example.com/it's $HOME/`id`/a b;c\"d\\/node-v18.20.4-linux-x64.tar.gz"
+	rels := genReleasesFromArray([]string{"18.20.4"})
+	rels[0].url = url
+	sources := []source{staticSource{releases: rels}}
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	assert.Nil(t, resolveWithSources(sources, "node", "18.x", options{outputFile: path, env: true, shell: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "export NODE_VERSION='18.20.4'\n"+
+		"export NODE_URL='https://mirror.example.com/it'\\''s $HOME/`id`/a b;c\"d\\/node-v18.20.4-linux-x64.tar.gz'\n")
+
+	assert.Nil(t, resolveWithSources(sources, "node", "18.x", options{outputFile: path, env: true, shell: true, shellPrefix: "BUILDPACK_NODE"}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "export BUILDPACK_NODE_VERSION='18.20.4'\n"+
+		"export BUILDPACK_NODE_URL='https://mirror.example.com/it'\\''s $HOME/`id`/a b;c\"d\\/node-v18.20.4-linux-x64.tar.gz'\n")
+
+	// what's eval'd is exported with exactly the value that was resolved
+	if sh, err := exec.LookPath("sh"); err == nil {
+		out, err := exec.Command(sh, "-c", `eval "$0"; sh -c 'printf "%s|%s" "$BUILDPACK_NODE_VERSION" "$BUILDPACK_NODE_URL"'`, string(contents)).Output()
+		assert.Nil(t, err)
+		assert.Equal(t, string(out), "18.20.4|"+url)
+	}
+
+	for _, prefix := range []string{"NODE-18", "1NODE", "NODE;rm", "$NODE", "NODE VERSION"} {
+		err := resolveWithSources(sources, "node", "18.x", options{outputFile: path, env: true, shell: true, shellPrefix: prefix})
+		assert.EqualError(t, err, "Invalid --shell-prefix: "+prefix+", expected the start of a shell variable name, like NODE", prefix)
+	}
+	err = resolveWithSources(sources, "node", "18.x", options{outputFile: path, shellPrefix: "NODE"})
+	assert.EqualError(t, err, "--shell-prefix requires --shell or --env")
+
+	// a batch exports each binary under its own name
+	results, failures := resolveAllRequirements(requirementList{
+		{binary: "node", versionRequirement: "12.x"},
+		{binary: "yarn", versionRequirement: "1.x"},
+	}, testSourcesFor)
+	assert.Len(t, failures, 0)
+	assert.Nil(t, printBatchResults(results, failures, options{outputFile: path, env: true, shell: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "export NODE_VERSION='12.13.0'\n"+
+		"export NODE_URL='https://heroku.com'\n"+
+		"export YARN_VERSION='1.19.1'\n"+
+		"export YARN_URL='https://heroku.com'\n")
+}
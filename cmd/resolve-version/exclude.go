@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// Matches the versions given to --exclude, which are never resolved to, or nil
+// when nothing is excluded
+var excludedVersions semver.Range
+
+// Parses a comma separated --exclude list. Each entry is an exact version,
+// like 18.17.0, or a range, like ">=18.17.0 <18.18.0", and is parsed with the
+// same --semver-mode as requirements
+func parseExclusions(value string) (semver.Range, error) {
+	var excluded semver.Range
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		r, err := parseRequirement(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --exclude: %s (%s)", entry, err)
+		}
+		if excluded == nil {
+			excluded = r
+		} else {
+			excluded = excluded.OR(r)
+		}
+	}
+	return excluded, nil
+}
+
+// Whether --exclude rules out a version
+func isExcluded(version semver.Version) bool {
+	return excludedVersions != nil && excludedVersions(version)
+}
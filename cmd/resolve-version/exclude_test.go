@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExclusions(t *testing.T) {
+	excluded, err := parseExclusions("18.17.0, >=18.18.0 <18.18.2,,")
+	if assert.Nil(t, err) {
+		for version, want := range map[string]bool{
+			"18.17.0": true,
+			"18.17.1": false,
+			"18.18.0": true,
+			"18.18.1": true,
+			"18.18.2": false,
+		} {
+			assert.Equal(t, excluded(semver.MustParse(version)), want, version)
+		}
+	}
+
+	excluded, err = parseExclusions("")
+	assert.Nil(t, err)
+	assert.Nil(t, excluded)
+
+	_, err = parseExclusions("18.17.0,not-a-version")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Invalid --exclude: not-a-version")
+	}
+}
+
+func TestResolveNodeExcluded(t *testing.T) {
+	defer func(original semver.Range) { excludedVersions = original }(excludedVersions)
+	releases := genReleasesFromArray([]string{"18.16.1", "18.17.0", "18.17.1", "18.18.0", "18.18.1"})
+
+	var err error
+	excludedVersions, err = parseExclusions("18.18.1,>=18.18.0 <18.18.1")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the highest matches are excluded, so the newest one that isn't is used
+	result, err := resolveNode(releases, "linux-x64", "^18")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "18.17.1")
+	}
+
+	// even when asked for exactly
+	result, err = resolveNode(releases, "linux-x64", "18.18.1")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+
+	// and staging builds, which are only used for exact versions, are
+	// excluded the same way
+	staging := append(releases, release{binary: "node", stage: "staging", platform: "linux-x64", version: semver.MustParse("18.18.1")})
+	result, err = resolveNode(staging, "linux-x64", "18.18.1")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+
+	excludedVersions = nil
+	result, err = resolveNode(releases, "linux-x64", "^18")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "18.18.1")
+	}
+}
+
+func TestClosestReleasesExcluded(t *testing.T) {
+	defer func(original semver.Range) { excludedVersions = original }(excludedVersions)
+	releases := genReleasesFromArray([]string{"18.16.1", "18.17.0", "18.18.0"})
+
+	var err error
+	excludedVersions, err = parseExclusions("18.17.0")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// an excluded version is neither suggested nor used in place of a
+	// missing one
+	result, err := resolveNode(releases, "linux-x64", "18.17.1")
+	if assert.Nil(t, err) && assert.False(t, result.matched) {
+		assert.Equal(t, describeReleases(result.closest), "18.16.1 and 18.18.0")
+		nearest, ok := nearestRelease(result.closest, semver.MustParse("18.17.1"))
+		if assert.True(t, ok) {
+			assert.Equal(t, nearest.version.String(), "18.16.1")
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Watches the listings of a binary for --follow, printing each new version
+// that satisfies the requirement as it's published
+type follower struct {
+	sources            []source
+	binary             string
+	versionRequirement string
+	platform           string
+	json               bool
+	out                io.Writer
+
+	// the versions that were already listed, which are never printed again
+	seen map[string]bool
+	// false until the first listing, whose versions are only recorded
+	listed bool
+}
+
+// Lists the releases once, printing a line for each version that matches the
+// requirement and wasn't listed before. The first listing only records what's
+// already published, so that only versions that appear while following are
+// printed
+func (f *follower) poll() error {
+	constraints, err := parseRequirement(f.versionRequirement)
+	if err != nil {
+		return err
+	}
+	candidates, err := listCandidates(f.binary, f.sources, f.platform)
+	if err != nil {
+		return err
+	}
+	candidates = publishedAsOf(candidates)
+	if f.binary == "node" {
+		candidates, _ = capReleases(candidates)
+	}
+
+	builds := map[string][]release{}
+	for _, rel := range candidates {
+		version := rel.version.String()
+		if f.seen[version] || stageRank(rel.stage) == len(stagePreference) {
+			continue
+		}
+		if !constraints(rel.version) || isExcluded(rel.version) {
+			continue
+		}
+		builds[version] = append(builds[version], rel)
+	}
+
+	added := []release{}
+	for version, rels := range builds {
+		f.seen[version] = true
+		// the build that resolving this exact version would pick
+		result, err := matchReleaseSemver(rels, version)
+		if err != nil {
+			return err
+		}
+		if result.matched {
+			added = append(added, result.release)
+		}
+	}
+	if !f.listed {
+		f.listed = true
+		return nil
+	}
+
+	sort.Slice(added, func(i, j int) bool {
+		return versionLess(added[i].version, added[j].version)
+	})
+	for _, rel := range added {
+		if err := f.print(rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *follower) print(rel release) error {
+	if f.json {
+		data, err := json.Marshal(binaryEntry{Binary: f.binary, Version: rel.version.String(), URL: rel.url})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(f.out, "%s\n", data)
+		return err
+	}
+	_, err := fmt.Fprintf(f.out, "%s %s %s\n", f.binary, rel.version.String(), rel.url)
+	return err
+}
+
+// Polls every interval until ctx is done. Only the first listing has to
+// succeed, later ones that fail are retried at the next interval
+func (f *follower) run(ctx context.Context, interval time.Duration) error {
+	if err := f.poll(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := f.poll(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not list %s: %s\n", f.binary, err)
+			}
+		}
+	}
+}
+
+// Runs --follow until it's interrupted, or for --follow-for when it's set
+func follow(binary string, versionRequirement string, opts options) error {
+	sources := sourcesFor(binary, opts.source)
+	if len(sources) == 0 {
+		return fmt.Errorf("Unknown binary: %s", binary)
+	}
+	if opts.followInterval <= 0 {
+		return fmt.Errorf("--follow-interval must be positive, got %s", opts.followInterval)
+	}
+	versionRequirement = normalizeRequirement(versionRequirement)
+	if _, err := parseRequirement(versionRequirement); err != nil {
+		return fmt.Errorf("Invalid version requirement for %s: %s (%s)", binary, versionRequirement, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if opts.followFor > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.followFor)
+		defer cancel()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	f := &follower{
+		sources:            sources,
+		binary:             binary,
+		versionRequirement: versionRequirement,
+		platform:           getPlatform(),
+		json:               opts.json,
+		out:                os.Stdout,
+		seen:               map[string]bool{},
+	}
+	fmt.Fprintf(os.Stderr, "Following %s %s every %s\n", binary, versionRequirement, opts.followInterval)
+	return f.run(ctx, opts.followInterval)
+}
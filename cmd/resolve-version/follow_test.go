@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns each listing in turn, and then the last one from then on
+type sequenceSource struct {
+	listings [][]release
+	errs     []error
+	calls    int
+}
+
+func (s *sequenceSource) List(prefix string) ([]release, error) {
+	i := s.calls
+	if i >= len(s.listings) {
+		i = len(s.listings) - 1
+	}
+	s.calls++
+	if i < len(s.errs) && s.errs[i] != nil {
+		return nil, s.errs[i]
+	}
+	return s.listings[i], nil
+}
+
+func TestFollowerPoll(t *testing.T) {
+	first := genReleasesFromArray([]string{"20.14.0", "20.15.0", "22.3.0"})
+	second := genReleasesFromArray([]string{"20.14.0", "20.15.0", "20.15.1", "22.3.0", "22.4.0"})
+	src := &sequenceSource{listings: [][]release{first, second}}
+
+	var out bytes.Buffer
+	f := &follower{sources: []source{src}, binary: "node", versionRequirement: "20.x", platform: "linux-x64", out: &out, seen: map[string]bool{}}
+
+	// what's already published isn't printed
+	assert.Nil(t, f.poll())
+	assert.Equal(t, out.String(), "")
+
+	// only the new version that matches the requirement is
+	assert.Nil(t, f.poll())
+	assert.Equal(t, out.String(), "node 20.15.1 https://heroku.com\n")
+
+	// and it's not printed again when it's listed again
+	assert.Nil(t, f.poll())
+	assert.Equal(t, out.String(), "node 20.15.1 https://heroku.com\n")
+	assert.Equal(t, src.calls, 3)
+}
+
+func TestFollowerPollJSON(t *testing.T) {
+	src := &sequenceSource{listings: [][]release{
+		genReleasesFromArray([]string{"22.3.0"}),
+		genReleasesFromArray([]string{"22.3.0", "22.5.0", "22.4.0"}),
+	}}
+	var out bytes.Buffer
+	f := &follower{sources: []source{src}, binary: "node", versionRequirement: ">=22", platform: "linux-x64", json: true, out: &out, seen: map[string]bool{}}
+
+	assert.Nil(t, f.poll())
+	assert.Nil(t, f.poll())
+	// several versions that appear at once are printed oldest first
+	assert.Equal(t, out.String(), `{"binary":"node","version":"22.4.0","url":"https://heroku.com"}
+{"binary":"node","version":"22.5.0","url":"https://heroku.com"}
+`)
+}
+
+func TestFollowerRun(t *testing.T) {
+	src := &sequenceSource{
+		listings: [][]release{
+			genReleasesFromArray([]string{"20.15.0"}),
+			nil,
+			genReleasesFromArray([]string{"20.15.0", "20.15.1"}),
+		},
+		// a listing that fails while following is retried
+		errs: []error{nil, errors.New("Unexpected status code: 503")},
+	}
+	var out bytes.Buffer
+	f := &follower{sources: []source{src}, binary: "node", versionRequirement: "20.x", platform: "linux-x64", out: &out, seen: map[string]bool{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, f.run(ctx, time.Millisecond))
+	assert.Equal(t, out.String(), "node 20.15.1 https://heroku.com\n")
+	assert.True(t, src.calls > 3)
+
+	// but the first listing has to succeed
+	failing := &sequenceSource{listings: [][]release{nil}, errs: []error{errors.New("Unexpected status code: 403")}}
+	f = &follower{sources: []source{failing}, binary: "node", versionRequirement: "20.x", platform: "linux-x64", out: &out, seen: map[string]bool{}}
+	assert.EqualError(t, f.run(context.Background(), time.Millisecond), "Unexpected status code: 403")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmorrell/semver"
+)
+
+// yarn 2+ ("berry") isn't mirrored in heroku-nodebin, so newer releases are
+// resolved against the GitHub releases of the yarnpkg/berry repo instead
+const yarnBerryRepo = "yarnpkg/berry"
+const yarnBerryTagPrefix = "@yarnpkg/cli/"
+
+var githubAPIURL = "https://api.github.com"
+
+// The repository given to --repo, which --source github lists releases of
+var githubRepo string
+
+var githubRepoRegex = regexp.MustCompile("^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$")
+var githubTagRegex = regexp.MustCompile("v?([0-9]+\\.[0-9]+\\.[0-9]+)$")
+
+// Like githubTagRegex, but also matching prerelease versions like 2.1.0-rc.1
+var githubPrereleaseTagRegex = regexp.MustCompile("v?([0-9]+\\.[0-9]+\\.[0-9]+(-[0-9A-Za-z.-]+)?)$")
+var githubPlatformRegex = regexp.MustCompile("(linux|darwin|win)-(x64|x86|arm64|armv7l|ppc64le|s390x)")
+var githubNextLinkRegex = regexp.MustCompile("<([^>]+)>;\\s*rel=\"next\"")
+
+type githubRelease struct {
+	TagName    string        `json:"tag_name"`
+	Draft      bool          `json:"draft"`
+	Prerelease bool          `json:"prerelease"`
+	Assets     []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// Query the GitHub API for every release of a repository. This follows the
+// Link headers the API uses to page through results, and will authenticate
+// with GITHUB_TOKEN if it is set to avoid the low anonymous rate limit
+func listGitHubReleases(repo string) ([]githubRelease, error) {
+	defer recordTiming(fmt.Sprintf("listing GitHub releases of %s", repo), time.Now())
+
+	out := []githubRelease{}
+	next := fmt.Sprintf("%s/repos/%s/releases?per_page=100", githubAPIURL, repo)
+
+	for next != "" {
+		req, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+		if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "token "+token)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" && (resp.StatusCode == 403 || resp.StatusCode == 429) {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GitHub API rate limit exceeded for %s, resets at %s", repo, rateLimitReset(resp))
+		}
+
+		if resp.StatusCode >= 300 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Unexpected status code: %d for listing GitHub releases: %s", resp.StatusCode, repo)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var page []githubRelease
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		out = append(out, page...)
+
+		next = ""
+		if match := githubNextLinkRegex.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+			next = match[1]
+		}
+	}
+
+	return out, nil
+}
+
+func rateLimitReset(resp *http.Response) string {
+	var seconds int64
+	if _, err := fmt.Sscanf(resp.Header.Get("X-RateLimit-Reset"), "%d", &seconds); err != nil {
+		return "an unknown time"
+	}
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+// Maps GitHub releases into releases of a binary. Only tags that start with
+// tagPrefix and end in a semver version are considered, and the first
+// tarball attached to the release is used as its download
+func parseGitHubReleases(binary string, tagPrefix string, ghReleases []githubRelease) []release {
+	out := []release{}
+	for _, ghRelease := range ghReleases {
+		if ghRelease.Draft || !strings.HasPrefix(ghRelease.TagName, tagPrefix) {
+			continue
+		}
+
+		match := githubTagRegex.FindStringSubmatch(strings.TrimPrefix(ghRelease.TagName, tagPrefix))
+		if match == nil {
+			continue
+		}
+		version, err := semver.Make(match[1])
+		if err != nil {
+			continue
+		}
+
+		for _, asset := range ghRelease.Assets {
+			if strings.HasSuffix(asset.Name, ".tar.gz") {
+				out = append(out, release{
+					binary:   binary,
+					stage:    "release",
+					platform: "",
+					url:      asset.BrowserDownloadURL,
+					version:  version,
+				})
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Like parseGitHubReleases, except that every tarball attached to a release
+// becomes a release of its own, for the platform named in the asset. Assets
+// that don't name a platform are taken to work on any of them. Releases that
+// GitHub marks as prereleases are given their own stage
+func parseGitHubPlatformReleases(binary string, tagPrefix string, ghReleases []githubRelease) []release {
+	out := []release{}
+	for _, ghRelease := range ghReleases {
+		if ghRelease.Draft || !strings.HasPrefix(ghRelease.TagName, tagPrefix) {
+			continue
+		}
+
+		match := githubPrereleaseTagRegex.FindStringSubmatch(strings.TrimPrefix(ghRelease.TagName, tagPrefix))
+		if match == nil {
+			continue
+		}
+		version, err := semver.Make(match[1])
+		if err != nil {
+			continue
+		}
+		stage := "release"
+		if ghRelease.Prerelease || len(version.Pre) > 0 {
+			stage = "prerelease"
+		}
+
+		for _, asset := range ghRelease.Assets {
+			if !strings.HasSuffix(asset.Name, ".tar.gz") {
+				continue
+			}
+			out = append(out, release{
+				binary:   binary,
+				stage:    stage,
+				platform: githubPlatformRegex.FindString(asset.Name),
+				url:      asset.BrowserDownloadURL,
+				version:  version,
+			})
+		}
+	}
+	return out
+}
+
+// Resolves a binary listed from --source github. Only the builds for the
+// platform, and the ones that work on any platform, are considered, and
+// prereleases have to be asked for by their exact version
+func resolveGitHub(all []release, platform string, versionRequirement string) (matchResult, error) {
+	releases := []release{}
+	for _, rel := range all {
+		if rel.platform == platform || rel.platform == "" {
+			releases = append(releases, rel)
+		}
+	}
+	return resolvePnpm(releases, versionRequirement)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListGitHubReleases(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/repos/yarnpkg/berry/releases")
+		assert.Equal(t, r.Header.Get("Authorization"), "token abc123")
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf("<%s/repos/yarnpkg/berry/releases?per_page=100&page=2>; rel=\"next\"", server.URL))
+			fmt.Fprint(w, `[{"tag_name": "@yarnpkg/cli/4.0.1", "assets": [{"name": "yarn-4.0.1.tar.gz", "browser_download_url": "https://github.com/yarn-4.0.1.tar.gz"}]}]`)
+		} else {
+			fmt.Fprint(w, `[{"tag_name": "@yarnpkg/cli/4.0.0", "assets": [{"name": "yarn-4.0.0.tar.gz", "browser_download_url": "https://github.com/yarn-4.0.0.tar.gz"}]}]`)
+		}
+	}))
+	defer server.Close()
+
+	defer func(original string) { githubAPIURL = original }(githubAPIURL)
+	githubAPIURL = server.URL
+	os.Setenv("GITHUB_TOKEN", "abc123")
+	defer os.Unsetenv("GITHUB_TOKEN")
+
+	releases, err := listGitHubReleases("yarnpkg/berry")
+	if assert.Nil(t, err) && assert.Len(t, releases, 2) {
+		assert.Equal(t, releases[0].TagName, "@yarnpkg/cli/4.0.1")
+		assert.Equal(t, releases[1].TagName, "@yarnpkg/cli/4.0.0")
+	}
+}
+
+func TestListGitHubReleasesRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", "1572000000")
+		w.WriteHeader(403)
+	}))
+	defer server.Close()
+
+	defer func(original string) { githubAPIURL = original }(githubAPIURL)
+	githubAPIURL = server.URL
+
+	releases, err := listGitHubReleases("yarnpkg/berry")
+	assert.Nil(t, releases)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "GitHub API rate limit exceeded for yarnpkg/berry, resets at 2019-10-25T10:40:00Z")
+	}
+}
+
+func TestParseGitHubReleases(t *testing.T) {
+	ghReleases := []githubRelease{
+		{TagName: "@yarnpkg/cli/4.0.2", Assets: []githubAsset{
+			{Name: "checksums.txt", BrowserDownloadURL: "https://github.com/checksums.txt"},
+			{Name: "yarn-4.0.2.tar.gz", BrowserDownloadURL: "https://github.com/yarn-4.0.2.tar.gz"},
+		}},
+		// other packages in the monorepo are ignored
+		{TagName: "@yarnpkg/core/4.0.2", Assets: []githubAsset{
+			{Name: "core-4.0.2.tar.gz", BrowserDownloadURL: "https://github.com/core-4.0.2.tar.gz"},
+		}},
+		// as are drafts, pre-releases, and releases without a tarball
+		{TagName: "@yarnpkg/cli/4.1.0", Draft: true, Assets: []githubAsset{
+			{Name: "yarn-4.1.0.tar.gz", BrowserDownloadURL: "https://github.com/yarn-4.1.0.tar.gz"},
+		}},
+		{TagName: "@yarnpkg/cli/4.1.0-rc.1", Assets: []githubAsset{
+			{Name: "yarn-4.1.0-rc.1.tar.gz", BrowserDownloadURL: "https://github.com/yarn-4.1.0-rc.1.tar.gz"},
+		}},
+		{TagName: "@yarnpkg/cli/4.0.1"},
+	}
+
+	releases := parseGitHubReleases("yarn", yarnBerryTagPrefix, ghReleases)
+	if assert.Len(t, releases, 1) {
+		assert.Equal(t, releases[0].binary, "yarn")
+		assert.Equal(t, releases[0].stage, "release")
+		assert.Equal(t, releases[0].version.String(), "4.0.2")
+		assert.Equal(t, releases[0].url, "https://github.com/yarn-4.0.2.tar.gz")
+	}
+
+	result, err := matchReleaseSemver(releases, ">=4")
+	if assert.Nil(t, err) {
+		assert.True(t, result.matched)
+		assert.Equal(t, result.release.version.String(), "4.0.2")
+	}
+}
+
+func TestResolveGitHubSource(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/github-releases.json")
+	if !assert.Nil(t, err) {
+		return
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/repos/example/tool/releases")
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	defer func(original string) { githubAPIURL = original }(githubAPIURL)
+	githubAPIURL = server.URL
+	defer func(original string) { githubRepo = original }(githubRepo)
+	githubRepo = "example/tool"
+	defer func(original string) { platformOverride = original }(platformOverride)
+
+	cases := []struct {
+		platform    string
+		requirement string
+		version     string
+		url         string
+	}{
+		{"linux-x64", "2.x", "2.0.1", "https://github.com/example/tool/releases/download/v2.0.1/tool-2.0.1-linux-x64.tar.gz"},
+		{"darwin-arm64", "2.x", "2.0.1", "https://github.com/example/tool/releases/download/v2.0.1/tool-2.0.1-darwin-arm64.tar.gz"},
+		// 2.0.0 only has a darwin-arm64 build
+		{"linux-x64", "2.0.0", "", ""},
+		// a tarball without a platform works anywhere
+		{"linux-x64", "1.x", "1.9.0", "https://github.com/example/tool/releases/download/v1.9.0/tool-1.9.0.tar.gz"},
+		// prereleases have to be asked for by their exact version
+		{"linux-x64", ">=2.1.0-rc.0", "", ""},
+		{"linux-x64", "2.1.0-rc.1", "2.1.0-rc.1", "https://github.com/example/tool/releases/download/v2.1.0-rc.1/tool-2.1.0-rc.1-linux-x64.tar.gz"},
+		// drafts are ignored
+		{"linux-x64", "3.x", "", ""},
+	}
+
+	for _, c := range cases {
+		platformOverride = c.platform
+		result, err := resolveFromSources(sourcesFor("tool", "github"), "tool", c.requirement)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Equal(t, result.matched, c.version != "", c.requirement)
+		if result.matched {
+			assert.Equal(t, result.release.version.String(), c.version)
+			assert.Equal(t, result.release.url, c.url)
+		}
+	}
+}
+
+func TestResolveYarnBerryFallback(t *testing.T) {
+	requests := 0
+	status := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		fmt.Fprint(w, `[{"tag_name": "@yarnpkg/cli/4.0.1", "assets": [{"name": "yarn-4.0.1.tar.gz", "browser_download_url": "https://github.com/yarn-4.0.1.tar.gz"}]}]`)
+	}))
+	defer server.Close()
+	defer func(original string) { githubAPIURL = original }(githubAPIURL)
+	githubAPIURL = server.URL
+
+	sources := []source{
+		staticSource{releases: genYarnReleasesFromArray([]string{"1.22.18", "1.22.19"})},
+		githubSource{repo: yarnBerryRepo, tagPrefix: yarnBerryTagPrefix, minimum: "2.0.0"},
+	}
+
+	// requirements that only yarn 1 can meet never reach GitHub
+	for _, requirement := range []string{"1.x", "1.22.17", "<2", "~1.21.0"} {
+		requests = 0
+		result, err := resolveFromSources(sources, "yarn", requirement)
+		assert.Nil(t, err, requirement)
+		assert.False(t, result.matched && result.release.version.Major != 1, requirement)
+		assert.Equal(t, requests, 0, requirement)
+	}
+
+	// while ones that allow yarn 2 and above do
+	for _, requirement := range []string{">=4", "4.0.1", "^4.0.0", ">1.22.19"} {
+		requests = 0
+		result, err := resolveFromSources(sources, "yarn", requirement)
+		if assert.Nil(t, err, requirement) && assert.True(t, result.matched, requirement) {
+			assert.Equal(t, result.release.version.String(), "4.0.1", requirement)
+		}
+		assert.Equal(t, requests, 1, requirement)
+	}
+
+	// and GitHub failing is still just no result, with the closest releases
+	// from S3
+	status = 403
+	result, err := resolveFromSources(sources, "yarn", "1.22.20")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+	result, err = resolveFromSources(sources, "yarn", "4.0.2")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+	assert.Equal(t, describeReleases(result.closest), "1.22.19")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// What is known about the glibc of the host that linux builds are picked for
+type glibcInfo struct {
+	version semver.Version
+	// false when the host doesn't use glibc, or its version couldn't be found
+	known bool
+}
+
+// The host's glibc, from NODE_RESOLVE_GLIBC, or detected the first time a
+// linux build is resolved when this is nil
+var hostGlibc *glibcInfo
+
+// Runs a command that prints the glibc version. Tests replace this
+var glibcCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// The builds of node that aren't qualified with a glibc version need glibc
+// 2.28 from node 18 onwards, and 2.17 before it
+var standardBuildGlibc = []struct {
+	versions semver.Range
+	glibc    semver.Version
+}{
+	{semver.MustParseRange(">=18.0.0"), semver.MustParse("2.28.0")},
+	{semver.MustParseRange("<18.0.0"), semver.MustParse("2.17.0")},
+}
+
+// Reads the glibc that node builds are picked for out of the environment:
+//
+//	NODE_RESOLVE_GLIBC  the glibc version of the host, like 2.17, or none to
+//	                    ignore glibc-qualified builds. Detected from
+//	                    ldd --version when unset
+func glibcFromEnv() (*glibcInfo, error) {
+	value := os.Getenv("NODE_RESOLVE_GLIBC")
+	switch value {
+	case "":
+		return nil, nil
+	case "none":
+		return &glibcInfo{}, nil
+	}
+	version, err := semver.ParseTolerant(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid NODE_RESOLVE_GLIBC: %s, expected a version like 2.28, or none", value)
+	}
+	return &glibcInfo{version: version, known: true}, nil
+}
+
+// Returns the glibc of the host when resolving for platform, which is only
+// known for linux platforms
+func glibcFor(platform string) (semver.Version, bool) {
+	if !strings.HasPrefix(platform, "linux-") {
+		return semver.Version{}, false
+	}
+	if hostGlibc == nil {
+		version, ok := detectGlibc()
+		hostGlibc = &glibcInfo{version: version, known: ok}
+	}
+	return hostGlibc.version, hostGlibc.known
+}
+
+// Finds the host's glibc from ldd --version, or by running libc itself, which
+// also prints its version. Neither says "glibc" on musl, where this fails
+func detectGlibc() (semver.Version, bool) {
+	commands := [][]string{
+		{"ldd", "--version"},
+		{"/lib/x86_64-linux-gnu/libc.so.6"},
+	}
+	for _, command := range commands {
+		out, err := glibcCommand(command[0], command[1:]...)
+		if err != nil {
+			continue
+		}
+		if version, ok := parseGlibcVersion(string(out)); ok {
+			return version, true
+		}
+	}
+	return semver.Version{}, false
+}
+
+var glibcVersionRegex = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// Parses the first line of ldd --version or libc.so.6 output, like
+// "ldd (GNU libc) 2.17" or "GNU C Library (Ubuntu GLIBC 2.35-0ubuntu3.8)
+// stable release version 2.35.", where the version is the last one given
+func parseGlibcVersion(out string) (semver.Version, bool) {
+	line := strings.SplitN(out, "\n", 2)[0]
+	lower := strings.ToLower(line)
+	if !strings.Contains(lower, "glibc") && !strings.Contains(lower, "gnu libc") && !strings.Contains(lower, "gnu c library") {
+		return semver.Version{}, false
+	}
+	matches := glibcVersionRegex.FindAllStringSubmatch(line, -1)
+	if len(matches) == 0 {
+		return semver.Version{}, false
+	}
+	last := matches[len(matches)-1]
+	major, _ := strconv.ParseUint(last[1], 10, 64)
+	minor, _ := strconv.ParseUint(last[2], 10, 64)
+	return semver.Version{Major: major, Minor: minor}, true
+}
+
+// Returns the glibc a build is for when its qualifier names one, like the
+// glibc-217 of node-v18.20.4-linux-x64-glibc-217.tar.gz, which is 2.17
+func glibcBuild(rel release) (semver.Version, bool) {
+	if !strings.HasPrefix(rel.qualifier, "glibc") {
+		return semver.Version{}, false
+	}
+	value := strings.TrimPrefix(strings.TrimPrefix(rel.qualifier, "glibc"), "-")
+	if strings.Contains(value, ".") {
+		version, err := semver.ParseTolerant(value)
+		return version, err == nil
+	}
+	if len(value) < 2 {
+		return semver.Version{}, false
+	}
+	major, err := strconv.ParseUint(value[:1], 10, 64)
+	if err != nil {
+		return semver.Version{}, false
+	}
+	minor, err := strconv.ParseUint(value[1:], 10, 64)
+	if err != nil {
+		return semver.Version{}, false
+	}
+	return semver.Version{Major: major, Minor: minor}, true
+}
+
+// Whether a glibc-qualified build needs a newer glibc than the host has. When
+// the host's glibc isn't known, builds are used as they would be without it
+func needsNewerGlibc(rel release) bool {
+	build, ok := glibcBuild(rel)
+	if !ok {
+		return false
+	}
+	glibc, known := glibcFor(rel.platform)
+	return known && build.GT(glibc)
+}
+
+// Ranks the builds of a version, lower being preferred. The standard build is
+// preferred when the host's glibc can run it, then builds for an older glibc
+// that it can run, then the standard build anyway, as the host's glibc may be
+// newer than it reports. Other variants come last
+func buildRank(rel release, glibc semver.Version, known bool) int {
+	if rel.qualifier == "" {
+		if !known {
+			return 0
+		}
+		for _, standard := range standardBuildGlibc {
+			if standard.versions(rel.version) && glibc.LT(standard.glibc) {
+				return 2
+			}
+		}
+		return 0
+	}
+	if _, ok := glibcBuild(rel); ok && known {
+		return 1
+	}
+	return 3
+}
+
+// Whether rel is a better build of a version to use than other, which is in
+// the same stage
+func preferredBuild(rel release, other release) bool {
+	build, ok := glibcBuild(rel)
+	otherBuild, otherOK := glibcBuild(other)
+	// the host's glibc only needs to be detected to choose between a glibc
+	// build and some other one
+	if !ok && !otherOK {
+		return other.qualifier != "" && rel.qualifier == ""
+	}
+	glibc, known := glibcFor(rel.platform)
+	rank, otherRank := buildRank(rel, glibc, known), buildRank(other, glibc, known)
+	if rank != otherRank {
+		return rank < otherRank
+	}
+	// of the builds for an older glibc, the newest is closest to the host
+	return rank == 1 && ok && otherOK && build.GT(otherBuild)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGlibcVersion(t *testing.T) {
+	cases := map[string]string{
+		"ldd (GNU libc) 2.17\nCopyright (C) 2012 Free Software Foundation, Inc.":                                                 "2.17.0",
+		"ldd (Ubuntu GLIBC 2.35-0ubuntu3.8) 2.35\nCopyright (C) 2022 Free Software Foundation, Inc.":                             "2.35.0",
+		"ldd (Debian GLIBC 2.36-9+deb12u7) 2.36\n":                                                                               "2.36.0",
+		"GNU C Library (Ubuntu GLIBC 2.35-0ubuntu3.8) stable release version 2.35.\nCopyright (C) 2022 Free Software Foundation": "2.35.0",
+	}
+	for out, want := range cases {
+		version, ok := parseGlibcVersion(out)
+		if assert.True(t, ok, out) {
+			assert.Equal(t, version.String(), want, out)
+		}
+	}
+
+	for _, out := range []string{
+		"musl libc (x86_64)\nVersion 1.2.4\nDynamic Program Loader",
+		"ldd (GNU libc)",
+		"",
+	} {
+		_, ok := parseGlibcVersion(out)
+		assert.False(t, ok, out)
+	}
+}
+
+func TestDetectGlibc(t *testing.T) {
+	defer func(original func(string, ...string) ([]byte, error)) { glibcCommand = original }(glibcCommand)
+
+	outputs := map[string]string{}
+	ran := []string{}
+	glibcCommand = func(name string, args ...string) ([]byte, error) {
+		ran = append(ran, strings.Join(append([]string{name}, args...), " "))
+		if out, ok := outputs[name]; ok {
+			return []byte(out), nil
+		}
+		return nil, errors.New("exec: not found")
+	}
+
+	outputs["ldd"] = "ldd (GNU libc) 2.17\n"
+	version, ok := detectGlibc()
+	if assert.True(t, ok) {
+		assert.Equal(t, version.String(), "2.17.0")
+	}
+	assert.Equal(t, ran, []string{"ldd --version"})
+
+	// without ldd, libc itself is run
+	delete(outputs, "ldd")
+	outputs["/lib/x86_64-linux-gnu/libc.so.6"] = "GNU C Library (GNU libc) stable release version 2.28.\n"
+	ran = nil
+	version, ok = detectGlibc()
+	if assert.True(t, ok) {
+		assert.Equal(t, version.String(), "2.28.0")
+	}
+	assert.Equal(t, ran, []string{"ldd --version", "/lib/x86_64-linux-gnu/libc.so.6"})
+
+	// and musl isn't mistaken for glibc
+	outputs = map[string]string{"ldd": "musl libc (x86_64)\nVersion 1.2.4\n"}
+	_, ok = detectGlibc()
+	assert.False(t, ok)
+}
+
+func TestGlibcFromEnv(t *testing.T) {
+	defer os.Unsetenv("NODE_RESOLVE_GLIBC")
+
+	info, err := glibcFromEnv()
+	assert.Nil(t, err)
+	assert.Nil(t, info)
+
+	os.Setenv("NODE_RESOLVE_GLIBC", "2.17")
+	info, err = glibcFromEnv()
+	if assert.Nil(t, err) && assert.NotNil(t, info) {
+		assert.True(t, info.known)
+		assert.Equal(t, info.version.String(), "2.17.0")
+	}
+
+	os.Setenv("NODE_RESOLVE_GLIBC", "none")
+	info, err = glibcFromEnv()
+	if assert.Nil(t, err) && assert.NotNil(t, info) {
+		assert.False(t, info.known)
+	}
+
+	os.Setenv("NODE_RESOLVE_GLIBC", "centos7")
+	_, err = glibcFromEnv()
+	assert.EqualError(t, err, "Invalid NODE_RESOLVE_GLIBC: centos7, expected a version like 2.28, or none")
+}
+
+func TestGlibcBuild(t *testing.T) {
+	for qualifier, want := range map[string]string{
+		"glibc-217":  "2.17.0",
+		"glibc-228":  "2.28.0",
+		"glibc-2.17": "2.17.0",
+		"glibc2.28":  "2.28.0",
+	} {
+		version, ok := glibcBuild(release{qualifier: qualifier})
+		if assert.True(t, ok, qualifier) {
+			assert.Equal(t, version.String(), want, qualifier)
+		}
+	}
+	for _, qualifier := range []string{"", "pointer-compression", "glibc", "glibc-x"} {
+		_, ok := glibcBuild(release{qualifier: qualifier})
+		assert.False(t, ok, qualifier)
+	}
+
+	// glibc is only looked at on linux
+	defer func(original *glibcInfo) { hostGlibc = original }(hostGlibc)
+	hostGlibc = &glibcInfo{version: semver.MustParse("2.17.0"), known: true}
+	_, known := glibcFor("darwin-arm64")
+	assert.False(t, known)
+	_, known = glibcFor("linux-x64")
+	assert.True(t, known)
+}
+
+func TestResolveNodeGlibc(t *testing.T) {
+	defer func(original *glibcInfo) { hostGlibc = original }(hostGlibc)
+	defer func(original string) { nodeVariant = original }(nodeVariant)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	fixture, err := ioutil.ReadFile("testdata/node-glibc.xml")
+	if !assert.Nil(t, err) {
+		return
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fixture)
+	}))
+	defer server.Close()
+	src := s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}
+
+	cases := []struct {
+		glibc       string
+		variant     string
+		requirement string
+		file        string
+	}{
+		// node 18 needs glibc 2.28, so an older one gets the glibc-217 build
+		{"2.17", "", "18.x", "node-v18.20.4-linux-x64-glibc-217.tar.gz"},
+		// but node 16 only needs 2.17, so its standard build is used
+		{"2.17", "", "16.x", "node-v16.20.2-linux-x64.tar.gz"},
+		// builds for a newer glibc than the host's are skipped
+		{"2.17", "", "20.x", "node-v20.15.1-linux-x64-glibc-217.tar.gz"},
+		// and of the ones it can run, the newest glibc is used
+		{"2.26", "", "20.x", "node-v20.15.1-linux-x64-glibc-225.tar.gz"},
+		{"2.35", "", "20.x", "node-v20.15.1-linux-x64.tar.gz"},
+		// without a glibc-qualified build, the standard build is used anyway
+		{"2.17", "", "22.x", "node-v22.4.1-linux-x64.tar.gz"},
+		{"2.17", "", ">=22", "node-v22.4.1-linux-x64.tar.gz"},
+		{"2.35", "", ">=22", "node-v23.0.0-linux-x64-glibc-231.tar.gz"},
+		// when glibc isn't known, builds are picked as for any other variant
+		{"none", "", "18.x", "node-v18.20.4-linux-x64.tar.gz"},
+		{"none", "", "23.x", "node-v23.0.0-linux-x64-glibc-231.tar.gz"},
+		// and asking for a variant uses it whatever the host's glibc
+		{"2.17", "glibc-231", "23.x", "node-v23.0.0-linux-x64-glibc-231.tar.gz"},
+		{"2.17", "standard", "18.x", "node-v18.20.4-linux-x64.tar.gz"},
+	}
+	for _, c := range cases {
+		hostGlibc = &glibcInfo{}
+		if c.glibc != "none" {
+			hostGlibc = &glibcInfo{version: semver.MustParse(c.glibc + ".0"), known: true}
+		}
+		nodeVariant = c.variant
+		result, err := resolveFromSources([]source{src}, "node", c.requirement)
+		if assert.Nil(t, err) && assert.True(t, result.matched, c.glibc+" "+c.requirement) {
+			assert.Equal(t, result.release.url, "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/"+c.file, c.glibc+" "+c.requirement)
+		}
+	}
+
+	hostGlibc = &glibcInfo{version: semver.MustParse("2.17.0"), known: true}
+	nodeVariant = ""
+	result, err := resolveFromSources([]source{src}, "node", "23.x")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+}
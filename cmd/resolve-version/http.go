@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The client used for every request made while resolving a version. This is
+// replaced with one built by newHTTPClient once the flags have been parsed
+var httpClient = http.DefaultClient
+
+// Settings for the HTTP client, which come from flags and NODE_RESOLVE_* env
+// vars
+type clientConfig struct {
+	http1Only     bool
+	caBundle      string
+	minTLSVersion uint16
+	// the number of consecutive failed requests after which no more are made,
+	// or 0 to keep trying
+	breakerThreshold int
+	// the network to dial, tcp4 or tcp6, or "" to use whichever DNS returns
+	ipNetwork string
+	// how long to wait on IPv6 before also trying IPv4, or 0 for Go's default
+	fallbackDelay time.Duration
+	// how long to wait for a connection to be established
+	dialTimeout time.Duration
+	// how long to wait for the TLS handshake once connected
+	tlsHandshakeTimeout time.Duration
+	// how long to wait for a response's headers once the request is sent
+	responseHeaderTimeout time.Duration
+	// where --trace-http logs requests, or nil not to
+	traceOut io.Writer
+	// skips verifying certificates, for --insecure. This is deliberately only
+	// settable with a flag, never from the environment
+	insecureSkipVerify bool
+}
+
+// Opens the connections made by clients from newHTTPClient. Tests replace this
+// to see which network is dialed
+var dialNetwork = func(dialer *net.Dialer, ctx context.Context, network string, addr string) (net.Conn, error) {
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// Printed to stderr whenever --insecure is used, however it was set
+const insecureWarning = "WARNING: --insecure is set, so TLS certificates are NOT being verified and any server can impersonate the mirror. Only use this with local or test mirrors"
+
+var ipNetworks = map[string]string{
+	"auto": "",
+	"4":    "tcp4",
+	"6":    "tcp6",
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Reads the client settings that are configured through the environment:
+//
+//	NODE_RESOLVE_CA_BUNDLE     a PEM file of CAs to trust instead of the system roots
+//	NODE_RESOLVE_MIN_TLS       the minimum TLS version to accept, defaults to 1.2
+//	NODE_RESOLVE_MAX_FAILURES  stop making requests for 30s after this many
+//	                           consecutive failures, defaults to 5, 0 never stops
+//	NODE_RESOLVE_IP            4 or 6 to only connect over IPv4 or IPv6, for
+//	                           dual-stack hosts where one of them is broken
+//	NODE_RESOLVE_FALLBACK_DELAY
+//	                           how long to wait on IPv6 before also trying
+//	                           IPv4, a negative delay disables the fallback
+//	NODE_RESOLVE_DIAL_TIMEOUT  how long to wait for a connection, defaults to 30s
+//	NODE_RESOLVE_TLS_HANDSHAKE_TIMEOUT
+//	                           how long to wait for the TLS handshake, defaults to 10s
+//	NODE_RESOLVE_RESPONSE_HEADER_TIMEOUT
+//	                           how long to wait for a response to start, defaults to 30s
+//	NODE_RESOLVE_TRACE_HTTP    set to log every request to stderr, like --trace-http
+func clientConfigFromEnv(http1Only bool) (clientConfig, error) {
+	config := clientConfig{
+		http1Only:        http1Only,
+		caBundle:         os.Getenv("NODE_RESOLVE_CA_BUNDLE"),
+		minTLSVersion:    tls.VersionTLS12,
+		breakerThreshold: 5,
+		dialTimeout:      30 * time.Second,
+
+		tlsHandshakeTimeout:   10 * time.Second,
+		responseHeaderTimeout: 30 * time.Second,
+	}
+
+	if maxFailures := os.Getenv("NODE_RESOLVE_MAX_FAILURES"); maxFailures != "" {
+		threshold, err := strconv.Atoi(maxFailures)
+		if err != nil || threshold < 0 {
+			return config, fmt.Errorf("Invalid NODE_RESOLVE_MAX_FAILURES: %s", maxFailures)
+		}
+		config.breakerThreshold = threshold
+	}
+
+	if ip := os.Getenv("NODE_RESOLVE_IP"); ip != "" {
+		network, ok := ipNetworks[ip]
+		if !ok {
+			return config, fmt.Errorf("Invalid NODE_RESOLVE_IP: %s", ip)
+		}
+		config.ipNetwork = network
+	}
+
+	if delay := os.Getenv("NODE_RESOLVE_FALLBACK_DELAY"); delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil {
+			return config, fmt.Errorf("Invalid NODE_RESOLVE_FALLBACK_DELAY: %s", delay)
+		}
+		config.fallbackDelay = d
+	}
+
+	if os.Getenv("NODE_RESOLVE_TRACE_HTTP") != "" {
+		config.traceOut = os.Stderr
+	}
+
+	timeouts := []struct {
+		name    string
+		timeout *time.Duration
+	}{
+		{"NODE_RESOLVE_DIAL_TIMEOUT", &config.dialTimeout},
+		{"NODE_RESOLVE_TLS_HANDSHAKE_TIMEOUT", &config.tlsHandshakeTimeout},
+		{"NODE_RESOLVE_RESPONSE_HEADER_TIMEOUT", &config.responseHeaderTimeout},
+	}
+	for _, t := range timeouts {
+		if timeout := os.Getenv(t.name); timeout != "" {
+			d, err := time.ParseDuration(timeout)
+			if err != nil || d <= 0 {
+				return config, fmt.Errorf("Invalid %s: %s", t.name, timeout)
+			}
+			*t.timeout = d
+		}
+	}
+
+	if minTLS := os.Getenv("NODE_RESOLVE_MIN_TLS"); minTLS != "" {
+		version, ok := tlsVersions[minTLS]
+		if !ok {
+			return config, fmt.Errorf("Unsupported NODE_RESOLVE_MIN_TLS: %s", minTLS)
+		}
+		config.minTLSVersion = version
+	}
+
+	return config, nil
+}
+
+// Builds the client used to talk to S3 and other sources. HTTP/2 is negotiated
+// whenever the server supports it, which lets the requests for each page of a
+// listing share a single connection. Setting http1Only forces HTTP/1.1, which
+// is useful when debugging a misbehaving mirror
+func newHTTPClient(config clientConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: config.minTLSVersion, InsecureSkipVerify: config.insecureSkipVerify}
+	if config.caBundle != "" {
+		pem, err := ioutil.ReadFile(config.caBundle)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA bundle: %s", config.caBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	dialer := &net.Dialer{
+		Timeout:       config.dialTimeout,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: config.fallbackDelay,
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			if network == "tcp" && config.ipNetwork != "" {
+				network = config.ipNetwork
+			}
+			return dialNetwork(dialer, ctx, network, addr)
+		},
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     !config.http1Only,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   config.tlsHandshakeTimeout,
+		ResponseHeaderTimeout: config.responseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if config.http1Only {
+		// a non-nil, empty map is how net/http is told not to upgrade to HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	var roundTripper http.RoundTripper = transport
+	if config.breakerThreshold > 0 {
+		roundTripper = &breakerTransport{transport: roundTripper, threshold: config.breakerThreshold}
+	}
+	if config.traceOut != nil {
+		roundTripper = &traceTransport{transport: roundTripper, out: config.traceOut}
+	}
+	return &http.Client{Transport: roundTripper, CheckRedirect: checkRedirect}, nil
+}
+
+// The most redirects a request follows before giving up
+const maxRedirects = 10
+
+// Stops following redirects as soon as one leads back to a URL that was
+// already requested, or after maxRedirects, with an error that shows where
+// each request was redirected to. Otherwise a misconfigured mirror only fails
+// with "stopped after 10 redirects"
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	chain := []string{}
+	for _, r := range via {
+		chain = append(chain, redactURL(r.URL))
+	}
+	chain = append(chain, redactURL(req.URL))
+
+	for _, r := range via {
+		if r.URL.String() == req.URL.String() {
+			return fmt.Errorf("Redirect loop: %s", strings.Join(chain, " -> "))
+		}
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("Stopped after %d redirects: %s", maxRedirects, strings.Join(chain, " -> "))
+	}
+	return nil
+}
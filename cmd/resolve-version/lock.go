@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jmorrell/semver"
+)
+
+const lockfileName = "node-resolve.lock"
+
+// The version of the lockfile format written by lock. install refuses to read
+// lockfiles written in a format it doesn't know
+const lockfileVersion = 1
+
+// The lockfile records exactly what each requirement resolved to, so that a
+// later install gets the same tarballs without listing any releases:
+//
+//	{
+//	  "lockfileVersion": 1,
+//	  "entries": [
+//	    {
+//	      "binary": "node",
+//	      "requirement": "12.x",
+//	      "version": "12.13.0",
+//	      "url": "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz",
+//	      "etag": "6b6b1fb579b1ab4b3b1d1a0a2b1f3a2c"
+//	    }
+//	  ]
+//	}
+type lockfile struct {
+	Version int         `json:"lockfileVersion"`
+	Entries []lockEntry `json:"entries"`
+}
+
+type lockEntry struct {
+	Binary      string `json:"binary"`
+	Requirement string `json:"requirement"`
+	Version     string `json:"version"`
+	URL         string `json:"url"`
+	ETag        string `json:"etag,omitempty"`
+}
+
+// Resolves each BINARY=VERSION_REQUIREMENT argument and writes the results to
+// the lockfile
+func lock(args []string, opts options) error {
+	reqs := requirementList{}
+	for _, arg := range args {
+		if err := reqs.Set(arg); err != nil {
+			return err
+		}
+	}
+
+	lf, err := lockRequirements(reqs, func(binary string) []source {
+		return sourcesFor(binary, opts.source)
+	})
+	if err != nil {
+		return err
+	}
+	return writeLockfile(opts.lockfile, lf)
+}
+
+func lockRequirements(reqs requirementList, sourcesFor func(string) []source) (lockfile, error) {
+	results, err := resolveRequirements(reqs, sourcesFor)
+	if err != nil {
+		return lockfile{}, err
+	}
+
+	lf := lockfile{Version: lockfileVersion, Entries: []lockEntry{}}
+	for i, r := range results {
+		lf.Entries = append(lf.Entries, lockEntry{
+			Binary:      r.binary,
+			Requirement: reqs[i].versionRequirement,
+			Version:     r.result.release.version.String(),
+			URL:         r.result.release.url,
+			ETag:        r.result.release.etag,
+		})
+	}
+	return lf, nil
+}
+
+func writeLockfile(path string, lf lockfile) error {
+	data, err := json.MarshalIndent(lf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+func readLockfile(path string) (lockfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return lockfile{}, fmt.Errorf("Could not read lockfile: %s", err)
+	}
+
+	var lf lockfile
+	if err := json.Unmarshal(data, &lf); err != nil {
+		return lockfile{}, fmt.Errorf("Could not parse lockfile %s: %s", path, err)
+	}
+	if lf.Version != lockfileVersion {
+		return lockfile{}, fmt.Errorf("Unsupported lockfile version %d in %s", lf.Version, path)
+	}
+	return lf, nil
+}
+
+// Prints the releases pinned in the lockfile. Nothing is listed unless
+// --verify asks for the pins to be checked against the sources
+func install(opts options) error {
+	if !opts.locked {
+		return errors.New("install requires --locked")
+	}
+
+	lf, err := readLockfile(opts.lockfile)
+	if err != nil {
+		return err
+	}
+
+	if opts.verify {
+		err := verifyLocked(lf, func(binary string) []source {
+			return sourcesFor(binary, opts.source)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	results, err := lockedResults(lf)
+	if err != nil {
+		return err
+	}
+	return printBatchResults(results, nil, opts)
+}
+
+func lockedResults(lf lockfile) ([]binaryResult, error) {
+	results := []binaryResult{}
+	for _, entry := range lf.Entries {
+		version, err := semver.Make(entry.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse locked version %s for %s", entry.Version, entry.Binary)
+		}
+		results = append(results, binaryResult{
+			binary: entry.Binary,
+			result: matchResult{
+				versionRequirement: entry.Requirement,
+				release:            release{binary: entry.Binary, version: version, url: entry.URL, etag: entry.ETag},
+				matched:            true,
+			},
+		})
+	}
+	return results, nil
+}
+
+// Checks that every pinned URL is still listed, and that its ETag hasn't
+// changed since it was locked
+func verifyLocked(lf lockfile, sourcesFor func(string) []source) error {
+	for _, entry := range lf.Entries {
+		sources := sourcesFor(entry.Binary)
+		if len(sources) == 0 {
+			return fmt.Errorf("Unknown binary: %s", entry.Binary)
+		}
+
+		listed, err := findLockedRelease(sources, entry)
+		if err != nil {
+			return err
+		}
+		if listed == nil {
+			return fmt.Errorf("Locked %s %s is no longer listed at %s", entry.Binary, entry.Version, entry.URL)
+		}
+		if entry.ETag != "" && listed.etag != "" && entry.ETag != listed.etag {
+			return fmt.Errorf("Locked %s %s has changed since it was locked: ETag %s, expected %s", entry.Binary, entry.Version, listed.etag, entry.ETag)
+		}
+	}
+	return nil
+}
+
+func findLockedRelease(sources []source, entry lockEntry) (*release, error) {
+	for _, src := range sources {
+		releases, err := src.List(entry.Binary)
+		if err != nil {
+			return nil, err
+		}
+		for i := range releases {
+			if releases[i].url == entry.URL {
+				return &releases[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
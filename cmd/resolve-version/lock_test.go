@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lockTestSourcesFor(etag string) func(string) []source {
+	return func(binary string) []source {
+		if binary != "node" {
+			return testSourcesFor(binary)
+		}
+		objects := genNodeS3ObjectList([]string{"10.15.3", "12.13.0"}, []string{}, "linux-x64")
+		for i := range objects {
+			objects[i].ETag = `"` + etag + `"`
+		}
+		return []source{staticSource{releases: parseObjects(objects)}}
+	}
+}
+
+func TestLockfileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, lockfileName)
+
+	lf, err := lockRequirements(requirementList{
+		{binary: "node", versionRequirement: "12.x"},
+		{binary: "yarn", versionRequirement: "1.x"},
+	}, lockTestSourcesFor("abcdef"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, lf, lockfile{
+		Version: lockfileVersion,
+		Entries: []lockEntry{
+			{
+				Binary:      "node",
+				Requirement: "12.x",
+				Version:     "12.13.0",
+				URL:         "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz",
+				ETag:        "abcdef",
+			},
+			{Binary: "yarn", Requirement: "1.x", Version: "1.19.1", URL: "https://heroku.com"},
+		},
+	})
+
+	assert.Nil(t, writeLockfile(path, lf))
+	read, err := readLockfile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, read, lf)
+
+	results, err := lockedResults(read)
+	if assert.Nil(t, err) && assert.Len(t, results, 2) {
+		assert.Equal(t, results[0].binary, "node")
+		assert.Equal(t, results[0].result.release.version.String(), "12.13.0")
+		assert.Equal(t, results[0].result.release.url, lf.Entries[0].URL)
+		assert.Equal(t, results[1].binary, "yarn")
+		assert.Equal(t, results[1].result.release.version.String(), "1.19.1")
+	}
+}
+
+func TestInstallLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, lockfileName)
+	out := filepath.Join(dir, "out")
+
+	assert.Nil(t, writeLockfile(path, lockfile{
+		Version: lockfileVersion,
+		Entries: []lockEntry{{Binary: "node", Requirement: "12.x", Version: "12.13.0", URL: "https://heroku.com"}},
+	}))
+
+	// no sources are available, so this only works if nothing is listed
+	assert.Nil(t, install(options{lockfile: path, locked: true, outputFile: out, source: "s3"}))
+	contents, _ := ioutil.ReadFile(out)
+	assert.Equal(t, string(contents), "node 12.13.0 https://heroku.com\n")
+
+	err = install(options{lockfile: path})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "install requires --locked")
+	}
+}
+
+func TestReadLockfileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, lockfileName)
+
+	_, err = readLockfile(path)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"lockfileVersion": 2, "entries": []}`), 0644))
+	_, err = readLockfile(path)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Unsupported lockfile version 2 in "+path)
+	}
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`not json`), 0644))
+	_, err = readLockfile(path)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyLocked(t *testing.T) {
+	lf, err := lockRequirements(requirementList{{binary: "node", versionRequirement: "12.x"}}, lockTestSourcesFor("abcdef"))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Nil(t, verifyLocked(lf, lockTestSourcesFor("abcdef")))
+
+	err = verifyLocked(lf, lockTestSourcesFor("123456"))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Locked node 12.13.0 has changed since it was locked: ETag 123456, expected abcdef")
+	}
+
+	lf.Entries[0].URL = "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.99.0-linux-x64.tar.gz"
+	err = verifyLocked(lf, lockTestSourcesFor("abcdef"))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Locked node 12.13.0 is no longer listed at "+lf.Entries[0].URL)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parses a tarball URL, like one pinned in a lockfile, back into the release
+// it was resolved to. This is the inverse of objectURL, and also accepts
+// virtual-hosted style URLs like https://heroku-nodebin.s3.amazonaws.com/KEY.
+// With head, the URL is also requested to check that it still exists and to
+// fill in the size, ETag, and last modified time that a listing would have
+func releaseByURL(rawURL string, head bool) (release, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" && (binariesEndpoint == nil || u.Scheme != binariesEndpoint.base.Scheme) {
+		return release{}, fmt.Errorf("Invalid release URL: %s", rawURL)
+	}
+
+	key, onMirror := mirrorKey(u)
+	switch {
+	case onMirror:
+	case u.Host == "s3.amazonaws.com" && strings.HasPrefix(u.Path, "/heroku-nodebin/"):
+		key = strings.TrimPrefix(u.Path, "/heroku-nodebin/")
+	case strings.HasPrefix(u.Host, "heroku-nodebin.s3.") && strings.HasSuffix(u.Host, ".amazonaws.com"):
+		key = strings.TrimPrefix(u.Path, "/")
+	default:
+		return release{}, fmt.Errorf("Not a heroku-nodebin URL: %s", rawURL)
+	}
+
+	rel, err := parseObject(key)
+	if err != nil {
+		return release{}, fmt.Errorf("Not a release URL: %s", rawURL)
+	}
+	if !head {
+		return rel, nil
+	}
+
+	resp, err := httpClient.Head(rel.url)
+	if err != nil {
+		return release{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return release{}, fmt.Errorf("Could not fetch %s: status code %d", rel.url, resp.StatusCode)
+	}
+	if resp.ContentLength >= 0 {
+		rel.size = resp.ContentLength
+	}
+	rel.etag = strings.Trim(resp.Header.Get("ETag"), `"`)
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		rel.lastModified = lastModified.In(time.UTC)
+	}
+	return rel, nil
+}
+
+// Returns the key a URL is for when it's in the bucket on
+// NODE_BINARIES_ENDPOINT
+func mirrorKey(u *url.URL) (string, bool) {
+	if binariesEndpoint == nil {
+		return "", false
+	}
+	mirror, err := url.Parse(binariesEndpoint.bucketURL("heroku-nodebin"))
+	if err != nil || u.Scheme != mirror.Scheme || u.Host != mirror.Host || !strings.HasPrefix(u.Path, mirror.Path+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(u.Path, mirror.Path+"/"), true
+}
+
+// Prints the release a URL is for, for lookup URL. With --verify-url the URL
+// is requested to check that it still exists
+func lookup(rawURL string, opts options) error {
+	rel, err := releaseByURL(rawURL, opts.verifyURL)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if opts.json {
+		data, err := json.MarshalIndent(rel, "", "  ")
+		if err != nil {
+			return err
+		}
+		out = append(data, '\n')
+	} else {
+		out = []byte(fmt.Sprintf("%s %s %s\n", rel.binary, rel.version.String(), rel.url))
+	}
+	return writeOutput(out, opts)
+}
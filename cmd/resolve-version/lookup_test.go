@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseByURL(t *testing.T) {
+	// every URL a listed release resolves to parses back into that release
+	for _, key := range []string{
+		"node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz",
+		"node/staging/darwin-arm64/node-v20.0.0-darwin-arm64.tar.gz",
+		"node/release/linux-x64/node-v18.20.0-linux-x64-glibc-217.tar.gz",
+		"yarn/release/yarn-v1.22.19.tar.gz",
+	} {
+		listed, err := parseObject(key)
+		if !assert.Nil(t, err, key) {
+			continue
+		}
+		rel, err := releaseByURL(listed.url, false)
+		if assert.Nil(t, err, key) {
+			assert.Equal(t, rel, listed, key)
+		}
+	}
+
+	rel, err := releaseByURL("https://heroku-nodebin.s3.us-east-1.amazonaws.com/node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz", false)
+	if assert.Nil(t, err) {
+		assert.Equal(t, rel.version.String(), "18.17.0")
+		assert.Equal(t, rel.platform, "linux-x64")
+		assert.Equal(t, rel.url, "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz")
+	}
+
+	cases := map[string]string{
+		"http://s3.amazonaws.com/heroku-nodebin/yarn/release/yarn-v1.22.19.tar.gz": "Invalid release URL: http://s3.amazonaws.com/heroku-nodebin/yarn/release/yarn-v1.22.19.tar.gz",
+		"https://nodejs.org/dist/v18.17.0/node-v18.17.0-linux-x64.tar.gz":          "Not a heroku-nodebin URL: https://nodejs.org/dist/v18.17.0/node-v18.17.0-linux-x64.tar.gz",
+		"https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/README":    "Not a release URL: https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/README",
+	}
+	for url, message := range cases {
+		_, err := releaseByURL(url, false)
+		if assert.NotNil(t, err, url) {
+			assert.Equal(t, err.Error(), message)
+		}
+	}
+}
+
+func TestReleaseByURLHead(t *testing.T) {
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.URL.Path == "/heroku-nodebin/node/release/linux-x64/node-v18.16.0-linux-x64.tar.gz" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("ETag", `"abcdef"`)
+		w.Header().Set("Last-Modified", "Tue, 18 Jul 2023 20:00:00 GMT")
+		w.Header().Set("Content-Length", "100")
+	}))
+	defer server.Close()
+
+	// requests for s3.amazonaws.com go to the test server instead
+	defer func(original *http.Client) { httpClient = original }(httpClient)
+	target, _ := url.Parse(server.URL)
+	httpClient = &http.Client{Transport: hostRewriter{target: target}}
+
+	rel, err := releaseByURL("https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz", true)
+	if assert.Nil(t, err) {
+		assert.Equal(t, rel.size, int64(100))
+		assert.Equal(t, rel.etag, "abcdef")
+		assert.Equal(t, rel.lastModified, time.Date(2023, 7, 18, 20, 0, 0, 0, time.UTC))
+	}
+	assert.Equal(t, methods, []string{"HEAD"})
+
+	_, err = releaseByURL("https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.16.0-linux-x64.tar.gz", true)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not fetch https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.16.0-linux-x64.tar.gz: status code 404")
+	}
+
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lookup")
+	assert.Nil(t, lookup("https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz", options{outputFile: path, verifyURL: true, json: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.JSONEq(t, string(contents), `{
+		"binary": "node",
+		"version": "18.17.0",
+		"major": 18,
+		"minor": 17,
+		"patch": 0,
+		"stage": "release",
+		"platform": "linux-x64",
+		"url": "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz",
+		"size": 100,
+		"lastModified": "2023-07-18T20:00:00Z",
+		"etag": "abcdef"
+	}`)
+}
+
+// Sends every request to target, whatever host it was made to
+type hostRewriter struct {
+	target *url.URL
+}
+
+func (h hostRewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = h.target.Scheme
+	req.URL.Host = h.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
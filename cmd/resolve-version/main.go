@@ -1,22 +1,29 @@
 package main
 
 import (
+	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/jmorrell/semver"
 )
 
+// A page of a ListObjectsV2 response. S3 puts every element in the
+// http://s3.amazonaws.com/doc/2006-03-01/ namespace, but the tags are left
+// unqualified so that they match it, or no namespace at all, as some mirrors
+// serve it
 type result struct {
+	XMLName               xml.Name   `xml:"ListBucketResult"`
 	Name                  string     `xml:"Name"`
 	KeyCount              int        `xml:"KeyCount"`
 	MaxKeys               int        `xml:"MaxKeys"`
@@ -25,6 +32,14 @@ type result struct {
 	NextContinuationToken string     `xml:"NextContinuationToken"`
 	Prefix                string     `xml:"Prefix"`
 	Contents              []s3Object `xml:"Contents"`
+	// only listed when a delimiter is given
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+	// "url" when the keys and prefixes in the listing are URL-encoded
+	EncodingType string `xml:"EncodingType"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
 }
 
 type s3Object struct {
@@ -41,127 +56,1568 @@ type release struct {
 	platform string
 	url      string
 	version  semver.Version
+	// the LTS codename, when the source knows it
+	lts string
+	// the S3 ETag of the object, without quotes, when listed from S3
+	etag string
+	// the S3 storage class of the object, when listed from S3
+	storageClass string
+	// anything after the platform in the file name, set for builds that
+	// differ from the standard one for the same platform
+	qualifier string
+	// the size of the tarball in bytes, or 0 when the source doesn't say
+	size int64
+	// when the tarball was published, or the zero time when unknown
+	lastModified time.Time
+}
+
+type matchResult struct {
+	versionRequirement string
+	release            release
+	matched            bool
+	// when an exact version didn't match, the nearest releases on either side
+	closest []release
+	// when nothing matched, the newest release that would have if it weren't
+	// above NODE_MAX_VERSION
+	capped *release
+	// the npm that ships with the resolved node, for --with-bundled-npm
+	bundledNpm *release
+}
+
+type options struct {
+	json               bool
+	latestPerMajor     bool
+	outputFile         string
+	http1Only          bool
+	requireSigned      bool
+	timings            bool
+	resolve            requirementList
+	minNodeForYarn     bool
+	strict             bool
+	source             string
+	withHeaders        bool
+	lockfile           string
+	locked             bool
+	verify             bool
+	verbose            bool
+	semverMode         string
+	prereleaseOrder    string
+	serve              string
+	serveRefresh       time.Duration
+	fromNvmrc          string
+	failFast           bool
+	bestEffort         bool
+	verifyDownload     string
+	verifyURL          bool
+	nearestOnMissing   bool
+	printURLOnly       bool
+	channel            string
+	limit              int
+	prefer             string
+	constraintsFromEnv bool
+	env                bool
+	compare            string
+	with               string
+	validate           string
+	quiet              bool
+	normalizeOnly      bool
+	includeStaging     bool
+	forceIPv4          bool
+	forceIPv6          bool
+	listMajors         bool
+	candidatesJSON     bool
+	traceHTTP          bool
+	asOf               string
+	withBundledNpm     bool
+	distTag            string
+	separator          string
+	countByMajor       bool
+	anyChannel         bool
+	includeMetadata    bool
+	insecure           bool
+	variant            string
+	checkUpdate        string
+	exclude            string
+	assert             string
+	current            string
+	failOnMajor        bool
+	follow             bool
+	followInterval     time.Duration
+	followFor          time.Duration
+	fromPackageManager string
+	withIntegrity      bool
+	shell              bool
+	shellPrefix        string
+	maxMajor           string
+	versionRange       string
+	ceiling            string
+	platforms          []string
+	// the hash of the packageManager that --with-integrity prints, which is
+	// set by --from-package-manager rather than by a flag
+	integrity string
+}
+
+func main() {
+	opts := options{}
+	fs := flag.NewFlagSet("resolve-version", flag.ExitOnError)
+	fs.BoolVar(&opts.json, "json", false, "print the output as JSON")
+	fs.BoolVar(&opts.latestPerMajor, "latest-per-major", false, "only list the newest release of each major version")
+	fs.BoolVar(&opts.candidatesJSON, "candidates-json", false, "print every release of BINARY for this platform as JSON, without resolving")
+	fs.BoolVar(&opts.countByMajor, "count-by-major", false, "print a JSON list of how many versions of each major version are available")
+	fs.BoolVar(&opts.listMajors, "list-majors", false, "summarize the newest version of each major version, marking LTS lines")
+	fs.StringVar(&opts.outputFile, "output-file", "", "write the resolved version to this file instead of stdout")
+	fs.BoolVar(&opts.http1Only, "http1-only", false, "don't negotiate HTTP/2 with S3")
+	fs.BoolVar(&opts.requireSigned, "require-signed", false, "verify the resolved node release against its signed checksums")
+	fs.BoolVar(&opts.timings, "timings", false, "print how long each step of resolution took to stderr")
+	fs.Var(&opts.resolve, "resolve", "resolve BINARY=VERSION_REQUIREMENT, may be repeated")
+	fs.BoolVar(&opts.minNodeForYarn, "min-node-for-yarn", false, "check that the resolved yarn supports the resolved node")
+	fs.BoolVar(&opts.strict, "strict", false, "fail instead of warning when checks don't pass, and don't fall back to other builds")
+	fs.StringVar(&opts.source, "source", "s3", "where to list releases from: s3, nodejs-org, or github")
+	fs.StringVar(&githubRepo, "repo", "", "the GitHub repository, as OWNER/NAME, that --source github lists releases of")
+	fs.BoolVar(&opts.withHeaders, "with-headers", false, "also print the URL of the headers tarball for the resolved node")
+	fs.StringVar(&opts.lockfile, "lockfile", lockfileName, "the lockfile written by lock and read by install")
+	fs.BoolVar(&opts.locked, "locked", false, "install the versions pinned in the lockfile")
+	fs.BoolVar(&opts.verify, "verify", false, "check that locked or prewarmed releases are still available")
+	fs.BoolVar(&opts.verbose, "verbose", false, "describe the resolved release on stderr")
+	fs.StringVar(&opts.semverMode, "semver-mode", "strict", "how version requirements are interpreted: strict or npm")
+	fs.StringVar(&opts.prereleaseOrder, "prerelease-order", "semver", "how prereleases rank against their release: semver or after-release")
+	fs.StringVar(&opts.serve, "serve", "", "serve resolutions over HTTP on this address instead of resolving once")
+	fs.DurationVar(&opts.serveRefresh, "serve-refresh", 5*time.Minute, "how often --serve lists releases again")
+	fs.StringVar(&opts.fromNvmrc, "from-nvmrc", "", "resolve node using the version in this .nvmrc file")
+	fs.BoolVar(&opts.failFast, "fail-fast", false, "with --resolve, stop at the first binary that can't be resolved")
+	fs.BoolVar(&opts.bestEffort, "best-effort", false, "with --resolve, resolve every binary before reporting failures (default)")
+	fs.StringVar(&opts.verifyDownload, "verify-download", "", "check that this downloaded file matches the resolved release")
+	fs.BoolVar(&opts.verifyURL, "verify-url", false, "check that the resolved tarball can be fetched and has the listed size")
+	fs.BoolVar(&opts.nearestOnMissing, "nearest-on-missing", false, "resolve an unavailable exact version to the nearest one in the same major")
+	fs.BoolVar(&opts.printURLOnly, "print-url-only", false, "print only the URL of the resolved tarball")
+	fs.StringVar(&opts.channel, "channel", "release", "which node builds to resolve: release or nightly")
+	fs.IntVar(&opts.limit, "limit", 0, "with list, print at most this many releases")
+	fs.StringVar(&opts.prefer, "prefer", "highest", "with list --limit, print the highest or lowest releases")
+	fs.BoolVar(&opts.constraintsFromEnv, "constraints-from-env", false, "read a missing version requirement from NODE_VERSION, YARN_VERSION, PNPM_VERSION, NPM_VERSION, or package.json")
+	fs.BoolVar(&opts.env, "env", false, "print the result as shell variable assignments")
+	fs.BoolVar(&opts.shell, "shell", false, "print the result as export statements for a shell to eval")
+	fs.StringVar(&opts.shellPrefix, "shell-prefix", "", "start the variable names --shell and --env print with this instead of the binary's name")
+	fs.StringVar(&opts.compare, "compare", "", "compare what this requirement resolves to with --with")
+	fs.StringVar(&opts.with, "with", "", "the requirement to compare --compare with")
+	fs.StringVar(&opts.validate, "validate", "", "check that a version requirement parses, without resolving it")
+	fs.BoolVar(&opts.quiet, "quiet", false, "don't show progress while listing releases")
+	fs.StringVar(&opts.separator, "separator", "SPACE", "what to join the version and URL with: TAB, NL, SPACE, or a literal string")
+	fs.StringVar(&opts.distTag, "dist-tag", "", "resolve node to the version a dist-tag points to: latest, lts, rc, or nightly")
+	fs.BoolVar(&opts.withBundledNpm, "with-bundled-npm", false, "also print the version and registry tarball of the npm bundled with node")
+	fs.StringVar(&opts.asOf, "as-of", "", "only resolve to releases published on or before this date, in UTC")
+	fs.BoolVar(&opts.traceHTTP, "trace-http", false, "log each request, its response status, and the start of its body to stderr")
+	fs.BoolVar(&opts.insecure, "insecure", false, "don't verify TLS certificates, for test mirrors with self-signed certificates")
+	fs.StringVar(&opts.checkUpdate, "check-update", "", "print whether a newer release than this pinned version of BINARY is available")
+	fs.StringVar(&opts.maxMajor, "max-major", "", "only resolve to releases in this major version or an older one")
+	fs.StringVar(&opts.versionRange, "range", "", "the version requirement, as a flag rather than an argument, to pair with --ceiling")
+	fs.StringVar(&opts.ceiling, "ceiling", "", "only resolve to releases that also satisfy this upper bound, like \"<19\"")
+	fs.StringVar(&opts.exclude, "exclude", "", "comma separated versions or ranges that are never resolved to")
+	fs.StringVar(&opts.assert, "assert", "", "fail unless the requirement resolves to exactly this version")
+	fs.StringVar(&opts.current, "current", "", "warn when the requirement resolves to a higher major than this version")
+	fs.BoolVar(&opts.failOnMajor, "fail-on-major", false, "fail instead of warning when --current is a lower major than the resolved version")
+	fs.BoolVar(&opts.follow, "follow", false, "keep listing releases, printing each new version that matches the requirement")
+	fs.DurationVar(&opts.followInterval, "follow-interval", time.Minute, "how often --follow lists releases")
+	fs.DurationVar(&opts.followFor, "follow-for", 0, "stop --follow after this long, or 0 to follow until interrupted")
+	fs.StringVar(&opts.fromPackageManager, "from-package-manager", "", "resolve the package manager pinned by the packageManager field of this package.json")
+	fs.BoolVar(&opts.withIntegrity, "with-integrity", false, "with --from-package-manager, also print the hash packageManager pins")
+	fs.StringVar(&opts.variant, "variant", "", "only resolve node to builds of this variant, like pointer-compression")
+	fs.BoolVar(&opts.forceIPv4, "force-ipv4", false, "only connect over IPv4, like NODE_RESOLVE_IP=4")
+	fs.BoolVar(&opts.forceIPv6, "force-ipv6", false, "only connect over IPv6, like NODE_RESOLVE_IP=6")
+	fs.BoolVar(&opts.includeMetadata, "include-metadata", false, "also print the ETag, storage class, and size of the resolved object")
+	fs.BoolVar(&opts.anyChannel, "any-channel", false, "resolve node to the newest match in release or staging, and say which it came from")
+	fs.BoolVar(&opts.includeStaging, "include-staging", false, "let ranges resolve to staging builds when no release matches")
+	fs.BoolVar(&opts.normalizeOnly, "normalize-only", false, "print the requirement releases would be matched against, without listing them")
+	platform := fs.String("platform", "", "resolve for these comma separated platforms instead of this machine's")
+	fs.Usage = printUsage
+	defer waitForTelemetry()
+
+	if err := loadConfig(fs); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	args, err := parseArgs(fs, os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	opts.platforms = parsePlatforms(*platform)
+	if len(opts.platforms) == 1 {
+		platformOverride = opts.platforms[0]
+	}
+	nodeVariant = opts.variant
+	// --shell is --env with every variable exported
+	if opts.shell {
+		opts.env = true
+	}
+
+	config, err := clientConfigFromEnv(opts.http1Only)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if opts.forceIPv4 && opts.forceIPv6 {
+		fmt.Println("Only one of --force-ipv4 and --force-ipv6 can be used")
+		os.Exit(1)
+	}
+	if opts.traceHTTP {
+		config.traceOut = os.Stderr
+	}
+	if opts.insecure {
+		fmt.Fprintln(os.Stderr, insecureWarning)
+		config.insecureSkipVerify = true
+	}
+	if opts.forceIPv4 {
+		config.ipNetwork = "tcp4"
+	} else if opts.forceIPv6 {
+		config.ipNetwork = "tcp6"
+	}
+	httpClient, err = newHTTPClient(config)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if opts.timings {
+		timingsOut = os.Stderr
+	}
+	if opts.verbose {
+		verboseOut = os.Stderr
+	}
+	if !opts.timings && !opts.verbose {
+		progressOut = progressWriter(opts.quiet, os.Stdout, os.Stderr)
+	}
+	listingCache, err = diskCacheFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	keyTemplates, urlTemplate, err = keyTemplatesFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	binariesEndpoint, err = binariesEndpointFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if binariesEndpoint != nil && urlTemplate == "" {
+		urlTemplate = binariesEndpoint.urlTemplate()
+	}
+	hostGlibc, err = glibcFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	listingRateLimit, err = rateLimitFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	stagePreference, err = stagesFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if opts.anyChannel && opts.channel == "nightly" {
+		fmt.Println("--any-channel can't be used with --channel nightly")
+		os.Exit(1)
+	}
+	if opts.includeStaging || opts.anyChannel {
+		stagePreference = withStaging(stagePreference)
+	}
+	usePlatformFallbacks = !opts.strict
+	if listingCache != nil {
+		listingCache.limitForStages(stagePreference)
+	}
+
+	if opts.source != "s3" && opts.source != "nodejs-org" && opts.source != "github" {
+		fmt.Printf("Unknown source: %s\n", opts.source)
+		os.Exit(1)
+	}
+	if opts.source == "github" && githubRepo == "" {
+		fmt.Println("--source github requires --repo OWNER/NAME")
+		os.Exit(1)
+	}
+	if githubRepo != "" {
+		if opts.source != "github" {
+			fmt.Println("--repo can only be used with --source github")
+			os.Exit(1)
+		}
+		if !githubRepoRegex.MatchString(githubRepo) {
+			fmt.Printf("Invalid --repo: %s, expected OWNER/NAME\n", githubRepo)
+			os.Exit(1)
+		}
+	}
+	if opts.channel != "release" && opts.channel != "nightly" {
+		fmt.Printf("Unknown channel: %s\n", opts.channel)
+		os.Exit(1)
+	}
+	if parser, ok := rangeParsers[opts.semverMode]; ok {
+		parseRange = parser
+	} else {
+		fmt.Printf("Unknown semver mode: %s\n", opts.semverMode)
+		os.Exit(1)
+	}
+
+	excludedVersions, err = parseExclusions(opts.exclude)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	withinMaxMajor, err = parseMaxMajor(opts.maxMajor)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	versionCeiling, err = parseCeiling(opts.ceiling)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	maxNodeVersion, belowMaxNodeVersion, err = maxNodeVersionFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if opts.asOf != "" {
+		asOf, err = parseAsOf(opts.asOf)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if less, ok := prereleaseOrders[opts.prereleaseOrder]; ok {
+		versionLess = less
+	} else {
+		fmt.Printf("Unknown prerelease order: %s\n", opts.prereleaseOrder)
+		os.Exit(1)
+	}
+
+	if opts.serve != "" {
+		if err := serve(opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.failFast && opts.bestEffort {
+		fmt.Println("Only one of --fail-fast and --best-effort can be used")
+		os.Exit(1)
+	}
+
+	if len(opts.resolve) > 0 {
+		if err := checkBatchOptions(opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		resolveBatch(opts.resolve, opts)
+		return
+	}
+
+	if len(args) > 0 && args[0] == "check-update" {
+		message, err := checkUpdate()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(message)
+		return
+	}
+
+	if len(args) > 0 && args[0] == "selftest" {
+		// a cached listing would hide a mirror that's gone unhealthy
+		listingCache = nil
+		err := selftest(func(binary string) []source {
+			return sourcesFor(binary, opts.source)
+		}, os.Stdout)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "refresh-cache" {
+		if len(args) > 2 {
+			printUsage()
+			os.Exit(1)
+		}
+		if err := refreshCache(defaultSource(), args[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "install" {
+		if err := install(opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.fromNvmrc != "" {
+		versionRequirement, err := readNvmrc(opts.fromNvmrc)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if opts.normalizeOnly {
+			printNormalized(versionRequirement)
+			return
+		}
+		if err := resolve("node", versionRequirement, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.withIntegrity && opts.fromPackageManager == "" {
+		fmt.Println("--with-integrity requires --from-package-manager")
+		os.Exit(1)
+	}
+	if opts.fromPackageManager != "" {
+		if err := resolvePackageManager(opts.fromPackageManager, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.versionRange != "" {
+		if len(args) != 1 {
+			fmt.Println("--range takes the place of VERSION_REQUIREMENT, so only BINARY can be given with it")
+			os.Exit(1)
+		}
+		args = []string{args[0], opts.versionRange}
+	}
+
+	if opts.constraintsFromEnv {
+		args, err = withConstraintFromEnv(args, "package.json")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.normalizeOnly && len(args) == 2 {
+		printNormalized(args[1])
+		return
+	}
+
+	if opts.validate != "" && len(args) == 1 {
+		if err := validateRequirement(args[0], opts.validate); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.distTag != "" && len(args) == 1 {
+		if args[0] != "node" {
+			fmt.Printf("--dist-tag is only supported for node, not %s\n", args[0])
+			os.Exit(1)
+		}
+		version, err := distTagVersion(opts.distTag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if opts.distTag == "nightly" {
+			opts.channel = "nightly"
+		}
+		if err := resolve("node", version, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.checkUpdate != "" && len(args) == 1 {
+		code, err := checkPinUpdate(args[0], opts.checkUpdate, opts)
+		if err != nil {
+			fmt.Println(err)
+		}
+		os.Exit(code)
+	}
+
+	if opts.follow && len(args) == 2 {
+		if err := follow(args[0], args[1], opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.candidatesJSON && len(args) == 1 {
+		if err := printCandidates(args[0], opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.compare != "" && len(args) == 1 {
+		if err := compare(args[0], opts.compare, opts.with, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// completion scripts run __complete BINARY [PARTIAL], which isn't in the
+	// usage since it isn't meant to be run by hand
+	if len(args) > 0 && args[0] == "__complete" && (len(args) == 2 || len(args) == 3) {
+		partial := ""
+		if len(args) == 3 {
+			partial = args[2]
+		}
+		if err := complete(args[1], partial, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) < 2 {
+		printUsage()
+		os.Exit(0)
+	}
+
+	if args[0] == "list" {
+		binary := args[1]
+		list(binary, opts)
+	} else if args[0] == "prewarm" {
+		if len(args) < 3 {
+			printUsage()
+			os.Exit(1)
+		}
+		if err := prewarm(args[1], args[2], opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if args[0] == "lock" {
+		if err := lock(args[1:], opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if args[0] == "cache" {
+		if err := cacheCommand(args[1], os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if args[0] == "lookup" {
+		if err := lookup(args[1], opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		binary := args[0]
+		versionRequirement := args[1]
+		if err := resolve(binary, versionRequirement, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// Parses flags that may appear before, between, or after the positional
+// arguments, and returns the positional arguments in order
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// special-case this string since nodebin does as well and some users use it
+func normalizeRequirement(versionRequirement string) string {
+	versionRequirement = strings.TrimSpace(versionRequirement)
+	if versionRequirement == "latest" {
+		return "*"
+	}
+	return versionRequirement
+}
+
+// Prints what a requirement normalizes to for --normalize-only
+func printNormalized(versionRequirement string) {
+	normalized, err := normalizeOnly(versionRequirement)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(normalized)
+}
+
+func resolve(binary string, versionRequirement string, opts options) error {
+	sources := sourcesFor(binary, opts.source)
+	if len(sources) == 0 {
+		return fmt.Errorf("Unknown binary: %s", binary)
+	}
+	if opts.channel == "nightly" {
+		if binary != "node" {
+			return fmt.Errorf("--channel nightly is only supported for node, not %s", binary)
+		}
+		sources = []source{nightlySource{}}
+	}
+
+	if len(opts.platforms) > 1 {
+		if opts.printURLOnly {
+			return errors.New("--print-url-only can't be used with more than one --platform")
+		}
+		if binary != "node" {
+			return fmt.Errorf("--platform is only supported for node, not %s", binary)
+		}
+		entries, err := resolveAcrossPlatforms(sources, normalizeRequirement(versionRequirement), opts.platforms)
+		if err != nil {
+			return err
+		}
+		return printPlatformEntries(entries, opts)
+	}
+	return resolveWithSources(sources, binary, versionRequirement, opts)
+}
+
+// Resolves a binary and writes out the result. Nothing is written if
+// resolution fails, so an existing --output-file is left as it was
+func resolveWithSources(sources []source, binary string, versionRequirement string, opts options) error {
+	versionRequirement = normalizeRequirement(versionRequirement)
+
+	if opts.requireSigned && binary != "node" {
+		return fmt.Errorf("--require-signed is only supported for node, not %s", binary)
+	}
+	if opts.withHeaders && binary != "node" {
+		return fmt.Errorf("--with-headers is only supported for node, not %s", binary)
+	}
+	if opts.printURLOnly && opts.json {
+		return errors.New("Only one of --print-url-only and --json can be used")
+	}
+	if opts.env && (opts.json || opts.printURLOnly) {
+		return errors.New("--env can't be used with --json or --print-url-only")
+	}
+	if opts.shellPrefix != "" && !opts.env {
+		return errors.New("--shell-prefix requires --shell or --env")
+	}
+	if opts.shellPrefix != "" {
+		if err := validateShellPrefix(opts.shellPrefix); err != nil {
+			return err
+		}
+	}
+	if opts.withBundledNpm && binary != "node" {
+		return fmt.Errorf("--with-bundled-npm is only supported for node, not %s", binary)
+	}
+	if opts.withBundledNpm && opts.printURLOnly {
+		return errors.New("--with-bundled-npm can't be used with --print-url-only")
+	}
+	if opts.includeMetadata && opts.printURLOnly {
+		return errors.New("--include-metadata can't be used with --print-url-only")
+	}
+	if opts.anyChannel && binary != "node" {
+		return fmt.Errorf("--any-channel is only supported for node, not %s", binary)
+	}
+	if opts.strict && opts.nearestOnMissing {
+		return errors.New("--nearest-on-missing can't be used with --strict")
+	}
+	var asserted semver.Version
+	if opts.assert != "" {
+		var err error
+		if asserted, err = parseAssertedVersion(opts.assert); err != nil {
+			return err
+		}
+	}
+	if opts.failOnMajor && opts.current == "" {
+		return errors.New("--fail-on-major requires --current")
+	}
+	if opts.ceiling != "" {
+		if err := checkCeiling(versionRequirement, opts.ceiling); err != nil {
+			return err
+		}
+	}
+	var current semver.Version
+	if opts.current != "" {
+		var err error
+		if current, err = parseCurrentVersion(opts.current); err != nil {
+			return err
+		}
+	}
+
+	result, err := resolveFromSources(sources, binary, versionRequirement)
+	if err != nil {
+		return err
+	}
+	if !result.matched && opts.nearestOnMissing {
+		if version, err := semver.Make(versionRequirement); err == nil {
+			if nearest, ok := nearestRelease(result.closest, version); ok {
+				fmt.Fprintf(os.Stderr, "WARNING: %s %s is not available, using %s instead because of --nearest-on-missing\n", binary, versionRequirement, nearest.version.String())
+				result.release = nearest
+				result.matched = true
+			}
+		}
+	}
+
+	if !result.matched {
+		// the shell matches on the exact "No result" output, so the suggestion
+		// goes to stderr where it still ends up in the build log
+		if result.capped != nil {
+			fmt.Fprintln(os.Stderr, cappedMessage(binary, versionRequirement, *result.capped))
+		} else if len(result.closest) > 0 {
+			fmt.Fprintf(os.Stderr, "%s %s is not available, the closest versions are %s\n", binary, versionRequirement, describeReleases(result.closest))
+		}
+		return errors.New("No result")
+	}
+
+	if opts.requireSigned {
+		if err := verifySignedRelease(result.release); err != nil {
+			return err
+		}
+	}
+
+	if opts.verifyDownload != "" {
+		if err := verifyDownload(opts.verifyDownload, result.release); err != nil {
+			return err
+		}
+	}
+	if opts.verifyURL {
+		if err := verifyURL(result.release); err != nil {
+			return err
+		}
+	}
+
+	if opts.withBundledNpm {
+		npm, err := bundledNpm(result.release)
+		if err != nil {
+			return err
+		}
+		result.bundledNpm = &npm
+	}
+
+	if opts.verbose {
+		fmt.Fprintf(os.Stderr, "Resolved %s %s to %s\n", binary, versionRequirement, describeRelease(result.release))
+	}
+
+	if opts.assert != "" {
+		if err := assertResolvedVersion(binary, versionRequirement, result.release, asserted); err != nil {
+			return err
+		}
+	}
+	if opts.current != "" {
+		if message := majorUpgradeMessage(binary, versionRequirement, result.release, current); message != "" {
+			if opts.failOnMajor {
+				return fmt.Errorf("%s, and --fail-on-major is set", message)
+			}
+			fmt.Fprintf(os.Stderr, "WARNING: %s\n", message)
+		}
+	}
+
+	if err := printResult(result, opts); err != nil {
+		return err
+	}
+	reportUsage(binary, result.release)
+	return nil
+}
+
+// Tries each source in order, returning the first match
+func resolveFromSources(sources []source, binary string, versionRequirement string) (matchResult, error) {
+	var result matchResult
+	var cappedMatch *release
+	closest := []release{}
+	for i, src := range sources {
+		fallback, isFallback := src.(fallbackSource)
+		isFallback = isFallback && i > 0
+		if isFallback && !fallback.mayMatch(versionRequirement) {
+			continue
+		}
+		releases, err := listForRequirement(src, binary, versionRequirement)
+		if err != nil && isFallback {
+			fmt.Fprintf(os.Stderr, "Warning: could not list more releases of %s: %s\n", binary, err)
+			continue
+		}
+		if err != nil {
+			return matchResult{}, err
+		}
+		releases = publishedAsOf(releases)
+		var capped []release
+		if binary == "node" {
+			releases, capped = capReleases(releases)
+		}
+
+		if _, ok := src.(nightlySource); ok {
+			result, err = resolveNightly(releases, getPlatform(), versionRequirement)
+		} else if gh, ok := src.(githubSource); ok && gh.platforms {
+			result, err = resolveGitHub(releases, getPlatform(), versionRequirement)
+		} else if binary == "node" {
+			result, err = resolveNodeWithFallback(releases, getPlatform(), versionRequirement)
+		} else if binary == "pnpm" {
+			result, err = resolvePnpm(releases, versionRequirement)
+		} else {
+			result, err = resolveYarn(releases, versionRequirement)
+		}
+		if err != nil || result.matched {
+			return result, err
+		}
+		closest = append(closest, result.closest...)
+		if newest, ok := newestCappedMatch(capped, versionRequirement); ok && (cappedMatch == nil || versionLess(cappedMatch.version, newest.version)) {
+			cappedMatch = &newest
+		}
+	}
+	result.capped = cappedMatch
+
+	if version, err := semver.Make(versionRequirement); err == nil {
+		result.closest = closestReleases(closest, version)
+	}
+	return result, nil
+}
+
+// Returns the newest release older than version and the oldest release newer
+// than it, whichever of these exist
+func closestReleases(releases []release, version semver.Version) []release {
+	var below, above *release
+	for i := range releases {
+		v := releases[i].version
+		if v.LT(version) && (below == nil || v.GT(below.version)) {
+			below = &releases[i]
+		}
+		if v.GT(version) && (above == nil || v.LT(above.version)) {
+			above = &releases[i]
+		}
+	}
+
+	closest := []release{}
+	if below != nil {
+		closest = append(closest, *below)
+	}
+	if above != nil {
+		closest = append(closest, *above)
+	}
+	return closest
 }
 
-type matchResult struct {
-	versionRequirement string
-	release            release
-	matched            bool
+// Describes everything that identifies a release, for --verbose output
+func describeRelease(rel release) string {
+	parts := []string{rel.version.String()}
+	if rel.stage != "" {
+		parts = append(parts, "stage "+rel.stage)
+	}
+	if rel.platform != "" {
+		parts = append(parts, "platform "+rel.platform)
+	}
+	if rel.qualifier != "" {
+		parts = append(parts, "qualifier "+rel.qualifier)
+	}
+	return strings.Join(parts, ", ")
 }
 
-func main() {
-	if len(os.Args) < 3 {
-		printUsage()
-		os.Exit(0)
+// Picks the release to use in place of an exact version that isn't available,
+// from the closest releases on either side of it. An older patch of the same
+// minor version is preferred, then a newer one, then the same goes for the
+// same major version. Other major versions and prereleases are never used
+func nearestRelease(closest []release, version semver.Version) (release, bool) {
+	for _, sameMinor := range []bool{true, false} {
+		for _, rel := range closest {
+			v := rel.version
+			if len(v.Pre) > 0 || v.Major != version.Major || sameMinor && v.Minor != version.Minor {
+				continue
+			}
+			return rel, true
+		}
 	}
+	return release{}, false
+}
 
-	if os.Args[1] == "list" {
-		binary := os.Args[2]
-		list(binary)
-	} else {
-		binary := os.Args[1]
-		versionRequirement := os.Args[2]
-		resolve(binary, versionRequirement)
+func describeReleases(releases []release) string {
+	versions := make([]string, len(releases))
+	for i, rel := range releases {
+		versions[i] = rel.version.String()
 	}
+	return strings.Join(versions, " and ")
 }
 
-func resolve(binary string, versionRequirement string) {
-	// special-case this string since nodebin does as well and some users use it
-	if versionRequirement == "latest" {
-		versionRequirement = "*"
+// Writes the resolved release to stdout, or to the file given by --output-file
+// so that the result doesn't get mixed up with any other output
+func printResult(result matchResult, opts options) error {
+	entry := listEntry{Version: result.release.version.String(), URL: result.release.url, Qualifier: result.release.qualifier}
+	if opts.withHeaders {
+		entry.HeadersURL = headersURL(result.release)
+	}
+	if opts.anyChannel {
+		entry.Channel = result.release.stage
+	}
+	if opts.includeMetadata {
+		entry.ETag = result.release.etag
+		entry.StorageClass = result.release.storageClass
+		entry.Size = result.release.size
+	}
+	if result.bundledNpm != nil {
+		entry.NpmVersion = result.bundledNpm.version.String()
+		entry.NpmURL = result.bundledNpm.url
 	}
+	entry.Integrity = opts.integrity
 
-	if binary == "node" {
-		objects, err := listS3Objects("heroku-nodebin", "us-east-1", "node")
+	sep := outputSeparator(opts.separator)
+	var out []byte
+	if opts.json {
+		data, err := json.MarshalIndent(entry, "", "  ")
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		result, err := resolveNode(objects, getPlatform(), versionRequirement)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return err
 		}
-		if result.matched {
-			fmt.Printf("%s %s\n", result.release.version.String(), result.release.url)
+		out = append(data, '\n')
+	} else if opts.env {
+		out = []byte(envExports(envFormatFor(opts), result.release.binary, result.release, opts.withHeaders))
+	} else if opts.printURLOnly {
+		out = []byte(entry.URL + "\n")
+	} else if opts.withHeaders {
+		out = []byte(strings.Join([]string{entry.Version, entry.URL, entry.HeadersURL}, sep) + "\n")
+	} else {
+		out = []byte(entry.Version + sep + entry.URL + "\n")
+	}
+	if opts.includeMetadata && !opts.json {
+		if opts.env {
+			out = append(out, envMetadata(envFormatFor(opts), result.release.binary, result.release)...)
 		} else {
-			fmt.Println("No result")
-			os.Exit(1)
-		}
-	} else if binary == "yarn" {
-		objects, err := listS3Objects("heroku-nodebin", "us-east-1", "yarn")
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			out = append(out, fmt.Sprintf("etag=%s storageClass=%s size=%d\n", entry.ETag, entry.StorageClass, entry.Size)...)
 		}
-		result, err := resolveYarn(objects, versionRequirement)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	}
+	if entry.Integrity != "" && !opts.json && !opts.printURLOnly {
+		if opts.env {
+			out = append(out, envIntegrity(envFormatFor(opts), result.release.binary, entry.Integrity)...)
+		} else {
+			out = append(out, fmt.Sprintf("integrity=%s\n", entry.Integrity)...)
 		}
-		if result.matched {
-			fmt.Printf("%s %s\n", result.release.version.String(), result.release.url)
+	}
+	if result.bundledNpm != nil && !opts.json {
+		if opts.env {
+			// --shell-prefix names the binary that was resolved, not npm
+			out = append(out, envExports(envFormat{export: opts.shell}, "npm", *result.bundledNpm, false)...)
 		} else {
-			fmt.Println("No result")
-			os.Exit(1)
+			out = append(out, entry.NpmVersion+sep+entry.NpmURL+"\n"...)
 		}
 	}
+
+	return writeOutput(out, opts)
+}
+
+// The names --separator accepts for characters that are awkward to pass on a
+// command line. Anything else is used as it is
+var separatorNames = map[string]string{
+	"TAB":   "\t",
+	"NL":    "\n",
+	"SPACE": " ",
+}
+
+// Returns what --separator says to join the fields of the default output with
+func outputSeparator(separator string) string {
+	if sep, ok := separatorNames[separator]; ok {
+		return sep
+	}
+	if separator == "" {
+		return " "
+	}
+	return separator
+}
+
+// Writes output to stdout, or to the file given by --output-file
+func writeOutput(out []byte, opts options) error {
+	if opts.outputFile == "" {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	return writeFileAtomic(opts.outputFile, out)
+}
+
+// Writes data to a temporary file in the same directory as path and renames it
+// into place, so readers will either see the previous contents or the complete
+// new contents, never a partial write
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// this is a no-op once the file has been renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
-func list(binary string) {
+func list(binary string, opts options) {
 	platform := getPlatform()
-	objects, err := listS3Objects("heroku-nodebin", "us-east-1", binary)
+	sources := sourcesFor(binary, opts.source)
+	if len(sources) == 0 {
+		fmt.Printf("Unknown binary: %s\n", binary)
+		os.Exit(1)
+	}
+
+	// ignore any releases that are not for the given platform
+	// unless the platform is empty (for yarn)
+	releases, err := listMatching(sources[0], binary, func(rel release) bool {
+		return (rel.platform == platform || rel.platform == "") && rel.stage == "release"
+	})
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	releases = publishedAsOf(releases)
 
-	for _, obj := range objects {
-		release, err := parseObject(obj.Key)
-		if err != nil {
-			continue
+	if opts.countByMajor {
+		if opts.latestPerMajor || opts.listMajors {
+			fmt.Println("--count-by-major can't be used with --latest-per-major or --list-majors")
+			os.Exit(1)
 		}
+		printJSON(countByMajor(releases))
+		return
+	}
 
-		// ignore any releases that are not for the given platform
-		// unless the platform is empty (for yarn)
-		if release.platform != platform && release.platform != "" {
-			continue
+	if opts.latestPerMajor || opts.listMajors {
+		releases = latestPerMajor(releases)
+	}
+	releases, err = limitReleases(releases, opts.limit, opts.prefer)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if opts.listMajors {
+		printMajorSummary(binary, releases, opts)
+	} else if opts.latestPerMajor {
+		printMajorReleases(releases, opts)
+	} else {
+		printReleases(releases, opts)
+	}
+}
+
+type listEntry struct {
+	Version    string `json:"version"`
+	URL        string `json:"url"`
+	HeadersURL string `json:"headersUrl,omitempty"`
+	Qualifier  string `json:"qualifier,omitempty"`
+	Channel    string `json:"channel,omitempty"`
+	NpmVersion string `json:"npmVersion,omitempty"`
+	NpmURL     string `json:"npmUrl,omitempty"`
+	// only with --include-metadata
+	ETag         string `json:"etag,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	// only with --from-package-manager and --with-integrity
+	Integrity string `json:"integrity,omitempty"`
+}
+
+type majorEntry struct {
+	Major   uint64 `json:"major"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+}
+
+func printReleases(releases []release, opts options) {
+	if opts.json {
+		entries := make([]listEntry, len(releases))
+		for i, rel := range releases {
+			entries[i] = listEntry{Version: rel.version.String(), URL: rel.url, Qualifier: rel.qualifier}
+		}
+		printJSON(entries)
+		return
+	}
+
+	sep := outputSeparator(opts.separator)
+	for _, rel := range releases {
+		fmt.Printf("%s%s%s\n", rel.version.String(), sep, rel.url)
+	}
+}
+
+func printMajorReleases(releases []release, opts options) {
+	if opts.json {
+		entries := make([]majorEntry, len(releases))
+		for i, rel := range releases {
+			entries[i] = majorEntry{Major: rel.version.Major, Version: rel.version.String(), URL: rel.url}
+		}
+		printJSON(entries)
+		return
+	}
+
+	sep := outputSeparator(opts.separator)
+	for _, rel := range releases {
+		fmt.Printf("%d%s%s%s%s\n", rel.version.Major, sep, rel.version.String(), sep, rel.url)
+	}
+}
+
+type majorSummaryEntry struct {
+	Major   uint64 `json:"major"`
+	Version string `json:"version"`
+	LTS     bool   `json:"lts"`
+}
+
+// Prints the newest version of each major for --list-majors, like
+//
+//	16 -> 16.20.2 (LTS)
+//	17 -> 17.9.1
+func printMajorSummary(binary string, releases []release, opts options) {
+	if opts.json {
+		entries := make([]majorSummaryEntry, len(releases))
+		for i, rel := range releases {
+			entries[i] = majorSummaryEntry{Major: rel.version.Major, Version: rel.version.String(), LTS: isLTSMajor(binary, rel.version.Major)}
+		}
+		printJSON(entries)
+		return
+	}
+
+	for _, rel := range releases {
+		if isLTSMajor(binary, rel.version.Major) {
+			fmt.Printf("%d -> %s (LTS)\n", rel.version.Major, rel.version.String())
+		} else {
+			fmt.Printf("%d -> %s\n", rel.version.Major, rel.version.String())
 		}
+	}
+}
+
+// Every even major of node since 4 has been an LTS line
+func isLTSMajor(binary string, major uint64) bool {
+	return binary == "node" && major >= 4 && major%2 == 0
+}
+
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(string(out))
+}
+
+// Keeps the highest limit releases, or the lowest when prefer is "lowest",
+// in ascending order. A limit of 0 keeps every release in the order given
+func limitReleases(releases []release, limit int, prefer string) ([]release, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("--limit can't be negative: %d", limit)
+	}
+	if prefer != "highest" && prefer != "lowest" {
+		return nil, fmt.Errorf("Unknown --prefer: %s", prefer)
+	}
+	if limit == 0 {
+		return releases, nil
+	}
+
+	sorted := append([]release{}, releases...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].version.LT(sorted[j].version)
+	})
+	if limit > len(sorted) {
+		limit = len(sorted)
+	}
+	if prefer == "lowest" {
+		return sorted[:limit], nil
+	}
+	return sorted[len(sorted)-limit:], nil
+}
+
+// Reduces a list of releases to the newest release of each major version,
+// ordered by major version so the output is stable regardless of the order
+// the releases were listed in
+func latestPerMajor(releases []release) []release {
+	groups := groupByMajor(releases)
+
+	out := make([]release, 0, len(groups))
+	for _, group := range groups {
+		out = append(out, newestRelease(group))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].version.Major < out[j].version.Major
+	})
+	return out
+}
+
+func groupByMajor(releases []release) map[uint64][]release {
+	groups := map[uint64][]release{}
+	for _, rel := range releases {
+		groups[rel.version.Major] = append(groups[rel.version.Major], rel)
+	}
+	return groups
+}
 
-		if release.stage == "release" {
-			fmt.Printf("%s %s\n", release.version.String(), release.url)
+func newestRelease(releases []release) release {
+	newest := releases[0]
+	for _, rel := range releases[1:] {
+		if rel.version.GT(newest.version) {
+			newest = rel
 		}
 	}
+	return newest
+}
+
+type majorCount struct {
+	Major  uint64 `json:"major"`
+	Count  int    `json:"count"`
+	Newest string `json:"newest"`
+}
+
+// Counts the versions of each major for --count-by-major, oldest major first.
+// This is a list rather than an object keyed by major, because encoding/json
+// sorts keys as strings, which puts 10 before 4
+func countByMajor(releases []release) []majorCount {
+	counts := []majorCount{}
+	for major, group := range groupByMajor(releases) {
+		counts = append(counts, majorCount{Major: major, Count: len(group), Newest: newestRelease(group).version.String()})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Major < counts[j].Major })
+	return counts
 }
 
 func printUsage() {
 	fmt.Println("resolve-version BINARY VERSION_REQUIREMENT")
 	fmt.Println("resolve-version list BINARY")
+	fmt.Println("resolve-version --resolve BINARY=VERSION_REQUIREMENT [--resolve ...]")
+	fmt.Println("resolve-version lock BINARY=VERSION_REQUIREMENT [BINARY=VERSION_REQUIREMENT ...]")
+	fmt.Println("resolve-version install --locked")
+	fmt.Println("resolve-version prewarm BINARY MAJOR")
+	fmt.Println("resolve-version check-update")
+	fmt.Println("resolve-version selftest")
+	fmt.Println("resolve-version refresh-cache [node|yarn]")
+	fmt.Println("resolve-version cache info|clear|prune")
+	fmt.Println("resolve-version lookup URL")
+	fmt.Println("resolve-version --serve ADDRESS")
+	fmt.Println("resolve-version --from-nvmrc PATH")
+	fmt.Println("resolve-version --from-package-manager PATH")
+	fmt.Println("resolve-version --constraints-from-env BINARY")
+	fmt.Println("resolve-version BINARY --compare VERSION_REQUIREMENT --with VERSION_REQUIREMENT")
+	fmt.Println("resolve-version BINARY --validate VERSION_REQUIREMENT")
+	fmt.Println("resolve-version BINARY --candidates-json")
+	fmt.Println("resolve-version BINARY --check-update VERSION")
+	fmt.Println("resolve-version --follow BINARY VERSION_REQUIREMENT")
+	fmt.Println("resolve-version BINARY --range VERSION_REQUIREMENT --ceiling VERSION_REQUIREMENT")
+	fmt.Println("resolve-version node --dist-tag TAG")
+	fmt.Println("")
+	fmt.Println("Options:")
+	fmt.Println("  --json              print the output as JSON")
+	fmt.Println("  --output-file PATH  write the resolved version to PATH instead of stdout")
+	fmt.Println("  --latest-per-major  only list the newest release of each major version")
+	fmt.Println("  --list-majors       list a summary of each major version and its newest release,")
+	fmt.Println("                      like 18 -> 18.20.4 (LTS), where node's even majors are LTS")
+	fmt.Println("  --count-by-major    print a JSON list of each major version's number of")
+	fmt.Println("                      available versions and its newest release")
+	fmt.Println("  --http1-only        don't negotiate HTTP/2 when listing releases")
+	fmt.Println("  --require-signed    verify the resolved node tarball against the checksums")
+	fmt.Println("                      signed by the node release keys")
+	fmt.Println("  --timings           print how long listing, parsing, and matching took to stderr")
+	fmt.Println("  --min-node-for-yarn warn if the yarn resolved by --resolve doesn't support the")
+	fmt.Println("                      node resolved alongside it")
+	fmt.Println("  --strict            fail instead of warning when a check doesn't pass, and only")
+	fmt.Println("                      resolve to a version that matches the requirement for this")
+	fmt.Println("                      platform. By default node falls back to a darwin-x64 build")
+	fmt.Println("                      on darwin-arm64, and --nearest-on-missing can resolve a")
+	fmt.Println("                      missing pin to a nearby version. --strict turns off the")
+	fmt.Println("                      first and can't be used with the second")
+	fmt.Println("  --fail-fast         stop --resolve at the first binary that can't be resolved")
+	fmt.Println("  --best-effort       resolve every binary given to --resolve and report the ones")
+	fmt.Println("                      that failed at the end, exiting non-zero (default)")
+	fmt.Println("  --with-headers      also print the URL of the headers tarball for the resolved")
+	fmt.Println("                      node, after the tarball URL")
+	fmt.Println("  --lockfile PATH     the lockfile written by lock and read by install, defaults")
+	fmt.Println("                      to node-resolve.lock")
+	fmt.Println("  --locked            install the versions pinned in the lockfile without listing")
+	fmt.Println("                      any releases")
+	fmt.Println("  --verify            with install --locked, check that each pinned release is")
+	fmt.Println("                      still listed with the same ETag, and with prewarm, that")
+	fmt.Println("                      each release's tarball can be fetched")
+	fmt.Println("  --verify-download PATH")
+	fmt.Println("                      check that the tarball downloaded to PATH has the size and")
+	fmt.Println("                      MD5 ETag listed for the resolved release")
+	fmt.Println("  --verify-url        check that the resolved tarball responds to a HEAD request")
+	fmt.Println("                      with a 200, and a Content-Length matching its listed size")
+	fmt.Println("                      With lookup, check that URL still exists and print its")
+	fmt.Println("                      size, ETag, and last modified time with --json")
+	fmt.Println("  --nearest-on-missing")
+	fmt.Println("                      when an exact version isn't available, warn and use the")
+	fmt.Println("                      nearest patch of the same minor, or else of the same major")
+	fmt.Println("  --print-url-only    print only the URL of the resolved tarball, without the")
+	fmt.Println("                      version. Can't be used with --json")
+	fmt.Println("  --channel NAME      which builds of node to resolve from:")
+	fmt.Println("                        release  released versions (default)")
+	fmt.Println("                        nightly  the daily builds at nodejs.org/download/nightly,")
+	fmt.Println("                                 by date (2023-10-01), commit, or version range")
+	fmt.Println("  --limit N           with list, print only the N highest releases, in ascending")
+	fmt.Println("                      order. 0, the default, prints every release")
+	fmt.Println("  --prefer ORDER      with list --limit, print the highest (default) or lowest")
+	fmt.Println("                      releases, to page through the newest or oldest")
+	fmt.Println("  --constraints-from-env")
+	fmt.Println("                      when no VERSION_REQUIREMENT is given, use the first of:")
+	fmt.Println("                        NODE_VERSION, YARN_VERSION, PNPM_VERSION, or NPM_VERSION")
+	fmt.Println("                        engines in ./package.json")
+	fmt.Println("                        the buildpack's default, 12.x for node and 1.x for yarn")
+	fmt.Println("                      A VERSION_REQUIREMENT argument always takes precedence")
+	fmt.Println("  --env               print the result as NODE_VERSION='...' and NODE_URL='...',")
+	fmt.Println("                      or YARN_VERSION and YARN_URL, for a shell to eval")
+	fmt.Println("  --shell             like --env, but as export NODE_VERSION='...' and")
+	fmt.Println("                      export NODE_URL='...', so that eval exports them")
+	fmt.Println("  --shell-prefix P    name the variables --shell and --env print P_VERSION and")
+	fmt.Println("                      P_URL instead of after the binary")
+	fmt.Println("  --compare REQ       with --with REQ, resolve both requirements and show whether")
+	fmt.Println("                      moving between them is a major, minor, or patch change")
+	fmt.Println("  --validate REQ      exit non-zero if REQ can't be parsed, using --semver-mode,")
+	fmt.Println("                      without listing any releases")
+	fmt.Println("  --quiet             don't show the progress of listings, which is otherwise")
+	fmt.Println("                      shown on stderr when run in a terminal")
+	fmt.Println("  --normalize-only    print the requirement, or .nvmrc with --from-nvmrc, as")
+	fmt.Println("                      it would be matched, without listing any releases")
+	fmt.Println("  --include-staging   let ranges resolve to staging builds, after releases and")
+	fmt.Println("                      any NODE_RESOLVE_STAGES. Cached listings are only used")
+	fmt.Println("                      for up to 1m while staging builds can be resolved")
+	fmt.Println("  --include-metadata  also print the ETag, S3 storage class, and size of the")
+	fmt.Println("                      resolved object, as etag=... storageClass=... size=... on")
+	fmt.Println("                      a second line, or as fields of --json and --env output")
+	fmt.Println("  --any-channel       resolve node to the newest match in either release or")
+	fmt.Println("                      staging, preferring release when both have it. The channel")
+	fmt.Println("                      it came from is in --json and --verbose output")
+	fmt.Println("  --insecure          don't verify TLS certificates, for local and test mirrors")
+	fmt.Println("                      with self-signed certificates. Never use this in a build;")
+	fmt.Println("                      NODE_RESOLVE_CA_BUNDLE trusts a mirror's CA instead")
+	fmt.Println("  --check-update VERSION")
+	fmt.Println("                      print the newest release in the same major as the pinned")
+	fmt.Println("                      VERSION and the newest overall. Exits 0 when VERSION is the")
+	fmt.Println("                      newest, or 2, 3, or 4 when a newer patch, minor, or major")
+	fmt.Println("                      is the most significant update available")
+	fmt.Println("  --assert VERSION    exit 1 with the difference unless the requirement resolves")
+	fmt.Println("                      to exactly VERSION, for CI gates that pin what a mirror serves")
+	fmt.Println("  --current VERSION   warn on stderr when the requirement resolves to a higher")
+	fmt.Println("                      major than VERSION, like a loose >=18 picking up node 20")
+	fmt.Println("  --fail-on-major     with --current, fail on a major upgrade instead of warning")
+	fmt.Println("  --follow            keep listing releases, printing a line for each new version")
+	fmt.Println("                      that satisfies the requirement as it's published")
+	fmt.Println("  --follow-interval D how often --follow lists releases, defaults to 1m")
+	fmt.Println("  --follow-for D      stop --follow after D, which otherwise runs until interrupted")
+	fmt.Println("  --range REQ         the VERSION_REQUIREMENT, given as a flag to pair with --ceiling")
+	fmt.Println("  --ceiling REQ       only resolve to releases that also satisfy REQ, like \"<19\",")
+	fmt.Println("                      so --range \">=16\" --ceiling \"<19\" is like \">=16 <19\"")
+	fmt.Println("  --max-major N       only resolve to the newest release in major N or below, so")
+	fmt.Println("                      \"*\" resolves to the latest 18.x with --max-major 18")
+	fmt.Println("  --exclude LIST      never resolve to these comma separated versions or ranges,")
+	fmt.Println("                      like \"18.17.0,>=18.18.0 <18.18.2\", even when they're the")
+	fmt.Println("                      newest match")
+	fmt.Println("  --variant NAME      only resolve node to builds of a variant, like the")
+	fmt.Println("                      pointer-compression in node-v22.0.0-linux-x64-pointer-compression,")
+	fmt.Println("                      or \"standard\" for only unsuffixed builds. By default the")
+	fmt.Println("                      standard build is preferred when a version has several")
+	fmt.Println("  --force-ipv4        only connect over IPv4, overriding NODE_RESOLVE_IP")
+	fmt.Println("  --force-ipv6        only connect over IPv6, for IPv6-only build networks")
+	fmt.Println("  --candidates-json   print every release of BINARY that could be resolved to on")
+	fmt.Println("                      this platform, in any stage, as a JSON array with the same")
+	fmt.Println("                      fields as --json")
+	fmt.Println("  --trace-http        log each request, its status, and the first 512 bytes of its")
+	fmt.Println("                      response to stderr, with any signatures redacted")
+	fmt.Println("  --as-of DATE        only resolve to releases published on or before DATE, like")
+	fmt.Println("                      2023-01-01 in UTC, to reproduce an old build. Releases")
+	fmt.Println("                      without a publication date, like yarn 2+, are left out")
+	fmt.Println("  --with-bundled-npm  also resolve the npm that ships with the resolved node, from")
+	fmt.Println("                      the nodejs.org release index, printing its version and npm")
+	fmt.Println("                      registry tarball on a second line, as NPM_VERSION and")
+	fmt.Println("                      NPM_URL with --env, or as npmVersion and npmUrl with --json")
+	fmt.Println("  --separator SEP     join the version and URL with SEP instead of a space:")
+	fmt.Println("                      TAB, NL, SPACE, or any literal string")
+	fmt.Println("  --dist-tag TAG      resolve node to the version that TAG points to in the")
+	fmt.Println("                      nodejs.org release index:")
+	fmt.Println("                        latest   the newest release")
+	fmt.Println("                        lts      the newest release of an LTS line")
+	fmt.Println("                        rc       the newest release candidate")
+	fmt.Println("                        nightly  the newest nightly build, like --channel nightly")
+	fmt.Println("  --platform LIST     resolve node for these comma separated platforms, like")
+	fmt.Println("                      linux-x64,linux-arm64, instead of this machine's. With more")
+	fmt.Println("                      than one, the same version is resolved for each and printed")
+	fmt.Println("                      as a JSON array")
+	fmt.Println("  --verbose           describe the stage, platform, and build of the resolved")
+	fmt.Println("                      release on stderr, and log when S3 rate limits requests")
+	fmt.Println("  --semver-mode MODE  how version requirements are interpreted:")
+	fmt.Println("                        strict  the semver library's range syntax (default)")
+	fmt.Println("                        npm     the rules npm uses for engines, where")
+	fmt.Println("                                prereleases only match ranges that name one")
+	fmt.Println("  --prerelease-order ORDER")
+	fmt.Println("                      how the newest of the versions that match is picked:")
+	fmt.Println("                        semver         prereleases come before their release,")
+	fmt.Println("                                       18.0.0-rc.1 < 18.0.0 (default)")
+	fmt.Println("                        after-release  prereleases come after their release and")
+	fmt.Println("                                       before the next patch, 18.0.0 <")
+	fmt.Println("                                       18.0.0-rc.1 < 18.0.1")
+	fmt.Println("  --serve ADDRESS     serve GET /resolve?binary=BINARY&requirement=REQUIREMENT")
+	fmt.Println("                      as JSON on ADDRESS, like :8080, keeping listings in memory")
+	fmt.Println("  --serve-refresh D   how often --serve lists releases again, defaults to 5m")
+	fmt.Println("  --from-nvmrc PATH   resolve node from the version, range, or alias like")
+	fmt.Println("                      lts/hydrogen in an .nvmrc file")
+	fmt.Println("  --from-package-manager PATH")
+	fmt.Println("                      resolve the yarn, pnpm, or npm that the Corepack")
+	fmt.Println("                      packageManager field of a package.json pins, like yarn@3.6.0")
+	fmt.Println("  --with-integrity    with --from-package-manager, also print the hash after the +")
+	fmt.Println("                      in packageManager, like sha224.953c8233...")
+	fmt.Println("  --source SOURCE     where node releases are listed from:")
+	fmt.Println("                        s3          the heroku-nodebin bucket (default)")
+	fmt.Println("                        nodejs-org  https://nodejs.org/dist/index.json, with")
+	fmt.Println("                                    tarballs downloaded from nodejs.org/dist")
+	fmt.Println("                        github      the GitHub releases of --repo, using the")
+	fmt.Println("                                    tarball attached for the platform")
+	fmt.Println("  --repo OWNER/NAME   the GitHub repository --source github lists releases of")
+	fmt.Println("")
+	fmt.Println("Environment:")
+	fmt.Println("  NODE_BINARIES_DIR          resolve against a local directory laid out like the")
+	fmt.Println("                             heroku-nodebin bucket instead of S3")
+	fmt.Println("  NODE_BINARIES_REGION       the S3 region to list releases from")
+	fmt.Println("  NODE_BINARIES_ENDPOINT     list and download releases from an S3-compatible store")
+	fmt.Println("                             like MinIO or R2 instead, like https://minio.example.com")
+	fmt.Println("  NODE_BINARIES_ADDRESSING   path to list https://host/heroku-nodebin, the default,")
+	fmt.Println("                             or virtual to list https://heroku-nodebin.host")
+	fmt.Println("  NODE_MAX_VERSION           never resolve node above this version, like 20.11.1 or 20")
+	fmt.Println("  NODE_RESOLVE_GLIBC         the host's glibc, like 2.17, to pick a glibc-qualified")
+	fmt.Println("                             build of node for, or none. Detected when unset")
+	fmt.Println("  NODE_RESOLVE_CA_BUNDLE     a PEM file of CAs to trust instead of the system roots")
+	fmt.Println("  NODE_RESOLVE_MIN_TLS       the minimum TLS version to accept, defaults to 1.2")
+	fmt.Println("  NODE_RESOLVE_MAX_FAILURES  stop after this many consecutive failed requests")
+	fmt.Println("  NODE_RESOLVE_IP            4 or 6 to only connect over IPv4 or IPv6, defaults to auto")
+	fmt.Println("  NODE_RESOLVE_FALLBACK_DELAY")
+	fmt.Println("                             how long to wait on IPv6 before also trying IPv4")
+	fmt.Println("  NODE_RESOLVE_DIAL_TIMEOUT  how long to wait for a connection, defaults to 30s")
+	fmt.Println("  NODE_RESOLVE_TLS_HANDSHAKE_TIMEOUT")
+	fmt.Println("                             how long to wait for the TLS handshake, defaults to 10s")
+	fmt.Println("  NODE_RESOLVE_RESPONSE_HEADER_TIMEOUT")
+	fmt.Println("                             how long to wait for a response to start, defaults to 30s")
+	fmt.Println("  NODE_RESOLVE_UPDATE_URL    where check-update finds the latest version of")
+	fmt.Println("                             resolve-version, served as plain text")
+	fmt.Println("  NODE_RESOLVE_TRACE_HTTP    set to anything to trace requests like --trace-http")
+	fmt.Println("  NODE_RESOLVE_CACHE_DIR     a directory to cache S3 listings in, which")
+	fmt.Println("                             refresh-cache lists again whether or not they expired.")
+	fmt.Println("                             cache info lists them, cache clear removes them, and")
+	fmt.Println("                             cache prune removes the expired ones")
+	fmt.Println("  NODE_RESOLVE_CACHE_TTL     how long a cached listing is used for, defaults to 1h,")
+	fmt.Println("                             and at most 1m when staging builds can be resolved")
+	fmt.Println("  NODE_RESOLVE_CACHE_FULL_TTL")
+	fmt.Println("                             how long an expired listing is refreshed with only the")
+	fmt.Println("                             keys after its last one before it's listed in full")
+	fmt.Println("                             again. Disabled by default, and always for staging")
+	fmt.Println("  NODE_RESOLVE_CACHE_VALIDATE")
+	fmt.Println("                             set to check a cached listing against the first page")
+	fmt.Println("                             of a fresh one before using it. One page listings are")
+	fmt.Println("                             then never stale, while longer ones are listed again")
+	fmt.Println("                             when their first page changed or they expired")
+	fmt.Println("  NODE_RESOLVE_RATE_LIMIT    the most S3 listing requests to make per second")
+	fmt.Println("  NODE_RESOLVE_NODE_KEY_TEMPLATE, NODE_RESOLVE_YARN_KEY_TEMPLATE,")
+	fmt.Println("  NODE_RESOLVE_PNPM_KEY_TEMPLATE")
+	fmt.Println("                             the layout of a mirror's keys, with the {stage},")
+	fmt.Println("                             {platform}, {version}, and {qualifier} fields. node")
+	fmt.Println("                             defaults to node/{stage}/{platform}/node-v{version}-{platform}{qualifier}.tar.gz")
+	fmt.Println("  NODE_RESOLVE_URL_TEMPLATE  the download URL of a release, like")
+	fmt.Println("                             https://mirror.example.com/{key}, with the {bucket},")
+	fmt.Println("                             {binary}, and {key} fields and those of the keys")
+	fmt.Println("  NODE_RESOLVE_TELEMETRY_URL opt in to reporting the binary, major version, and")
+	fmt.Println("                             platform of each resolution to this URL. Nothing else")
+	fmt.Println("                             is sent, and reporting never fails a resolution")
+	fmt.Println("  NODE_RESOLVE_NPM_REGISTRY  the npm registry pnpm is resolved from when it isn't in")
+	fmt.Println("                             heroku-nodebin, defaults to https://registry.npmjs.org")
+	fmt.Println("  NODE_RESOLVE_STAGES        the stages ranges resolve against, most preferred first,")
+	fmt.Println("                             like release,rc. Defaults to release")
+	fmt.Println("  NODE_RESOLVE_KEYRING       an armored keyring of node release keys for --require-signed,")
+	fmt.Println("                             instead of the ones embedded in resolve-version")
+	fmt.Println("  GITHUB_TOKEN               authenticates requests to the GitHub API")
+	fmt.Println("  NODE_RESOLVE_CONFIG        the config file to read, defaults to ~/.resolve-version.toml")
+	fmt.Println("")
+	fmt.Println("Config file:")
+	fmt.Println("  Defaults for any flag, or for the env vars above, can be set in a config file of")
+	fmt.Println("  key = value lines, like")
+	fmt.Println("")
+	fmt.Println("    platform = \"linux-arm64\"")
+	fmt.Println("    strict = true")
+	fmt.Println("    NODE_BINARIES_REGION = \"eu-west-1\"")
+	fmt.Println("")
+	fmt.Println("  Flags on the command line take precedence over env vars, which take precedence")
+	fmt.Println("  over the config file, which takes precedence over the built-in defaults.")
+}
+
+// The headers needed to build native modules are published next to each
+// node tarball, for the same stage and version
+func headersURL(rel release) string {
+	dir := rel.url[:strings.LastIndex(rel.url, "/")]
+	return fmt.Sprintf("%s/node-v%s-headers.tar.gz", dir, rel.version.String())
 }
 
 func getPlatform() string {
-	if runtime.GOOS == "darwin" {
+	if platformOverride != "" {
+		return platformOverride
+	}
+	return platformFor(runtime.GOOS, runtime.GOARCH)
+}
+
+func platformFor(goos string, goarch string) string {
+	if goos == "darwin" {
+		if goarch == "arm64" {
+			return "darwin-arm64"
+		}
 		return "darwin-x64"
 	}
 	return "linux-x64"
 }
 
-func resolveNode(objects []s3Object, platform string, versionRequirement string) (matchResult, error) {
+// Platforms that can run the builds of another platform when there's no
+// native build, like Apple Silicon Macs running darwin-x64 builds under
+// Rosetta. Older versions of node were never built for darwin-arm64
+var platformFallbacks = map[string]string{
+	"darwin-arm64": "darwin-x64",
+}
+
+// Cleared by --strict, so that node only resolves to a native build
+var usePlatformFallbacks = true
+
+// Resolves node for the platform, falling back to a compatible platform when
+// no native build matches
+func resolveNodeWithFallback(releases []release, platform string, versionRequirement string) (matchResult, error) {
+	result, err := resolveNode(releases, platform, versionRequirement)
+	if err != nil || result.matched {
+		return result, err
+	}
+
+	fallback, ok := platformFallbacks[platform]
+	if !ok {
+		return result, nil
+	}
+	fallbackResult, err := resolveNode(releases, fallback, versionRequirement)
+	if err != nil || !fallbackResult.matched {
+		return result, err
+	}
+	if !usePlatformFallbacks {
+		fmt.Fprintf(os.Stderr, "No %s build of node matches %s, and --strict doesn't fall back to the %s build of %s\n", platform, versionRequirement, fallback, fallbackResult.release.version.String())
+		return result, nil
+	}
+	fmt.Fprintf(os.Stderr, "No %s build of node matches %s, using the %s build of %s instead\n", platform, versionRequirement, fallback, fallbackResult.release.version.String())
+	return fallbackResult, nil
+}
+
+func resolveNode(all []release, platform string, versionRequirement string) (matchResult, error) {
 	releases := []release{}
 	staging := []release{}
 
-	for _, obj := range objects {
-		release, err := parseObject(obj.Key)
-		if err != nil {
-			continue
-		}
-
-		// ignore any releases that are not for the given platform
-		if release.platform != platform {
+	for _, release := range all {
+		// ignore any releases that are not for the given platform, or that
+		// aren't the variant asked for. Unless a variant is asked for, builds
+		// for a newer glibc than the host's are ignored too
+		if release.platform != platform || !matchesVariant(release) || nodeVariant == "" && needsNewerGlibc(release) {
 			continue
 		}
 
-		if release.stage == "release" {
+		if stageRank(release.stage) < len(stagePreference) {
 			releases = append(releases, release)
 		} else {
 			staging = append(staging, release)
@@ -190,30 +1646,49 @@ func resolveNode(objects []s3Object, platform string, versionRequirement string)
 	return result, nil
 }
 
-func resolveYarn(objects []s3Object, versionRequirement string) (matchResult, error) {
-	releases := []release{}
+func resolveYarn(releases []release, versionRequirement string) (matchResult, error) {
+	return matchReleaseSemver(releases, versionRequirement)
+}
 
-	for _, obj := range objects {
-		release, err := parseObject(obj.Key)
-		if err != nil {
-			continue
+// Like resolveYarn, except that prereleases are only used when one is asked
+// for by its exact version
+func resolvePnpm(all []release, versionRequirement string) (matchResult, error) {
+	releases := []release{}
+	prereleases := []release{}
+	for _, rel := range all {
+		if rel.stage == "release" {
+			releases = append(releases, rel)
+		} else {
+			prereleases = append(prereleases, rel)
 		}
+	}
 
-		releases = append(releases, release)
+	result, err := matchReleaseSemver(releases, versionRequirement)
+	if err != nil || result.matched {
+		return result, err
+	}
+	if prereleaseResult := matchReleaseExact(prereleases, versionRequirement); prereleaseResult.matched {
+		return prereleaseResult, nil
 	}
+	return result, nil
+}
 
-	return matchReleaseSemver(releases, versionRequirement)
+// Whether --exclude, --max-major, and --ceiling all allow a version
+func isAllowed(version semver.Version) bool {
+	return !isExcluded(version) && !aboveMaxMajor(version) && !aboveCeiling(version)
 }
 
 func matchReleaseSemver(releases []release, versionRequirement string) (matchResult, error) {
-	constraints, err := semver.ParseRange(versionRequirement)
+	defer recordTiming("matching", time.Now())
+
+	constraints, err := parseRequirement(versionRequirement)
 	if err != nil {
 		return matchResult{}, err
 	}
 
 	filtered := []release{}
 	for _, release := range releases {
-		if constraints(release.version) {
+		if constraints(release.version) && isAllowed(release.version) {
 			filtered = append(filtered, release)
 		}
 	}
@@ -224,33 +1699,59 @@ func matchReleaseSemver(releases []release, versionRequirement string) (matchRes
 	}
 
 	coll := semver.Versions(versions)
-	sort.Sort(coll)
+	sort.Slice(coll, func(i, j int) bool {
+		return versionLess(coll[i], coll[j])
+	})
 
 	if len(coll) == 0 {
+		// what's suggested, and what --nearest-on-missing resolves to, has
+		// to be allowed too
+		var closest []release
+		if version, err := semver.Make(versionRequirement); err == nil {
+			allowed := []release{}
+			for _, rel := range releases {
+				if isAllowed(rel.version) {
+					allowed = append(allowed, rel)
+				}
+			}
+			closest = closestReleases(allowed, version)
+		}
 		return matchResult{
 			versionRequirement: versionRequirement,
 			release:            release{},
 			matched:            false,
+			closest:            closest,
 		}, nil
 	}
 
 	resolvedVersion := coll[len(coll)-1]
 
-	for _, rel := range filtered {
-		if rel.version.Equals(resolvedVersion) {
-			return matchResult{
-				versionRequirement: versionRequirement,
-				release:            rel,
-				matched:            true,
-			}, nil
+	// there may be several builds of the same version, in which case the one
+	// in the most preferred stage is used, and then the build preferredBuild
+	// picks for the host
+	var resolved *release
+	for i, rel := range filtered {
+		if !rel.version.Equals(resolvedVersion) {
+			continue
 		}
+		if resolved == nil || stageRank(rel.stage) < stageRank(resolved.stage) ||
+			stageRank(rel.stage) == stageRank(resolved.stage) && preferredBuild(rel, *resolved) {
+			resolved = &filtered[i]
+		}
+	}
+	if resolved == nil {
+		return matchResult{}, errors.New("Unknown error")
 	}
-	return matchResult{}, errors.New("Unknown error")
+	return matchResult{
+		versionRequirement: versionRequirement,
+		release:            *resolved,
+		matched:            true,
+	}, nil
 }
 
 func matchReleaseExact(releases []release, version string) matchResult {
 	for _, release := range releases {
-		if release.version.String() == version {
+		if release.version.String() == version && isAllowed(release.version) {
 			return matchResult{
 				versionRequirement: version,
 				release:            release,
@@ -265,90 +1766,276 @@ func matchReleaseExact(releases []release, version string) matchResult {
 	}
 }
 
-// Parses an S3 key into a struct of information about that release
+// Parses an S3 key into a struct of information about that release, using
+// the key template of each binary in turn. See keyTemplatesFromEnv
 // Example input: node/release/linux-x64/node-v6.2.2-linux-x64.tar.gz
 func parseObject(key string) (release, error) {
-	nodeRegex := regexp.MustCompile("node\\/([^\\/]+)\\/([^\\/]+)\\/node-v([0-9]+\\.[0-9]+\\.[0-9]+)-([^.]*)(.*)\\.tar\\.gz")
-	yarnRegex := regexp.MustCompile("yarn\\/([^\\/]+)\\/yarn-v([0-9]+\\.[0-9]+\\.[0-9]+)\\.tar\\.gz")
-
-	if nodeRegex.MatchString(key) {
-		match := nodeRegex.FindStringSubmatch(key)
-		version, err := semver.Make(match[3])
-		if err != nil {
-			return release{}, fmt.Errorf("Failed to parse version as semver:%s\n%s", match[3], err.Error())
-		}
-		return release{
-			binary:   "node",
-			stage:    match[1],
-			platform: match[2],
-			version:  version,
-			url:      fmt.Sprintf("https://s3.amazonaws.com/%s/node/%s/%s/node-v%s-%s.tar.gz", "heroku-nodebin", match[1], match[2], match[3], match[2]),
-		}, nil
-	}
-
-	if yarnRegex.MatchString(key) {
-		match := yarnRegex.FindStringSubmatch(key)
-		version, err := semver.Make(match[2])
+	for _, binary := range templateBinaries {
+		values, ok := keyTemplates[binary].match(key)
+		if !ok {
+			continue
+		}
+		version, err := parseKeyVersion(values["version"])
 		if err != nil {
+			if binary == "node" {
+				return release{}, fmt.Errorf("Failed to parse version as semver:%s\n%s", values["version"], err.Error())
+			}
 			return release{}, errors.New("Failed to parse version as semver")
 		}
-		return release{
-			binary:   "yarn",
-			stage:    match[1],
-			platform: "",
-			url:      fmt.Sprintf("https://s3.amazonaws.com/heroku-nodebin/yarn/release/yarn-v%s.tar.gz", version),
-			version:  version,
-		}, nil
+		// a layout without stages only has releases
+		stage := values["stage"]
+		if stage == "" {
+			stage = "release"
+		}
+		rel := release{
+			binary:    binary,
+			stage:     stage,
+			platform:  values["platform"],
+			qualifier: values["qualifier"],
+			version:   version,
+		}
+		if urlTemplate != "" {
+			rel.url = templateURL("heroku-nodebin", key, rel)
+		} else {
+			rel.url = objectURL("heroku-nodebin", key)
+		}
+		return rel, nil
 	}
 
 	return release{}, fmt.Errorf("Failed to parse key: %s", key)
 }
 
+// Parses the version in a key. Some mirrors publish keys like node-v16.00.0,
+// which semver rejects, so leading zeros are dropped from each component
+// first. The release's URL is still built from the key as it was listed
+func parseKeyVersion(v string) (semver.Version, error) {
+	parts := strings.Split(v, ".")
+	for i, part := range parts {
+		if trimmed := strings.TrimLeft(part, "0"); trimmed != part {
+			if trimmed == "" {
+				trimmed = "0"
+			}
+			parts[i] = trimmed
+		}
+	}
+	return semver.Make(strings.Join(parts, "."))
+}
+
+// Builds the download URL for an object. The URL is built from the key itself
+// so that it always points at the object that was listed
+func objectURL(bucketName string, key string) string {
+	u := url.URL{
+		Scheme: "https",
+		Host:   "s3.amazonaws.com",
+		Path:   fmt.Sprintf("/%s/%s", bucketName, key),
+	}
+	return u.String()
+}
+
 // Wrapper around the S3 API for listing objects
 // This maps directly to the API and parses the XML response but will not handle
 // paging and offsets automaticaly
-func fetchS3Result(bucketName string, region string, options map[string]string) (result, error) {
+//
+// The regional endpoint for the bucket is tried first, falling back to the
+// global endpoint if that request fails
+func fetchS3Result(endpoints []string, bucketName string, options map[string]string) (result, error) {
+	var result result
+	var err error
+	for _, endpoint := range endpoints {
+		result, err = fetchS3ResultFromEndpoint(endpoint, bucketName, options)
+		if err == nil {
+			return result, nil
+		}
+	}
+	return result, err
+}
+
+func fetchS3ResultFromEndpoint(endpoint string, bucketName string, options map[string]string) (result, error) {
 	var result result
 	v := url.Values{}
 	v.Set("list-type", "2")
+	// otherwise a key with characters that can't appear in XML 1.0 would make
+	// the whole listing unparseable
+	v.Set("encoding-type", "url")
+	// values are encoded here, and only here, so a continuation token has to
+	// be passed on exactly as S3 listed it
 	for key, val := range options {
 		v.Set(key, val)
 	}
-	url := fmt.Sprintf("https://%s.s3.%s.amazonaws.com?%s", bucketName, region, v.Encode())
-	resp, err := http.Get(url)
+	url := fmt.Sprintf("%s?%s", endpoint, v.Encode())
+	body, err := getS3Listing(url, bucketName)
 	if err != nil {
 		return result, err
 	}
 
-	if resp.StatusCode >= 300 {
-		return result, fmt.Errorf("Unexpected status code: %d for listing S3 bucket: %s", resp.StatusCode, bucketName)
+	if err := xml.Unmarshal(body, &result); err != nil {
+		if _, ok := err.(xml.UnmarshalError); ok {
+			return result, fmt.Errorf("Could not parse listing for S3 bucket: %s, %s", bucketName, err)
+		}
+		return result, err
+	}
+	if err := decodeResult(&result); err != nil {
+		return result, fmt.Errorf("Could not parse listing for S3 bucket: %s, %s", bucketName, err)
+	}
+
+	// A response that looks like a listing but isn't laid out the way S3 lays
+	// it out can parse without any objects, which would otherwise look like
+	// there were no releases to match against
+	if result.KeyCount > 0 && len(result.Contents)+len(result.CommonPrefixes) == 0 {
+		return result, fmt.Errorf("Could not parse listing for S3 bucket: %s, KeyCount is %d but no objects were found", bucketName, result.KeyCount)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return result, err
+	return result, nil
+}
+
+// Decodes the keys and prefixes of a listing that S3 URL-encoded, which it
+// only does when asked to with encoding-type=url and says so in EncodingType.
+// A mirror that ignores encoding-type lists them as they are
+func decodeResult(result *result) error {
+	if result.EncodingType != "url" {
+		return nil
+	}
+
+	var err error
+	decode := func(s *string) {
+		if err != nil {
+			return
+		}
+		decoded, decodeErr := url.QueryUnescape(*s)
+		if decodeErr != nil {
+			err = fmt.Errorf("invalid URL-encoded key %s", *s)
+			return
+		}
+		*s = decoded
+	}
+	decode(&result.Prefix)
+	for i := range result.Contents {
+		decode(&result.Contents[i].Key)
+	}
+	for i := range result.CommonPrefixes {
+		decode(&result.CommonPrefixes[i].Prefix)
+	}
+	return err
+}
+
+// Returns the endpoints that can be used to list a bucket, in the order they
+// should be tried: the virtual-hosted style endpoint for the given region,
+// followed by the global endpoint
+func s3Endpoints(bucketName string, region string) []string {
+	endpoints := []string{}
+	if region != "" {
+		endpoints = append(endpoints, fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucketName, region))
 	}
+	return append(endpoints, fmt.Sprintf("https://%s.s3.amazonaws.com", bucketName))
+}
 
-	return result, xml.Unmarshal(body, &result)
+// The heroku-nodebin bucket lives in us-east-1, but users building far away
+// from it can point at a closer regional endpoint with NODE_BINARIES_REGION
+func getRegion() string {
+	if region := os.Getenv("NODE_BINARIES_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
 }
 
 // Query the S3 API for a list of all the objects in an S3 bucket with a
 // given prefix. This will handle the inherent 1000 item limit and paging
 // for you
 func listS3Objects(bucketName string, region string, prefix string) ([]s3Object, error) {
+	return listS3ObjectsFromEndpoints(s3Endpoints(bucketName, region), bucketName, prefix)
+}
+
+func listS3ObjectsFromEndpoints(endpoints []string, bucketName string, prefix string) ([]s3Object, error) {
+	return listMatchingS3Objects(endpoints, bucketName, prefix, nil)
+}
+
+// Lists the objects under prefix that keep returns true for, or every object
+// when keep is nil. Objects are dropped as each page arrives, so only the kept
+// ones are held in memory rather than the whole listing
+func listMatchingS3Objects(endpoints []string, bucketName string, prefix string, keep func(s3Object) bool) ([]s3Object, error) {
+	return listS3ObjectsHelper(endpoints, bucketName, prefix, "", keep)
+}
+
+// Lists only the objects under prefix whose keys sort after startAfter, which
+// is how a cached listing is brought up to date without listing it all again
+func listS3ObjectsAfter(endpoints []string, bucketName string, prefix string, startAfter string) ([]s3Object, error) {
+	return listS3ObjectsHelper(endpoints, bucketName, prefix, startAfter, nil)
+}
+
+// Lists the distinct beginnings of the keys under prefix, up to and including
+// the next delimiter, without listing the keys themselves. Listing
+// node/release/linux-x64/node-v with "." returns one prefix per major
+func listS3CommonPrefixes(endpoints []string, bucketName string, prefix string, delimiter string) ([]string, error) {
+	defer recordTiming(fmt.Sprintf("listing %s by %s", prefix, delimiter), time.Now())
+
+	prefixes := []string{}
+	options := map[string]string{"prefix": prefix, "delimiter": delimiter}
+	for {
+		result, err := fetchS3Result(endpoints, bucketName, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !result.IsTruncated {
+			return prefixes, nil
+		}
+		if result.NextContinuationToken == "" {
+			return nil, fmt.Errorf("Truncated listing without a continuation token for S3 bucket: %s", bucketName)
+		}
+		options["continuation-token"] = result.NextContinuationToken
+	}
+}
+
+func listS3ObjectsHelper(endpoints []string, bucketName string, prefix string, startAfter string, keep func(s3Object) bool) ([]s3Object, error) {
+	defer recordTiming(fmt.Sprintf("listing %s", prefix), time.Now())
+	defer clearProgress()
+
 	var out = []s3Object{}
+	var listed = 0
 	var options = map[string]string{"prefix": prefix}
+	// S3 ignores this once there is a continuation token, which already
+	// carries on from where the previous page ended
+	if startAfter != "" {
+		options["start-after"] = startAfter
+	}
 
-	for {
-		result, err := fetchS3Result(bucketName, region, options)
+	for page := 1; ; page++ {
+		start := time.Now()
+		result, err := fetchS3Result(endpoints, bucketName, options)
 		if err != nil {
 			return nil, err
 		}
+		recordTiming(fmt.Sprintf("listing %s page %d", prefix, page), start)
+		// a mirror returning small pages shows up here as a low KeyCount
+		recordCount("listing %s page %d had KeyCount %d, MaxKeys %d", prefix, page, result.KeyCount, result.MaxKeys)
+		// a listing that parsed fewer objects than S3 says it returned has
+		// silently lost some, most likely to a namespace or layout the
+		// parser doesn't expect
+		if result.KeyCount > 0 && result.KeyCount != len(result.Contents) {
+			logVerbose("Warning: listing %s page %d has KeyCount %d, but %d objects were parsed\n", prefix, page, result.KeyCount, len(result.Contents))
+		}
+		if result.MaxKeys > 0 && len(result.Contents) > result.MaxKeys {
+			logVerbose("Warning: listing %s page %d has %d objects, more than its MaxKeys of %d\n", prefix, page, len(result.Contents), result.MaxKeys)
+		}
 
-		out = append(out, result.Contents...)
+		listed += len(result.Contents)
+		reportProgress(prefix, page, listed)
+		for _, obj := range result.Contents {
+			if keep == nil || keep(obj) {
+				out = append(out, obj)
+			}
+		}
 		if !result.IsTruncated {
+			recordCount("listing %s fetched %d objects in %d pages", prefix, listed, page)
 			break
 		}
+		// Without a token we would only ever see the first page, and miss the
+		// newest versions since S3 lists keys in lexical order
+		if result.NextContinuationToken == "" {
+			return nil, fmt.Errorf("Truncated listing without a continuation token for S3 bucket: %s", bucketName)
+		}
 
 		options["continuation-token"] = result.NextContinuationToken
 	}
@@ -0,0 +1,39 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func FuzzParseObject(f *testing.F) {
+	f.Add("node/release/linux-x64/node-v6.2.2-linux-x64.tar.gz")
+	f.Add("node/staging/darwin-x64/node-v6.17.0-darwin-x64.tar.gz")
+	f.Add("yarn/release/yarn-v1.9.1.tar.gz")
+	f.Add("node/release/linux-x64/node-v1.2.3.4-linux-x64.tar.gz")
+	f.Add("something/weird")
+
+	f.Fuzz(func(t *testing.T, key string) {
+		release, err := parseObject(key)
+		if err != nil {
+			return
+		}
+
+		if err := release.version.Validate(); err != nil {
+			t.Fatalf("parsed %q into an invalid version: %s", key, err)
+		}
+
+		u, err := url.Parse(release.url)
+		if err != nil {
+			t.Fatalf("parsed %q into an invalid url %q: %s", key, release.url, err)
+		}
+		if u.Scheme != "https" || u.Host != "s3.amazonaws.com" || u.RawQuery != "" || u.Fragment != "" {
+			t.Fatalf("parsed %q into an unexpected url %q", key, release.url)
+		}
+		if u.Path != "/heroku-nodebin/"+key {
+			t.Fatalf("parsed %q into a url %q that does not point at the key", key, release.url)
+		}
+	})
+}
@@ -1,7 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -39,9 +51,22 @@ func TestParseObject(t *testing.T) {
 	assert.Equal(t, release.platform, "")
 	assert.Equal(t, release.version.String(), "1.9.1")
 
+	release, err = parseObject("yarn/staging/yarn-v1.22.0.tar.gz")
+	assert.Nil(t, err)
+	assert.Equal(t, release.stage, "staging")
+	assert.Equal(t, release.url, "https://s3.amazonaws.com/heroku-nodebin/yarn/staging/yarn-v1.22.0.tar.gz")
+
 	release, err = parseObject("something/weird")
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), "Failed to parse key: something/weird")
+
+	// the platform directory must agree with the file name
+	release, err = parseObject("node/release/linux-x64/node-v6.2.2-darwin-x64.tar.gz")
+	assert.NotNil(t, err)
+	assert.Equal(t, err.Error(), "Failed to parse key: node/release/linux-x64/node-v6.2.2-darwin-x64.tar.gz")
+
+	release, err = parseObject("mirror/node/release/linux-x64/node-v6.2.2-linux-x64.tar.gz")
+	assert.NotNil(t, err)
 }
 
 func genReleasesFromArray(versions []string) []release {
@@ -139,6 +164,29 @@ func genYarnS3ObjectList(versions []string) []s3Object {
 	return out
 }
 
+func TestMatchReleasePartialVersion(t *testing.T) {
+	defer func(original func(string) (semver.Range, error)) { parseRange = original }(parseRange)
+
+	releases := genReleasesFromArray([]string{"18.0.0", "18.16.1", "18.17.0", "18.17.1", "19.0.0"})
+
+	// a bare major or major.minor is a range over everything it's a prefix
+	// of, not the .0 release it would be if padded out with zeros
+	cases := []Case{
+		Case{input: "18", output: "18.17.1"},
+		Case{input: "18.17", output: "18.17.1"},
+		Case{input: "18.17.0", output: "18.17.0"},
+	}
+	for _, mode := range []string{"strict", "npm"} {
+		parseRange = rangeParsers[mode]
+		for _, c := range cases {
+			result, err := matchReleaseSemver(releases, c.input)
+			if assert.Nil(t, err, "%s %s", mode, c.input) && assert.True(t, result.matched, "%s %s", mode, c.input) {
+				assert.Equal(t, result.release.version.String(), c.output, "%s %s", mode, c.input)
+			}
+		}
+	}
+}
+
 func TestResolveYarn(t *testing.T) {
 	// yarn releases as of 4/18/2019
 	objects := genYarnS3ObjectList([]string{
@@ -173,7 +221,7 @@ func TestResolveYarn(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		result, err := resolveYarn(objects, c.input)
+		result, err := resolveYarn(parseObjects(objects), c.input)
 		if assert.Nil(t, err) {
 			assert.True(t, result.matched)
 			assert.Equal(t, result.release.version.String(), c.output)
@@ -240,7 +288,7 @@ func TestResolveNode(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		result, err := resolveNode(objects, "linux-x64", c.input)
+		result, err := resolveNode(parseObjects(objects), "linux-x64", c.input)
 		if assert.Nil(t, err) {
 			assert.True(t, result.matched)
 			assert.Equal(t, result.release.version.String(), c.output)
@@ -248,7 +296,7 @@ func TestResolveNode(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		result, err := resolveNode(objects, "darwin-x64", c.input)
+		result, err := resolveNode(parseObjects(objects), "darwin-x64", c.input)
 		if assert.Nil(t, err) {
 			assert.False(t, result.matched)
 			assert.Equal(t, result.versionRequirement, c.input)
@@ -278,7 +326,7 @@ func TestResolveNodeStaging(t *testing.T) {
 		// staging has a few releases that were already released, but one: 10.15.4 that has not been
 		objects := genNodeS3ObjectList(releasedVersions, []string{"10.15.1", "10.15.2", "10.15.3", "10.15.4"}, platform)
 
-		result, err := resolveNode(objects, platform, "10.15.1")
+		result, err := resolveNode(parseObjects(objects), platform, "10.15.1")
 		if assert.Nil(t, err) {
 			assert.True(t, result.matched)
 			assert.Equal(t, result.release.version.String(), "10.15.1")
@@ -290,7 +338,7 @@ func TestResolveNodeStaging(t *testing.T) {
 			}
 		}
 
-		result, err = resolveNode(objects, platform, "10.15.4")
+		result, err = resolveNode(parseObjects(objects), platform, "10.15.4")
 		if assert.Nil(t, err) {
 			assert.True(t, result.matched)
 			assert.Equal(t, result.release.version.String(), "10.15.4")
@@ -302,10 +350,911 @@ func TestResolveNodeStaging(t *testing.T) {
 			}
 		}
 
-		result, err = resolveNode(objects, platform, "10.15.5")
+		result, err = resolveNode(parseObjects(objects), platform, "10.15.5")
 		if assert.Nil(t, err) {
 			assert.False(t, result.matched)
 			assert.Equal(t, result.versionRequirement, "10.15.5")
 		}
 	}
 }
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "result")
+	assert.Nil(t, writeFileAtomic(path, []byte("10.15.3 https://heroku.com\n")))
+	assert.Nil(t, writeFileAtomic(path, []byte("12.13.0 https://heroku.com\n")))
+
+	contents, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "12.13.0 https://heroku.com\n")
+
+	// no temporary files should be left behind
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestS3Endpoints(t *testing.T) {
+	assert.Equal(t, s3Endpoints("heroku-nodebin", "us-east-1"), []string{
+		"https://heroku-nodebin.s3.us-east-1.amazonaws.com",
+		"https://heroku-nodebin.s3.amazonaws.com",
+	})
+	assert.Equal(t, s3Endpoints("heroku-nodebin", "eu-west-1"), []string{
+		"https://heroku-nodebin.s3.eu-west-1.amazonaws.com",
+		"https://heroku-nodebin.s3.amazonaws.com",
+	})
+	assert.Equal(t, s3Endpoints("heroku-nodebin", ""), []string{
+		"https://heroku-nodebin.s3.amazonaws.com",
+	})
+}
+
+func TestGetRegion(t *testing.T) {
+	defer os.Unsetenv("NODE_BINARIES_REGION")
+
+	os.Unsetenv("NODE_BINARIES_REGION")
+	assert.Equal(t, getRegion(), "us-east-1")
+
+	os.Setenv("NODE_BINARIES_REGION", "eu-west-1")
+	assert.Equal(t, getRegion(), "eu-west-1")
+	assert.Equal(t, s3Endpoints("heroku-nodebin", getRegion())[0], "https://heroku-nodebin.s3.eu-west-1.amazonaws.com")
+}
+
+// Serves a ListObjectsV2 listing of keys, pageSize keys at a time, using the
+// index of the next key as the continuation token. Keys are expected to be
+// given in lexical order, as S3 lists them. With a delimiter, only the
+// CommonPrefixes are listed, on a single page. Like S3, keys and prefixes are
+// URL-encoded when encoding-type=url is asked for
+func s3ListingHandler(keys []string, pageSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		prefix := query.Get("prefix")
+		encode := func(s string) string { return s }
+		encodingType := ""
+		if query.Get("encoding-type") == "url" {
+			encode = url.QueryEscape
+			encodingType = "<EncodingType>url</EncodingType>"
+		}
+		matching := []string{}
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) && key > query.Get("start-after") {
+				matching = append(matching, key)
+			}
+		}
+
+		if delimiter := query.Get("delimiter"); delimiter != "" {
+			seen := map[string]bool{}
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+			fmt.Fprintf(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+			fmt.Fprintf(w, `<Name>heroku-nodebin</Name><Prefix>%s</Prefix><Delimiter>%s</Delimiter>%s<IsTruncated>false</IsTruncated>`, encode(prefix), encode(delimiter), encodingType)
+			for _, key := range matching {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					common := key[:len(prefix)+i+len(delimiter)]
+					if !seen[common] {
+						seen[common] = true
+						fmt.Fprintf(w, `<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>`, encode(common))
+					}
+				}
+			}
+			fmt.Fprintf(w, `<KeyCount>%d</KeyCount></ListBucketResult>`, len(seen))
+			return
+		}
+
+		start := 0
+		if token := query.Get("continuation-token"); token != "" {
+			start, _ = strconv.Atoi(token)
+		}
+		end := start + pageSize
+		if end > len(matching) {
+			end = len(matching)
+		}
+
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+		fmt.Fprintf(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+		fmt.Fprintf(w, `<Name>heroku-nodebin</Name><Prefix>%s</Prefix>%s<KeyCount>%d</KeyCount><MaxKeys>%d</MaxKeys>`, encode(prefix), encodingType, end-start, pageSize)
+		if end < len(matching) {
+			fmt.Fprintf(w, `<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>`, end)
+		} else {
+			fmt.Fprintf(w, `<IsTruncated>false</IsTruncated>`)
+		}
+		for _, key := range matching[start:end] {
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><LastModified>2019-10-24T00:00:00.000Z</LastModified><ETag>"abcdef"</ETag><Size>100</Size><StorageClass>STANDARD</StorageClass></Contents>`, encode(key))
+		}
+		fmt.Fprintf(w, `</ListBucketResult>`)
+	}
+}
+
+func genNodeKeys(count int) []string {
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("node/release/linux-x64/node-v%d.%d.%d-linux-x64.tar.gz", i/100, (i/10)%10, i%10)
+	}
+	return keys
+}
+
+func TestListS3ObjectsFromEndpoints(t *testing.T) {
+	server := httptest.NewServer(s3ListingHandler(append(genNodeKeys(25), "yarn/release/yarn-v1.19.1.tar.gz"), 10))
+	defer server.Close()
+
+	objects, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	if assert.Nil(t, err) && assert.Len(t, objects, 25) {
+		assert.Equal(t, objects[0].Key, "node/release/linux-x64/node-v0.0.0-linux-x64.tar.gz")
+		assert.Equal(t, objects[24].Key, "node/release/linux-x64/node-v0.2.4-linux-x64.tar.gz")
+		assert.Equal(t, objects[24].Size, 100)
+	}
+}
+
+func TestListS3ObjectsAcrossPages(t *testing.T) {
+	// more keys than S3 returns in a single page, where the newest versions are
+	// lexically last and so only appear on the final page
+	keys := append(genNodeKeys(1500), "node/release/linux-x64/node-v99.0.0-linux-x64.tar.gz")
+	server := httptest.NewServer(s3ListingHandler(keys, 1000))
+	defer server.Close()
+
+	objects, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	if !assert.Nil(t, err) || !assert.Len(t, objects, 1501) {
+		return
+	}
+
+	result, err := resolveNode(parseObjects(objects), "linux-x64", "*")
+	assert.Nil(t, err)
+	assert.True(t, result.matched)
+	assert.Equal(t, result.release.version.String(), "99.0.0")
+
+	result, err = resolveNode(parseObjects(objects), "linux-x64", "14.x")
+	assert.Nil(t, err)
+	assert.True(t, result.matched)
+	assert.Equal(t, result.release.version.String(), "14.9.9")
+}
+
+func TestListS3ObjectsAfter(t *testing.T) {
+	var queries []string
+	handler := s3ListingHandler(genNodeKeys(25), 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		handler(w, r)
+	}))
+	defer server.Close()
+
+	marker := "node/release/linux-x64/node-v0.0.9-linux-x64.tar.gz"
+	objects, err := listS3ObjectsAfter([]string{server.URL}, "heroku-nodebin", "node", marker)
+	if assert.Nil(t, err) && assert.Len(t, objects, 15) {
+		assert.Equal(t, objects[0].Key, "node/release/linux-x64/node-v0.1.0-linux-x64.tar.gz")
+		assert.Equal(t, objects[14].Key, "node/release/linux-x64/node-v0.2.4-linux-x64.tar.gz")
+		for _, obj := range objects {
+			assert.True(t, obj.Key > marker, obj.Key)
+		}
+	}
+	if assert.Len(t, queries, 2) {
+		assert.Contains(t, queries[0], "start-after="+url.QueryEscape(marker))
+	}
+
+	// nothing after the last key is an empty listing, not an error
+	objects, err = listS3ObjectsAfter([]string{server.URL}, "heroku-nodebin", "node", "node/release/linux-x64/node-v0.2.4-linux-x64.tar.gz")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 0)
+}
+
+func TestListS3ObjectsTruncatedWithoutToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<ListBucketResult><KeyCount>1</KeyCount><IsTruncated>true</IsTruncated>`)
+		fmt.Fprintf(w, `<Contents><Key>node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz</Key></Contents></ListBucketResult>`)
+	}))
+	defer server.Close()
+
+	_, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Truncated listing without a continuation token for S3 bucket: heroku-nodebin")
+	}
+}
+
+func TestListS3ObjectsNestedContents(t *testing.T) {
+	// a mirror that nests the objects one level deeper than S3 does
+	fixture, err := ioutil.ReadFile("testdata/nested-contents.xml")
+	if !assert.Nil(t, err) {
+		return
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fixture)
+	}))
+	defer server.Close()
+
+	_, err = listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not parse listing for S3 bucket: heroku-nodebin, KeyCount is 2 but no objects were found")
+	}
+}
+
+func TestFetchS3ResultURLEncoded(t *testing.T) {
+	// a page listed with encoding-type=url, where one of the keys has a
+	// character that can't appear in XML at all
+	fixture, err := ioutil.ReadFile("testdata/s3-list-objects-url-encoded.xml")
+	if !assert.Nil(t, err) {
+		return
+	}
+	body := fixture
+	var encodingType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodingType = r.URL.Query().Get("encoding-type")
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	page, err := fetchS3ResultFromEndpoint(server.URL, "heroku-nodebin", map[string]string{"prefix": "node/release/linux-x64/node-v18."})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, encodingType, "url")
+	assert.Equal(t, page.Prefix, "node/release/linux-x64/node-v18.")
+	if assert.Len(t, page.Contents, 2) {
+		assert.Equal(t, page.Contents[0].Key, "node/release/linux-x64/node-v18.0.0-linux-x64.tar.gz")
+		assert.Equal(t, page.Contents[1].Key, "node/release/linux-x64/node-v18.1.0-linux-x64-c++\x01.tar.gz")
+	}
+	releases := parseObjects(page.Contents)
+	if assert.Len(t, releases, 2) {
+		assert.Equal(t, releases[1].qualifier, "c++\x01")
+	}
+
+	// keys are only decoded when the listing says they were encoded
+	body = bytes.Replace(fixture, []byte("<EncodingType>url</EncodingType>"), nil, 1)
+	page, err = fetchS3ResultFromEndpoint(server.URL, "heroku-nodebin", map[string]string{})
+	if assert.Nil(t, err) && assert.Len(t, page.Contents, 2) {
+		assert.Equal(t, page.Contents[0].Key, "node%2Frelease%2Flinux-x64%2Fnode-v18.0.0-linux-x64.tar.gz")
+	}
+
+	body = bytes.Replace(fixture, []byte("c%2B%2B"), []byte("c%zz"), 1)
+	_, err = fetchS3ResultFromEndpoint(server.URL, "heroku-nodebin", map[string]string{})
+	assert.EqualError(t, err, "Could not parse listing for S3 bucket: heroku-nodebin, invalid URL-encoded key node%2Frelease%2Flinux-x64%2Fnode-v18.1.0-linux-x64-c%zz%01.tar.gz")
+}
+
+func TestFetchS3ResultNamespace(t *testing.T) {
+	// a page of a listing as S3 serves it, in its default namespace
+	fixture, err := ioutil.ReadFile("testdata/s3-list-objects-v2.xml")
+	if !assert.Nil(t, err) {
+		return
+	}
+	body := fixture
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	page, err := fetchS3ResultFromEndpoint(server.URL, "heroku-nodebin", map[string]string{"prefix": "node/release/linux-x64/node-v12."})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, page.XMLName.Space, "http://s3.amazonaws.com/doc/2006-03-01/")
+	assert.Equal(t, page.Name, "heroku-nodebin")
+	assert.Equal(t, page.KeyCount, 2)
+	assert.True(t, page.IsTruncated)
+	assert.Equal(t, page.NextContinuationToken, "1Xj5zcUPmWFACrWj4lSyeYtUyfdctLBhOk6sMqiuqvJc1wrHw8Zp4Wx/uWcKEKJTRwW79qdBybAs=")
+	if assert.Len(t, page.Contents, 2) {
+		assert.Equal(t, page.Contents[1].Key, "node/release/linux-x64/node-v12.1.0-linux-x64.tar.gz")
+		assert.Equal(t, page.Contents[1].ETag, `"c3b1c0e1c0a3b29b2e1f8f5e9f1d0a6c-2"`)
+		assert.Equal(t, page.Contents[1].Size, 14968417)
+		assert.Equal(t, page.Contents[1].LastModified, time.Date(2019, 4, 30, 17, 12, 9, 0, time.UTC))
+	}
+
+	// the same page without the namespace, as some mirrors serve it
+	body = bytes.Replace(fixture, []byte(` xmlns="http://s3.amazonaws.com/doc/2006-03-01/"`), nil, 1)
+	unqualified, err := fetchS3ResultFromEndpoint(server.URL, "heroku-nodebin", map[string]string{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, unqualified.NextContinuationToken, page.NextContinuationToken)
+		assert.Equal(t, unqualified.Contents, page.Contents)
+	}
+
+	// anything other than a listing is an error rather than an empty page
+	body = []byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+	_, err = fetchS3ResultFromEndpoint(server.URL, "heroku-nodebin", map[string]string{})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not parse listing for S3 bucket: heroku-nodebin, expected element type <ListBucketResult> but have <Error>")
+	}
+}
+
+func TestListS3ObjectsKeyCountMismatch(t *testing.T) {
+	fixture, err := ioutil.ReadFile("testdata/key-count-mismatch.xml")
+	if !assert.Nil(t, err) {
+		return
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fixture)
+	}))
+	defer server.Close()
+
+	var verbose bytes.Buffer
+	defer func(original io.Writer) { verboseOut = original }(verboseOut)
+	verboseOut = &verbose
+
+	// the objects that could be parsed are still used
+	objects, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 2)
+	assert.Contains(t, verbose.String(), "Warning: listing node page 1 has KeyCount 3, but 2 objects were parsed\n")
+}
+
+func TestListS3ObjectsContinuationTokenEncoding(t *testing.T) {
+	// S3 tokens are base64, and can contain characters that are special in
+	// both URLs and XML
+	token := "1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM+a&b=c=="
+	pages := [][]byte{}
+	for _, name := range []string{"testdata/continuation-token-page-1.xml", "testdata/continuation-token-page-2.xml"} {
+		fixture, err := ioutil.ReadFile(name)
+		if !assert.Nil(t, err) {
+			return
+		}
+		pages = append(pages, fixture)
+	}
+
+	rawQueries := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawQueries = append(rawQueries, r.URL.RawQuery)
+		switch r.URL.Query().Get("continuation-token") {
+		case "":
+			w.Write(pages[0])
+		case token:
+			w.Write(pages[1])
+		default:
+			w.WriteHeader(400)
+		}
+	}))
+	defer server.Close()
+
+	objects, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 2)
+
+	// the token is sent exactly as it was listed, encoded once
+	if assert.Len(t, rawQueries, 2) {
+		assert.Contains(t, rawQueries[1], "continuation-token=1ueGcxLPRx1Tr%2FXYExHnhbYLgveDs2J%2Fwm36Hy4vbOwM%2Ba%26b%3Dc%3D%3D")
+	}
+}
+
+func TestResolveOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "node-version")
+	opts := options{outputFile: path}
+	sources := []source{staticSource{releases: genReleasesFromArray([]string{"10.15.3", "12.13.0"})}}
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("8.16.0 https://heroku.com\n"), 0644))
+
+	// the previous result is atomically replaced
+	assert.Nil(t, resolveWithSources(sources, "node", "12.x", opts))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "12.13.0 https://heroku.com\n")
+
+	// and a failed resolution leaves it untouched
+	err = resolveWithSources(sources, "node", "99.x", opts)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "No result")
+	}
+	err = resolveWithSources([]source{staticSource{err: errors.New("listing failed")}}, "node", "12.x", opts)
+	assert.NotNil(t, err)
+
+	contents, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "12.13.0 https://heroku.com\n")
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
+
+func TestParseObjectQualifier(t *testing.T) {
+	release, err := parseObject("node/release/linux-x64/node-v18.0.0-linux-x64-glibc-217.tar.gz")
+	assert.Nil(t, err)
+	assert.Equal(t, release.platform, "linux-x64")
+	assert.Equal(t, release.qualifier, "glibc-217")
+	assert.Equal(t, release.version.String(), "18.0.0")
+
+	release, err = parseObject("node/release/linux-x64/node-v18.0.0-linux-x64.musl.tar.gz")
+	assert.Nil(t, err)
+	assert.Equal(t, release.platform, "linux-x64")
+	assert.Equal(t, release.qualifier, "musl")
+
+	release, err = parseObject("node/release/linux-x64/node-v18.0.0-linux-x64.tar.gz")
+	assert.Nil(t, err)
+	assert.Equal(t, release.qualifier, "")
+
+	// the qualifier has to be separated from the platform
+	_, err = parseObject("node/release/linux-x64/node-v18.0.0-linux-x6432.tar.gz")
+	assert.NotNil(t, err)
+	_, err = parseObject("node/release/linux-x64/node-v18.0.0-linux-x64-.tar.gz")
+	assert.NotNil(t, err)
+}
+
+func TestParseObjectLeadingZeros(t *testing.T) {
+	keys := []string{
+		"node/release/linux-x64/node-v16.00.0-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v16.1.09-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v014.21.3-linux-x64.tar.gz",
+		"yarn/release/yarn-v1.022.0.tar.gz",
+		"pnpm/release/pnpm-v08.15.9.tar.gz",
+	}
+	objects := []s3Object{}
+	for _, key := range keys {
+		objects = append(objects, s3Object{Key: key})
+	}
+	releases := parseObjects(objects)
+	if !assert.Len(t, releases, 5) {
+		return
+	}
+	versions := []string{}
+	for _, rel := range releases {
+		versions = append(versions, rel.version.String())
+	}
+	assert.Equal(t, versions, []string{"16.0.0", "16.1.9", "14.21.3", "1.22.0", "8.15.9"})
+
+	// they resolve like any other version, but keep the key they were listed
+	// under in their URL
+	result, err := resolveNode(releases, "linux-x64", "16.0.0")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.url, "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v16.00.0-linux-x64.tar.gz")
+	}
+	result, err = resolveNode(releases, "linux-x64", "14.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.url, "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v014.21.3-linux-x64.tar.gz")
+	}
+	result, err = resolveYarn(releases[3:4], "1.22.0")
+	if assert.Nil(t, err) {
+		assert.True(t, result.matched)
+	}
+}
+
+func TestResolveNodePrefersUnqualifiedBuild(t *testing.T) {
+	keys := []string{
+		"node/release/linux-x64/node-v18.0.0-linux-x64-glibc-217.tar.gz",
+		"node/release/linux-x64/node-v18.0.0-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v18.0.0-linux-x64-musl.tar.gz",
+	}
+	objects := []s3Object{}
+	for _, key := range keys {
+		objects = append(objects, s3Object{Key: key})
+	}
+	releases := parseObjects(objects)
+	assert.Len(t, releases, 3)
+
+	result, err := resolveNode(releases, "linux-x64", "18.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.qualifier, "")
+		assert.Equal(t, result.release.url, "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.0.0-linux-x64.tar.gz")
+	}
+
+	// when only qualified builds exist one of them is still used
+	result, err = resolveNode(releases[:1], "linux-x64", "18.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.qualifier, "glibc-217")
+		assert.Equal(t, describeRelease(result.release), "18.0.0, stage release, platform linux-x64, qualifier glibc-217")
+	}
+}
+
+func TestClosestReleases(t *testing.T) {
+	releases := genReleasesFromArray([]string{"18.16.1", "18.17.2", "18.17.0", "20.0.0"})
+
+	cases := []struct {
+		version string
+		closest []string
+	}{
+		{"18.17.1", []string{"18.17.0", "18.17.2"}},
+		{"19.0.0", []string{"18.17.2", "20.0.0"}},
+		{"17.0.0", []string{"18.16.1"}},
+		{"21.0.0", []string{"20.0.0"}},
+		{"18.17.0", []string{"18.16.1", "18.17.2"}},
+	}
+
+	for _, c := range cases {
+		closest := closestReleases(releases, semver.MustParse(c.version))
+		versions := []string{}
+		for _, rel := range closest {
+			versions = append(versions, rel.version.String())
+		}
+		assert.Equal(t, versions, c.closest, c.version)
+	}
+
+	// only exact pins get suggestions
+	result, err := resolveNode(releases, "linux-x64", "18.17.1")
+	if assert.Nil(t, err) && assert.False(t, result.matched) {
+		assert.Equal(t, describeReleases(result.closest), "18.17.0 and 18.17.2")
+	}
+	result, err = resolveNode(releases, "linux-x64", "19.x")
+	if assert.Nil(t, err) && assert.False(t, result.matched) {
+		assert.Len(t, result.closest, 0)
+	}
+}
+
+func TestPlatformFor(t *testing.T) {
+	assert.Equal(t, platformFor("linux", "amd64"), "linux-x64")
+	assert.Equal(t, platformFor("darwin", "amd64"), "darwin-x64")
+	assert.Equal(t, platformFor("darwin", "arm64"), "darwin-arm64")
+}
+
+func TestResolveNodeWithFallback(t *testing.T) {
+	objects := append(
+		genNodeS3ObjectList([]string{"14.21.3", "16.20.2"}, []string{}, "darwin-x64"),
+		genNodeS3ObjectList([]string{"16.20.2"}, []string{}, "darwin-arm64")...,
+	)
+	releases := parseObjects(objects)
+
+	// a native build is used whenever one matches
+	result, err := resolveNodeWithFallback(releases, "darwin-arm64", "16.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.platform, "darwin-arm64")
+	}
+
+	// and older versions fall back to the x64 build
+	result, err = resolveNodeWithFallback(releases, "darwin-arm64", "14.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.platform, "darwin-x64")
+		assert.Equal(t, result.release.version.String(), "14.21.3")
+	}
+
+	result, err = resolveNodeWithFallback(releases, "darwin-arm64", "12.x")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+
+	// platforms without a fallback don't get one
+	result, err = resolveNodeWithFallback(releases, "linux-x64", "14.x")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+
+	// --strict only resolves to a native build
+	defer func(original bool) { usePlatformFallbacks = original }(usePlatformFallbacks)
+	usePlatformFallbacks = false
+	result, err = resolveNodeWithFallback(releases, "darwin-arm64", "14.x")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+	result, err = resolveNodeWithFallback(releases, "darwin-arm64", "16.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.platform, "darwin-arm64")
+	}
+}
+
+func TestNearestRelease(t *testing.T) {
+	cases := []struct {
+		available []string
+		pinned    string
+		nearest   string
+	}{
+		// an older patch of the same minor
+		{[]string{"18.16.1", "18.17.0", "18.17.2"}, "18.17.1", "18.17.0"},
+		// then a newer patch of the same minor
+		{[]string{"18.16.1", "18.17.2", "18.18.0"}, "18.17.0", "18.17.2"},
+		// then the same major
+		{[]string{"16.20.2", "18.16.1", "18.18.0"}, "18.17.0", "18.16.1"},
+		{[]string{"16.20.2", "18.18.0", "20.0.0"}, "18.17.0", "18.18.0"},
+		// but never another major
+		{[]string{"16.20.2", "20.0.0"}, "18.17.0", ""},
+		{[]string{"18.17.0-rc.1", "20.0.0"}, "18.17.0", ""},
+	}
+
+	for _, c := range cases {
+		version := semver.MustParse(c.pinned)
+		rel, ok := nearestRelease(closestReleases(genReleasesFromArray(c.available), version), version)
+		if c.nearest == "" {
+			assert.False(t, ok, c.pinned)
+			continue
+		}
+		if assert.True(t, ok, c.pinned) {
+			assert.Equal(t, rel.version.String(), c.nearest, c.pinned)
+		}
+	}
+}
+
+func TestResolveNearestOnMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node-version")
+
+	// 18.17.1 was published and then removed from the bucket
+	sources := []source{staticSource{releases: genReleasesFromArray([]string{"18.16.1", "18.17.0", "18.17.2"})}}
+
+	err = resolveWithSources(sources, "node", "18.17.1", options{outputFile: path})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "No result")
+	}
+
+	assert.Nil(t, resolveWithSources(sources, "node", "18.17.1", options{outputFile: path, nearestOnMissing: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "18.17.0 https://heroku.com\n")
+
+	// ranges aren't pins, so there's nothing to be near
+	err = resolveWithSources(sources, "node", "19.x", options{outputFile: path, nearestOnMissing: true})
+	assert.NotNil(t, err)
+
+	err = resolveWithSources(sources, "node", "18.17.1", options{outputFile: path, nearestOnMissing: true, strict: true})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "--nearest-on-missing can't be used with --strict")
+	}
+}
+
+func TestHeadersURL(t *testing.T) {
+	rel, err := parseObject("node/staging/linux-x64/node-v12.13.1-linux-x64.tar.gz")
+	if assert.Nil(t, err) {
+		assert.Equal(t, headersURL(rel), "https://s3.amazonaws.com/heroku-nodebin/node/staging/linux-x64/node-v12.13.1-headers.tar.gz")
+	}
+
+	releases := parseNodejsOrgIndex([]nodejsOrgRelease{{Version: "v12.13.0", Files: []string{"linux-x64"}}})
+	if assert.Len(t, releases, 1) {
+		assert.Equal(t, headersURL(releases[0]), nodeDistURL+"/v12.13.0/node-v12.13.0-headers.tar.gz")
+	}
+}
+
+func TestResolveWithHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "node-version")
+	sources := []source{staticSource{releases: parseObjects(genNodeS3ObjectList([]string{"12.13.0"}, []string{}, "linux-x64"))}}
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.x", options{outputFile: path, withHeaders: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "12.13.0 https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-headers.tar.gz\n")
+
+	assert.NotNil(t, resolveWithSources(sources, "yarn", "1.x", options{outputFile: path, withHeaders: true}))
+}
+
+func TestResolvePrintURLOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "node-url")
+	sources := []source{staticSource{releases: parseObjects(genNodeS3ObjectList([]string{"12.13.0"}, []string{}, "linux-x64"))}}
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.x", options{outputFile: path, printURLOnly: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz\n")
+
+	err = resolveWithSources(sources, "node", "12.x", options{outputFile: path, printURLOnly: true, json: true})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Only one of --print-url-only and --json can be used")
+	}
+}
+
+func TestResolveSeparator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "node")
+	url := "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz"
+	sources := []source{staticSource{releases: parseObjects(genNodeS3ObjectList([]string{"12.13.0"}, []string{}, "linux-x64"))}}
+
+	cases := map[string]string{
+		"":      "12.13.0 " + url + "\n",
+		"SPACE": "12.13.0 " + url + "\n",
+		"TAB":   "12.13.0\t" + url + "\n",
+		"NL":    "12.13.0\n" + url + "\n",
+		",":     "12.13.0," + url + "\n",
+	}
+	for separator, expected := range cases {
+		assert.Nil(t, resolveWithSources(sources, "node", "12.x", options{outputFile: path, separator: separator}))
+		contents, _ := ioutil.ReadFile(path)
+		assert.Equal(t, string(contents), expected, separator)
+	}
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.x", options{outputFile: path, separator: "TAB", withHeaders: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "12.13.0\t"+url+"\t"+headersURL(sources[0].(staticSource).releases[0])+"\n")
+}
+
+func TestResolveIncludeMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	fixture, err := ioutil.ReadFile("testdata/s3-list-objects-v2.xml")
+	if !assert.Nil(t, err) {
+		return
+	}
+	// the fixture is a truncated page, which is all there is to list here
+	fixture = bytes.Replace(fixture, []byte("<IsTruncated>true</IsTruncated>"), []byte("<IsTruncated>false</IsTruncated>"), 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fixture)
+	}))
+	defer server.Close()
+	sources := []source{s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}}
+	path := filepath.Join(dir, "node")
+	url := "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v12.1.0-linux-x64.tar.gz"
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.1.0", options{outputFile: path, includeMetadata: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "12.1.0 "+url+"\netag=c3b1c0e1c0a3b29b2e1f8f5e9f1d0a6c-2 storageClass=STANDARD size=14968417\n")
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.1.0", options{outputFile: path, includeMetadata: true, json: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.JSONEq(t, string(contents), `{
+		"version": "12.1.0",
+		"url": "`+url+`",
+		"etag": "c3b1c0e1c0a3b29b2e1f8f5e9f1d0a6c-2",
+		"storageClass": "STANDARD",
+		"size": 14968417
+	}`)
+
+	assert.Nil(t, resolveWithSources(sources, "node", "12.1.0", options{outputFile: path, includeMetadata: true, env: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), "NODE_VERSION='12.1.0'\nNODE_URL='"+url+"'\n"+
+		"NODE_ETAG='c3b1c0e1c0a3b29b2e1f8f5e9f1d0a6c-2'\nNODE_STORAGE_CLASS='STANDARD'\nNODE_SIZE='14968417'\n")
+
+	// without the flag, none of it is printed
+	assert.Nil(t, resolveWithSources(sources, "node", "12.1.0", options{outputFile: path, json: true}))
+	contents, _ = ioutil.ReadFile(path)
+	assert.NotContains(t, string(contents), "etag")
+
+	err = resolveWithSources(sources, "node", "12.1.0", options{outputFile: path, includeMetadata: true, printURLOnly: true})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "--include-metadata can't be used with --print-url-only")
+	}
+}
+
+func TestLimitReleases(t *testing.T) {
+	// S3 lists keys in lexical order, not version order
+	releases := genReleasesFromArray([]string{"10.0.0", "12.1.0", "12.10.0", "12.2.0", "8.0.0"})
+	versions := func(releases []release) []string {
+		out := []string{}
+		for _, rel := range releases {
+			out = append(out, rel.version.String())
+		}
+		return out
+	}
+
+	limited, err := limitReleases(releases, 0, "highest")
+	assert.Nil(t, err)
+	assert.Equal(t, versions(limited), []string{"10.0.0", "12.1.0", "12.10.0", "12.2.0", "8.0.0"})
+
+	limited, err = limitReleases(releases, 2, "highest")
+	assert.Nil(t, err)
+	assert.Equal(t, versions(limited), []string{"12.2.0", "12.10.0"})
+
+	limited, err = limitReleases(releases, 2, "lowest")
+	assert.Nil(t, err)
+	assert.Equal(t, versions(limited), []string{"8.0.0", "10.0.0"})
+
+	limited, err = limitReleases(releases, 10, "highest")
+	assert.Nil(t, err)
+	assert.Equal(t, versions(limited), []string{"8.0.0", "10.0.0", "12.1.0", "12.2.0", "12.10.0"})
+
+	_, err = limitReleases(releases, -1, "highest")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "--limit can't be negative: -1")
+	}
+	_, err = limitReleases(releases, 2, "newest")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Unknown --prefer: newest")
+	}
+}
+
+func TestLatestPerMajor(t *testing.T) {
+	// listed in lexical order, with majors interleaved and 0.x and 1.x mixed in
+	releases := genReleasesFromArray([]string{
+		"0.10.48", "0.12.18", "14.17.0", "14.21.3", "14.9.0",
+		"16.20.2", "16.3.0", "17.9.1", "18.20.4", "18.3.0", "18.9.1", "4.9.1",
+	})
+
+	summary := []string{}
+	for _, rel := range latestPerMajor(releases) {
+		summary = append(summary, fmt.Sprintf("%d -> %s %t", rel.version.Major, rel.version.String(), isLTSMajor("node", rel.version.Major)))
+	}
+	assert.Equal(t, summary, []string{
+		"0 -> 0.12.18 false",
+		"4 -> 4.9.1 true",
+		"14 -> 14.21.3 true",
+		"16 -> 16.20.2 true",
+		"17 -> 17.9.1 false",
+		"18 -> 18.20.4 true",
+	})
+
+	// only node has LTS lines
+	assert.False(t, isLTSMajor("yarn", 2))
+}
+
+// Returns what f prints to stdout
+func captureStdout(t *testing.T, f func()) string {
+	reader, writer, err := os.Pipe()
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer reader.Close()
+	defer func(original *os.File) { os.Stdout = original }(os.Stdout)
+	os.Stdout = writer
+
+	out := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		out <- data
+	}()
+	f()
+	writer.Close()
+	return string(<-out)
+}
+
+func TestPrintReleasesSeparator(t *testing.T) {
+	releases := genReleasesFromArray([]string{"18.20.4", "20.10.0"})
+	assert.Equal(t, captureStdout(t, func() { printReleases(releases, options{separator: "SPACE"}) }), ""+
+		"18.20.4 https://heroku.com\n"+
+		"20.10.0 https://heroku.com\n")
+	assert.Equal(t, captureStdout(t, func() { printReleases(releases, options{separator: "TAB"}) }), ""+
+		"18.20.4\thttps://heroku.com\n"+
+		"20.10.0\thttps://heroku.com\n")
+	assert.Equal(t, captureStdout(t, func() { printMajorReleases(latestPerMajor(releases), options{separator: ","}) }), ""+
+		"18,18.20.4,https://heroku.com\n"+
+		"20,20.10.0,https://heroku.com\n")
+}
+
+func TestPrintMajorReleases(t *testing.T) {
+	// majors are sorted numerically rather than in the order they're listed
+	node := latestPerMajor(genReleasesFromArray([]string{"16.20.2", "10.24.1", "18.3.0", "18.20.4", "16.3.0"}))
+	assert.Equal(t, captureStdout(t, func() { printMajorReleases(node, options{}) }), ""+
+		"10 10.24.1 https://heroku.com\n"+
+		"16 16.20.2 https://heroku.com\n"+
+		"18 18.20.4 https://heroku.com\n")
+
+	var entries []map[string]interface{}
+	out := captureStdout(t, func() { printMajorReleases(node, options{json: true}) })
+	if assert.Nil(t, json.Unmarshal([]byte(out), &entries)) {
+		assert.Equal(t, entries, []map[string]interface{}{
+			{"major": 10.0, "version": "10.24.1", "url": "https://heroku.com"},
+			{"major": 16.0, "version": "16.20.2", "url": "https://heroku.com"},
+			{"major": 18.0, "version": "18.20.4", "url": "https://heroku.com"},
+		})
+	}
+
+	// yarn has no platform, and its 1.x and 2.x lines are majors too
+	yarn := latestPerMajor(parseObjects(genYarnS3ObjectList([]string{"2.4.3", "1.22.19", "1.9.4", "2.0.0"})))
+	out = captureStdout(t, func() { printMajorReleases(yarn, options{json: true}) })
+	entries = nil
+	if assert.Nil(t, json.Unmarshal([]byte(out), &entries)) {
+		assert.Equal(t, entries, []map[string]interface{}{
+			{"major": 1.0, "version": "1.22.19", "url": "https://s3.amazonaws.com/heroku-nodebin/yarn/release/yarn-v1.22.19.tar.gz"},
+			{"major": 2.0, "version": "2.4.3", "url": "https://s3.amazonaws.com/heroku-nodebin/yarn/release/yarn-v2.4.3.tar.gz"},
+		})
+	}
+
+	// and no releases is an empty list rather than null
+	out = captureStdout(t, func() { printMajorReleases(latestPerMajor(nil), options{json: true}) })
+	assert.Equal(t, out, "[]\n")
+}
+
+func TestCountByMajor(t *testing.T) {
+	releases := genReleasesFromArray([]string{
+		"10.24.1", "12.0.0", "12.22.12", "12.9.1", "14.17.0", "14.21.3", "14.9.0", "8.17.0",
+	})
+
+	counts := countByMajor(releases)
+	assert.Equal(t, counts, []majorCount{
+		{Major: 8, Count: 1, Newest: "8.17.0"},
+		{Major: 10, Count: 1, Newest: "10.24.1"},
+		{Major: 12, Count: 3, Newest: "12.22.12"},
+		{Major: 14, Count: 3, Newest: "14.21.3"},
+	})
+
+	// majors are ordered by number, not as strings
+	releases = genReleasesFromArray([]string{"10.0.0", "4.9.1", "10.24.1", "4.0.0"})
+	out, err := json.Marshal(countByMajor(releases))
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), `[{"major":4,"count":2,"newest":"4.9.1"},{"major":10,"count":2,"newest":"10.24.1"}]`)
+
+	out, err = json.Marshal(countByMajor([]release{}))
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), "[]")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// The NODE_MAX_VERSION that node is capped at, or "" when it isn't
+var maxNodeVersion string
+
+// Matches the versions of node at or below maxNodeVersion
+var belowMaxNodeVersion semver.Range
+
+// Reads the newest version of node that may be resolved out of the
+// environment:
+//
+//	NODE_MAX_VERSION  a version like 20.11.1, or a partial one like 20, for
+//	                  platforms that can't run anything newer whatever
+//	                  engines.node asks for
+//
+// This is ANDed onto every requirement as <=NODE_MAX_VERSION, so a partial
+// version caps at the newest release it matches, letting NODE_MAX_VERSION=20
+// resolve 20.x but never 21
+func maxNodeVersionFromEnv() (string, semver.Range, error) {
+	value := strings.TrimSpace(os.Getenv("NODE_MAX_VERSION"))
+	if value == "" {
+		return "", nil, nil
+	}
+	if _, err := semver.ParseTolerant(value); err != nil {
+		return "", nil, fmt.Errorf("Invalid NODE_MAX_VERSION: %s, expected a version like 20.11.1", value)
+	}
+	r, err := parseRequirement("<=" + strings.TrimPrefix(value, "v"))
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid NODE_MAX_VERSION: %s (%s)", value, err)
+	}
+	return value, r, nil
+}
+
+// Drops the releases above NODE_MAX_VERSION, returning them separately so that
+// a requirement that only matches those can be told apart from one that
+// matches nothing at all
+func capReleases(releases []release) ([]release, []release) {
+	if belowMaxNodeVersion == nil {
+		return releases, nil
+	}
+	allowed := []release{}
+	capped := []release{}
+	for _, rel := range releases {
+		if belowMaxNodeVersion(rel.version) {
+			allowed = append(allowed, rel)
+		} else {
+			capped = append(capped, rel)
+		}
+	}
+	return allowed, capped
+}
+
+// Returns the newest of the releases that NODE_MAX_VERSION dropped that would
+// have matched the requirement, if any
+func newestCappedMatch(capped []release, versionRequirement string) (release, bool) {
+	constraints, err := parseRequirement(versionRequirement)
+	if err != nil {
+		return release{}, false
+	}
+	var newest *release
+	for i, rel := range capped {
+		if constraints(rel.version) && !isExcluded(rel.version) && (newest == nil || versionLess(newest.version, rel.version)) {
+			newest = &capped[i]
+		}
+	}
+	if newest == nil {
+		return release{}, false
+	}
+	return *newest, true
+}
+
+// Explains that a requirement didn't resolve because of NODE_MAX_VERSION
+func cappedMessage(binary string, versionRequirement string, newest release) string {
+	return fmt.Sprintf("%s %s only matches versions above NODE_MAX_VERSION=%s, like %s", binary, versionRequirement, maxNodeVersion, newest.version.String())
+}
+
+// Matches the versions in a major at or below --max-major, or nil when it
+// isn't set
+var withinMaxMajor semver.Range
+
+// Parses --max-major, which only lets a requirement resolve to the majors up
+// to and including it. Unlike NODE_MAX_VERSION this is a whole major, so
+// --max-major 18 still picks up each new 18.x as it's released
+func parseMaxMajor(value string) (semver.Range, error) {
+	if value == "" {
+		return nil, nil
+	}
+	major, err := strconv.ParseUint(strings.TrimPrefix(value, "v"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --max-major: %s, expected a major version like 18", value)
+	}
+	return func(v semver.Version) bool {
+		return v.Major <= major
+	}, nil
+}
+
+// Whether --max-major rules out a version
+func aboveMaxMajor(version semver.Version) bool {
+	return withinMaxMajor != nil && !withinMaxMajor(version)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxNodeVersionFromEnv(t *testing.T) {
+	defer os.Unsetenv("NODE_MAX_VERSION")
+
+	value, r, err := maxNodeVersionFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, value, "")
+	assert.Nil(t, r)
+
+	os.Setenv("NODE_MAX_VERSION", "not-a-version")
+	_, _, err = maxNodeVersionFromEnv()
+	assert.EqualError(t, err, "Invalid NODE_MAX_VERSION: not-a-version, expected a version like 20.11.1")
+
+	os.Setenv("NODE_MAX_VERSION", ">=20")
+	_, _, err = maxNodeVersionFromEnv()
+	assert.EqualError(t, err, "Invalid NODE_MAX_VERSION: >=20, expected a version like 20.11.1")
+
+	for max, cases := range map[string]map[string]bool{
+		"20.11.1":  {"20.11.1": true, "20.11.2": false, "20.0.0": true},
+		"v20.11.1": {"20.11.1": true, "20.11.2": false},
+		// a partial version allows anything it matches
+		"20.11": {"20.11.9": true, "20.12.0": false},
+		"20":    {"20.18.0": true, "21.0.0": false, "18.20.4": true},
+	} {
+		os.Setenv("NODE_MAX_VERSION", max)
+		value, r, err := maxNodeVersionFromEnv()
+		if !assert.Nil(t, err, max) {
+			continue
+		}
+		assert.Equal(t, value, max)
+		for version, want := range cases {
+			assert.Equal(t, r(semver.MustParse(version)), want, "%s %s", max, version)
+		}
+	}
+}
+
+func TestResolveNodeCapped(t *testing.T) {
+	defer func(original string) { maxNodeVersion = original }(maxNodeVersion)
+	defer func(original semver.Range) { belowMaxNodeVersion = original }(belowMaxNodeVersion)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	defer os.Unsetenv("NODE_MAX_VERSION")
+	platformOverride = "linux-x64"
+	src := staticSource{releases: genReleasesFromArray([]string{"18.19.1", "18.20.4", "20.11.0", "20.11.1", "20.15.1", "22.4.1"})}
+
+	os.Setenv("NODE_MAX_VERSION", "20.11.1")
+	var err error
+	maxNodeVersion, belowMaxNodeVersion, err = maxNodeVersionFromEnv()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	cases := []struct {
+		requirement string
+		version     string
+		capped      string
+	}{
+		// the cap is ANDed onto ranges, so the newest release under it wins
+		{requirement: ">=18", version: "20.11.1"},
+		{requirement: "20.x", version: "20.11.1"},
+		{requirement: "^20.11.0", version: "20.11.1"},
+		// and ranges that are already below it aren't affected
+		{requirement: "18.x", version: "18.20.4"},
+		{requirement: "20.11.0", version: "20.11.0"},
+		// but when it rules out everything that was asked for, that's reported
+		{requirement: ">=22", capped: "22.4.1"},
+		{requirement: "20.15.1", capped: "20.15.1"},
+		{requirement: ">20.11.1 <22", capped: "20.15.1"},
+	}
+	for _, c := range cases {
+		result, err := resolveFromSources([]source{src}, "node", c.requirement)
+		if !assert.Nil(t, err, c.requirement) {
+			continue
+		}
+		if c.capped == "" {
+			if assert.True(t, result.matched, c.requirement) {
+				assert.Equal(t, result.release.version.String(), c.version, c.requirement)
+			}
+			assert.Nil(t, result.capped, c.requirement)
+			continue
+		}
+		assert.False(t, result.matched, c.requirement)
+		if assert.NotNil(t, result.capped, c.requirement) {
+			assert.Equal(t, result.capped.version.String(), c.capped, c.requirement)
+		}
+	}
+
+	// a requirement nothing matches, cap or not, isn't blamed on it
+	result, err := resolveFromSources([]source{src}, "node", "19.x")
+	if assert.Nil(t, err) {
+		assert.False(t, result.matched)
+		assert.Nil(t, result.capped)
+	}
+
+	// the cap only applies to node
+	yarn := staticSource{releases: genReleasesFromArray([]string{"1.22.19", "22.0.0"})}
+	result, err = resolveFromSources([]source{yarn}, "yarn", "*")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "22.0.0")
+	}
+
+	_, err = resolveRequirement(binaryRequirement{binary: "node", versionRequirement: ">=22"}, func(string) []source { return []source{src} })
+	assert.EqualError(t, err, "No result, node >=22 only matches versions above NODE_MAX_VERSION=20.11.1, like 22.4.1")
+}
+
+func TestResolveLatestNodeCapped(t *testing.T) {
+	defer func(original string) { maxNodeVersion = original }(maxNodeVersion)
+	defer func(original semver.Range) { belowMaxNodeVersion = original }(belowMaxNodeVersion)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	defer os.Unsetenv("NODE_MAX_VERSION")
+	platformOverride = "linux-x64"
+
+	server := httptest.NewServer(s3ListingHandler([]string{
+		"node/release/linux-x64/node-v18.20.4-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v20.10.0-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v22.1.0-linux-x64.tar.gz",
+	}, 2))
+	defer server.Close()
+	src := s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}
+
+	os.Setenv("NODE_MAX_VERSION", "20")
+	var err error
+	maxNodeVersion, belowMaxNodeVersion, err = maxNodeVersionFromEnv()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the newest major is over the cap, so everything is listed instead of
+	// resolving to nothing
+	for _, requirement := range []string{"*", "latest", ">=18"} {
+		result, err := resolveFromSources([]source{src}, "node", normalizeRequirement(requirement))
+		if assert.Nil(t, err, requirement) && assert.True(t, result.matched, requirement) {
+			assert.Equal(t, result.release.version.String(), "20.10.0", requirement)
+			assert.Nil(t, result.capped, requirement)
+		}
+	}
+}
+
+func TestParseMaxMajor(t *testing.T) {
+	within, err := parseMaxMajor("")
+	assert.Nil(t, err)
+	assert.Nil(t, within)
+
+	for _, value := range []string{"18", "v18"} {
+		within, err = parseMaxMajor(value)
+		if assert.Nil(t, err, value) {
+			assert.True(t, within(semver.MustParse("18.20.4")), value)
+			assert.True(t, within(semver.MustParse("16.0.0")), value)
+			assert.False(t, within(semver.MustParse("19.0.0")), value)
+			assert.False(t, within(semver.MustParse("20.0.0-rc.1")), value)
+		}
+	}
+
+	for _, value := range []string{"18.x", "18.2", "-1", "latest"} {
+		_, err = parseMaxMajor(value)
+		assert.EqualError(t, err, "Invalid --max-major: "+value+", expected a major version like 18", value)
+	}
+}
+
+func TestResolveNodeMaxMajor(t *testing.T) {
+	defer func(original semver.Range) { withinMaxMajor = original }(withinMaxMajor)
+	defer func(original semver.Range) { excludedVersions = original }(excludedVersions)
+	releases := genReleasesFromArray([]string{"16.20.2", "18.19.1", "18.20.4", "19.9.0", "20.15.1", "22.4.1"})
+
+	var err error
+	withinMaxMajor, err = parseMaxMajor("18")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	cases := []struct {
+		requirement string
+		version     string
+	}{
+		// the latest patch of the newest major it allows, though 20 exists
+		{"*", "18.20.4"},
+		{">=16", "18.20.4"},
+		{"^16 || ^20", "16.20.2"},
+		// constraints below it aren't affected
+		{"16.x", "16.20.2"},
+		{"18.19.1", "18.19.1"},
+		// and ones above it don't match at all
+		{"20.x", ""},
+		{"19.9.0", ""},
+	}
+	for _, c := range cases {
+		result, err := resolveNode(releases, "linux-x64", c.requirement)
+		if !assert.Nil(t, err, c.requirement) {
+			continue
+		}
+		assert.Equal(t, result.matched, c.version != "", c.requirement)
+		if result.matched {
+			assert.Equal(t, result.release.version.String(), c.version, c.requirement)
+		}
+	}
+
+	// it's combined with --exclude
+	excludedVersions, err = parseExclusions("18.20.4")
+	if assert.Nil(t, err) {
+		result, err := resolveNode(releases, "linux-x64", "*")
+		if assert.Nil(t, err) && assert.True(t, result.matched) {
+			assert.Equal(t, result.release.version.String(), "18.19.1")
+		}
+	}
+
+	// and staging builds above it aren't used even when asked for exactly
+	staging := append(releases, release{binary: "node", stage: "staging", platform: "linux-x64", version: semver.MustParse("20.16.0")})
+	result, err := resolveNode(staging, "linux-x64", "20.16.0")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+}
+
+func TestClosestReleasesMaxMajor(t *testing.T) {
+	defer func(original semver.Range) { withinMaxMajor = original }(withinMaxMajor)
+	releases := genReleasesFromArray([]string{"18.20.4", "20.15.1"})
+
+	var err error
+	withinMaxMajor, err = parseMaxMajor("18")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// only the one below the missing version is within --max-major
+	result, err := resolveNode(releases, "linux-x64", "19.0.0")
+	if assert.Nil(t, err) && assert.False(t, result.matched) {
+		assert.Equal(t, describeReleases(result.closest), "18.20.4")
+	}
+	result, err = resolveNode(releases, "linux-x64", "20.0.0")
+	if assert.Nil(t, err) && assert.False(t, result.matched) {
+		assert.Equal(t, describeReleases(result.closest), "18.20.4")
+		_, ok := nearestRelease(result.closest, semver.MustParse("20.0.0"))
+		assert.False(t, ok)
+	}
+}
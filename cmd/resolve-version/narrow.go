@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// Requirements simple enough to know which major they are confined to. Both
+// --semver-mode parsers agree on which releases these match, but the strict
+// one also matches some prereleases past them, see narrowVersionPrefixes
+var narrowRegex = regexp.MustCompile(`^(=|\^|~)?v?([0-9]+)(?:\.([0-9]+|[xX*]))?(?:\.([0-9]+|[xX*]))?$`)
+
+// Returns the start of the file name of every node version the requirement
+// could match, like "node-v18.", and whether the requirement is an exact
+// version. Requirements that can't be narrowed return ""
+//
+// Requirements are only narrowed to their major. Exact versions need the rest
+// of it to suggest the closest versions when one isn't available, and a
+// minor prefix like node-v16.0. would leave out keys such as node-v16.00.0
+func narrowVersionPrefix(versionRequirement string) (string, bool) {
+	match := narrowRegex.FindStringSubmatch(strings.TrimSpace(versionRequirement))
+	if match == nil {
+		return "", false
+	}
+	op, major, minor, patch := match[1], match[2], match[3], match[4]
+
+	isNumber := func(s string) bool { return s != "" && s[0] >= '0' && s[0] <= '9' }
+	// a wildcard can't be followed by a number, like 18.x.2
+	if !isNumber(minor) && isNumber(patch) {
+		return "", false
+	}
+
+	exact := op != "^" && op != "~" && isNumber(patch)
+	return "node-v" + major + ".", exact
+}
+
+// Like narrowVersionPrefix, but also covers the prereleases that the range
+// matches outside of the prefix. In --semver-mode strict 18.x is <19.0.0,
+// which 19.0.0-rc.1 is. npm ranges never match those, so only the one prefix
+// is listed for them
+func narrowVersionPrefixes(versionRequirement string) ([]string, bool) {
+	versionPrefix, exact := narrowVersionPrefix(versionRequirement)
+	if versionPrefix == "" {
+		return nil, false
+	}
+	rng, err := parseRange(normalizeRequirement(versionRequirement))
+	if err != nil {
+		return []string{versionPrefix}, exact
+	}
+
+	major, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(versionPrefix, "node-v"), "."))
+	if rng(semver.MustParse(fmt.Sprintf("%d.0.0-0", major+1))) {
+		// only the prereleases of the next major's .0, with or without
+		// leading zeros, like node-v19.0.0-rc.1 or node-v19.00.0-rc.1
+		return []string{versionPrefix, fmt.Sprintf("node-v%d.0", major+1)}, exact
+	}
+	return []string{versionPrefix}, exact
+}
+
+// Returns the S3 prefixes that hold every node release the requirement could
+// resolve to on the platform, or nil if the whole node prefix has to be listed
+func narrowNodePrefixes(platform string, versionRequirement string) []string {
+	versionPrefixes, exact := narrowVersionPrefixes(versionRequirement)
+	if versionPrefixes == nil {
+		return nil
+	}
+	return nodePrefixes(platform, versionPrefixes, exact)
+}
+
+// Returns the S3 prefixes under which node's file names start with one of
+// versionPrefixes, for each stage and platform a release could come from
+func nodePrefixes(platform string, versionPrefixes []string, exact bool) []string {
+	platforms := []string{platform}
+	if fallback, ok := platformFallbacks[platform]; ok {
+		platforms = append(platforms, fallback)
+	}
+	// staging builds are only used for exact versions, see resolveNode
+	stages := append([]string{}, stagePreference...)
+	if exact && stageRank("staging") == len(stagePreference) {
+		stages = append(stages, "staging")
+	}
+
+	prefixes := []string{}
+	for _, stage := range stages {
+		for _, p := range platforms {
+			for _, versionPrefix := range versionPrefixes {
+				prefixes = append(prefixes, "node/"+stage+"/"+p+"/"+versionPrefix)
+			}
+		}
+	}
+	return prefixes
+}
+
+// Lists the releases of binary that versionRequirement could match. Listing
+// the whole node prefix of the bucket takes several requests, so when the
+// requirement pins a major or minor only the keys under it are listed
+func listForRequirement(src source, binary string, versionRequirement string) ([]release, error) {
+	s3, ok := src.(s3Source)
+	if !ok || binary != "node" || !hasDefaultLayout(binary) {
+		return src.List(binary)
+	}
+	platform := getPlatform()
+	if isLatestRequirement(versionRequirement) {
+		if releases, ok := listLatestNode(s3, platform, versionRequirement); ok {
+			return releases, nil
+		}
+	}
+	prefixes := narrowNodePrefixes(platform, versionRequirement)
+	if prefixes == nil {
+		// only builds for the platform, or the one it falls back to, can match
+		fallback, hasFallback := platformFallbacks[platform]
+		return s3.ListMatching(binary, func(rel release) bool {
+			return rel.platform == platform || hasFallback && rel.platform == fallback
+		})
+	}
+
+	logVerbose("Listing %s instead of all of %s\n", strings.Join(prefixes, ", "), binary)
+	releases := []release{}
+	for _, prefix := range prefixes {
+		listed, err := s3.List(prefix)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, listed...)
+	}
+	return releases, nil
+}
+
+// Whether a requirement resolves to the newest release, whatever it is
+func isLatestRequirement(versionRequirement string) bool {
+	switch strings.TrimSpace(versionRequirement) {
+	case "*", "x", "X", "latest":
+		return true
+	}
+	return false
+}
+
+// Returns the newest major of node with a build for the platform in any of
+// the preferred stages. Keys are listed in lexical order, where node-v9 sorts
+// after node-v22, so rather than relying on the order of the keys this lists
+// the majors themselves, which S3 returns as a single short page
+func newestNodeMajor(s3 s3Source, platform string) (string, bool, error) {
+	newest := -1
+	for _, stage := range stagePreference {
+		prefix := "node/" + stage + "/" + platform + "/node-v"
+		majors, err := listS3CommonPrefixes(s3.listEndpoints(), s3.bucketName, prefix, ".")
+		if err != nil {
+			return "", false, err
+		}
+		for _, p := range majors {
+			major, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(p, prefix), "."))
+			if err == nil && major > newest {
+				newest = major
+			}
+		}
+	}
+	if newest < 0 {
+		return "", false, nil
+	}
+	return strconv.Itoa(newest), true, nil
+}
+
+// The common case of resolving the newest node only has to list its newest
+// major. This returns false whenever that might not give the same result as
+// listing everything, like when --as-of or NODE_MAX_VERSION rules out every
+// build of that major, so that the caller can list everything instead
+func listLatestNode(s3 s3Source, platform string, versionRequirement string) ([]release, bool) {
+	major, ok, err := newestNodeMajor(s3, platform)
+	if err != nil {
+		logVerbose("Could not list the majors of node, listing all of them: %s\n", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	// there's no newer major whose prereleases could match
+	prefixes := nodePrefixes(platform, []string{"node-v" + major + "."}, false)
+	logVerbose("Listing %s instead of all of node\n", strings.Join(prefixes, ", "))
+	releases := []release{}
+	for _, prefix := range prefixes {
+		listed, err := s3.List(prefix)
+		if err != nil {
+			logVerbose("Could not list %s, listing all of node: %s\n", prefix, err)
+			return nil, false
+		}
+		releases = append(releases, listed...)
+	}
+
+	capped, _ := capReleases(publishedAsOf(releases))
+	result, err := resolveNode(capped, platform, versionRequirement)
+	if err != nil || !result.matched {
+		logVerbose("No release of node %s matches %s, listing all of node\n", major, versionRequirement)
+		return nil, false
+	}
+	return releases, true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmorrell/semver"
+)
+
+// Where nodejs.org publishes a build of node's main branch every day
+var nodeNightlyURL = "https://nodejs.org/download/nightly"
+
+// Nightly versions carry the date of the build and the commit it was built
+// from, like v21.0.0-nightly20231001abcdef
+var nightlyRegex = regexp.MustCompile(`^nightly([0-9]{8})([0-9a-f]+)$`)
+
+// Nightly builds from nodejs.org, laid out like the release index:
+//
+//	https://nodejs.org/download/nightly/v21.0.0-nightly20231001abcdef/node-v21.0.0-nightly20231001abcdef-linux-x64.tar.gz
+type nightlySource struct{}
+
+func (s nightlySource) List(prefix string) ([]release, error) {
+	if prefix != "node" {
+		return nil, fmt.Errorf("There are only nightly builds of node, not %s", prefix)
+	}
+
+	defer recordTiming("listing nodejs.org nightly builds", time.Now())
+
+	index, err := fetchNodejsOrgIndex(nodeNightlyURL)
+	if err != nil {
+		return nil, err
+	}
+	releases := []release{}
+	for _, rel := range parseNodejsOrgIndexAt(nodeNightlyURL, index) {
+		if _, _, ok := parseNightly(rel.version); ok {
+			rel.stage = "nightly"
+			releases = append(releases, rel)
+		}
+	}
+	return releases, nil
+}
+
+// Returns the build date, as YYYYMMDD, and the abbreviated commit of a nightly
+// version
+func parseNightly(version semver.Version) (string, string, bool) {
+	if len(version.Pre) != 1 || version.Pre[0].IsNum {
+		return "", "", false
+	}
+	match := nightlyRegex.FindStringSubmatch(version.Pre[0].VersionStr)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+var nightlyDateRegex = regexp.MustCompile(`^([0-9]{4})-?([0-9]{2})-?([0-9]{2})$`)
+var nightlyCommitRegex = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// Resolves a nightly build for the platform. The requirement is one of:
+//
+//	2023-10-01, 20231001   the build from that day
+//	abcdef1                the build of a commit, by at least 7 characters of it
+//	21.x                   the newest build of a version matching the range
+//
+// The nightly part of a version isn't meaningful to semver, so ranges are
+// matched against the version without it, and the newest build wins
+func resolveNightly(all []release, platform string, versionRequirement string) (matchResult, error) {
+	var matches func(date string, commit string, version semver.Version) bool
+	requirement := strings.TrimSpace(versionRequirement)
+	if match := nightlyDateRegex.FindStringSubmatch(requirement); match != nil {
+		day := match[1] + match[2] + match[3]
+		matches = func(date string, commit string, version semver.Version) bool { return date == day }
+	} else if nightlyCommitRegex.MatchString(requirement) {
+		matches = func(date string, commit string, version semver.Version) bool {
+			return strings.HasPrefix(commit, requirement) || strings.HasPrefix(requirement, commit)
+		}
+	} else {
+		if err := checkRequirementSize(requirement); err != nil {
+			return matchResult{}, err
+		}
+		rng, err := parseRange(requirement)
+		if err != nil {
+			return matchResult{}, fmt.Errorf("Could not parse nightly requirement: %s", versionRequirement)
+		}
+		matches = func(date string, commit string, version semver.Version) bool {
+			return rng(semver.Version{Major: version.Major, Minor: version.Minor, Patch: version.Patch})
+		}
+	}
+
+	result := matchResult{versionRequirement: versionRequirement}
+	newestDate := ""
+	for _, rel := range all {
+		if rel.platform != platform {
+			continue
+		}
+		date, commit, ok := parseNightly(rel.version)
+		if !ok || !matches(date, commit, rel.version) {
+			continue
+		}
+		if !result.matched || date > newestDate || (date == newestDate && rel.version.GT(result.release.version)) {
+			result.release = rel
+			result.matched = true
+			newestDate = date
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nightlyIndexFixture = `[
+  {"version": "v21.0.0-nightly20231002fedcba9876", "date": "2023-10-02", "files": ["linux-x64", "osx-arm64-tar"]},
+  {"version": "v21.0.0-nightly20231001abcdef1234", "date": "2023-10-01", "files": ["linux-x64", "osx-arm64-tar"]},
+  {"version": "v20.9.0-nightly202309301234567890", "date": "2023-09-30", "files": ["linux-x64"]},
+  {"version": "v20.8.0", "date": "2023-09-28", "files": ["linux-x64"]}
+]`
+
+func TestNightlySource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/index.json" {
+			w.WriteHeader(404)
+			return
+		}
+		fmt.Fprint(w, nightlyIndexFixture)
+	}))
+	defer server.Close()
+
+	defer func(original string) { nodeNightlyURL = original }(nodeNightlyURL)
+	nodeNightlyURL = server.URL
+
+	releases, err := nightlySource{}.List("node")
+	if !assert.Nil(t, err) {
+		return
+	}
+	// 20.8.0 isn't a nightly build
+	assert.Len(t, releases, 5)
+	assert.Equal(t, releases[0].version.String(), "21.0.0-nightly20231002fedcba9876")
+	assert.Equal(t, releases[0].stage, "nightly")
+	assert.Equal(t, releases[0].url, server.URL+"/v21.0.0-nightly20231002fedcba9876/node-v21.0.0-nightly20231002fedcba9876-linux-x64.tar.gz")
+
+	_, err = nightlySource{}.List("yarn")
+	assert.NotNil(t, err)
+
+	cases := []struct {
+		requirement string
+		platform    string
+		version     string
+	}{
+		{"2023-10-01", "linux-x64", "21.0.0-nightly20231001abcdef1234"},
+		{"20230930", "linux-x64", "20.9.0-nightly202309301234567890"},
+		{"abcdef1", "linux-x64", "21.0.0-nightly20231001abcdef1234"},
+		{"fedcba98765432100000", "linux-x64", "21.0.0-nightly20231002fedcba9876"},
+		{"*", "linux-x64", "21.0.0-nightly20231002fedcba9876"},
+		{"20.x", "linux-x64", "20.9.0-nightly202309301234567890"},
+		{"<21", "darwin-arm64", ""},
+		{"2023-09-30", "darwin-arm64", ""},
+		{"1234567", "linux-x64", "20.9.0-nightly202309301234567890"},
+		{"7654321", "linux-x64", ""},
+		{"2023-10-03", "linux-x64", ""},
+	}
+	for _, c := range cases {
+		result, err := resolveNightly(releases, c.platform, c.requirement)
+		if !assert.Nil(t, err, c.requirement) {
+			continue
+		}
+		assert.Equal(t, result.matched, c.version != "", c.requirement)
+		if result.matched {
+			assert.Equal(t, result.release.version.String(), c.version, c.requirement)
+		}
+	}
+
+	_, err = resolveNightly(releases, "linux-x64", "yesterday")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not parse nightly requirement: yesterday")
+	}
+
+	result, err := resolveFromSources([]source{nightlySource{}}, "node", "2023-10-01")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "21.0.0-nightly20231001abcdef1234")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmorrell/semver"
+)
+
+// An entry in https://nodejs.org/dist/index.json
+type nodejsOrgRelease struct {
+	Version string `json:"version"`
+	Date    string `json:"date"`
+	// the version of npm that ships with this release
+	Npm   string   `json:"npm"`
+	Files []string `json:"files"`
+	// the LTS codename, or false for releases that aren't LTS
+	LTS interface{} `json:"lts"`
+}
+
+// The names nodejs.org uses in the files list for each of our platforms
+var nodejsOrgPlatforms = []struct {
+	platform string
+	file     string
+}{
+	{platform: "linux-x64", file: "linux-x64"},
+	{platform: "darwin-x64", file: "osx-x64-tar"},
+	{platform: "darwin-arm64", file: "osx-arm64-tar"},
+}
+
+// The official node release index. Unlike the S3 bucket there is no staging
+// area, and tarballs are downloaded from the nodejs.org dist directory:
+//
+//	https://nodejs.org/dist/v12.13.0/node-v12.13.0-linux-x64.tar.gz
+type nodejsOrgSource struct{}
+
+func (s nodejsOrgSource) List(prefix string) ([]release, error) {
+	if prefix != "node" {
+		return nil, fmt.Errorf("nodejs.org only publishes node, not %s", prefix)
+	}
+
+	defer recordTiming("listing nodejs.org releases", time.Now())
+
+	index, err := fetchNodejsOrgIndex(nodeDistURL)
+	if err != nil {
+		return nil, err
+	}
+	return parseNodejsOrgIndex(index), nil
+}
+
+// Downloads the index.json of a nodejs.org download directory
+func fetchNodejsOrgIndex(distURL string) ([]nodejsOrgRelease, error) {
+	body, err := download(distURL + "/index.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var index []nodejsOrgRelease
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Maps the release index into one release per version and platform
+func parseNodejsOrgIndex(index []nodejsOrgRelease) []release {
+	return parseNodejsOrgIndexAt(nodeDistURL, index)
+}
+
+// Like parseNodejsOrgIndex, for an index whose tarballs are under distURL
+func parseNodejsOrgIndexAt(distURL string, index []nodejsOrgRelease) []release {
+	releases := []release{}
+	for _, entry := range index {
+		version, err := semver.ParseTolerant(entry.Version)
+		if err != nil {
+			// ParseTolerant can't handle prereleases, like the nightly builds
+			version, err = semver.Make(strings.TrimPrefix(entry.Version, "v"))
+		}
+		if err != nil {
+			continue
+		}
+
+		lts, _ := entry.LTS.(string)
+		// the index only has the day each version was released on
+		published, _ := time.Parse("2006-01-02", entry.Date)
+		for _, p := range nodejsOrgPlatforms {
+			if !contains(entry.Files, p.file) {
+				continue
+			}
+			releases = append(releases, release{
+				binary:       "node",
+				stage:        "release",
+				platform:     p.platform,
+				url:          fmt.Sprintf("%s/v%s/node-v%s-%s.tar.gz", distURL, version.String(), version.String(), p.platform),
+				version:      version,
+				lts:          lts,
+				lastModified: published,
+			})
+		}
+	}
+	return releases
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
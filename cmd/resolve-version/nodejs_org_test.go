@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nodejsOrgIndexFixture = `[
+  {"version": "v13.0.1", "date": "2019-10-23", "files": ["linux-x64", "osx-x64-tar", "win-x64-zip"], "npm": "6.12.0", "lts": false},
+  {"version": "v12.13.0", "date": "2019-10-21", "files": ["linux-x64", "osx-x64-tar"], "npm": "6.12.0", "lts": "Erbium"},
+  {"version": "v12.12.0", "date": "2019-10-11", "files": ["linux-x64", "osx-x64-tar"], "npm": "6.11.3", "lts": false},
+  {"version": "v10.16.3", "date": "2019-08-14", "files": ["linux-x64"], "npm": "6.9.0", "lts": "Dubnium"}
+]`
+
+func newNodejsOrgServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/index.json" {
+			w.WriteHeader(404)
+			return
+		}
+		fmt.Fprint(w, nodejsOrgIndexFixture)
+	}))
+}
+
+func TestNodejsOrgSource(t *testing.T) {
+	server := newNodejsOrgServer()
+	defer server.Close()
+
+	defer func(original string) { nodeDistURL = original }(nodeDistURL)
+	nodeDistURL = server.URL
+
+	releases, err := nodejsOrgSource{}.List("node")
+	if !assert.Nil(t, err) {
+		return
+	}
+	// 10.16.3 wasn't published for darwin
+	assert.Len(t, releases, 7)
+	assert.Equal(t, releases[2].version.String(), "12.13.0")
+	assert.Equal(t, releases[2].platform, "linux-x64")
+	assert.Equal(t, releases[2].lts, "Erbium")
+	assert.Equal(t, releases[2].url, server.URL+"/v12.13.0/node-v12.13.0-linux-x64.tar.gz")
+	assert.Equal(t, releases[4].lts, "")
+
+	result, err := resolveNode(releases, "darwin-x64", "<=12")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "12.13.0")
+		assert.Equal(t, result.release.url, server.URL+"/v12.13.0/node-v12.13.0-darwin-x64.tar.gz")
+	}
+
+	result, err = resolveNode(releases, "darwin-x64", "10.x")
+	if assert.Nil(t, err) {
+		assert.False(t, result.matched)
+	}
+
+	_, err = nodejsOrgSource{}.List("yarn")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// How version requirements are interpreted, chosen with --semver-mode. strict
+// uses the semver library's own range syntax, npm follows the rules npm uses
+// for engines.node so that a requirement resolves to what npm would accept
+var rangeParsers = map[string]func(string) (semver.Range, error){
+	"strict": semver.ParseRange,
+	"npm":    parseNpmRange,
+}
+
+// The range parser used when matching releases
+var parseRange = semver.ParseRange
+
+// A single comparison against a version. An empty op matches every version
+type npmComparator struct {
+	op      string
+	version semver.Version
+}
+
+func (c npmComparator) matches(v semver.Version) bool {
+	switch c.op {
+	case "":
+		return true
+	case "<":
+		return v.LT(c.version)
+	case "<=":
+		return v.LTE(c.version)
+	case ">":
+		return v.GT(c.version)
+	case ">=":
+		return v.GTE(c.version)
+	case "=":
+		return v.EQ(c.version)
+	}
+	return false
+}
+
+// A version where trailing components may be wildcards, like 1.2 or 1.x. parts
+// is the number of components that were given
+type npmPartial struct {
+	major, minor, patch uint64
+	pre                 []semver.PRVersion
+	parts               int
+}
+
+func (p npmPartial) version() semver.Version {
+	return semver.Version{Major: p.major, Minor: p.minor, Patch: p.patch, Pre: p.pre}
+}
+
+var npmPartialRegex = regexp.MustCompile(`^v?([0-9]+|[xX*])(?:\.([0-9]+|[xX*])(?:\.([0-9]+|[xX*])(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?)?)?$`)
+var npmHyphenRegex = regexp.MustCompile(`^(\S+)\s+-\s+(\S+)$`)
+var npmOperatorSpaceRegex = regexp.MustCompile(`(<=|>=|<|>|=|~>|~|\^)\s+`)
+
+// Parses a range the way npm does:
+//
+//	1.2.3 - 2.3.4   hyphen ranges, where a partial upper bound is a wildcard
+//	1.x, 1.2, *     wildcards in any trailing component
+//	~1.2.3          patch changes, or minor changes when only a major is given
+//	^0.2.3          changes that don't modify the first non-zero component
+//	>=1.2 <3        any comparators, where all of them must match
+//	a || b          either of several ranges
+//
+// Unlike the semver library, prereleases only match a range that mentions a
+// prerelease of the same major.minor.patch
+func parseNpmRange(s string) (semver.Range, error) {
+	sets := [][]npmComparator{}
+	for _, part := range strings.Split(s, "||") {
+		set, err := parseNpmComparatorSet(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+
+	return func(v semver.Version) bool {
+		for _, set := range sets {
+			if npmSetMatches(set, v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func npmSetMatches(set []npmComparator, v semver.Version) bool {
+	for _, c := range set {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	if len(v.Pre) == 0 {
+		return true
+	}
+
+	for _, c := range set {
+		if c.op != "" && len(c.version.Pre) > 0 &&
+			c.version.Major == v.Major && c.version.Minor == v.Minor && c.version.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNpmComparatorSet(s string) ([]npmComparator, error) {
+	if s == "" {
+		return []npmComparator{{}}, nil
+	}
+
+	if match := npmHyphenRegex.FindStringSubmatch(s); match != nil {
+		return parseNpmHyphen(match[1], match[2])
+	}
+
+	set := []npmComparator{}
+	for _, token := range strings.Fields(npmOperatorSpaceRegex.ReplaceAllString(s, "$1")) {
+		comparators, err := parseNpmSimple(token)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, comparators...)
+	}
+	return set, nil
+}
+
+func parseNpmHyphen(from string, to string) ([]npmComparator, error) {
+	lower, err := parseNpmPartial(from)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := parseNpmPartial(to)
+	if err != nil {
+		return nil, err
+	}
+
+	set := []npmComparator{{}}
+	if lower.parts > 0 {
+		set = []npmComparator{{op: ">=", version: lower.version()}}
+	}
+	switch upper.parts {
+	case 3:
+		set = append(set, npmComparator{op: "<=", version: upper.version()})
+	case 2:
+		set = append(set, npmComparator{op: "<", version: npmPrereleaseZero(upper.major, upper.minor+1, 0)})
+	case 1:
+		set = append(set, npmComparator{op: "<", version: npmPrereleaseZero(upper.major+1, 0, 0)})
+	}
+	return set, nil
+}
+
+func parseNpmSimple(token string) ([]npmComparator, error) {
+	op := ""
+	for _, prefix := range []string{"<=", ">=", "~>", "<", ">", "=", "~", "^"} {
+		if strings.HasPrefix(token, prefix) {
+			op = prefix
+			break
+		}
+	}
+
+	p, err := parseNpmPartial(token[len(op):])
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "~", "~>":
+		return npmTilde(p), nil
+	case "^":
+		return npmCaret(p), nil
+	case "", "=":
+		return npmXRange(p), nil
+	}
+	return npmPrimitive(op, p), nil
+}
+
+func parseNpmPartial(s string) (npmPartial, error) {
+	match := npmPartialRegex.FindStringSubmatch(s)
+	if match == nil {
+		return npmPartial{}, fmt.Errorf("Could not parse version requirement: %s", s)
+	}
+
+	p := npmPartial{}
+	components := []*uint64{&p.major, &p.minor, &p.patch}
+	for i, component := range match[1:4] {
+		if component == "" || component == "x" || component == "X" || component == "*" {
+			break
+		}
+		n, err := strconv.ParseUint(component, 10, 64)
+		if err != nil {
+			return npmPartial{}, fmt.Errorf("Could not parse version requirement: %s", s)
+		}
+		*components[i] = n
+		p.parts++
+	}
+
+	if match[4] != "" && p.parts == 3 {
+		for _, id := range strings.Split(match[4], ".") {
+			pre, err := semver.NewPRVersion(id)
+			if err != nil {
+				return npmPartial{}, fmt.Errorf("Could not parse version requirement: %s", s)
+			}
+			p.pre = append(p.pre, pre)
+		}
+	}
+	return p, nil
+}
+
+// The lowest possible version of major.minor.patch, which sorts before every
+// other prerelease of it
+func npmPrereleaseZero(major, minor, patch uint64) semver.Version {
+	return semver.Version{Major: major, Minor: minor, Patch: patch, Pre: []semver.PRVersion{{VersionNum: 0, IsNum: true}}}
+}
+
+func npmXRange(p npmPartial) []npmComparator {
+	switch p.parts {
+	case 0:
+		return []npmComparator{{}}
+	case 1:
+		return []npmComparator{{op: ">=", version: p.version()}, {op: "<", version: npmPrereleaseZero(p.major+1, 0, 0)}}
+	case 2:
+		return []npmComparator{{op: ">=", version: p.version()}, {op: "<", version: npmPrereleaseZero(p.major, p.minor+1, 0)}}
+	}
+	return []npmComparator{{op: "=", version: p.version()}}
+}
+
+func npmTilde(p npmPartial) []npmComparator {
+	switch p.parts {
+	case 0:
+		return []npmComparator{{}}
+	case 1:
+		return []npmComparator{{op: ">=", version: p.version()}, {op: "<", version: npmPrereleaseZero(p.major+1, 0, 0)}}
+	}
+	return []npmComparator{{op: ">=", version: p.version()}, {op: "<", version: npmPrereleaseZero(p.major, p.minor+1, 0)}}
+}
+
+func npmCaret(p npmPartial) []npmComparator {
+	lower := npmComparator{op: ">=", version: p.version()}
+	switch {
+	case p.parts == 0:
+		return []npmComparator{{}}
+	case p.major > 0 || p.parts == 1:
+		return []npmComparator{lower, {op: "<", version: npmPrereleaseZero(p.major+1, 0, 0)}}
+	case p.minor > 0 || p.parts == 2:
+		return []npmComparator{lower, {op: "<", version: npmPrereleaseZero(0, p.minor+1, 0)}}
+	}
+	return []npmComparator{lower, {op: "<", version: npmPrereleaseZero(0, 0, p.patch+1)}}
+}
+
+// Comparators against a partial version, where the wildcards are filled in so
+// that the comparison covers every version the partial could mean
+func npmPrimitive(op string, p npmPartial) []npmComparator {
+	if p.parts == 0 {
+		if op == ">=" || op == "<=" {
+			return []npmComparator{{}}
+		}
+		// nothing is greater or less than every version
+		return []npmComparator{{op: "<", version: npmPrereleaseZero(0, 0, 0)}}
+	}
+	if p.parts == 3 {
+		return []npmComparator{{op: op, version: p.version()}}
+	}
+
+	next := semver.Version{Major: p.major + 1}
+	if p.parts == 2 {
+		next = semver.Version{Major: p.major, Minor: p.minor + 1}
+	}
+	switch op {
+	case ">":
+		return []npmComparator{{op: ">=", version: next}}
+	case "<=":
+		return []npmComparator{{op: "<", version: npmPrereleaseZero(next.Major, next.Minor, 0)}}
+	case "<":
+		return []npmComparator{{op: "<", version: npmPrereleaseZero(p.major, p.minor, 0)}}
+	}
+	return []npmComparator{{op: op, version: p.version()}}
+}
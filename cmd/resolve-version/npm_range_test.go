@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+var npmRangeTestVersions = []string{
+	"0.0.3", "0.0.4", "0.2.3", "0.2.9", "0.3.0",
+	"1.0.0-beta", "1.0.0", "1.2.0", "1.2.3-beta.2", "1.2.3-beta.3", "1.2.3", "1.2.4-beta", "1.2.9", "1.3.0",
+	"2.0.0-rc.1", "2.0.0", "2.3.4", "2.4.0", "2.5.0",
+}
+
+func matchingVersions(r semver.Range) []string {
+	matching := []string{}
+	for _, v := range npmRangeTestVersions {
+		if r(semver.MustParse(v)) {
+			matching = append(matching, v)
+		}
+	}
+	return matching
+}
+
+// Tricky requirements, with what each mode matches. The modes mostly differ
+// on prereleases, which npm only matches when the range names a prerelease of
+// the same version
+func TestSemverModes(t *testing.T) {
+	cases := []struct {
+		requirement string
+		strict      []string
+		npm         []string
+	}{
+		{
+			requirement: "^0.2.3",
+			strict:      []string{"0.2.3", "0.2.9"},
+			npm:         []string{"0.2.3", "0.2.9"},
+		},
+		{
+			requirement: "^0.0.3",
+			strict:      []string{"0.0.3"},
+			npm:         []string{"0.0.3"},
+		},
+		{
+			requirement: "~1.2",
+			strict:      []string{"1.2.0", "1.2.3-beta.2", "1.2.3-beta.3", "1.2.3", "1.2.4-beta", "1.2.9"},
+			npm:         []string{"1.2.0", "1.2.3", "1.2.9"},
+		},
+		{
+			requirement: "1.2.3 - 2.3.4",
+			strict:      []string{"1.2.3", "1.2.4-beta", "1.2.9", "1.3.0", "2.0.0-rc.1", "2.0.0", "2.3.4"},
+			npm:         []string{"1.2.3", "1.2.9", "1.3.0", "2.0.0", "2.3.4"},
+		},
+		{
+			requirement: ">=1.0.0-beta <2",
+			strict:      []string{"1.0.0-beta", "1.0.0", "1.2.0", "1.2.3-beta.2", "1.2.3-beta.3", "1.2.3", "1.2.4-beta", "1.2.9", "1.3.0", "2.0.0-rc.1"},
+			npm:         []string{"1.0.0-beta", "1.0.0", "1.2.0", "1.2.3", "1.2.9", "1.3.0"},
+		},
+		{
+			requirement: "^1.2.3-beta.2",
+			strict:      []string{"1.2.3-beta.2", "1.2.3-beta.3", "1.2.3", "1.2.4-beta", "1.2.9", "1.3.0", "2.0.0-rc.1"},
+			npm:         []string{"1.2.3-beta.2", "1.2.3-beta.3", "1.2.3", "1.2.9", "1.3.0"},
+		},
+		{
+			requirement: "1.x || >=2.5.0",
+			strict:      []string{"1.0.0", "1.2.0", "1.2.3-beta.2", "1.2.3-beta.3", "1.2.3", "1.2.4-beta", "1.2.9", "1.3.0", "2.0.0-rc.1", "2.5.0"},
+			npm:         []string{"1.0.0", "1.2.0", "1.2.3", "1.2.9", "1.3.0", "2.5.0"},
+		},
+		{
+			requirement: ">= 2",
+			strict:      []string{"2.0.0", "2.3.4", "2.4.0", "2.5.0"},
+			npm:         []string{"2.0.0", "2.3.4", "2.4.0", "2.5.0"},
+		},
+		{
+			requirement: ">1.2",
+			strict:      []string{"1.3.0", "2.0.0-rc.1", "2.0.0", "2.3.4", "2.4.0", "2.5.0"},
+			npm:         []string{"1.3.0", "2.0.0", "2.3.4", "2.4.0", "2.5.0"},
+		},
+	}
+
+	for _, c := range cases {
+		strict, err := rangeParsers["strict"](c.requirement)
+		if assert.Nil(t, err, c.requirement) {
+			assert.Equal(t, c.strict, matchingVersions(strict), "strict %s", c.requirement)
+		}
+		npm, err := rangeParsers["npm"](c.requirement)
+		if assert.Nil(t, err, c.requirement) {
+			assert.Equal(t, c.npm, matchingVersions(npm), "npm %s", c.requirement)
+		}
+	}
+}
+
+func TestNpmRange(t *testing.T) {
+	stable := []string{"0.0.3", "0.0.4", "0.2.3", "0.2.9", "0.3.0", "1.0.0", "1.2.0", "1.2.3", "1.2.9", "1.3.0", "2.0.0", "2.3.4", "2.4.0", "2.5.0"}
+	cases := []struct {
+		requirement string
+		matching    []string
+	}{
+		{"", stable},
+		{"*", stable},
+		{"x.x", stable},
+		{"1.2.3", []string{"1.2.3"}},
+		{"=v1.2.3", []string{"1.2.3"}},
+		{"1.2.3-beta.2", []string{"1.2.3-beta.2"}},
+		{"^0.0.x", []string{"0.0.3", "0.0.4"}},
+		{"^0.x", []string{"0.0.3", "0.0.4", "0.2.3", "0.2.9", "0.3.0"}},
+		{"~0.2", []string{"0.2.3", "0.2.9"}},
+		{"~1", []string{"1.0.0", "1.2.0", "1.2.3", "1.2.9", "1.3.0"}},
+		{"~1.2.3-beta.3", []string{"1.2.3-beta.3", "1.2.3", "1.2.9"}},
+		{"<1.2", []string{"0.0.3", "0.0.4", "0.2.3", "0.2.9", "0.3.0", "1.0.0"}},
+		{"<=1.2", []string{"0.0.3", "0.0.4", "0.2.3", "0.2.9", "0.3.0", "1.0.0", "1.2.0", "1.2.3", "1.2.9"}},
+		{"1.2 - 2", []string{"1.2.0", "1.2.3", "1.2.9", "1.3.0", "2.0.0", "2.3.4", "2.4.0", "2.5.0"}},
+		{"0.2.9 - 1.2", []string{"0.2.9", "0.3.0", "1.0.0", "1.2.0", "1.2.3", "1.2.9"}},
+		{">*", []string{}},
+		{">=0.3.0 <1.2.0 || 2.4.x", []string{"0.3.0", "1.0.0", "2.4.0"}},
+	}
+
+	for _, c := range cases {
+		r, err := parseNpmRange(c.requirement)
+		if assert.Nil(t, err, c.requirement) {
+			assert.Equal(t, c.matching, matchingVersions(r), c.requirement)
+		}
+	}
+
+	for _, requirement := range []string{"1.2.3.4", "abc", ">=1 <", "^1.2.3 - 2"} {
+		_, err := parseNpmRange(requirement)
+		assert.NotNil(t, err, requirement)
+	}
+}
+
+func TestResolveNodeNpmSemverMode(t *testing.T) {
+	defer func(original func(string) (semver.Range, error)) { parseRange = original }(parseRange)
+	releases := genReleasesFromArray([]string{"12.13.0", "13.0.0-rc.1", "13.0.0"})
+
+	parseRange = rangeParsers["npm"]
+	result, err := resolveNode(releases, "linux-x64", ">=13.0.0-rc.0 <13.0.0")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "13.0.0-rc.1")
+	}
+	result, err = resolveNode(releases, "linux-x64", "<13")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "12.13.0")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmorrell/semver"
+)
+
+// pnpm isn't mirrored in heroku-nodebin, so it is resolved against the npm
+// registry, which NODE_RESOLVE_NPM_REGISTRY can point at a mirror of
+func npmRegistryURL() string {
+	if registry := os.Getenv("NODE_RESOLVE_NPM_REGISTRY"); registry != "" {
+		return strings.TrimSuffix(registry, "/")
+	}
+	return "https://registry.npmjs.org"
+}
+
+// The abbreviated metadata the registry serves to package managers, which
+// leaves out the readme and everything else that isn't needed to install
+type npmPackument struct {
+	Versions map[string]struct {
+		Dist struct {
+			Tarball string `json:"tarball"`
+		} `json:"dist"`
+	} `json:"versions"`
+}
+
+// The published versions of a package in the npm registry. The prefix is
+// used as the name of the binary, like githubSource
+type npmRegistrySource struct {
+	pkg string
+}
+
+func (s npmRegistrySource) List(prefix string) ([]release, error) {
+	defer recordTiming(fmt.Sprintf("listing %s from the npm registry", s.pkg), time.Now())
+
+	url := fmt.Sprintf("%s/%s", npmRegistryURL(), s.pkg)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.npm.install-v1+json; q=1.0, application/json; q=0.8")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Unexpected status code: %d for listing %s from the npm registry", resp.StatusCode, s.pkg)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var packument npmPackument
+	if err := json.Unmarshal(body, &packument); err != nil {
+		return nil, err
+	}
+	return parseNpmPackument(prefix, packument), nil
+}
+
+// Maps the versions of a package into releases. Prereleases are given their
+// own stage, since the registry has no staging area the way the bucket does
+func parseNpmPackument(binary string, packument npmPackument) []release {
+	releases := []release{}
+	for v, metadata := range packument.Versions {
+		version, err := semver.Make(v)
+		if err != nil || metadata.Dist.Tarball == "" {
+			continue
+		}
+		stage := "release"
+		if len(version.Pre) > 0 {
+			stage = "prerelease"
+		}
+		releases = append(releases, release{
+			binary:  binary,
+			stage:   stage,
+			url:     metadata.Dist.Tarball,
+			version: version,
+		})
+	}
+	return releases
+}
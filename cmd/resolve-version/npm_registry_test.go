@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newNpmRegistryServer(t *testing.T) *httptest.Server {
+	fixture, err := ioutil.ReadFile("testdata/pnpm-registry.json")
+	assert.Nil(t, err)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pnpm" || r.Header.Get("Accept") == "" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(fixture)
+	}))
+}
+
+func TestNpmRegistrySource(t *testing.T) {
+	server := newNpmRegistryServer(t)
+	defer server.Close()
+	os.Setenv("NODE_RESOLVE_NPM_REGISTRY", server.URL+"/")
+	defer os.Unsetenv("NODE_RESOLVE_NPM_REGISTRY")
+
+	releases, err := npmRegistrySource{pkg: "pnpm"}.List("pnpm")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, releases, 4)
+
+	cases := []struct {
+		requirement string
+		version     string
+	}{
+		{"latest", "8.15.4"},
+		{"8", "8.15.4"},
+		{"~8.6", "8.6.0"},
+		{">=7 <8", "7.33.7"},
+		{"8.6.0", "8.6.0"},
+		// prereleases are only used when asked for exactly
+		{"9.0.0-alpha.1", "9.0.0-alpha.1"},
+		{"9.x", ""},
+		{"6.x", ""},
+	}
+	for _, c := range cases {
+		result, err := resolvePnpm(releases, normalizeRequirement(c.requirement))
+		if !assert.Nil(t, err, c.requirement) {
+			continue
+		}
+		assert.Equal(t, result.matched, c.version != "", c.requirement)
+		if result.matched {
+			assert.Equal(t, result.release.version.String(), c.version, c.requirement)
+			assert.Equal(t, result.release.url, "https://registry.npmjs.org/pnpm/-/pnpm-"+c.version+".tgz", c.requirement)
+			assert.Equal(t, result.release.binary, "pnpm")
+		}
+	}
+
+	_, err = npmRegistrySource{pkg: "not-a-package"}.List("not-a-package")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Unexpected status code: 404 for listing not-a-package from the npm registry")
+	}
+}
+
+func TestResolvePnpmFallsBackToRegistry(t *testing.T) {
+	server := newNpmRegistryServer(t)
+	defer server.Close()
+	os.Setenv("NODE_RESOLVE_NPM_REGISTRY", server.URL)
+	defer os.Unsetenv("NODE_RESOLVE_NPM_REGISTRY")
+
+	// a mirrored release in the bucket is preferred
+	mirrored, err := parseObject("pnpm/release/pnpm-v8.6.0.tar.gz")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, mirrored.binary, "pnpm")
+	assert.Equal(t, mirrored.url, "https://s3.amazonaws.com/heroku-nodebin/pnpm/release/pnpm-v8.6.0.tar.gz")
+
+	sources := []source{staticSource{releases: []release{mirrored}}, npmRegistrySource{pkg: "pnpm"}}
+	result, err := resolveFromSources(sources, "pnpm", "8.6.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.url, mirrored.url)
+	}
+
+	result, err = resolveFromSources(sources, "pnpm", "8.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "8.6.0")
+	}
+
+	result, err = resolveFromSources(sources, "pnpm", ">8.6.0")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.url, "https://registry.npmjs.org/pnpm/-/pnpm-8.15.4.tgz")
+	}
+}
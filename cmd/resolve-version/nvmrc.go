@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// An LTS line of node, like hydrogen for 18.x
+type ltsLine struct {
+	codename string
+	major    int
+}
+
+// The codename of each LTS line of node, oldest first. S3 keys don't say which
+// releases are LTS, so aliases are resolved through this table, and through
+// the nodejs.org release index for lines newer than it, see nodeLTSLines
+var nodeLTSCodenames = []ltsLine{
+	{codename: "argon", major: 4},
+	{codename: "boron", major: 6},
+	{codename: "carbon", major: 8},
+	{codename: "dubnium", major: 10},
+	{codename: "erbium", major: 12},
+	{codename: "fermium", major: 14},
+	{codename: "gallium", major: 16},
+	{codename: "hydrogen", major: 18},
+	{codename: "iron", major: 20},
+	{codename: "jod", major: 22},
+	{codename: "krypton", major: 24},
+}
+
+// Returns nodeLTSCodenames along with any newer lines that nodejs.org lists
+// in its release index, oldest first. When the index can't be fetched only
+// the table is used
+func nodeLTSLines() []ltsLine {
+	lines := append([]ltsLine{}, nodeLTSCodenames...)
+	index, err := fetchNodejsOrgIndex(nodeDistURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not list the LTS lines on nodejs.org, using the built-in ones: %s\n", err)
+		return lines
+	}
+
+	known := map[string]bool{}
+	for _, lts := range lines {
+		known[lts.codename] = true
+	}
+	for _, entry := range index {
+		codename, _ := entry.LTS.(string)
+		codename = strings.ToLower(codename)
+		if codename == "" || known[codename] {
+			continue
+		}
+		version, err := semver.ParseTolerant(entry.Version)
+		if err != nil {
+			continue
+		}
+		known[codename] = true
+		lines = append(lines, ltsLine{codename: codename, major: int(version.Major)})
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].major < lines[j].major })
+	return lines
+}
+
+// Finds the line an LTS codename names. The table is checked first so that
+// the lines it knows about resolve without a request
+func findLTSLine(codename string) (ltsLine, bool) {
+	for _, lts := range nodeLTSCodenames {
+		if lts.codename == codename {
+			return lts, true
+		}
+	}
+	for _, lts := range nodeLTSLines() {
+		if lts.codename == codename {
+			return lts, true
+		}
+	}
+	return ltsLine{}, false
+}
+
+// Reads the version requirement from an .nvmrc file. This is the first line
+// that isn't blank or a # comment
+func readNvmrc(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Could not read .nvmrc: %s", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return normalizeNvmrc(line)
+	}
+	return "", errors.New("Could not parse .nvmrc: no version found")
+}
+
+// Turns what nvm accepts into a version requirement. A bare version or a
+// range is used as it is, while aliases are resolved to the line they name:
+//
+//	v18.17.1      18.17.1
+//	>=18 <20      >=18 <20
+//	lts/hydrogen  18.x
+//	lts/*         the newest LTS line, as listed on nodejs.org
+//	node          the newest release
+func normalizeNvmrc(value string) (string, error) {
+	value = strings.ToLower(value)
+
+	switch value {
+	case "node", "stable", "latest":
+		return "*", nil
+	}
+
+	if strings.HasPrefix(value, "lts/") {
+		codename := strings.TrimPrefix(value, "lts/")
+		if codename == "*" {
+			lines := nodeLTSLines()
+			return fmt.Sprintf("%d.x", lines[len(lines)-1].major), nil
+		}
+		if lts, ok := findLTSLine(codename); ok {
+			return fmt.Sprintf("%d.x", lts.major), nil
+		}
+		return "", fmt.Errorf("Could not parse .nvmrc: unknown LTS alias %s", value)
+	}
+
+	// nvm versions are usually written with a leading v, which exact version
+	// matching doesn't accept
+	if len(value) > 1 && value[0] == 'v' && value[1] >= '0' && value[1] <= '9' {
+		value = value[1:]
+	}
+	return value, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves a release index with one LTS line newer than nodeLTSCodenames
+func newLTSIndexServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+  {"version": "v26.1.0", "date": "2026-10-28", "files": ["linux-x64"], "npm": "11.6.0", "lts": "Future"},
+  {"version": "v25.1.0", "date": "2026-10-20", "files": ["linux-x64"], "npm": "11.6.0", "lts": false},
+  {"version": "v24.11.0", "date": "2026-10-08", "files": ["linux-x64"], "npm": "11.6.0", "lts": "Krypton"}
+]`)
+	}))
+}
+
+func TestReadNvmrc(t *testing.T) {
+	server := newLTSIndexServer()
+	defer server.Close()
+	defer func(original string) { nodeDistURL = original }(nodeDistURL)
+	nodeDistURL = server.URL
+
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".nvmrc")
+
+	cases := []struct {
+		contents    string
+		requirement string
+	}{
+		{"18.17.1\n", "18.17.1"},
+		{"v18.17.1\n", "18.17.1"},
+		{"18\n", "18"},
+		{"v18\r\n", "18"},
+		{">=18 <20\n", ">=18 <20"},
+		{"lts/hydrogen\n", "18.x"},
+		{"lts/Gallium", "16.x"},
+		{"lts/krypton\n", "24.x"},
+		{"lts/Future\n", "26.x"},
+		{"lts/*\n", "26.x"},
+		{"node\n", "*"},
+		{"# pinned for native modules\n\n  20.x  \n", "20.x"},
+	}
+	for _, c := range cases {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(c.contents), 0644))
+		requirement, err := readNvmrc(path)
+		if assert.Nil(t, err, c.contents) {
+			assert.Equal(t, requirement, c.requirement, c.contents)
+		}
+	}
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("lts/unknown\n"), 0644))
+	_, err = readNvmrc(path)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not parse .nvmrc: unknown LTS alias lts/unknown")
+	}
+
+	// without the index only the built-in lines are known
+	server.Close()
+	assert.Nil(t, ioutil.WriteFile(path, []byte("lts/*\n"), 0644))
+	requirement, err := readNvmrc(path)
+	if assert.Nil(t, err) {
+		assert.Equal(t, requirement, "24.x")
+	}
+	assert.Nil(t, ioutil.WriteFile(path, []byte("lts/hydrogen\n"), 0644))
+	requirement, err = readNvmrc(path)
+	if assert.Nil(t, err) {
+		assert.Equal(t, requirement, "18.x")
+	}
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("# nothing here\n\n"), 0644))
+	_, err = readNvmrc(path)
+	assert.NotNil(t, err)
+
+	_, err = readNvmrc(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
+
+func TestResolveNvmrcRequirement(t *testing.T) {
+	releases := genReleasesFromArray([]string{"16.20.2", "18.17.0", "18.19.1", "20.10.0"})
+
+	for requirement, version := range map[string]string{"lts/hydrogen": "18.19.1", "v18.17.0": "18.17.0", "node": "20.10.0"} {
+		normalized, err := normalizeNvmrc(requirement)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		result, err := resolveNode(releases, "linux-x64", normalized)
+		if assert.Nil(t, err) && assert.True(t, result.matched, requirement) {
+			assert.Equal(t, result.release.version.String(), version, requirement)
+		}
+	}
+}
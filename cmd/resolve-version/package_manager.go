@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// The package managers that Corepack pins with packageManager, and that can be
+// resolved here
+var packageManagerBinaries = map[string]bool{
+	"yarn": true,
+	"pnpm": true,
+	"npm":  true,
+}
+
+// The package manager pinned by the packageManager field of a package.json,
+// like "yarn@3.6.0+sha224.953c8233f7a92884eee2de69a1b92d1f2ec1655e66d08071ba9a02fa"
+type packageManagerSpec struct {
+	binary  string
+	version string
+	// the hash after the +, like sha224.953c..., which Corepack checks the
+	// download against. It isn't part of the version
+	integrity string
+}
+
+// Reads the packageManager field of the package.json at path
+func readPackageManager(path string) (packageManagerSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return packageManagerSpec{}, fmt.Errorf("Could not read %s: %s", path, err)
+	}
+	var pkg struct {
+		PackageManager string `json:"packageManager"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return packageManagerSpec{}, fmt.Errorf("Could not parse %s: %s", path, err)
+	}
+	if pkg.PackageManager == "" {
+		return packageManagerSpec{}, fmt.Errorf("No packageManager field in %s", path)
+	}
+	return parsePackageManager(path, pkg.PackageManager)
+}
+
+// Parses NAME@VERSION or NAME@VERSION+HASH. Corepack only accepts exact
+// versions, so ranges are rejected here too rather than resolved
+func parsePackageManager(path string, value string) (packageManagerSpec, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "@", 2)
+	if len(parts) != 2 || !packageManagerBinaries[parts[0]] {
+		return packageManagerSpec{}, fmt.Errorf("Unsupported packageManager in %s: %s, expected yarn, pnpm, or npm", path, value)
+	}
+
+	spec := packageManagerSpec{binary: parts[0], version: parts[1]}
+	if i := strings.Index(spec.version, "+"); i >= 0 {
+		spec.version, spec.integrity = spec.version[:i], spec.version[i+1:]
+		if spec.integrity == "" {
+			return packageManagerSpec{}, fmt.Errorf("Invalid packageManager in %s: %s, expected a hash after the +", path, value)
+		}
+	}
+	if _, err := semver.Make(spec.version); err != nil {
+		return packageManagerSpec{}, fmt.Errorf("packageManager in %s must be an exact version, like yarn@3.6.0, got %s", path, value)
+	}
+	return spec, nil
+}
+
+// Resolves the package manager that --from-package-manager's package.json pins
+func resolvePackageManager(path string, opts options) error {
+	spec, err := readPackageManager(path)
+	if err != nil {
+		return err
+	}
+	if opts.withIntegrity {
+		opts.integrity = spec.integrity
+	}
+	return resolve(spec.binary, spec.version, opts)
+}
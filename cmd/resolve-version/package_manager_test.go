@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePackageManager(t *testing.T) {
+	cases := []struct {
+		value string
+		spec  packageManagerSpec
+	}{
+		{"yarn@3.6.0", packageManagerSpec{binary: "yarn", version: "3.6.0"}},
+		{"pnpm@8.0.0", packageManagerSpec{binary: "pnpm", version: "8.0.0"}},
+		{"npm@10.8.2", packageManagerSpec{binary: "npm", version: "10.8.2"}},
+		{"yarn@4.0.0-rc.42", packageManagerSpec{binary: "yarn", version: "4.0.0-rc.42"}},
+		{
+			"yarn@3.6.0+sha224.953c8233f7a92884eee2de69a1b92d1f2ec1655e66d08071ba9a02fa",
+			packageManagerSpec{binary: "yarn", version: "3.6.0", integrity: "sha224.953c8233f7a92884eee2de69a1b92d1f2ec1655e66d08071ba9a02fa"},
+		},
+	}
+	for _, c := range cases {
+		spec, err := parsePackageManager("package.json", c.value)
+		if assert.Nil(t, err, c.value) {
+			assert.Equal(t, spec, c.spec, c.value)
+		}
+	}
+
+	errors := map[string]string{
+		"bun@1.1.0":   "Unsupported packageManager in package.json: bun@1.1.0, expected yarn, pnpm, or npm",
+		"yarn":        "Unsupported packageManager in package.json: yarn, expected yarn, pnpm, or npm",
+		"yarn@3.6.0+": "Invalid packageManager in package.json: yarn@3.6.0+, expected a hash after the +",
+		"yarn@^3.6.0": "packageManager in package.json must be an exact version, like yarn@3.6.0, got yarn@^3.6.0",
+		"pnpm@8":      "packageManager in package.json must be an exact version, like yarn@3.6.0, got pnpm@8",
+	}
+	for value, want := range errors {
+		_, err := parsePackageManager("package.json", value)
+		assert.EqualError(t, err, want, value)
+	}
+}
+
+func TestReadPackageManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "package-manager")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "package.json")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"name": "app", "packageManager": "pnpm@8.6.0"}`), 0644))
+	spec, err := readPackageManager(path)
+	if assert.Nil(t, err) {
+		assert.Equal(t, spec, packageManagerSpec{binary: "pnpm", version: "8.6.0"})
+	}
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"name": "app"}`), 0644))
+	_, err = readPackageManager(path)
+	assert.EqualError(t, err, "No packageManager field in "+path)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"packageManager": `), 0644))
+	_, err = readPackageManager(path)
+	assert.EqualError(t, err, "Could not parse "+path+": unexpected end of JSON input")
+}
+
+func TestResolvePackageManager(t *testing.T) {
+	pnpm, err := ioutil.ReadFile("testdata/pnpm-registry.json")
+	if !assert.Nil(t, err) {
+		return
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pnpm":
+			w.Write(pnpm)
+		case "/heroku-nodebin":
+			// pnpm is looked for in the bucket before the registry
+			w.Write([]byte(`<ListBucketResult><Name>heroku-nodebin</Name><KeyCount>0</KeyCount><IsTruncated>false</IsTruncated></ListBucketResult>`))
+		case "/npm":
+			w.Write([]byte(`{"versions": {"10.8.2": {"dist": {"tarball": "https://registry.npmjs.org/npm/-/npm-10.8.2.tgz"}}}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+	os.Setenv("NODE_RESOLVE_NPM_REGISTRY", server.URL)
+	defer os.Unsetenv("NODE_RESOLVE_NPM_REGISTRY")
+	defer func(original *s3CompatibleEndpoint) { binariesEndpoint = original }(binariesEndpoint)
+	os.Setenv("NODE_BINARIES_ENDPOINT", server.URL)
+	defer os.Unsetenv("NODE_BINARIES_ENDPOINT")
+	binariesEndpoint, err = binariesEndpointFromEnv()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "package-manager")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "package.json")
+	out := filepath.Join(dir, "out")
+
+	cases := []struct {
+		packageManager string
+		opts           options
+		output         string
+	}{
+		{"npm@10.8.2", options{}, "10.8.2 https://registry.npmjs.org/npm/-/npm-10.8.2.tgz\n"},
+		// the hash isn't part of the version that's resolved, and is only
+		// printed when it's asked for
+		{"pnpm@8.6.0+sha512.abc123", options{}, "8.6.0 https://registry.npmjs.org/pnpm/-/pnpm-8.6.0.tgz\n"},
+		{"pnpm@8.6.0+sha512.abc123", options{withIntegrity: true}, "8.6.0 https://registry.npmjs.org/pnpm/-/pnpm-8.6.0.tgz\nintegrity=sha512.abc123\n"},
+		{"pnpm@8.6.0+sha512.abc123", options{withIntegrity: true, env: true}, "PNPM_VERSION='8.6.0'\nPNPM_URL='https://registry.npmjs.org/pnpm/-/pnpm-8.6.0.tgz'\nPNPM_INTEGRITY='sha512.abc123'\n"},
+		{"pnpm@8.6.0+sha512.abc123", options{withIntegrity: true, json: true}, `{
+  "version": "8.6.0",
+  "url": "https://registry.npmjs.org/pnpm/-/pnpm-8.6.0.tgz",
+  "integrity": "sha512.abc123"
+}
+`},
+		{"pnpm@8.6.0+sha512.abc123", options{withIntegrity: true, printURLOnly: true}, "https://registry.npmjs.org/pnpm/-/pnpm-8.6.0.tgz\n"},
+	}
+	for _, c := range cases {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(`{"packageManager": "`+c.packageManager+`"}`), 0644))
+		c.opts.outputFile = out
+		if !assert.Nil(t, resolvePackageManager(path, c.opts), c.packageManager) {
+			continue
+		}
+		data, err := ioutil.ReadFile(out)
+		if assert.Nil(t, err) {
+			assert.Equal(t, string(data), c.output, c.packageManager)
+		}
+	}
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"packageManager": "npm@1.0.0"}`), 0644))
+	assert.EqualError(t, resolvePackageManager(path, options{outputFile: out}), "No result")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmorrell/semver"
+)
+
+// The exit code of --check-update for the most significant update available.
+// The pin being up to date exits 0, like any other success, and 1 is left to
+// mean the check itself failed
+var pinUpdateExitCodes = map[string]int{
+	"none":       0,
+	"prerelease": 2,
+	"patch":      2,
+	"minor":      3,
+	"major":      4,
+}
+
+type pinCheckEntry struct {
+	Binary  string `json:"binary"`
+	Current string `json:"current"`
+	// the newest release in the same major as the pin, or "" if there is none
+	LatestInMajor string `json:"latestInMajor,omitempty"`
+	Latest        string `json:"latest"`
+	// major, minor, patch, or prerelease for the most significant newer
+	// release, or none when the pin is the newest
+	Update string `json:"update"`
+}
+
+// Compares a pinned version with the newest release in its major and the
+// newest release overall, from the same sorted candidates --candidates-json
+// lists. Builds that wouldn't be resolved by a range, like staging builds
+// and prereleases, aren't counted as updates
+func checkPin(sources []source, binary string, pinned string, platform string) (pinCheckEntry, error) {
+	current, err := semver.ParseTolerant(pinned)
+	if err != nil {
+		return pinCheckEntry{}, fmt.Errorf("--check-update expects a version, like 18.17.0, got %s", pinned)
+	}
+
+	candidates, err := listCandidates(binary, sources, platform)
+	if err != nil {
+		return pinCheckEntry{}, err
+	}
+
+	var latest, latestInMajor *semver.Version
+	for i, rel := range publishedAsOf(candidates) {
+		if stageRank(rel.stage) == len(stagePreference) || len(rel.version.Pre) > 0 {
+			continue
+		}
+		// candidates are sorted oldest first
+		latest = &candidates[i].version
+		if rel.version.Major == current.Major {
+			latestInMajor = &candidates[i].version
+		}
+	}
+	if latest == nil {
+		return pinCheckEntry{}, fmt.Errorf("No releases of %s were found", binary)
+	}
+
+	entry := pinCheckEntry{Binary: binary, Current: current.String(), Latest: latest.String(), Update: "none"}
+	if latestInMajor != nil {
+		entry.LatestInMajor = latestInMajor.String()
+	}
+	if latest.GT(current) {
+		entry.Update = versionJump(current, *latest)
+	}
+	return entry, nil
+}
+
+// Checks whether a newer release than the pin is available, and returns the
+// exit code for the answer
+func checkPinUpdate(binary string, pinned string, opts options) (int, error) {
+	sources := sourcesFor(binary, opts.source)
+	if len(sources) == 0 {
+		return 1, fmt.Errorf("Unknown binary: %s", binary)
+	}
+
+	entry, err := checkPin(sources, binary, pinned, getPlatform())
+	if err != nil {
+		return 1, err
+	}
+	if err := printPinCheck(entry, opts); err != nil {
+		return 1, err
+	}
+	return pinUpdateExitCodes[entry.Update], nil
+}
+
+func printPinCheck(entry pinCheckEntry, opts options) error {
+	if opts.json {
+		data, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeOutput(append(data, '\n'), opts)
+	}
+
+	out := ""
+	if entry.LatestInMajor != "" {
+		major := semver.MustParse(entry.LatestInMajor).Major
+		out += fmt.Sprintf("The newest %s %d.x is %s\n", entry.Binary, major, entry.LatestInMajor)
+	}
+	out += fmt.Sprintf("The newest %s is %s\n", entry.Binary, entry.Latest)
+	if entry.Update == "none" {
+		out += fmt.Sprintf("%s %s is up to date\n", entry.Binary, entry.Current)
+	} else {
+		out += fmt.Sprintf("A %s update is available for %s %s\n", entry.Update, entry.Binary, entry.Current)
+	}
+	return writeOutput([]byte(out), opts)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPin(t *testing.T) {
+	releases := genReleasesFromArray([]string{"18.17.0", "18.17.1", "18.19.1", "20.11.0", "22.3.0", "23.0.0-rc.1"})
+	releases = append(releases, release{binary: "node", stage: "staging", platform: "linux-x64", version: semver.MustParse("22.4.0")})
+	src := staticSource{releases: releases}
+
+	cases := []struct {
+		pinned        string
+		latestInMajor string
+		update        string
+	}{
+		{"18.17.0", "18.19.1", "major"},
+		{"22.2.0", "22.3.0", "minor"},
+		{"22.3.0", "22.3.0", "none"},
+		{"v22.3.0", "22.3.0", "none"},
+		// a pin newer than anything published has nothing to update to
+		{"24.0.0", "", "none"},
+		{"17.0.0", "", "major"},
+	}
+	for _, c := range cases {
+		entry, err := checkPin([]source{src}, "node", c.pinned, "linux-x64")
+		if assert.Nil(t, err, c.pinned) {
+			assert.Equal(t, entry.LatestInMajor, c.latestInMajor, c.pinned)
+			assert.Equal(t, entry.Latest, "22.3.0", c.pinned)
+			assert.Equal(t, entry.Update, c.update, c.pinned)
+		}
+	}
+
+	entry, err := checkPin([]source{staticSource{releases: releases[:3]}}, "node", "18.17.0", "linux-x64")
+	if assert.Nil(t, err) {
+		assert.Equal(t, entry.Update, "minor")
+		assert.Equal(t, pinUpdateExitCodes[entry.Update], 3)
+	}
+	entry, err = checkPin([]source{staticSource{releases: releases[:2]}}, "node", "18.17.0", "linux-x64")
+	if assert.Nil(t, err) {
+		assert.Equal(t, entry.Update, "patch")
+		assert.Equal(t, pinUpdateExitCodes[entry.Update], 2)
+	}
+
+	_, err = checkPin([]source{src}, "node", "18.x", "linux-x64")
+	assert.EqualError(t, err, "--check-update expects a version, like 18.17.0, got 18.x")
+	_, err = checkPin([]source{src}, "node", "18.17.0", "darwin-arm64")
+	assert.EqualError(t, err, "No releases of node were found")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The platform given to --platform, which replaces the platform of the
+// machine resolve-version runs on
+var platformOverride string
+
+// Splits a comma separated --platform value
+func parsePlatforms(value string) []string {
+	platforms := []string{}
+	for _, platform := range strings.Split(value, ",") {
+		if platform = strings.TrimSpace(platform); platform != "" {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+type platformEntry struct {
+	Platform string `json:"platform"`
+	Version  string `json:"version"`
+	URL      string `json:"url"`
+}
+
+// Resolves node for several platforms at once, for artifacts that bundle a
+// build for each of them. The version is resolved for the first platform and
+// then the same build of it is looked up for the others, so every platform
+// gets exactly the same version. NODE_MAX_VERSION applies as it does to a
+// single platform
+func resolveAcrossPlatforms(sources []source, versionRequirement string, platforms []string) ([]platformEntry, error) {
+	var cappedMatch *release
+	for _, src := range sources {
+		releases, err := src.List("node")
+		if err != nil {
+			return nil, err
+		}
+		releases, capped := capReleases(publishedAsOf(releases))
+
+		result, err := resolveNode(releases, platforms[0], versionRequirement)
+		if err != nil {
+			return nil, err
+		}
+		if !result.matched {
+			if newest, ok := newestCappedMatch(capped, versionRequirement); ok && (cappedMatch == nil || versionLess(cappedMatch.version, newest.version)) {
+				cappedMatch = &newest
+			}
+			continue
+		}
+
+		entries := []platformEntry{}
+		missing := []string{}
+		for _, platform := range platforms {
+			rel, ok := findPlatformBuild(releases, result.release, platform)
+			if !ok {
+				missing = append(missing, platform)
+				continue
+			}
+			entries = append(entries, platformEntry{Platform: platform, Version: rel.version.String(), URL: rel.url})
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("node %s is not available for %s", result.release.version.String(), strings.Join(missing, ", "))
+		}
+		return entries, nil
+	}
+	if cappedMatch != nil {
+		return nil, fmt.Errorf("No result, %s", cappedMessage("node", versionRequirement, *cappedMatch))
+	}
+	return nil, errors.New("No result")
+}
+
+// Finds the build of a release for another platform, from the same stage and
+// with the same qualifier
+func findPlatformBuild(releases []release, resolved release, platform string) (release, bool) {
+	for _, rel := range releases {
+		if rel.platform == platform && rel.stage == resolved.stage && rel.qualifier == resolved.qualifier && rel.version.Equals(resolved.version) {
+			return rel, true
+		}
+	}
+	return release{}, false
+}
+
+func printPlatformEntries(entries []platformEntry, opts options) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutput(append(data, '\n'), opts)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	assert.Equal(t, parsePlatforms(""), []string{})
+	assert.Equal(t, parsePlatforms("linux-x64"), []string{"linux-x64"})
+	assert.Equal(t, parsePlatforms("linux-x64, linux-arm64,"), []string{"linux-x64", "linux-arm64"})
+}
+
+func TestResolveAcrossPlatforms(t *testing.T) {
+	objects := append(
+		genNodeS3ObjectList([]string{"18.19.0", "18.19.1"}, []string{}, "linux-x64"),
+		genNodeS3ObjectList([]string{"18.19.0", "18.19.1"}, []string{}, "linux-arm64")...,
+	)
+	objects = append(objects, genNodeS3ObjectList([]string{"18.19.0"}, []string{}, "darwin-arm64")...)
+	sources := []source{staticSource{releases: parseObjects(objects)}}
+
+	entries, err := resolveAcrossPlatforms(sources, "18.x", []string{"linux-x64", "linux-arm64"})
+	assert.Nil(t, err)
+	assert.Equal(t, entries, []platformEntry{
+		{Platform: "linux-x64", Version: "18.19.1", URL: "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.19.1-linux-x64.tar.gz"},
+		{Platform: "linux-arm64", Version: "18.19.1", URL: "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-arm64/node-v18.19.1-linux-arm64.tar.gz"},
+	})
+
+	// the version is resolved once, so a platform that lacks it is an error
+	// rather than getting an older version
+	_, err = resolveAcrossPlatforms(sources, "18.x", []string{"linux-x64", "darwin-arm64", "darwin-x64"})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "node 18.19.1 is not available for darwin-arm64, darwin-x64")
+	}
+
+	_, err = resolveAcrossPlatforms(sources, "20.x", []string{"linux-x64", "linux-arm64"})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "No result")
+	}
+}
+
+func TestResolveAcrossPlatformsCapped(t *testing.T) {
+	defer func(original string) { maxNodeVersion = original }(maxNodeVersion)
+	defer func(original semver.Range) { belowMaxNodeVersion = original }(belowMaxNodeVersion)
+	defer os.Unsetenv("NODE_MAX_VERSION")
+	objects := append(
+		genNodeS3ObjectList([]string{"18.19.0", "20.11.1"}, []string{}, "linux-x64"),
+		genNodeS3ObjectList([]string{"18.19.0", "20.11.1"}, []string{}, "darwin-x64")...,
+	)
+	sources := []source{staticSource{releases: parseObjects(objects)}}
+
+	os.Setenv("NODE_MAX_VERSION", "18")
+	var err error
+	maxNodeVersion, belowMaxNodeVersion, err = maxNodeVersionFromEnv()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// every platform gets the newest release under the cap
+	entries, err := resolveAcrossPlatforms(sources, "*", []string{"linux-x64", "darwin-x64"})
+	if assert.Nil(t, err) && assert.Len(t, entries, 2) {
+		assert.Equal(t, entries[0].Version, "18.19.0")
+		assert.Equal(t, entries[1].Version, "18.19.0")
+	}
+
+	// and a requirement that's only above it says so
+	_, err = resolveAcrossPlatforms(sources, ">=20", []string{"linux-x64", "darwin-x64"})
+	assert.EqualError(t, err, "No result, node >=20 only matches versions above NODE_MAX_VERSION=18, like 20.11.1")
+}
+
+func TestPrintPlatformEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	assert.Nil(t, printPlatformEntries([]platformEntry{
+		{Platform: "linux-x64", Version: "18.19.1", URL: "https://heroku.com/x64"},
+		{Platform: "linux-arm64", Version: "18.19.1", URL: "https://heroku.com/arm64"},
+	}, options{outputFile: path}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(contents), `[
+  {
+    "platform": "linux-x64",
+    "version": "18.19.1",
+    "url": "https://heroku.com/x64"
+  },
+  {
+    "platform": "linux-arm64",
+    "version": "18.19.1",
+    "url": "https://heroku.com/arm64"
+  }
+]
+`)
+}
+
+func TestPlatformOverride(t *testing.T) {
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-arm64"
+	assert.Equal(t, getPlatform(), "linux-arm64")
+}
@@ -0,0 +1,39 @@
+package main
+
+import "github.com/jmorrell/semver"
+
+// How the versions that matched a requirement are ordered when picking the
+// newest one, chosen with --prerelease-order:
+//
+//	semver         prereleases come before their release, as semver orders
+//	               them, so 18.0.0-rc.1 < 18.0.0 < 18.0.1-rc.1
+//	after-release  prereleases come after their release and before the next
+//	               patch, so 18.0.0 < 18.0.0-rc.1 < 18.0.1, for mirrors that
+//	               tag patched builds of a release as prereleases of it
+//
+// Either way prereleases of the same version are in semver order, and which
+// versions match the requirement isn't changed
+var prereleaseOrders = map[string]func(a semver.Version, b semver.Version) bool{
+	"semver":        semverLess,
+	"after-release": prereleaseAfterReleaseLess,
+}
+
+// The order used when matching releases
+var versionLess = semverLess
+
+func semverLess(a semver.Version, b semver.Version) bool {
+	return a.LT(b)
+}
+
+func prereleaseAfterReleaseLess(a semver.Version, b semver.Version) bool {
+	base := func(v semver.Version) semver.Version {
+		return semver.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	}
+	if c := base(a).Compare(base(b)); c != 0 {
+		return c < 0
+	}
+	if len(a.Pre) == 0 || len(b.Pre) == 0 {
+		return len(a.Pre) == 0 && len(b.Pre) > 0
+	}
+	return a.LT(b)
+}
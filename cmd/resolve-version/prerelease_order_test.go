@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrereleaseOrder(t *testing.T) {
+	defer func(original func(semver.Version, semver.Version) bool) { versionLess = original }(versionLess)
+
+	releases := genReleasesFromArray([]string{"17.9.1", "18.0.0-patch.1", "18.0.0-patch.2", "18.0.0", "18.1.0-rc.1"})
+	cases := []struct {
+		order       string
+		requirement string
+		version     string
+	}{
+		{"semver", ">=17", "18.1.0-rc.1"},
+		{"semver", ">=17 <18.1.0-rc.0", "18.0.0"},
+		{"after-release", ">=17 <18.1.0-rc.0", "18.0.0-patch.2"},
+		// a prerelease still comes before the next version's release
+		{"after-release", ">=17", "18.1.0-rc.1"},
+		// and nothing changes when only one side matches
+		{"after-release", "<18.0.0", "18.0.0-patch.2"},
+		{"semver", "<18.0.0", "18.0.0-patch.2"},
+	}
+	for _, c := range cases {
+		versionLess = prereleaseOrders[c.order]
+		result, err := matchReleaseSemver(releases, c.requirement)
+		if assert.Nil(t, err) && assert.True(t, result.matched, c.requirement) {
+			assert.Equal(t, result.release.version.String(), c.version, c.order+" "+c.requirement)
+		}
+	}
+
+	less := prereleaseAfterReleaseLess
+	assert.True(t, less(semver.MustParse("18.0.0"), semver.MustParse("18.0.0-rc.1")))
+	assert.True(t, less(semver.MustParse("18.0.0-rc.1"), semver.MustParse("18.0.0-rc.2")))
+	assert.True(t, less(semver.MustParse("18.0.0-rc.1"), semver.MustParse("18.0.1")))
+	assert.False(t, less(semver.MustParse("18.0.0"), semver.MustParse("18.0.0")))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmorrell/semver"
+)
+
+// How many releases are verified at once. The shared client's circuit breaker
+// and connection limits still apply across all of these
+const prewarmConcurrency = 4
+
+// Lists every release of a major version line, which populates the listing
+// cache, and with --verify checks that each of their tarballs can be fetched
+func prewarm(binary string, major string, opts options) error {
+	if _, err := strconv.ParseUint(major, 10, 64); err != nil {
+		return fmt.Errorf("Could not parse major version: %s", major)
+	}
+	sources := sourcesFor(binary, opts.source)
+	if len(sources) == 0 {
+		return fmt.Errorf("Unknown binary: %s", binary)
+	}
+
+	start := time.Now()
+	releases, err := prewarmReleases(sources, binary, major, getPlatform())
+	if err != nil {
+		return err
+	}
+	if opts.verify {
+		if err := verifyReleases(releases); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Prewarmed %d %s %s.x releases in %s\n", len(releases), binary, major, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Returns every release in a major version line, for the platform when the
+// binary is platform specific
+func prewarmReleases(sources []source, binary string, major string, platform string) ([]release, error) {
+	inMajor, err := semver.ParseRange(major + ".x")
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []release{}
+	for _, src := range sources {
+		all, err := src.List(binary)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range all {
+			if rel.stage != "release" || (rel.platform != "" && rel.platform != platform) {
+				continue
+			}
+			if inMajor(rel.version) {
+				releases = append(releases, rel)
+			}
+		}
+	}
+	return releases, nil
+}
+
+// Sends a HEAD request for each release's tarball, a few at a time, and
+// reports every one that couldn't be fetched
+func verifyReleases(releases []release) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := []string{}
+
+	slots := make(chan struct{}, prewarmConcurrency)
+	for _, rel := range releases {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(rel release) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := headURL(rel.url); err != nil {
+				mu.Lock()
+				failed = append(failed, fmt.Sprintf("%s (%s)", rel.version.String(), err))
+				mu.Unlock()
+			}
+		}(rel)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Could not verify %d releases: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func headURL(url string) error {
+	resp, err := httpClient.Head(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrewarmReleasesPopulatesCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(original *diskCache) { listingCache = original }(listingCache)
+	listingCache = &diskCache{dir: dir, ttl: time.Hour}
+
+	keys := []string{
+		"node/release/linux-x64/node-v16.20.2-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v18.0.0-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v18.19.1-linux-x64.tar.gz",
+		"node/release/darwin-x64/node-v18.19.1-darwin-x64.tar.gz",
+		"node/staging/linux-x64/node-v18.20.0-linux-x64.tar.gz",
+	}
+	server := httptest.NewServer(s3ListingHandler(keys, 2))
+	defer server.Close()
+
+	src := s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}
+	releases, err := prewarmReleases([]source{src}, "node", "18", "linux-x64")
+	if !assert.Nil(t, err) {
+		return
+	}
+	versions := []string{}
+	for _, rel := range releases {
+		versions = append(versions, rel.version.String())
+	}
+	assert.Equal(t, versions, []string{"18.0.0", "18.17.0", "18.19.1"})
+
+	// every matching release can now be resolved from the cache alone
+	cached, ok := listingCache.load(src.listEndpoints(), "heroku-nodebin", "node")
+	if assert.True(t, ok) {
+		for _, version := range versions {
+			result, err := resolveNode(parseObjects(cached), "linux-x64", version)
+			assert.Nil(t, err)
+			assert.True(t, result.matched, version)
+		}
+	}
+}
+
+func TestVerifyReleases(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, r.Method, http.MethodHead)
+		if r.URL.Path == "/missing.tar.gz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	releases := []release{}
+	for i := 0; i < 12; i++ {
+		releases = append(releases, release{version: semver.MustParse("18.0.0"), url: server.URL + "/node.tar.gz"})
+	}
+	assert.Nil(t, verifyReleases(releases))
+	assert.True(t, maxInFlight <= prewarmConcurrency)
+
+	releases = append(releases, release{version: semver.MustParse("18.1.0"), url: server.URL + "/missing.tar.gz"})
+	err := verifyReleases(releases)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not verify 1 releases: 18.1.0 (Unexpected status code: 404)")
+	}
+}
+
+func TestPrewarmInvalidMajor(t *testing.T) {
+	err := prewarm("node", "18.x", options{})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not parse major version: 18.x")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Where the progress of a listing is drawn, or nil when it is hidden
+var progressOut io.Writer
+
+// Whether a progress line is on screen and needs erasing
+var progressDrawn bool
+
+// Progress is only shown to someone watching a terminal, and not when there
+// is other output on stderr that it would get tangled up with
+func progressWriter(quiet bool, stdout *os.File, stderr *os.File) io.Writer {
+	if quiet || !isTerminal(stdout) || !isTerminal(stderr) {
+		return nil
+	}
+	return stderr
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Redraws the progress line for a listing in place
+func reportProgress(prefix string, pages int, objects int) {
+	if progressOut == nil {
+		return
+	}
+	fmt.Fprintf(progressOut, "\rListing %s: %d pages, %d objects", prefix, pages, objects)
+	progressDrawn = true
+}
+
+// Erases the progress line, leaving the cursor where it started
+func clearProgress() {
+	if progressOut == nil || !progressDrawn {
+		return
+	}
+	fmt.Fprint(progressOut, "\r\033[K")
+	progressDrawn = false
+}
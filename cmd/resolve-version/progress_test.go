@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressHiddenWithoutTerminal(t *testing.T) {
+	file, err := ioutil.TempFile("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	reader, writer, err := os.Pipe()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	assert.False(t, isTerminal(file))
+	assert.False(t, isTerminal(writer))
+	assert.Nil(t, progressWriter(false, file, writer))
+	assert.Nil(t, progressWriter(false, writer, file))
+	assert.Nil(t, progressWriter(true, file, file))
+}
+
+func TestProgressIsErased(t *testing.T) {
+	server := httptest.NewServer(s3ListingHandler(genNodeKeys(25), 10))
+	defer server.Close()
+
+	var out bytes.Buffer
+	defer func(original io.Writer) { progressOut = original }(progressOut)
+	progressOut = &out
+
+	_, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Equal(t, out.String(), "\rListing node: 1 pages, 10 objects"+
+		"\rListing node: 2 pages, 20 objects"+
+		"\rListing node: 3 pages, 25 objects"+
+		"\r\033[K")
+
+	// nothing is drawn, or erased, without a terminal
+	out.Reset()
+	progressOut = nil
+	_, err = listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Equal(t, out.Len(), 0)
+}
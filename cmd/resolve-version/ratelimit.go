@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits how often the bucket is listed, or nil for no limit
+var listingRateLimit *tokenBucket
+
+// Reads NODE_RESOLVE_RATE_LIMIT, the most listing requests to make per second.
+// This is for builds that share a small mirror, and requests are not limited
+// when it isn't set
+func rateLimitFromEnv() (*tokenBucket, error) {
+	value := os.Getenv("NODE_RESOLVE_RATE_LIMIT")
+	if value == "" {
+		return nil, nil
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 {
+		return nil, fmt.Errorf("Invalid NODE_RESOLVE_RATE_LIMIT: %s", value)
+	}
+	return newTokenBucket(rate), nil
+}
+
+// Allows rate requests per second on average, with bursts of up to one
+// second's worth. Waits are jittered so that builds started at the same time
+// don't keep requesting in lockstep
+type tokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, now: time.Now, tokens: burst}
+}
+
+// Reserves a request, returning how long to wait before making it
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Blocks until a request can be made
+func (b *tokenBucket) wait() {
+	delay := b.reserve()
+	if delay <= 0 {
+		return
+	}
+	// up to a tenth of the wait again, so that waits spread out
+	sleep(delay + time.Duration(rand.Int63n(int64(delay)/10+1)))
+}
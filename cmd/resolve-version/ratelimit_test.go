@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitFromEnv(t *testing.T) {
+	defer os.Unsetenv("NODE_RESOLVE_RATE_LIMIT")
+
+	os.Unsetenv("NODE_RESOLVE_RATE_LIMIT")
+	limit, err := rateLimitFromEnv()
+	assert.Nil(t, err)
+	assert.Nil(t, limit)
+
+	os.Setenv("NODE_RESOLVE_RATE_LIMIT", "0.5")
+	limit, err = rateLimitFromEnv()
+	if assert.Nil(t, err) && assert.NotNil(t, limit) {
+		assert.Equal(t, limit.rate, 0.5)
+	}
+
+	for _, value := range []string{"fast", "0", "-1"} {
+		os.Setenv("NODE_RESOLVE_RATE_LIMIT", value)
+		_, err = rateLimitFromEnv()
+		if assert.NotNil(t, err) {
+			assert.Equal(t, err.Error(), "Invalid NODE_RESOLVE_RATE_LIMIT: "+value)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2019, 10, 24, 0, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(2)
+	bucket.now = func() time.Time { return now }
+
+	// a burst of a second's worth of requests goes straight through
+	assert.Equal(t, bucket.reserve(), time.Duration(0))
+	assert.Equal(t, bucket.reserve(), time.Duration(0))
+
+	// after which requests are spaced out
+	assert.Equal(t, bucket.reserve(), 500*time.Millisecond)
+	assert.Equal(t, bucket.reserve(), time.Second)
+
+	// and the bucket refills over time, without going above the burst
+	now = now.Add(time.Minute)
+	assert.Equal(t, bucket.reserve(), time.Duration(0))
+	assert.Equal(t, bucket.reserve(), time.Duration(0))
+	assert.Equal(t, bucket.reserve(), 500*time.Millisecond)
+}
+
+func TestListingRateLimit(t *testing.T) {
+	now := time.Date(2019, 10, 24, 0, 0, 0, 0, time.UTC)
+	sleeps := []time.Duration{}
+	defer func(original func(time.Duration)) { sleep = original }(sleep)
+	sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+	}
+	defer func(original *tokenBucket) { listingRateLimit = original }(listingRateLimit)
+	listingRateLimit = newTokenBucket(1)
+	listingRateLimit.now = func() time.Time { return now }
+
+	server := httptest.NewServer(s3ListingHandler(genNodeKeys(30), 10))
+	defer server.Close()
+
+	objects, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 30)
+
+	// the first page is the burst, and the two after it each wait for about a
+	// second, give or take the jitter
+	if assert.Len(t, sleeps, 2) {
+		for _, d := range sleeps {
+			assert.True(t, d > 850*time.Millisecond && d <= 1100*time.Millisecond, d.String())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmorrell/semver"
+)
+
+// Builds a release from a listed object, keeping what S3 reports about the
+// object alongside what its key says about the release
+func releaseFromObject(obj s3Object) (release, error) {
+	rel, err := parseObject(obj.Key)
+	if err != nil {
+		return release{}, err
+	}
+	rel.etag = strings.Trim(obj.ETag, `"`)
+	rel.storageClass = obj.StorageClass
+	rel.size = int64(obj.Size)
+	rel.lastModified = obj.LastModified
+	return rel, nil
+}
+
+// The JSON form of a release, for tools that consume resolve-version's output.
+// Fields are only ever added to this, and fields a source doesn't know about
+// are left out rather than written as zero values:
+//
+//	binary        "node", "yarn", or "pnpm"
+//	version       the full version, like "18.19.1-rc.1"
+//	major         the components of the version
+//	minor
+//	patch
+//	prerelease    the prerelease identifiers, like ["rc", "1"]
+//	stage         "release" or "staging"
+//	platform      like "linux-x64", absent for yarn
+//	qualifier     anything after the platform in the file name
+//	url           where the tarball can be downloaded from
+//	size          the size of the tarball in bytes
+//	lastModified  when the tarball was published, in RFC 3339
+//	etag          the ETag of the tarball, without quotes
+//	storageClass  the S3 storage class of the tarball, like "STANDARD"
+//
+// This stands in for an exported Release type. Package main can't be
+// imported, so this JSON is the contract that other tools get
+type releaseEntry struct {
+	Binary       string     `json:"binary"`
+	Version      string     `json:"version"`
+	Major        uint64     `json:"major"`
+	Minor        uint64     `json:"minor"`
+	Patch        uint64     `json:"patch"`
+	Prerelease   []string   `json:"prerelease,omitempty"`
+	Stage        string     `json:"stage,omitempty"`
+	Platform     string     `json:"platform,omitempty"`
+	Qualifier    string     `json:"qualifier,omitempty"`
+	URL          string     `json:"url"`
+	Size         int64      `json:"size,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	StorageClass string     `json:"storageClass,omitempty"`
+}
+
+func newReleaseEntry(rel release) releaseEntry {
+	entry := releaseEntry{
+		Binary:       rel.binary,
+		Version:      rel.version.String(),
+		Major:        rel.version.Major,
+		Minor:        rel.version.Minor,
+		Patch:        rel.version.Patch,
+		Stage:        rel.stage,
+		Platform:     rel.platform,
+		Qualifier:    rel.qualifier,
+		URL:          rel.url,
+		Size:         rel.size,
+		ETag:         rel.etag,
+		StorageClass: rel.storageClass,
+	}
+	for _, pre := range rel.version.Pre {
+		entry.Prerelease = append(entry.Prerelease, pre.String())
+	}
+	if !rel.lastModified.IsZero() {
+		lastModified := rel.lastModified.UTC()
+		entry.LastModified = &lastModified
+	}
+	return entry
+}
+
+func (rel release) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newReleaseEntry(rel))
+}
+
+func (rel *release) UnmarshalJSON(data []byte) error {
+	var entry releaseEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	version, err := semver.Make(entry.Version)
+	if err != nil {
+		return fmt.Errorf("Could not parse release version %s: %s", entry.Version, err)
+	}
+
+	*rel = release{
+		binary:       entry.Binary,
+		stage:        entry.Stage,
+		platform:     entry.Platform,
+		qualifier:    entry.Qualifier,
+		url:          entry.URL,
+		version:      version,
+		size:         entry.Size,
+		etag:         entry.ETag,
+		storageClass: entry.StorageClass,
+	}
+	if entry.LastModified != nil {
+		rel.lastModified = *entry.LastModified
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+package main
+
+// The armored public keys of nodeReleaseKeyFingerprints. release_keys.sh
+// replaces this with the keys from https://github.com/nodejs/release-keys,
+// and until it has, --require-signed needs NODE_RESOLVE_KEYRING
+var nodeReleaseKeys = ""
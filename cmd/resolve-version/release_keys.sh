@@ -0,0 +1,34 @@
+#!/usr/bin/env bash
+# Regenerates release_keys.go with the public keys of every fingerprint in
+# nodeReleaseKeyFingerprints, from the keyring the node project maintains,
+# which can verify every past release. Run it with go generate when the
+# release keys change
+
+set -euo pipefail
+cd "$(dirname "$0")"
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+curl -fsSL -o "$tmp/pubring.kbx" https://github.com/nodejs/release-keys/raw/refs/heads/main/gpg/pubring.kbx
+
+fingerprints=$(sed -n 's/^\t"\([0-9A-F]\{40\}\)",.*/\1/p' signature.go)
+gpg --homedir "$tmp" --no-default-keyring --keyring "$tmp/pubring.kbx" --export-options export-minimal --armor --export $fingerprints > "$tmp/keys.asc"
+
+# every key has to be found, or releases it signed would fail to verify
+for fingerprint in $fingerprints; do
+  if ! gpg --homedir "$tmp" --no-default-keyring --keyring "$tmp/pubring.kbx" --list-keys "$fingerprint" > /dev/null 2>&1; then
+    echo "No key for $fingerprint in the node release keyring" >&2
+    exit 1
+  fi
+done
+
+{
+  echo "// Code generated by release_keys.sh. DO NOT EDIT."
+  echo
+  echo "package main"
+  echo
+  echo "// The armored public keys of nodeReleaseKeyFingerprints"
+  printf 'var nodeReleaseKeys = `%s`\n' "$(cat "$tmp/keys.asc")"
+} > release_keys.go
+gofmt -l release_keys.go
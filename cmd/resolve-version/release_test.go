@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseFromObject(t *testing.T) {
+	lastModified := time.Date(2023, 11, 29, 18, 4, 5, 0, time.UTC)
+	rel, err := releaseFromObject(s3Object{
+		Key:          "node/release/linux-x64/node-v18.19.0-linux-x64.tar.gz",
+		LastModified: lastModified,
+		ETag:         `"0123456789abcdef0123456789abcdef"`,
+		Size:         44000000,
+		StorageClass: "STANDARD",
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, rel.version.String(), "18.19.0")
+		assert.Equal(t, rel.etag, "0123456789abcdef0123456789abcdef")
+		assert.Equal(t, rel.storageClass, "STANDARD")
+		assert.Equal(t, rel.size, int64(44000000))
+		assert.Equal(t, rel.lastModified, lastModified)
+	}
+
+	_, err = releaseFromObject(s3Object{Key: "something/weird"})
+	assert.NotNil(t, err)
+}
+
+func TestReleaseJSON(t *testing.T) {
+	rel, err := releaseFromObject(s3Object{
+		Key:          "node/release/linux-x64/node-v18.19.0-linux-x64.tar.gz",
+		LastModified: time.Date(2023, 11, 29, 18, 4, 5, 0, time.UTC),
+		ETag:         `"0123456789abcdef0123456789abcdef"`,
+		Size:         44000000,
+		StorageClass: "STANDARD",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the format is a contract with the tools reading it, so it is compared
+	// byte for byte
+	data, err := json.MarshalIndent(rel, "", "  ")
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), `{
+  "binary": "node",
+  "version": "18.19.0",
+  "major": 18,
+  "minor": 19,
+  "patch": 0,
+  "stage": "release",
+  "platform": "linux-x64",
+  "url": "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.19.0-linux-x64.tar.gz",
+  "size": 44000000,
+  "lastModified": "2023-11-29T18:04:05Z",
+  "etag": "0123456789abcdef0123456789abcdef",
+  "storageClass": "STANDARD"
+}`)
+
+	var decoded release
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, decoded, rel)
+
+	again, err := json.MarshalIndent(decoded, "", "  ")
+	assert.Nil(t, err)
+	assert.Equal(t, string(again), string(data))
+}
+
+func TestReleaseJSONOptionalFields(t *testing.T) {
+	rel, err := parseObject("yarn/release/yarn-v1.22.0.tar.gz")
+	if !assert.Nil(t, err) {
+		return
+	}
+	data, err := json.Marshal(rel)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), `{"binary":"yarn","version":"1.22.0","major":1,"minor":22,"patch":0,"stage":"release","url":"https://s3.amazonaws.com/heroku-nodebin/yarn/release/yarn-v1.22.0.tar.gz"}`)
+
+	rel = genReleasesFromArray([]string{"20.0.0-rc.1"})[0]
+	data, err = json.Marshal(rel)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"prerelease":["rc","1"]`)
+
+	var decoded release
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, decoded, rel)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`{"version":"not a version"}`), &decoded))
+}
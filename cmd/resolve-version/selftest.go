@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// The resolutions selftest expects to succeed against any healthy source: the
+// latest release, and what the buildpack installs when nothing asks for a
+// version. They're loose enough to keep passing as new versions are published
+var selftestChecks = []struct {
+	binary             string
+	versionRequirement string
+}{
+	{"node", "latest"},
+	{"node", defaultConstraints["node"]},
+	{"yarn", "latest"},
+	{"yarn", defaultConstraints["yarn"]},
+}
+
+// Runs each of selftestChecks through the same listing, parsing, and matching
+// as resolving does, printing a line for each. This returns an error if any of
+// them fail, so that a deploy of the buildpack, or a mirror, can be checked
+// with the exit code
+func selftest(sourcesFor func(string) []source, out io.Writer) error {
+	failed := 0
+	for _, check := range selftestChecks {
+		start := time.Now()
+		rel, err := selftestCheck(sourcesFor(check.binary), check.binary, check.versionRequirement)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "FAIL %s %s: %s (%s)\n", check.binary, check.versionRequirement, err, elapsed)
+			continue
+		}
+		fmt.Fprintf(out, "ok   %s %s resolved to %s (%s)\n", check.binary, check.versionRequirement, describeRelease(rel), elapsed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d self-test checks failed", failed, len(selftestChecks))
+	}
+	return nil
+}
+
+func selftestCheck(sources []source, binary string, versionRequirement string) (release, error) {
+	if len(sources) == 0 {
+		return release{}, fmt.Errorf("Unknown binary: %s", binary)
+	}
+	result, err := resolveFromSources(sources, binary, normalizeRequirement(versionRequirement))
+	if err != nil {
+		return release{}, err
+	}
+	if !result.matched {
+		return release{}, fmt.Errorf("No result")
+	}
+	if result.release.url == "" {
+		return release{}, fmt.Errorf("Resolved to %s, which has no URL", result.release.version.String())
+	}
+	return result.release, nil
+}
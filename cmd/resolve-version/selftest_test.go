@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelftest(t *testing.T) {
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	node := staticSource{releases: genReleasesFromArray([]string{"12.22.12", "20.15.1", "22.4.1"})}
+	yarn := staticSource{releases: []release{
+		{binary: "yarn", stage: "release", url: "https://heroku.com/yarn-v1.22.22.tar.gz", version: semver.MustParse("1.22.22")},
+	}}
+	sources := map[string][]source{"node": {node}, "yarn": {yarn}}
+
+	var out bytes.Buffer
+	err := selftest(func(binary string) []source { return sources[binary] }, &out)
+	assert.Nil(t, err)
+	// the timings vary, so they're left out of the comparison
+	timings := regexp.MustCompile(`\([0-9.]+[a-zµ]+\)`)
+	assert.Equal(t, timings.ReplaceAllString(out.String(), "(Xms)"), `ok   node latest resolved to 22.4.1, stage release, platform linux-x64 (Xms)
+ok   node 12.x resolved to 12.22.12, stage release, platform linux-x64 (Xms)
+ok   yarn latest resolved to 1.22.22, stage release (Xms)
+ok   yarn 1.x resolved to 1.22.22, stage release (Xms)
+`)
+
+	// a listing that fails, or that doesn't have the default, fails the
+	// self-test, but every check is still run
+	sources["node"] = []source{staticSource{releases: genReleasesFromArray([]string{"20.15.1"})}}
+	sources["yarn"] = []source{staticSource{err: errors.New("Unexpected status code: 503")}}
+	out.Reset()
+	err = selftest(func(binary string) []source { return sources[binary] }, &out)
+	assert.EqualError(t, err, "3 of 4 self-test checks failed")
+	assert.Equal(t, timings.ReplaceAllString(out.String(), "(Xms)"), `ok   node latest resolved to 20.15.1, stage release, platform linux-x64 (Xms)
+FAIL node 12.x: No result (Xms)
+FAIL yarn latest: Unexpected status code: 503 (Xms)
+FAIL yarn 1.x: Unexpected status code: 503 (Xms)
+`)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Wraps a source so that each binary is only listed once, after which the
+// releases are kept in memory and refreshed in the background
+type memorySource struct {
+	source source
+
+	mu       sync.Mutex
+	releases map[string][]release
+}
+
+func newMemorySource(src source) *memorySource {
+	return &memorySource{source: src, releases: map[string][]release{}}
+}
+
+func (s *memorySource) List(prefix string) ([]release, error) {
+	s.mu.Lock()
+	releases, ok := s.releases[prefix]
+	s.mu.Unlock()
+	if ok {
+		return releases, nil
+	}
+
+	releases, err := s.source.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.releases[prefix] = releases
+	s.mu.Unlock()
+	return releases, nil
+}
+
+// Lists every binary that has been listed before again. A listing that fails
+// keeps the releases from the last one that succeeded
+func (s *memorySource) refresh() error {
+	s.mu.Lock()
+	prefixes := []string{}
+	for prefix := range s.releases {
+		prefixes = append(prefixes, prefix)
+	}
+	s.mu.Unlock()
+
+	var lastErr error
+	for _, prefix := range prefixes {
+		releases, err := s.source.List(prefix)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s.mu.Lock()
+		s.releases[prefix] = releases
+		s.mu.Unlock()
+	}
+	return lastErr
+}
+
+// Answers GET /resolve?binary=node&requirement=18.x with the same JSON that
+// --resolve --json prints for each binary
+type resolveServer struct {
+	sources map[string][]source
+	cached  []*memorySource
+}
+
+func newResolveServer(binaries []string, sourcesFor func(string) []source) *resolveServer {
+	server := &resolveServer{sources: map[string][]source{}}
+	for _, binary := range binaries {
+		sources := []source{}
+		for _, src := range sourcesFor(binary) {
+			cached := newMemorySource(src)
+			server.cached = append(server.cached, cached)
+			sources = append(sources, cached)
+		}
+		server.sources[binary] = sources
+	}
+	return server
+}
+
+func (s *resolveServer) refresh() {
+	for _, cached := range s.cached {
+		if err := cached.refresh(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not refresh listing: %s\n", err)
+		}
+	}
+}
+
+func (s *resolveServer) refreshEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.refresh()
+	}
+}
+
+type serveError struct {
+	Error string `json:"error"`
+}
+
+func (s *resolveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/resolve" {
+		writeServeJSON(w, http.StatusNotFound, serveError{Error: "Not found"})
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeServeJSON(w, http.StatusMethodNotAllowed, serveError{Error: "Only GET is supported"})
+		return
+	}
+
+	binary := r.URL.Query().Get("binary")
+	versionRequirement := r.URL.Query().Get("requirement")
+	if binary == "" || versionRequirement == "" {
+		writeServeJSON(w, http.StatusBadRequest, serveError{Error: "binary and requirement are required"})
+		return
+	}
+	sources, ok := s.sources[binary]
+	if !ok || len(sources) == 0 {
+		writeServeJSON(w, http.StatusBadRequest, serveError{Error: fmt.Sprintf("Unknown binary: %s", binary)})
+		return
+	}
+
+	result, err := resolveFromSources(sources, binary, normalizeRequirement(versionRequirement))
+	if err != nil {
+		writeServeJSON(w, http.StatusBadGateway, serveError{Error: err.Error()})
+		return
+	}
+	if !result.matched {
+		writeServeJSON(w, http.StatusNotFound, serveError{Error: "No result"})
+		return
+	}
+
+	writeServeJSON(w, http.StatusOK, binaryEntry{
+		Binary:  binary,
+		Version: result.release.version.String(),
+		URL:     result.release.url,
+	})
+}
+
+func writeServeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Sets up the globals that resolving otherwise sets lazily, since handlers
+// run concurrently. The host's glibc is detected once, and progress is never
+// drawn, because concurrent listings would share the one progress line
+func prepareToServe() {
+	progressOut = nil
+	glibcFor(getPlatform())
+}
+
+// Runs resolve-version as an HTTP server until it fails
+func serve(opts options) error {
+	server := newResolveServer(sourceBinaries, func(binary string) []source {
+		return sourcesFor(binary, opts.source)
+	})
+	prepareToServe()
+	if opts.serveRefresh > 0 {
+		go server.refreshEvery(opts.serveRefresh)
+	}
+
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", opts.serve)
+	return http.ListenAndServe(opts.serve, server)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A source that counts how often it is listed
+type countingSource struct {
+	releases []release
+	err      error
+	lists    int
+}
+
+func (s *countingSource) List(prefix string) ([]release, error) {
+	s.lists++
+	return s.releases, s.err
+}
+
+func getResolve(t *testing.T, server http.Handler, query string) (int, map[string]string) {
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/resolve?"+query, nil))
+
+	body := map[string]string{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+func TestResolveServer(t *testing.T) {
+	node := &countingSource{releases: genReleasesFromArray([]string{"16.20.2", "18.17.0", "18.19.1"})}
+	server := newResolveServer([]string{"node", "yarn"}, func(binary string) []source {
+		if binary == "node" {
+			return []source{node}
+		}
+		return testSourcesFor(binary)
+	})
+
+	status, body := getResolve(t, server, "binary=node&requirement=18.x")
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, body, map[string]string{"binary": "node", "version": "18.19.1", "url": "https://heroku.com"})
+
+	status, body = getResolve(t, server, "binary=node&requirement=latest")
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, body["version"], "18.19.1")
+
+	status, body = getResolve(t, server, "binary=yarn&requirement=1.x")
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, body["version"], "1.19.1")
+
+	// the listing is shared by every request
+	assert.Equal(t, node.lists, 1)
+
+	status, body = getResolve(t, server, "binary=node&requirement=20.x")
+	assert.Equal(t, status, http.StatusNotFound)
+	assert.Equal(t, body["error"], "No result")
+
+	status, body = getResolve(t, server, "binary=bun&requirement=1.x")
+	assert.Equal(t, status, http.StatusBadRequest)
+	assert.Equal(t, body["error"], "Unknown binary: bun")
+
+	status, _ = getResolve(t, server, "binary=node")
+	assert.Equal(t, status, http.StatusBadRequest)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/resolve?binary=node&requirement=18.x", nil))
+	assert.Equal(t, w.Code, http.StatusMethodNotAllowed)
+}
+
+func TestResolveServerRefresh(t *testing.T) {
+	node := &countingSource{releases: genReleasesFromArray([]string{"18.17.0"})}
+	server := newResolveServer([]string{"node"}, func(string) []source { return []source{node} })
+
+	_, body := getResolve(t, server, "binary=node&requirement=18.x")
+	assert.Equal(t, body["version"], "18.17.0")
+
+	node.releases = genReleasesFromArray([]string{"18.17.0", "18.19.1"})
+	_, body = getResolve(t, server, "binary=node&requirement=18.x")
+	assert.Equal(t, body["version"], "18.17.0")
+
+	server.refresh()
+	_, body = getResolve(t, server, "binary=node&requirement=18.x")
+	assert.Equal(t, body["version"], "18.19.1")
+	assert.Equal(t, node.lists, 2)
+
+	// a failed refresh keeps serving the last listing
+	node.err = errors.New("listing failed")
+	server.refresh()
+	status, body := getResolve(t, server, "binary=node&requirement=18.x")
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, body["version"], "18.19.1")
+}
+
+func TestResolveServerListingError(t *testing.T) {
+	server := newResolveServer([]string{"node"}, func(string) []source {
+		return []source{&countingSource{err: errors.New("listing failed")}}
+	})
+
+	status, body := getResolve(t, server, "binary=node&requirement=18.x")
+	assert.Equal(t, status, http.StatusBadGateway)
+	assert.Equal(t, body["error"], "listing failed")
+}
+
+func TestPrepareToServe(t *testing.T) {
+	defer func(original *glibcInfo) { hostGlibc = original }(hostGlibc)
+	defer func(original func(string, ...string) ([]byte, error)) { glibcCommand = original }(glibcCommand)
+	defer func(original io.Writer) { progressOut = original }(progressOut)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	glibcCommand = func(name string, args ...string) ([]byte, error) {
+		return []byte("ldd (GNU libc) 2.28\n"), nil
+	}
+	hostGlibc = nil
+	progressOut = &bytes.Buffer{}
+
+	// nothing is left for concurrent handlers to set
+	prepareToServe()
+	assert.Nil(t, progressOut)
+	if assert.NotNil(t, hostGlibc) {
+		assert.Equal(t, hostGlibc.version.String(), "2.28.0")
+	}
+}
+
+func TestResolveServerBinaries(t *testing.T) {
+	pnpm := staticSource{releases: parseObjects([]s3Object{{Key: "pnpm/release/pnpm-v8.15.9.tar.gz"}})}
+	server := newResolveServer(sourceBinaries, func(binary string) []source {
+		if binary == "pnpm" {
+			return []source{pnpm}
+		}
+		return testSourcesFor(binary)
+	})
+
+	status, body := getResolve(t, server, "binary=pnpm&requirement=8.x")
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, body["version"], "8.15.9")
+}
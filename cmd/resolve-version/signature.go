@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// Fingerprints of the keys used to sign node releases, current and past, so
+// that older releases can still be verified. The canonical list is in the
+// "Release keys" section of https://github.com/nodejs/node#release-keys
+//
+// The keys themselves are embedded in nodeReleaseKeys, which release_keys.sh
+// generates from this list, or read from NODE_RESOLVE_KEYRING when it's set.
+// Either way, any key that doesn't match one of these fingerprints is
+// discarded
+//
+//go:generate ./release_keys.sh
+var nodeReleaseKeyFingerprints = []string{
+	"5BE8A3F6C8A5C01D106C0AD820B1A390B168D356", // Antoine du Hamel
+	"DD792F5973C6DE52C432CBDAC77ABFA00DDBF2B7", // Juan José Arboleda
+	"CC68F5A3106FF448322E48ED27F5E38D5B0A215F", // Marco Ippolito
+	"8FCCA13FEF1D0C2E91008E09770F7A9A5AE15600", // Michaël Zasso
+	"890C08DB8579162FEE0DF9DB8BEAB4DFCF555EF4", // Rafael Gonzaga
+	"C82FA3AE1CBEDC6BE46B9360C43CEC45C17AB93C", // Richard Lau
+	"108F52B48DB57BB0CC439B2997B01419BD92F80A", // Ruy Adorno
+	"A363A499291CBBC940DD62E41F10027AF002F8B0", // Ulises Gascón
+
+	// keys that only signed previous releases
+	"C0D6248439F1D5604AAFFB4021D900FFDB233756", // Antoine du Hamel
+	"4ED778F539E3634C779C87C6D7062848A1AB005C", // Beth Griggs
+	"141F07595B7B3FFE74309A937405533BE57C7D57", // Bryan English
+	"9554F04D7259F04124DE6B476D5A82AC7E37093B", // Chris Dickinson
+	"94AE36675C464D64BAFA68DD7434390BDBE9B9C5", // Colin Ihrig
+	"1C050899334244A8AF75E53792EF661D867B9DFA", // Danielle Adams
+	"74F12602B6F1C4E913FAA37AD3A89613643B6201", // Danielle Adams
+	"B9AE9905FFD7803F25714661B63B535A4C206CA9", // Evan Lucas
+	"77984A986EBC2AA786BC0F66B01FBB92821C587A", // Gibson Fahnestock
+	"93C7E9E91B49E432C2F75674B0A78B0A6C481CF6", // Isaac Z. Schlueter
+	"56730D5401028683275BD23C23EFEFE93C4CFFFE", // Italo A. Casas
+	"71DCFD284A79C3B38668286BC97EC7A07EDE3FC1", // James M Snell
+	"FD3A5288F042B6850C66B31F09FE44734EB7990E", // Jeremiah Senkpiel
+	"61FC681DFB92A079F1685E77973F295594EC4689", // Juan José Arboleda
+	"114F43EE0176B71C7BC219DD50A3051F888C628D", // Julien Gilli
+	"C4F0DFFF4E8C1A8236409D08E73BC641CC11F4C8", // Myles Borins
+	"DD8F2338BAE7501E3DD5AC78C273792F7D83545D", // Rod Vagg
+	"A48C2BEE680E841632CD4E44F07496B3EB3C1762", // Ruben Bridgewater
+	"B9E2F5981AA6E0CD28160D9FF13993A75599653C", // Shelley Vohr
+	"7937DFD2AB06298B2293C3187D33FF9D0246406D", // Timothy J Fontaine
+}
+
+var nodeDistURL = "https://nodejs.org/dist"
+
+// Confirms that a resolved node release is the one published by the node
+// project: SHASUMS256.txt.asc for the version must carry a valid signature
+// from a release key, and the tarball must match the checksum it lists
+func verifySignedRelease(rel release) error {
+	keyring, err := loadNodeReleaseKeys()
+	if err != nil {
+		return err
+	}
+
+	sumsURL := fmt.Sprintf("%s/v%s/SHASUMS256.txt.asc", nodeDistURL, rel.version.String())
+	signed, err := download(sumsURL)
+	if err != nil {
+		return err
+	}
+
+	shasums, err := checkClearsigned(keyring, signed)
+	if err != nil {
+		return fmt.Errorf("Could not verify the signature of %s: %s", sumsURL, err)
+	}
+
+	filename := fmt.Sprintf("node-v%s-%s.tar.gz", rel.version.String(), rel.platform)
+	expected, err := findChecksum(shasums, filename)
+	if err != nil {
+		return err
+	}
+
+	actual, err := sha256URL(rel.url)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("Checksum mismatch for %s: expected %s but got %s", rel.url, expected, actual)
+	}
+	return nil
+}
+
+// Checks the signature on a clearsigned message and returns the signed text
+func checkClearsigned(keyring openpgp.EntityList, signed []byte) ([]byte, error) {
+	block, _ := clearsign.Decode(signed)
+	if block == nil {
+		return nil, fmt.Errorf("no signed message found")
+	}
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+		return nil, err
+	}
+	return block.Plaintext, nil
+}
+
+// Finds the checksum for a file in the contents of a SHASUMS256.txt file
+func findChecksum(shasums []byte, filename string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(shasums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == filename {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("No checksum found for %s", filename)
+}
+
+// Reads the release keys from NODE_RESOLVE_KEYRING, or the ones embedded in
+// nodeReleaseKeys, without making any requests
+func loadNodeReleaseKeys() (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+
+	if path := os.Getenv("NODE_RESOLVE_KEYRING"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		keyring, err = openpgp.ReadArmoredKeyRing(f)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read keyring %s: %s", path, err)
+		}
+	} else {
+		if nodeReleaseKeys == "" {
+			return nil, fmt.Errorf("No node release keys are embedded in this build, run go generate or set NODE_RESOLVE_KEYRING")
+		}
+		var err error
+		keyring, err = openpgp.ReadArmoredKeyRing(strings.NewReader(nodeReleaseKeys))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the embedded node release keys: %s", err)
+		}
+	}
+
+	trusted := trustedEntities(keyring, nodeReleaseKeyFingerprints)
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("Could not load any node release keys")
+	}
+	return trusted, nil
+}
+
+// Filters a keyring down to the keys with one of the given fingerprints
+func trustedEntities(keyring openpgp.EntityList, fingerprints []string) openpgp.EntityList {
+	trusted := openpgp.EntityList{}
+	for _, entity := range keyring {
+		fingerprint := strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]))
+		for _, f := range fingerprints {
+			if f == fingerprint {
+				trusted = append(trusted, entity)
+				break
+			}
+		}
+	}
+	return trusted
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Unexpected status code: %d for downloading %s", resp.StatusCode, url)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func sha256URL(url string) (string, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("Unexpected status code: %d for downloading %s", resp.StatusCode, url)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
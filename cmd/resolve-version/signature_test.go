@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func newTestEntity(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("Test Releaser", "", "releaser@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func fingerprint(entity *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]))
+}
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) []byte {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func clearsignText(t *testing.T, entity *openpgp.Entity, text string) []byte {
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, entity.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(text))
+	w.Close()
+	return buf.Bytes()
+}
+
+// Serves a node dist directory for 10.15.3 signed by signer, and a tarball
+func newSignedReleaseServer(t *testing.T, signer *openpgp.Entity, tarball []byte) *httptest.Server {
+	sum := sha256.Sum256([]byte("the real tarball"))
+	shasums := fmt.Sprintf("%s  node-v10.15.3-linux-x64.tar.gz\n%s  node-v10.15.3-darwin-x64.tar.gz\n", hex.EncodeToString(sum[:]), strings.Repeat("0", 64))
+	signed := clearsignText(t, signer, shasums)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v10.15.3/SHASUMS256.txt.asc":
+			w.Write(signed)
+		case "/node-v10.15.3-linux-x64.tar.gz":
+			w.Write(tarball)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func setupSignatureTest(t *testing.T, trusted *openpgp.Entity, server *httptest.Server) func() {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := filepath.Join(dir, "keyring.asc")
+	ioutil.WriteFile(keyring, armoredPublicKey(t, trusted), 0644)
+
+	originalFingerprints, originalDistURL := nodeReleaseKeyFingerprints, nodeDistURL
+	nodeReleaseKeyFingerprints = []string{fingerprint(trusted)}
+	nodeDistURL = server.URL
+	os.Setenv("NODE_RESOLVE_KEYRING", keyring)
+
+	return func() {
+		nodeReleaseKeyFingerprints, nodeDistURL = originalFingerprints, originalDistURL
+		os.Unsetenv("NODE_RESOLVE_KEYRING")
+		os.RemoveAll(dir)
+	}
+}
+
+func signedTestRelease(server *httptest.Server) release {
+	return release{
+		binary:   "node",
+		stage:    "release",
+		platform: "linux-x64",
+		url:      server.URL + "/node-v10.15.3-linux-x64.tar.gz",
+		version:  semver.MustParse("10.15.3"),
+	}
+}
+
+func TestVerifySignedRelease(t *testing.T) {
+	releaser := newTestEntity(t)
+	server := newSignedReleaseServer(t, releaser, []byte("the real tarball"))
+	defer server.Close()
+	defer setupSignatureTest(t, releaser, server)()
+
+	assert.Nil(t, verifySignedRelease(signedTestRelease(server)))
+}
+
+func TestVerifySignedReleaseChecksumMismatch(t *testing.T) {
+	releaser := newTestEntity(t)
+	server := newSignedReleaseServer(t, releaser, []byte("a tampered tarball"))
+	defer server.Close()
+	defer setupSignatureTest(t, releaser, server)()
+
+	err := verifySignedRelease(signedTestRelease(server))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Checksum mismatch")
+	}
+}
+
+func TestVerifySignedReleaseUntrustedSigner(t *testing.T) {
+	releaser := newTestEntity(t)
+	imposter := newTestEntity(t)
+	server := newSignedReleaseServer(t, imposter, []byte("the real tarball"))
+	defer server.Close()
+	defer setupSignatureTest(t, releaser, server)()
+
+	err := verifySignedRelease(signedTestRelease(server))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Could not verify the signature")
+	}
+}
+
+func TestLoadEmbeddedNodeReleaseKeys(t *testing.T) {
+	releaser := newTestEntity(t)
+	imposter := newTestEntity(t)
+
+	// nothing is requested, so a keyserver being unreachable doesn't matter
+	defer func(original *http.Client) { httpClient = original }(httpClient)
+	httpClient = &http.Client{Transport: failingTransport{}}
+
+	originalFingerprints, originalKeys := nodeReleaseKeyFingerprints, nodeReleaseKeys
+	defer func() { nodeReleaseKeyFingerprints, nodeReleaseKeys = originalFingerprints, originalKeys }()
+	nodeReleaseKeyFingerprints = []string{fingerprint(releaser), strings.Repeat("A", 40)}
+
+	// and a key that's embedded but not trusted is discarded
+	nodeReleaseKeys = string(armoredPublicKey(t, releaser)) + "\n" + string(armoredPublicKey(t, imposter))
+	keyring, err := loadNodeReleaseKeys()
+	if assert.Nil(t, err) && assert.Len(t, keyring, 1) {
+		assert.Equal(t, fingerprint(keyring[0]), fingerprint(releaser))
+	}
+
+	nodeReleaseKeys = ""
+	_, err = loadNodeReleaseKeys()
+	assert.EqualError(t, err, "No node release keys are embedded in this build, run go generate or set NODE_RESOLVE_KEYRING")
+}
+
+func TestEmbeddedNodeReleaseKeysAreTrusted(t *testing.T) {
+	if nodeReleaseKeys == "" {
+		t.Skip("no release keys are embedded, see release_keys.sh")
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(nodeReleaseKeys))
+	if !assert.Nil(t, err) {
+		return
+	}
+	// release_keys.sh exports exactly the fingerprints that are trusted
+	assert.Len(t, trustedEntities(keyring, nodeReleaseKeyFingerprints), len(nodeReleaseKeyFingerprints))
+}
+
+func TestFindChecksum(t *testing.T) {
+	shasums := []byte("abc123  node-v10.15.3-linux-x64.tar.gz\nDEF456  node-v10.15.3-darwin-x64.tar.gz\n")
+
+	sum, err := findChecksum(shasums, "node-v10.15.3-darwin-x64.tar.gz")
+	assert.Nil(t, err)
+	assert.Equal(t, sum, "def456")
+
+	_, err = findChecksum(shasums, "node-v10.15.3-linux-x64.tar.xz")
+	assert.NotNil(t, err)
+}
+
+// Fails every request, for tests that must not make any
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("unexpected request for %s", req.URL)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How many times a request that S3 answered with SlowDown is retried. These
+// aren't counted by the circuit breaker, see breakerTransport
+const slowDownRetries = 4
+
+// The body of an S3 error response
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// Waits between requests that S3 rate limited. Each SlowDown in a row doubles
+// the wait, so that a bucket that stays overloaded is asked less and less
+// often, and any request that isn't rate limited resets it
+type slowDownBackoff struct {
+	initial time.Duration
+	max     time.Duration
+
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+func (b *slowDownBackoff) next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.delay == 0 {
+		b.delay = b.initial
+	} else {
+		b.delay *= 2
+	}
+	if b.delay > b.max {
+		b.delay = b.max
+	}
+	return b.delay
+}
+
+func (b *slowDownBackoff) reset() {
+	b.mu.Lock()
+	b.delay = 0
+	b.mu.Unlock()
+}
+
+var s3SlowDown = &slowDownBackoff{initial: time.Second, max: 30 * time.Second}
+
+// Replaced in tests so that backing off doesn't need to wait
+var sleep = time.Sleep
+
+// Gets a page of a listing, backing off and retrying while S3 responds with
+// 503 SlowDown. A Retry-After header from S3 is used instead of the backoff
+// when there is one
+func getS3Listing(url string, bucketName string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if listingRateLimit != nil {
+			listingRateLimit.wait()
+		}
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if !isSlowDown(resp, body) {
+			s3SlowDown.reset()
+			if resp.StatusCode >= 300 {
+				return nil, fmt.Errorf("Unexpected status code: %d for listing S3 bucket: %s", resp.StatusCode, bucketName)
+			}
+			return body, nil
+		}
+
+		if attempt == slowDownRetries {
+			return nil, fmt.Errorf("S3 is rate limiting requests for listing S3 bucket: %s", bucketName)
+		}
+		delay := s3SlowDown.next()
+		if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && retryAfter >= 0 {
+			delay = time.Duration(retryAfter) * time.Second
+		}
+		logVerbose("S3 is rate limiting requests for listing %s, retrying in %s\n", bucketName, delay)
+		sleep(delay)
+	}
+}
+
+func isSlowDown(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+	var s3Err s3Error
+	return xml.Unmarshal(body, &s3Err) == nil && s3Err.Code == "SlowDown"
+}
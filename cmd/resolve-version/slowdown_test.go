@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const slowDownBody = `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`
+
+// Serves a SlowDown response for the first slowDowns requests, and a listing
+// after that
+func slowDownHandler(slowDowns int, retryAfter string) http.HandlerFunc {
+	listing := s3ListingHandler(genNodeKeys(3), 10)
+	requests := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= slowDowns {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, slowDownBody)
+			return
+		}
+		listing(w, r)
+	}
+}
+
+// Records the backoff instead of sleeping, returning a func that restores it
+func recordSlowDownSleeps() (*[]time.Duration, func()) {
+	sleeps := []time.Duration{}
+	originalSleep, originalBackoff := sleep, s3SlowDown
+	sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	s3SlowDown = &slowDownBackoff{initial: time.Second, max: 4 * time.Second}
+	return &sleeps, func() {
+		sleep, s3SlowDown = originalSleep, originalBackoff
+	}
+}
+
+func TestSlowDownBackoff(t *testing.T) {
+	sleeps, restore := recordSlowDownSleeps()
+	defer restore()
+	var log bytes.Buffer
+	defer func(original io.Writer) { verboseOut = original }(verboseOut)
+	verboseOut = &log
+
+	server := httptest.NewServer(slowDownHandler(3, ""))
+	defer server.Close()
+
+	objects, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 3)
+	assert.Equal(t, *sleeps, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second})
+	assert.Contains(t, log.String(), "S3 is rate limiting requests for listing heroku-nodebin, retrying in 1s")
+
+	// the backoff starts over once a request gets through
+	assert.Equal(t, s3SlowDown.next(), time.Second)
+}
+
+func TestSlowDownRetryAfter(t *testing.T) {
+	sleeps, restore := recordSlowDownSleeps()
+	defer restore()
+
+	server := httptest.NewServer(slowDownHandler(1, "7"))
+	defer server.Close()
+
+	_, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Equal(t, *sleeps, []time.Duration{7 * time.Second})
+}
+
+func TestSlowDownGivesUp(t *testing.T) {
+	sleeps, restore := recordSlowDownSleeps()
+	defer restore()
+
+	server := httptest.NewServer(slowDownHandler(100, ""))
+	defer server.Close()
+
+	_, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "S3 is rate limiting requests for listing S3 bucket: heroku-nodebin")
+	}
+	assert.Len(t, *sleeps, slowDownRetries)
+}
+
+func TestSlowDownDoesNotOpenBreaker(t *testing.T) {
+	sleeps, restore := recordSlowDownSleeps()
+	defer restore()
+	client, err := newHTTPClient(clientConfig{breakerThreshold: 5})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer func(original *http.Client) { httpClient = original }(httpClient)
+	httpClient = client
+
+	// every retry is rate limited, which is more 503s in a row than the
+	// threshold, but the listing still succeeds on the last one
+	server := httptest.NewServer(slowDownHandler(slowDownRetries, ""))
+	defer server.Close()
+	objects, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 3)
+	assert.Len(t, *sleeps, slowDownRetries)
+
+	// and giving up on a bucket that stays rate limited doesn't stop later
+	// requests either
+	limited := httptest.NewServer(slowDownHandler(100, ""))
+	defer limited.Close()
+	for i := 0; i < 2; i++ {
+		_, err = listS3ObjectsFromEndpoints([]string{limited.URL}, "heroku-nodebin", "node")
+		if assert.NotNil(t, err) {
+			assert.Equal(t, err.Error(), "S3 is rate limiting requests for listing S3 bucket: heroku-nodebin")
+		}
+	}
+	objects, err = listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 3)
+
+	// while other 503s still count toward it
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	for i := 0; i < 5; i++ {
+		if resp, err := client.Get(down.URL); err == nil {
+			resp.Body.Close()
+		}
+	}
+	_, err = client.Get(server.URL)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "after 5 consecutive failed requests")
+	}
+}
+
+func TestOtherServiceUnavailableIsNotRetried(t *testing.T) {
+	sleeps, restore := recordSlowDownSleeps()
+	defer restore()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `<Error><Code>ServiceUnavailable</Code></Error>`)
+	}))
+	defer server.Close()
+
+	_, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Unexpected status code: 503 for listing S3 bucket: heroku-nodebin")
+	}
+	assert.Len(t, *sleeps, 0)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmorrell/semver"
+)
+
+// A place that releases of a binary can be listed from. Every source produces
+// the same release structs, so the matching logic doesn't need to know where
+// a release came from
+type source interface {
+	// List returns every release of the binary identified by prefix
+	List(prefix string) ([]release, error)
+}
+
+// The heroku-nodebin S3 bucket, where keys are laid out as described in
+// parseObject
+type s3Source struct {
+	bucketName string
+	region     string
+	// overrides the endpoints derived from the bucket name and region
+	endpoints []string
+}
+
+func (s s3Source) List(prefix string) ([]release, error) {
+	objects, err := listCachedS3Objects(s.listEndpoints(), s.bucketName, listingPrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+	return parseObjects(objects), nil
+}
+
+// Lists the releases that keep returns true for, dropping the rest as each
+// page of the listing arrives. A cached listing has to hold every object, so
+// with NODE_RESOLVE_CACHE_DIR set the whole listing is fetched and filtered
+func (s s3Source) ListMatching(prefix string, keep func(release) bool) ([]release, error) {
+	if listingCache != nil {
+		return filterList(s, prefix, keep)
+	}
+
+	objects, err := listMatchingS3Objects(s.listEndpoints(), s.bucketName, listingPrefix(prefix), func(obj s3Object) bool {
+		rel, err := releaseFromObject(obj)
+		return err == nil && keep(rel)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseObjects(objects), nil
+}
+
+func (s s3Source) listEndpoints() []string {
+	if len(s.endpoints) > 0 {
+		return s.endpoints
+	}
+	if binariesEndpoint != nil {
+		return []string{binariesEndpoint.bucketURL(s.bucketName)}
+	}
+	return s3Endpoints(s.bucketName, s.region)
+}
+
+// A source that can drop the releases it won't be asked for while it lists
+// them, rather than holding every release until they are filtered
+type matchingSource interface {
+	ListMatching(prefix string, keep func(release) bool) ([]release, error)
+}
+
+// Lists the releases of a source that keep returns true for
+func listMatching(src source, prefix string, keep func(release) bool) ([]release, error) {
+	if m, ok := src.(matchingSource); ok {
+		return m.ListMatching(prefix, keep)
+	}
+	return filterList(src, prefix, keep)
+}
+
+func filterList(src source, prefix string, keep func(release) bool) ([]release, error) {
+	all, err := src.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	releases := []release{}
+	for _, rel := range all {
+		if keep(rel) {
+			releases = append(releases, rel)
+		}
+	}
+	return releases, nil
+}
+
+// The releases of a GitHub repository. Repositories only hold releases of a
+// single binary, so the prefix is used as the name of that binary
+type githubSource struct {
+	repo      string
+	tagPrefix string
+	// whether releases attach a tarball for each platform, as with --repo,
+	// rather than a single tarball
+	platforms bool
+	// the oldest version the repository has releases of, when it only holds
+	// the newer releases of a binary, like yarn berry
+	minimum string
+}
+
+// A source that's only listed when the ones before it have nothing that
+// matches. It's skipped for requirements it can't match, and a failure to
+// list it is warned about rather than hiding what the sources before it found
+type fallbackSource interface {
+	mayMatch(versionRequirement string) bool
+}
+
+// Whether versionRequirement allows a version at or above the minimum. Ranges
+// are opaque functions, so they're probed like checkCeiling probes them
+func (s githubSource) mayMatch(versionRequirement string) bool {
+	if s.minimum == "" {
+		return true
+	}
+	minimum := semver.MustParse(s.minimum)
+	if version, err := semver.Parse(versionRequirement); err == nil {
+		return version.GTE(minimum)
+	}
+	r, err := parseRequirement(versionRequirement)
+	if err != nil {
+		return false
+	}
+	for _, probe := range append(boundProbes(versionRequirement), minimum) {
+		if probe.GTE(minimum) && r(probe) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s githubSource) List(prefix string) ([]release, error) {
+	ghReleases, err := listGitHubReleases(s.repo)
+	if err != nil {
+		return nil, err
+	}
+	if s.platforms {
+		return parseGitHubPlatformReleases(prefix, s.tagPrefix, ghReleases), nil
+	}
+	return parseGitHubReleases(prefix, s.tagPrefix, ghReleases), nil
+}
+
+// A local directory laid out like the S3 bucket, for offline builds. Files
+// are resolved to file:// URLs
+type localDirSource struct {
+	dir string
+}
+
+func (s localDirSource) List(prefix string) ([]release, error) {
+	if _, err := os.Stat(s.dir); err != nil {
+		return nil, err
+	}
+
+	releases := []release{}
+	root := filepath.Join(s.dir, prefix)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return filepath.SkipDir
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		key, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		release, err := parseObject(filepath.ToSlash(key))
+		if err != nil {
+			return nil
+		}
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		release.url = (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+		release.size = info.Size()
+		releases = append(releases, release)
+		return nil
+	})
+	return releases, err
+}
+
+// Releases come from the heroku-nodebin bucket, unless NODE_BINARIES_DIR
+// points at a local copy of it
+func defaultSource() source {
+	if dir := os.Getenv("NODE_BINARIES_DIR"); dir != "" {
+		return localDirSource{dir: dir}
+	}
+	return s3Source{bucketName: "heroku-nodebin", region: getRegion()}
+}
+
+// Every binary that sourcesFor has sources for
+var sourceBinaries = []string{"node", "yarn", "pnpm", "npm"}
+
+// Returns the sources a binary is resolved against, in order. Later sources
+// are only consulted if nothing in an earlier one matches. sourceName picks
+// where node is listed from, as given to --source
+func sourcesFor(binary string, sourceName string) []source {
+	// a repository given to --repo can hold releases of any binary
+	if sourceName == "github" {
+		return []source{githubSource{repo: githubRepo, platforms: true}}
+	}
+
+	switch binary {
+	case "node":
+		if sourceName == "nodejs-org" {
+			return []source{nodejsOrgSource{}}
+		}
+		return []source{defaultSource()}
+	case "yarn":
+		// a local directory is meant for hermetic builds that shouldn't
+		// touch the network at all
+		if _, ok := defaultSource().(localDirSource); ok {
+			return []source{defaultSource()}
+		}
+		// yarn 2+ isn't in the S3 bucket, so look for requirements that
+		// can't be met there in the yarn berry GitHub releases
+		return []source{defaultSource(), githubSource{repo: yarnBerryRepo, tagPrefix: yarnBerryTagPrefix, minimum: "2.0.0"}}
+	case "pnpm":
+		if _, ok := defaultSource().(localDirSource); ok {
+			return []source{defaultSource()}
+		}
+		// heroku-nodebin doesn't mirror pnpm yet, so anything that isn't
+		// there is looked for in the npm registry
+		return []source{defaultSource(), npmRegistrySource{pkg: "pnpm"}}
+	case "npm":
+		// npm is only ever installed from the registry, for a packageManager
+		// that pins it
+		return []source{npmRegistrySource{pkg: "npm"}}
+	}
+	return nil
+}
+
+// Parses every object that looks like a release, skipping anything else that
+// is in the bucket
+func parseObjects(objects []s3Object) []release {
+	defer recordTiming("parsing", time.Now())
+
+	releases := []release{}
+	for _, obj := range objects {
+		release, err := releaseFromObject(obj)
+		if err != nil {
+			continue
+		}
+		releases = append(releases, release)
+	}
+	return releases
+}
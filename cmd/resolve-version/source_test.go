@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticSource struct {
+	releases []release
+	err      error
+}
+
+func (s staticSource) List(prefix string) ([]release, error) {
+	return s.releases, s.err
+}
+
+func TestResolveFromSources(t *testing.T) {
+	classic := staticSource{releases: genReleasesFromArray([]string{"1.19.0", "1.22.0"})}
+	berry := staticSource{releases: genReleasesFromArray([]string{"4.0.0", "4.0.2"})}
+
+	// the first source that matches wins
+	result, err := resolveFromSources([]source{classic, berry}, "yarn", "1.x")
+	if assert.Nil(t, err) {
+		assert.True(t, result.matched)
+		assert.Equal(t, result.release.version.String(), "1.22.0")
+	}
+
+	// later sources are only used if earlier ones have no match
+	result, err = resolveFromSources([]source{classic, berry}, "yarn", ">=4")
+	if assert.Nil(t, err) {
+		assert.True(t, result.matched)
+		assert.Equal(t, result.release.version.String(), "4.0.2")
+	}
+
+	result, err = resolveFromSources([]source{classic, berry}, "yarn", "5.x")
+	if assert.Nil(t, err) {
+		assert.False(t, result.matched)
+	}
+
+	// an exact miss suggests the nearest versions across every source
+	result, err = resolveFromSources([]source{classic, berry}, "yarn", "2.0.0")
+	if assert.Nil(t, err) && assert.False(t, result.matched) && assert.Len(t, result.closest, 2) {
+		assert.Equal(t, result.closest[0].version.String(), "1.22.0")
+		assert.Equal(t, result.closest[1].version.String(), "4.0.0")
+	}
+
+	// an error stops resolution rather than silently skipping the source
+	broken := staticSource{err: errors.New("listing failed")}
+	_, err = resolveFromSources([]source{broken, berry}, "yarn", ">=4")
+	assert.NotNil(t, err)
+}
+
+func TestParseObjects(t *testing.T) {
+	releases := parseObjects([]s3Object{
+		{Key: "node/release/linux-x64/node-v10.15.3-linux-x64.tar.gz"},
+		{Key: "node/release/linux-x64/README.md"},
+		{Key: "yarn/release/yarn-v1.19.1.tar.gz"},
+	})
+	if assert.Len(t, releases, 2) {
+		assert.Equal(t, releases[0].binary, "node")
+		assert.Equal(t, releases[1].binary, "yarn")
+	}
+}
+
+func TestLocalDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodebin")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for _, key := range []string{
+		"node/release/linux-x64/node-v10.15.3-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz",
+		"node/release/darwin-x64/node-v12.13.0-darwin-x64.tar.gz",
+		"node/staging/linux-x64/node-v12.13.1-linux-x64.tar.gz",
+		"node/release/linux-x64/notes.txt",
+		"yarn/release/yarn-v1.19.1.tar.gz",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(key))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte{}, 0644)
+	}
+
+	src := localDirSource{dir: dir}
+	releases, err := src.List("node")
+	if assert.Nil(t, err) {
+		assert.Len(t, releases, 4)
+	}
+
+	result, err := resolveNode(releases, "linux-x64", "12.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "12.13.0")
+		assert.Equal(t, result.release.url, "file://"+filepath.ToSlash(dir)+"/node/release/linux-x64/node-v12.13.0-linux-x64.tar.gz")
+	}
+
+	result, err = resolveNode(releases, "linux-x64", "12.13.1")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.stage, "staging")
+	}
+
+	releases, err = src.List("yarn")
+	if assert.Nil(t, err) && assert.Len(t, releases, 1) {
+		assert.Equal(t, releases[0].version.String(), "1.19.1")
+	}
+
+	// a binary that isn't in the directory has no releases
+	os.RemoveAll(filepath.Join(dir, "yarn"))
+	releases, err = src.List("yarn")
+	assert.Nil(t, err)
+	assert.Empty(t, releases)
+
+	// but a directory that doesn't exist at all is an error
+	_, err = localDirSource{dir: filepath.Join(dir, "missing")}.List("node")
+	assert.NotNil(t, err)
+}
+
+func TestDefaultSource(t *testing.T) {
+	defer os.Unsetenv("NODE_BINARIES_DIR")
+
+	os.Unsetenv("NODE_BINARIES_DIR")
+	assert.IsType(t, s3Source{}, defaultSource())
+	assert.Len(t, sourcesFor("yarn", "s3"), 2)
+
+	os.Setenv("NODE_BINARIES_DIR", "/opt/nodebin")
+	assert.Equal(t, defaultSource(), localDirSource{dir: "/opt/nodebin"})
+	assert.Equal(t, sourcesFor("yarn", "s3"), []source{localDirSource{dir: "/opt/nodebin"}})
+}
+
+func TestSourceBinaries(t *testing.T) {
+	for _, binary := range sourceBinaries {
+		assert.NotEmpty(t, sourcesFor(binary, "s3"), binary)
+	}
+	assert.Empty(t, sourcesFor("bun", "s3"))
+}
+
+func TestS3SourceListMatching(t *testing.T) {
+	// years of patch releases, most of them for other platforms
+	keys := []string{}
+	for i := 0; i < 2000; i++ {
+		platform := []string{"darwin-x64", "linux-arm64", "linux-x64", "win-x64"}[i%4]
+		keys = append(keys, fmt.Sprintf("node/release/%s/node-v%d.%d.%d-%s.tar.gz", platform, i/400, (i/20)%20, i%20, platform))
+	}
+	server := httptest.NewServer(s3ListingHandler(keys, 100))
+	defer server.Close()
+
+	isLinux := func(obj s3Object) bool { return strings.Contains(obj.Key, "/linux-x64/") }
+	objects, err := listMatchingS3Objects([]string{server.URL}, "heroku-nodebin", "node", isLinux)
+	assert.Nil(t, err)
+	assert.Len(t, objects, 500)
+	// only the kept objects were ever appended, not all 2000
+	assert.True(t, cap(objects) < 1000, "capacity %d", cap(objects))
+
+	src := s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}
+	releases, err := src.ListMatching("node", func(rel release) bool { return rel.platform == "linux-x64" })
+	assert.Nil(t, err)
+	assert.Len(t, releases, 500)
+	for _, rel := range releases {
+		assert.Equal(t, rel.platform, "linux-x64")
+	}
+
+	all, err := src.List("node")
+	assert.Nil(t, err)
+	assert.Len(t, all, 2000)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The stages of the bucket that version ranges resolve against, most
+// preferred first. Builds in any other stage, like staging, are only used
+// when their exact version is asked for
+var stagePreference = []string{"release"}
+
+// Reads NODE_RESOLVE_STAGES, a comma separated list of stages in order of
+// preference, like release,rc,canary. When the newest version matching a
+// range is in more than one of these stages, the build in the earliest one is
+// used
+func stagesFromEnv() ([]string, error) {
+	value := os.Getenv("NODE_RESOLVE_STAGES")
+	if value == "" {
+		return []string{"release"}, nil
+	}
+
+	stages := []string{}
+	seen := map[string]bool{}
+	for _, stage := range strings.Split(value, ",") {
+		stage = strings.TrimSpace(stage)
+		if stage == "" || seen[stage] {
+			return nil, fmt.Errorf("Invalid NODE_RESOLVE_STAGES: %s", value)
+		}
+		seen[stage] = true
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// The position of a stage in stagePreference, or len(stagePreference) for
+// stages that ranges don't resolve against
+func stageRank(stage string) int {
+	for i, s := range stagePreference {
+		if s == stage {
+			return i
+		}
+	}
+	return len(stagePreference)
+}
+
+// Adds staging as the least preferred stage for --include-staging, so that
+// ranges can resolve to staging builds when nothing newer has been released
+func withStaging(stages []string) []string {
+	for _, stage := range stages {
+		if stage == "staging" {
+			return stages
+		}
+	}
+	return append(stages, "staging")
+}
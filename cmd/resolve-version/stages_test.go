@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStagesFromEnv(t *testing.T) {
+	defer os.Unsetenv("NODE_RESOLVE_STAGES")
+
+	os.Unsetenv("NODE_RESOLVE_STAGES")
+	stages, err := stagesFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, stages, []string{"release"})
+
+	os.Setenv("NODE_RESOLVE_STAGES", "release, rc,canary")
+	stages, err = stagesFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, stages, []string{"release", "rc", "canary"})
+
+	for _, value := range []string{"release,,rc", "release,rc,release", " , "} {
+		os.Setenv("NODE_RESOLVE_STAGES", value)
+		_, err = stagesFromEnv()
+		if assert.NotNil(t, err, value) {
+			assert.Equal(t, err.Error(), "Invalid NODE_RESOLVE_STAGES: "+value)
+		}
+	}
+}
+
+func TestResolveNodeStagePreference(t *testing.T) {
+	defer func(original []string) { stagePreference = original }(stagePreference)
+
+	keys := []string{
+		"node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz",
+		"node/rc/linux-x64/node-v18.17.0-linux-x64.tar.gz",
+		"node/rc/linux-x64/node-v18.17.1-linux-x64.tar.gz",
+		"node/canary/linux-x64/node-v18.17.1-linux-x64.tar.gz",
+		"node/canary/linux-x64/node-v18.18.0-linux-x64.tar.gz",
+		"node/staging/linux-x64/node-v18.19.0-linux-x64.tar.gz",
+	}
+	releases := []release{}
+	for _, key := range keys {
+		rel, err := parseObject(key)
+		if !assert.Nil(t, err, key) {
+			return
+		}
+		releases = append(releases, rel)
+	}
+
+	cases := []struct {
+		stages      []string
+		requirement string
+		version     string
+		stage       string
+	}{
+		// the default only resolves ranges against release
+		{[]string{"release"}, "18.x", "18.17.0", "release"},
+		{[]string{"release"}, "18.18.0", "18.18.0", "canary"},
+		{[]string{"release"}, "18.19.0", "18.19.0", "staging"},
+		// the newest version wins, whatever stage it is in
+		{[]string{"release", "rc"}, "18.x", "18.17.1", "rc"},
+		{[]string{"release", "rc", "canary"}, "18.x", "18.18.0", "canary"},
+		// and when stages share it, the most preferred one is used
+		{[]string{"release", "rc"}, "18.17.x <18.17.1", "18.17.0", "release"},
+		{[]string{"rc", "release"}, "18.17.0", "18.17.0", "rc"},
+		{[]string{"release", "rc", "canary"}, "18.17.1", "18.17.1", "rc"},
+		{[]string{"canary", "rc"}, "18.17.1", "18.17.1", "canary"},
+		{[]string{"canary", "rc"}, "<18.18", "18.17.1", "canary"},
+		// --include-staging lets ranges resolve to staging builds
+		{withStaging([]string{"release"}), "18.x", "18.19.0", "staging"},
+		{withStaging([]string{"release"}), "18.17.x", "18.17.0", "release"},
+		{withStaging([]string{"staging", "release"}), "18.17.x", "18.17.0", "release"},
+	}
+	for _, c := range cases {
+		stagePreference = c.stages
+		result, err := resolveNode(releases, "linux-x64", c.requirement)
+		if assert.Nil(t, err) && assert.True(t, result.matched, c.requirement) {
+			assert.Equal(t, result.release.version.String(), c.version, c.requirement)
+			assert.Equal(t, result.release.stage, c.stage, c.requirement)
+		}
+	}
+
+	// every preferred stage is listed when narrowing the prefix
+	stagePreference = []string{"release", "rc"}
+	assert.Equal(t, narrowNodePrefixes("linux-x64", "~18.2.0"), []string{
+		"node/release/linux-x64/node-v18.",
+		"node/rc/linux-x64/node-v18.",
+	})
+}
+
+func TestResolveAnyChannel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.json")
+
+	defer func(original []string) { stagePreference = original }(stagePreference)
+	stagePreference = withStaging([]string{"release"})
+
+	keys := []string{
+		"node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz",
+		"node/release/linux-x64/node-v20.1.0-linux-x64.tar.gz",
+		"node/staging/linux-x64/node-v18.19.0-linux-x64.tar.gz",
+		"node/staging/linux-x64/node-v20.1.0-linux-x64.tar.gz",
+	}
+	releases := []release{}
+	for _, key := range keys {
+		rel, err := parseObject(key)
+		if !assert.Nil(t, err, key) {
+			return
+		}
+		releases = append(releases, rel)
+	}
+	sources := []source{staticSource{releases: releases}}
+
+	cases := []struct {
+		requirement string
+		version     string
+		channel     string
+	}{
+		// the newest match is only in staging
+		{"18.x", "18.19.0", "staging"},
+		// the newest match is in both, so the release is used
+		{"20.x", "20.1.0", "release"},
+		{"18.17.x", "18.17.0", "release"},
+	}
+	for _, c := range cases {
+		assert.Nil(t, resolveWithSources(sources, "node", c.requirement, options{outputFile: path, json: true, anyChannel: true}))
+		contents, _ := ioutil.ReadFile(path)
+		var entry listEntry
+		if assert.Nil(t, json.Unmarshal(contents, &entry), c.requirement) {
+			assert.Equal(t, entry.Version, c.version, c.requirement)
+			assert.Equal(t, entry.Channel, c.channel, c.requirement)
+		}
+	}
+
+	result, err := resolveFromSources(sources, "node", "18.x")
+	if assert.Nil(t, err) {
+		assert.Equal(t, describeRelease(result.release), "18.19.0, stage staging, platform linux-x64")
+	}
+
+	// without --any-channel the channel is left out
+	assert.Nil(t, resolveWithSources(sources, "node", "20.x", options{outputFile: path, json: true}))
+	contents, _ := ioutil.ReadFile(path)
+	assert.NotContains(t, string(contents), "channel")
+
+	err = resolveWithSources(sources, "yarn", "1.x", options{outputFile: path, anyChannel: true})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "--any-channel is only supported for node, not yarn")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelemetryDisabled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	os.Unsetenv("NODE_RESOLVE_TELEMETRY_URL")
+	reportUsage("node", genReleasesFromArray([]string{"18.17.0"})[0])
+	waitForTelemetry()
+	assert.Equal(t, requests, 0)
+}
+
+func TestTelemetryReportsOnlyMajorAndPlatform(t *testing.T) {
+	bodies := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	os.Setenv("NODE_RESOLVE_TELEMETRY_URL", server.URL)
+	defer os.Unsetenv("NODE_RESOLVE_TELEMETRY_URL")
+
+	sources := []source{staticSource{releases: parseObjects(genNodeS3ObjectList([]string{"18.17.0"}, []string{}, "linux-x64"))}}
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, resolveWithSources(sources, "node", "18.x", options{outputFile: filepath.Join(dir, "out")}))
+	waitForTelemetry()
+	select {
+	case body := <-bodies:
+		assert.Equal(t, body, map[string]interface{}{"binary": "node", "major": float64(18), "platform": "linux-x64"})
+	default:
+		t.Error("No usage was reported")
+	}
+}
+
+func TestTelemetryUnreachable(t *testing.T) {
+	defer func(original time.Duration) { telemetryTimeout = original }(telemetryTimeout)
+	telemetryTimeout = 100 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	sources := []source{staticSource{releases: parseObjects(genNodeS3ObjectList([]string{"18.17.0"}, []string{}, "linux-x64"))}}
+	defer os.Unsetenv("NODE_RESOLVE_TELEMETRY_URL")
+
+	// nothing is listening
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	os.Setenv("NODE_RESOLVE_TELEMETRY_URL", closed.URL)
+	assert.Nil(t, resolveWithSources(sources, "node", "18.x", options{outputFile: filepath.Join(dir, "out")}))
+	waitForTelemetry()
+
+	// the endpoint never responds
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+	os.Setenv("NODE_RESOLVE_TELEMETRY_URL", hanging.URL)
+
+	start := time.Now()
+	assert.Nil(t, resolveWithSources(sources, "node", "18.x", options{outputFile: filepath.Join(dir, "out")}))
+	waitForTelemetry()
+	assert.True(t, time.Since(start) < time.Second, time.Since(start).String())
+
+	contents, _ := ioutil.ReadFile(filepath.Join(dir, "out"))
+	assert.Equal(t, string(contents), "18.17.0 https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/node-v18.17.0-linux-x64.tar.gz\n")
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The layouts of the keys in heroku-nodebin, which are used unless a mirror
+// with a different layout overrides them. A field that appears twice, like
+// the platform of node, has to be the same both times
+var defaultKeyTemplates = map[string]string{
+	"node": "node/{stage}/{platform}/node-v{version}-{platform}{qualifier}.tar.gz",
+	"yarn": "yarn/{stage}/yarn-v{version}.tar.gz",
+	"pnpm": "pnpm/{stage}/pnpm-v{version}.tar.gz",
+}
+
+// The binaries keys are parsed as, in the order their templates are tried
+var templateBinaries = []string{"node", "yarn", "pnpm"}
+
+// The parsed key template of each binary
+var keyTemplates = mustParseDefaultKeyTemplates()
+
+// Builds the download URL of a release when set, see parseURLTemplate.
+// Otherwise the URL is the key in heroku-nodebin, see objectURL
+var urlTemplate string
+
+// One part of a template, either literal text or a {field}
+type templatePart struct {
+	literal string
+	field   string
+}
+
+type keyTemplate struct {
+	binary string
+	source string
+	parts  []templatePart
+}
+
+var keyTemplateFields = map[string]bool{"stage": true, "platform": true, "version": true, "qualifier": true}
+
+// Reads the key and URL templates of a mirror with a different layout than
+// heroku-nodebin from the environment, or from the config file:
+//
+//	NODE_RESOLVE_NODE_KEY_TEMPLATE  the layout of node keys, defaults to
+//	                                node/{stage}/{platform}/node-v{version}-{platform}{qualifier}.tar.gz
+//	NODE_RESOLVE_YARN_KEY_TEMPLATE  the layout of yarn keys, defaults to
+//	                                yarn/{stage}/yarn-v{version}.tar.gz
+//	NODE_RESOLVE_PNPM_KEY_TEMPLATE  the layout of pnpm keys, defaults to
+//	                                pnpm/{stage}/pnpm-v{version}.tar.gz
+//	NODE_RESOLVE_URL_TEMPLATE       the download URL of a release, like
+//	                                https://mirror.example.com/{key}
+//
+// Every key of a layout without a {stage} is taken to be a release
+func keyTemplatesFromEnv() (map[string]keyTemplate, string, error) {
+	templates := mustParseDefaultKeyTemplates()
+	for _, binary := range templateBinaries {
+		name := fmt.Sprintf("NODE_RESOLVE_%s_KEY_TEMPLATE", strings.ToUpper(binary))
+		source := os.Getenv(name)
+		if source == "" {
+			continue
+		}
+		t, err := parseKeyTemplate(binary, source)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid %s: %s", name, err)
+		}
+		templates[binary] = t
+	}
+
+	urlSource := os.Getenv("NODE_RESOLVE_URL_TEMPLATE")
+	if urlSource != "" {
+		if err := parseURLTemplate(urlSource); err != nil {
+			return nil, "", fmt.Errorf("Invalid NODE_RESOLVE_URL_TEMPLATE: %s", err)
+		}
+	}
+	return templates, urlSource, nil
+}
+
+func mustParseDefaultKeyTemplates() map[string]keyTemplate {
+	templates := map[string]keyTemplate{}
+	for binary, source := range defaultKeyTemplates {
+		t, err := parseKeyTemplate(binary, source)
+		if err != nil {
+			panic(err)
+		}
+		templates[binary] = t
+	}
+	return templates
+}
+
+// Splits a template into its literal text and {fields}
+func splitTemplate(template string, fields map[string]bool) ([]templatePart, error) {
+	parts := []templatePart{}
+	source := template
+	for source != "" {
+		start := strings.Index(source, "{")
+		if start < 0 {
+			parts = append(parts, templatePart{literal: source})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, templatePart{literal: source[:start]})
+		}
+		end := strings.Index(source[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %s", template)
+		}
+		field := source[start+1 : start+end]
+		if !fields[field] {
+			return nil, fmt.Errorf("unknown field {%s}", field)
+		}
+		parts = append(parts, templatePart{field: field})
+		source = source[start+end+1:]
+	}
+	return parts, nil
+}
+
+func parseKeyTemplate(binary string, source string) (keyTemplate, error) {
+	parts, err := splitTemplate(source, keyTemplateFields)
+	if err != nil {
+		return keyTemplate{}, err
+	}
+	hasVersion := false
+	for _, part := range parts {
+		hasVersion = hasVersion || part.field == "version"
+	}
+	if !hasVersion {
+		return keyTemplate{}, fmt.Errorf("%s has no {version}", source)
+	}
+	return keyTemplate{binary: binary, source: source, parts: parts}, nil
+}
+
+// The fields of a URL template, which are those of the key templates along
+// with the bucket, the binary, and the key itself
+var urlTemplateFields = map[string]bool{"bucket": true, "binary": true, "key": true, "stage": true, "platform": true, "version": true, "qualifier": true}
+
+// Checks that a URL template only uses known fields and builds an http or
+// https URL
+func parseURLTemplate(source string) error {
+	if _, err := splitTemplate(source, urlTemplateFields); err != nil {
+		return err
+	}
+	u, err := url.Parse(strings.NewReplacer("{", "", "}", "").Replace(source))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s is not an http or https URL", source)
+	}
+	return nil
+}
+
+// Whether a binary's keys are laid out as they are in heroku-nodebin, which is
+// what narrowing a listing to a single major relies on
+func hasDefaultLayout(binary string) bool {
+	return keyTemplates[binary].source == defaultKeyTemplates[binary]
+}
+
+// Returns the prefix to list for a binary. heroku-nodebin is listed by the
+// name of the binary, and other layouts by everything in their template
+// before the first field
+func listingPrefix(prefix string) string {
+	t, ok := keyTemplates[prefix]
+	if !ok || hasDefaultLayout(prefix) {
+		return prefix
+	}
+	if len(t.parts) > 0 && t.parts[0].literal != "" {
+		return t.parts[0].literal
+	}
+	return ""
+}
+
+// Matches a key against the template, returning the value of each field.
+// Stages and platforms can't contain a slash, versions are three numbers, and
+// a qualifier is anything that is either empty or starts with a - or . that
+// isn't part of its value
+func (t keyTemplate) match(key string) (map[string]string, bool) {
+	values := map[string]string{}
+	if !matchParts(t.parts, key, values) {
+		return nil, false
+	}
+	if values["qualifier"] != "" {
+		values["qualifier"] = values["qualifier"][1:]
+	}
+	return values, true
+}
+
+func matchParts(parts []templatePart, key string, values map[string]string) bool {
+	if len(parts) == 0 {
+		return key == ""
+	}
+	part := parts[0]
+	if part.literal != "" {
+		return strings.HasPrefix(key, part.literal) && matchParts(parts[1:], key[len(part.literal):], values)
+	}
+	if value, ok := values[part.field]; ok {
+		return strings.HasPrefix(key, value) && matchParts(parts[1:], key[len(value):], values)
+	}
+
+	// like a regexp, the longest value that lets the rest match is used
+	for end := fieldLimit(part.field, key); end >= 0; end-- {
+		if !validFieldValue(part.field, key[:end]) {
+			continue
+		}
+		values[part.field] = key[:end]
+		if matchParts(parts[1:], key[end:], values) {
+			return true
+		}
+		delete(values, part.field)
+	}
+	return false
+}
+
+// Returns how much of the start of key a field could cover. Only a qualifier
+// can contain a slash, and a version can only contain numbers and dots
+func fieldLimit(field string, key string) int {
+	for i, c := range key {
+		if c == '/' && field != "qualifier" || field == "version" && c != '.' && (c < '0' || c > '9') {
+			return i
+		}
+	}
+	return len(key)
+}
+
+func validFieldValue(field string, value string) bool {
+	switch field {
+	case "version":
+		parts := strings.Split(value, ".")
+		if len(parts) != 3 {
+			return false
+		}
+		for _, part := range parts {
+			if part == "" {
+				return false
+			}
+		}
+		return true
+	case "qualifier":
+		return value == "" || len(value) > 1 && (value[0] == '-' || value[0] == '.')
+	}
+	return value != ""
+}
+
+// Builds the download URL of a release from urlTemplate
+func templateURL(bucketName string, key string, rel release) string {
+	escapedKey := (&url.URL{Path: key}).EscapedPath()
+	return strings.NewReplacer(
+		"{bucket}", bucketName,
+		"{binary}", rel.binary,
+		"{key}", escapedKey,
+		"{stage}", rel.stage,
+		"{platform}", rel.platform,
+		"{version}", rel.version.String(),
+		"{qualifier}", rel.qualifier,
+	).Replace(urlTemplate)
+}
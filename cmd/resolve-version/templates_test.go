@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeyTemplate(t *testing.T) {
+	tmpl, err := parseKeyTemplate("node", "mirror/{stage}/v{version}/node-v{version}-{platform}{qualifier}.tgz")
+	if !assert.Nil(t, err) {
+		return
+	}
+	values, ok := tmpl.match("mirror/release/v18.1.0/node-v18.1.0-linux-x64-glibc-217.tgz")
+	if assert.True(t, ok) {
+		assert.Equal(t, values, map[string]string{"stage": "release", "version": "18.1.0", "platform": "linux-x64-glibc-217", "qualifier": ""})
+	}
+	// a field that appears twice has to be the same both times
+	_, ok = tmpl.match("mirror/release/v18.1.0/node-v18.2.0-linux-x64.tgz")
+	assert.False(t, ok)
+	_, ok = tmpl.match("mirror/release/v18.1/node-v18.1-linux-x64.tgz")
+	assert.False(t, ok)
+
+	_, err = parseKeyTemplate("node", "node/{arch}/node-v{version}.tar.gz")
+	assert.EqualError(t, err, "unknown field {arch}")
+	_, err = parseKeyTemplate("node", "node/{stage/node-v{version}.tar.gz")
+	assert.EqualError(t, err, "unknown field {stage/node-v{version}")
+	_, err = parseKeyTemplate("node", "node/{stage")
+	assert.EqualError(t, err, "unclosed { in node/{stage")
+	_, err = parseKeyTemplate("yarn", "yarn/{stage}/yarn.tar.gz")
+	assert.EqualError(t, err, "yarn/{stage}/yarn.tar.gz has no {version}")
+}
+
+func TestKeyTemplatesFromEnv(t *testing.T) {
+	defer os.Unsetenv("NODE_RESOLVE_YARN_KEY_TEMPLATE")
+	defer os.Unsetenv("NODE_RESOLVE_URL_TEMPLATE")
+
+	templates, urlSource, err := keyTemplatesFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, urlSource, "")
+	for binary, source := range defaultKeyTemplates {
+		assert.Equal(t, templates[binary].source, source)
+	}
+
+	os.Setenv("NODE_RESOLVE_YARN_KEY_TEMPLATE", "dist/yarn/{version}/yarn.tar.gz")
+	os.Setenv("NODE_RESOLVE_URL_TEMPLATE", "https://cdn.example.com/{key}")
+	templates, urlSource, err = keyTemplatesFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, templates["yarn"].source, "dist/yarn/{version}/yarn.tar.gz")
+	assert.Equal(t, templates["node"].source, defaultKeyTemplates["node"])
+	assert.Equal(t, urlSource, "https://cdn.example.com/{key}")
+
+	os.Setenv("NODE_RESOLVE_URL_TEMPLATE", "cdn.example.com/{key}")
+	_, _, err = keyTemplatesFromEnv()
+	assert.EqualError(t, err, "Invalid NODE_RESOLVE_URL_TEMPLATE: cdn.example.com/{key} is not an http or https URL")
+	os.Setenv("NODE_RESOLVE_URL_TEMPLATE", "https://cdn.example.com/{path}")
+	_, _, err = keyTemplatesFromEnv()
+	assert.EqualError(t, err, "Invalid NODE_RESOLVE_URL_TEMPLATE: unknown field {path}")
+
+	os.Setenv("NODE_RESOLVE_YARN_KEY_TEMPLATE", "dist/yarn/yarn.tar.gz")
+	_, _, err = keyTemplatesFromEnv()
+	assert.EqualError(t, err, "Invalid NODE_RESOLVE_YARN_KEY_TEMPLATE: dist/yarn/yarn.tar.gz has no {version}")
+}
+
+func TestResolveCustomLayout(t *testing.T) {
+	defer func(original map[string]keyTemplate) { keyTemplates = original }(keyTemplates)
+	defer func(original string) { urlTemplate = original }(urlTemplate)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	os.Setenv("NODE_RESOLVE_NODE_KEY_TEMPLATE", "mirror/node/{version}/node-v{version}-{platform}{qualifier}.tar.xz")
+	os.Setenv("NODE_RESOLVE_URL_TEMPLATE", "https://cdn.example.com/{binary}/{version}/{key}")
+	defer os.Unsetenv("NODE_RESOLVE_NODE_KEY_TEMPLATE")
+	defer os.Unsetenv("NODE_RESOLVE_URL_TEMPLATE")
+	var err error
+	keyTemplates, urlTemplate, err = keyTemplatesFromEnv()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	keys := []string{
+		"mirror/node/18.19.1/node-v18.19.1-linux-x64.tar.xz",
+		"mirror/node/18.20.4/node-v18.20.4-darwin-x64.tar.xz",
+		"mirror/node/18.20.4/node-v18.20.4-linux-x64.tar.xz",
+		"mirror/node/20.11.0/node-v20.11.0-linux-x64.tar.xz",
+		"mirror/node/SHASUMS256.txt",
+	}
+	var prefixes []string
+	handler := s3ListingHandler(keys, 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefixes = append(prefixes, r.URL.Query().Get("prefix"))
+		handler(w, r)
+	}))
+	defer server.Close()
+	src := s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}
+
+	// a layout other than heroku-nodebin's can't be narrowed to a major, so
+	// everything before its first field is listed
+	result, err := resolveFromSources([]source{src}, "node", "18.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "18.20.4")
+		assert.Equal(t, result.release.stage, "release")
+		assert.Equal(t, result.release.url, "https://cdn.example.com/node/18.20.4/mirror/node/18.20.4/node-v18.20.4-linux-x64.tar.xz")
+	}
+	assert.Equal(t, prefixes, []string{"mirror/node/"})
+
+	// and the default layouts are still used for binaries that weren't given
+	// one
+	rel, err := parseObject("yarn/release/yarn-v1.22.19.tar.gz")
+	if assert.Nil(t, err) {
+		assert.Equal(t, rel.url, "https://cdn.example.com/yarn/1.22.19/yarn/release/yarn-v1.22.19.tar.gz")
+	}
+	_, err = parseObject("node/release/linux-x64/node-v18.20.4-linux-x64.tar.gz")
+	assert.NotNil(t, err)
+}
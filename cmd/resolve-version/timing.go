@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Where --timings output is written, or nil when it is disabled
+var timingsOut io.Writer
+
+// Reports how long a step took when --timings is enabled. Use it with defer:
+//
+//	defer recordTiming("list", time.Now())
+func recordTiming(step string, start time.Time) {
+	if timingsOut != nil {
+		fmt.Fprintf(timingsOut, "timing: %s took %s\n", step, time.Since(start))
+	}
+}
+
+// Where --verbose output is written, or nil when it is disabled
+var verboseOut io.Writer
+
+func logVerbose(format string, args ...interface{}) {
+	if verboseOut != nil {
+		fmt.Fprintf(verboseOut, format, args...)
+	}
+}
+
+// Reports a count, like the number of pages a listing took, in both the
+// --timings and --verbose output
+func recordCount(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if timingsOut != nil {
+		fmt.Fprintf(timingsOut, "timing: %s\n", message)
+	}
+	logVerbose("%s\n", message)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimings(t *testing.T) {
+	server := httptest.NewServer(s3ListingHandler(genNodeKeys(25), 10))
+	defer server.Close()
+
+	var out bytes.Buffer
+	timingsOut = &out
+	defer func() { timingsOut = nil }()
+
+	objects, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+	_, err = resolveNode(parseObjects(objects), "linux-x64", "0.x")
+	assert.Nil(t, err)
+
+	lines := regexp.MustCompile("timing: (.*) took [0-9.]+[µnm]?s\n").FindAllStringSubmatch(out.String(), -1)
+	steps := []string{}
+	for _, line := range lines {
+		steps = append(steps, line[1])
+	}
+	assert.Equal(t, steps, []string{
+		"listing node page 1",
+		"listing node page 2",
+		"listing node page 3",
+		"listing node",
+		"parsing",
+		"matching",
+	})
+}
+
+func TestListingPageCounts(t *testing.T) {
+	server := httptest.NewServer(s3ListingHandler(genNodeKeys(25), 10))
+	defer server.Close()
+
+	var timings, verbose bytes.Buffer
+	timingsOut = &timings
+	defer func() { timingsOut = nil }()
+	defer func(original io.Writer) { verboseOut = original }(verboseOut)
+	verboseOut = &verbose
+
+	_, err := listS3ObjectsFromEndpoints([]string{server.URL}, "heroku-nodebin", "node")
+	assert.Nil(t, err)
+
+	counts := []string{
+		"listing node page 1 had KeyCount 10, MaxKeys 10",
+		"listing node page 2 had KeyCount 10, MaxKeys 10",
+		"listing node page 3 had KeyCount 5, MaxKeys 10",
+		"listing node fetched 25 objects in 3 pages",
+	}
+	assert.Equal(t, verbose.String(), strings.Join(counts, "\n")+"\n")
+	for _, count := range counts {
+		assert.Contains(t, timings.String(), "timing: "+count+"\n")
+	}
+}
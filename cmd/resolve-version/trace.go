@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// How much of each response body --trace-http shows
+const traceBodyBytes = 512
+
+// Query parameters that sign or authorize a request, which --trace-http
+// doesn't print
+var redactedParams = []string{
+	"AWSAccessKeyId",
+	"Signature",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+	"X-Amz-Signature",
+}
+
+// Wraps a transport to log each request, its response status, and the start
+// of the response body, for --trace-http. The body is still read in full by
+// whoever made the request
+type traceTransport struct {
+	transport http.RoundTripper
+	out       io.Writer
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fmt.Fprintf(t.out, "> %s %s\n", req.Method, redactURL(req.URL))
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(t.out, "< %s %s failed: %s\n", req.Method, redactURL(req.URL), err)
+		return resp, err
+	}
+
+	fmt.Fprintf(t.out, "< %s %s %s\n", resp.Status, req.Method, redactURL(req.URL))
+	if resp.Body == nil || req.Method == "HEAD" {
+		return resp, nil
+	}
+
+	start, err := ioutil.ReadAll(io.LimitReader(resp.Body, traceBodyBytes))
+	if len(start) > 0 {
+		fmt.Fprintf(t.out, "%s\n", strings.TrimRight(string(start), "\n"))
+		if len(start) == traceBodyBytes {
+			fmt.Fprintf(t.out, "< (body truncated to %d bytes)\n", traceBodyBytes)
+		}
+	}
+	resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(start), errReader{err}, resp.Body), Closer: resp.Body}
+	return resp, nil
+}
+
+// Returns the URL with any signature in its query replaced with REDACTED
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, param := range redactedParams {
+		if query.Get(param) != "" {
+			query.Set(param, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	copied := *u
+	copied.RawQuery = query.Encode()
+	return copied.String()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Returns err from the first read, or nothing when err is nil, so that an
+// error reading the start of a traced body is still seen by the caller
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
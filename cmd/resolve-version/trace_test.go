@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceTransport(t *testing.T) {
+	body := "<ListBucketResult>" + strings.Repeat("x", 1000) + "</ListBucketResult>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(404)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>\n")
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	client, err := newHTTPClient(clientConfig{traceOut: &out})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	resp, err := client.Get(server.URL + "/missing")
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+	}
+	resp, err = client.Get(server.URL + "/?list-type=2&X-Amz-Signature=abc123&X-Amz-Credential=AKIA")
+	if assert.Nil(t, err) {
+		// the caller still gets the whole body
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, string(data), body)
+	}
+
+	assert.Equal(t, out.String(), strings.Join([]string{
+		"> GET " + server.URL + "/missing",
+		"< 404 Not Found GET " + server.URL + "/missing",
+		"<Error><Code>NoSuchKey</Code></Error>",
+		"> GET " + server.URL + "/?X-Amz-Credential=REDACTED&X-Amz-Signature=REDACTED&list-type=2",
+		"< 200 OK GET " + server.URL + "/?X-Amz-Credential=REDACTED&X-Amz-Signature=REDACTED&list-type=2",
+		body[:traceBodyBytes],
+		"< (body truncated to 512 bytes)",
+	}, "\n")+"\n")
+	assert.NotContains(t, out.String(), "abc123")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmorrell/semver"
+)
+
+// The version resolve-version was built as, set by the makefile with
+// -ldflags "-X main.buildVersion=..."
+var buildVersion = "dev"
+
+// The longest check-update waits for the latest version
+var updateCheckTimeout = 2 * time.Second
+
+// Compares buildVersion with the latest version published at
+// NODE_RESOLVE_UPDATE_URL, which serves just the version as plain text, like
+// "1.4.0". Nothing is downloaded, and this only ever runs when check-update
+// asks for it, so resolving a version is never held up by it
+func checkUpdate() (string, error) {
+	endpoint := os.Getenv("NODE_RESOLVE_UPDATE_URL")
+	if endpoint == "" {
+		return "", errors.New("check-update needs NODE_RESOLVE_UPDATE_URL to be set to where the latest version is published")
+	}
+
+	current, err := semver.ParseTolerant(buildVersion)
+	if err != nil {
+		return "", fmt.Errorf("Can't check for updates to a %s build of resolve-version", buildVersion)
+	}
+
+	latest, err := fetchLatestVersion(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if latest.GT(current) {
+		return fmt.Sprintf("resolve-version %s is available, this is %s", latest.String(), current.String()), nil
+	}
+	return fmt.Sprintf("resolve-version %s is up to date", current.String()), nil
+}
+
+func fetchLatestVersion(endpoint string) (semver.Version, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return semver.Version{}, err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("Could not check for updates: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return semver.Version{}, fmt.Errorf("Unexpected status code: %d for checking for updates at %s", resp.StatusCode, endpoint)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("Could not check for updates: %s", err)
+	}
+	latest, err := semver.ParseTolerant(strings.TrimSpace(string(body)))
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("Could not parse the latest version published at %s: %s", endpoint, strings.TrimSpace(string(body)))
+	}
+	return latest, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckUpdate(t *testing.T) {
+	latest := "1.4.0\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		fmt.Fprint(w, latest)
+	}))
+	defer server.Close()
+
+	defer func(original string) { buildVersion = original }(buildVersion)
+	defer os.Unsetenv("NODE_RESOLVE_UPDATE_URL")
+
+	// checking is opt-in
+	buildVersion = "1.3.2"
+	_, err := checkUpdate()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "check-update needs NODE_RESOLVE_UPDATE_URL to be set to where the latest version is published")
+	}
+
+	os.Setenv("NODE_RESOLVE_UPDATE_URL", server.URL+"/latest")
+	message, err := checkUpdate()
+	assert.Nil(t, err)
+	assert.Equal(t, message, "resolve-version 1.4.0 is available, this is 1.3.2")
+
+	buildVersion = "v1.4.0"
+	message, err = checkUpdate()
+	assert.Nil(t, err)
+	assert.Equal(t, message, "resolve-version 1.4.0 is up to date")
+
+	buildVersion = "dev"
+	_, err = checkUpdate()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Can't check for updates to a dev build of resolve-version")
+	}
+
+	buildVersion = "1.3.2"
+	latest = "<html>not found</html>"
+	_, err = checkUpdate()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Could not parse the latest version published at "+server.URL+"/latest: <html>not found</html>")
+	}
+
+	// a slow endpoint gives up rather than holding up the build
+	defer func(original time.Duration) { updateCheckTimeout = original }(updateCheckTimeout)
+	updateCheckTimeout = 50 * time.Millisecond
+	os.Setenv("NODE_RESOLVE_UPDATE_URL", server.URL+"/slow")
+	start := time.Now()
+	_, err = checkUpdate()
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+}
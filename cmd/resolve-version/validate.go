@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmorrell/semver"
+)
+
+// Limits on the size of requirements, which come from package.json files and
+// HTTP requests that may not be trustworthy. The range parsers and matching
+// both take time in proportion to the size of a requirement, and real ones
+// are nowhere near these
+const (
+	maxRequirementLength       = 1024
+	maxRequirementAlternatives = 32
+	maxBatchRequirements       = 64
+)
+
+// Rejects a requirement that is too large to be a real one, before any time
+// is spent parsing it. The requirement isn't repeated in the error, since it
+// could be enormous
+func checkRequirementSize(versionRequirement string) error {
+	if len(versionRequirement) > maxRequirementLength {
+		return fmt.Errorf("Version requirement is too long: %d characters, at most %d are allowed", len(versionRequirement), maxRequirementLength)
+	}
+	if n := strings.Count(versionRequirement, "||") + 1; n > maxRequirementAlternatives {
+		return fmt.Errorf("Version requirement has too many alternatives: %d ranges joined by ||, at most %d are allowed", n, maxRequirementAlternatives)
+	}
+	return nil
+}
+
+// Parses a requirement with the --semver-mode in use, once it's known to be
+// small enough to be a real one
+func parseRequirement(versionRequirement string) (semver.Range, error) {
+	if err := checkRequirementSize(versionRequirement); err != nil {
+		return nil, err
+	}
+	return parseRange(versionRequirement)
+}
+
+// Checks that a requirement parses with the --semver-mode in use, without
+// listing any releases, so that typos in engines can be caught before deploy
+func validateRequirement(binary string, versionRequirement string) error {
+	if len(sourcesFor(binary, "s3")) == 0 {
+		return fmt.Errorf("Unknown binary: %s", binary)
+	}
+	if err := checkRequirementSize(versionRequirement); err != nil {
+		return err
+	}
+	if _, err := parseRange(normalizeRequirement(versionRequirement)); err != nil {
+		return fmt.Errorf("Invalid version requirement for %s: %s (%s)", binary, versionRequirement, err)
+	}
+	return nil
+}
+
+// Returns the requirement that releases would be matched against, going
+// through the same normalization as resolving does, so that what a
+// requirement turns into can be checked without listing any releases
+func normalizeOnly(versionRequirement string) (string, error) {
+	if err := checkRequirementSize(versionRequirement); err != nil {
+		return "", err
+	}
+	normalized := normalizeRequirement(versionRequirement)
+	if _, err := parseRange(normalized); err != nil {
+		return "", fmt.Errorf("Invalid version requirement: %q normalizes to %s (%s)", versionRequirement, normalized, err)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmorrell/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRequirement(t *testing.T) {
+	defer func(original func(string) (semver.Range, error)) { parseRange = original }(parseRange)
+
+	for _, requirement := range []string{"18.x", ">= 18", "^18 || ^20", "latest", "*"} {
+		assert.Nil(t, validateRequirement("node", requirement), requirement)
+	}
+
+	err := validateRequirement("node", "^18 || bogus")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), `Invalid version requirement for node: ^18 || bogus (Could not get version from string: "bogus")`)
+	}
+	assert.NotNil(t, validateRequirement("yarn", "1.x ||"))
+
+	// npm allows an empty side of ||, the semver library doesn't
+	parseRange = parseNpmRange
+	assert.Nil(t, validateRequirement("yarn", "1.x ||"))
+	err = validateRequirement("node", "^18 || bogus")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Invalid version requirement for node: ^18 || bogus (Could not parse version requirement: bogus)")
+	}
+
+	err = validateRequirement("bun", "1.x")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Unknown binary: bun")
+	}
+}
+
+func TestNormalizeOnly(t *testing.T) {
+	server := newLTSIndexServer()
+	defer server.Close()
+	defer func(original string) { nodeDistURL = original }(nodeDistURL)
+	nodeDistURL = server.URL
+
+	dir, err := ioutil.TempDir("", "resolve-version")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".nvmrc")
+
+	cases := []struct {
+		requirement string
+		nvmrc       bool
+		normalized  string
+	}{
+		{requirement: "18.x", normalized: "18.x"},
+		{requirement: "  >=18 <20 ", normalized: ">=18 <20"},
+		{requirement: "latest", normalized: "*"},
+		{requirement: " latest\n", normalized: "*"},
+		// .nvmrc aliases and quirks only apply to --from-nvmrc
+		{requirement: "v18.17.1\n", nvmrc: true, normalized: "18.17.1"},
+		{requirement: "# pinned\n\n  lts/Hydrogen  \n", nvmrc: true, normalized: "18.x"},
+		{requirement: "lts/*", nvmrc: true, normalized: "26.x"},
+		{requirement: "node", nvmrc: true, normalized: "*"},
+		{requirement: "stable\r\n", nvmrc: true, normalized: "*"},
+	}
+
+	for _, c := range cases {
+		requirement := c.requirement
+		if c.nvmrc {
+			ioutil.WriteFile(path, []byte(c.requirement), 0644)
+			requirement, err = readNvmrc(path)
+			if !assert.Nil(t, err, c.requirement) {
+				continue
+			}
+		}
+		normalized, err := normalizeOnly(requirement)
+		if assert.Nil(t, err, c.requirement) {
+			assert.Equal(t, normalized, c.normalized, c.requirement)
+		}
+	}
+
+	_, err = normalizeOnly(" bogus ")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), `Invalid version requirement: " bogus " normalizes to bogus (Could not get version from string: "bogus")`)
+	}
+}
+
+func TestRequirementSizeLimits(t *testing.T) {
+	defer func(original func(string) (semver.Range, error)) { parseRange = original }(parseRange)
+	// oversized requirements are rejected without being parsed at all
+	parsed := 0
+	parseRange = func(s string) (semver.Range, error) {
+		parsed++
+		return semver.ParseRange(s)
+	}
+
+	long := strings.Repeat(">=1.0.0 ", 200)
+	alternatives := strings.Repeat("1.x || ", 40) + "2.x"
+	releases := genReleasesFromArray([]string{"1.0.0", "2.0.0"})
+
+	_, err := matchReleaseSemver(releases, long)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Version requirement is too long: 1600 characters, at most 1024 are allowed")
+	}
+	_, err = matchReleaseSemver(releases, alternatives)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Version requirement has too many alternatives: 41 ranges joined by ||, at most 32 are allowed")
+	}
+	err = validateRequirement("node", long)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "Version requirement is too long: 1600 characters, at most 1024 are allowed")
+	}
+	_, err = normalizeOnly(alternatives)
+	assert.NotNil(t, err)
+	_, err = resolveNightly([]release{}, "linux-x64", long)
+	assert.NotNil(t, err)
+	assert.Equal(t, parsed, 0)
+
+	// requirements right at the limits still parse
+	result, err := matchReleaseSemver(releases, strings.Repeat("1.x || ", 31)+"2.x")
+	if assert.Nil(t, err) && assert.True(t, result.matched) {
+		assert.Equal(t, result.release.version.String(), "2.0.0")
+	}
+	assert.Equal(t, parsed, 1)
+
+	reqs := requirementList{}
+	for i := 0; i < maxBatchRequirements; i++ {
+		assert.Nil(t, reqs.Set("node=18.x"))
+	}
+	err = reqs.Set("node=18.x")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, err.Error(), "at most 64 requirements can be resolved at once")
+	}
+}
+
+// Matches a requirement with as many alternatives as are allowed against 5000
+// releases, which bounds how long any one resolution spends matching. This
+// came out around 85ms, nearly all of it spent by the semver library
+// compiling a regexp for each range it parses, rather than in matching
+func BenchmarkMatchLargestRequirement(b *testing.B) {
+	releases := genReleasesFromArray(genVersions(5000))
+	alternatives := []string{}
+	for i := 0; i < maxRequirementAlternatives; i++ {
+		alternatives = append(alternatives, fmt.Sprintf(">=%d.0.0 <%d.5.0", i, i))
+	}
+	requirement := strings.Join(alternatives, " || ")
+	if len(requirement) > maxRequirementLength {
+		b.Fatalf("requirement is %d characters", len(requirement))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := matchReleaseSemver(releases, requirement); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func genVersions(count int) []string {
+	versions := make([]string, count)
+	for i := range versions {
+		versions[i] = fmt.Sprintf("%d.%d.%d", i/100, (i/10)%10, i%10)
+	}
+	return versions
+}
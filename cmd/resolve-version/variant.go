@@ -0,0 +1,22 @@
+package main
+
+// The build of node given to --variant, which is whatever follows the
+// platform in the file name, like the "pointer-compression" in
+// node-v22.0.0-linux-x64-pointer-compression.tar.gz. When it's "" the
+// standard build of a version is preferred, but a variant is still used if
+// it's the only build there is
+var nodeVariant string
+
+// The --variant that only matches builds without a suffix
+const standardVariant = "standard"
+
+// Whether a release is a build of the variant given to --variant
+func matchesVariant(rel release) bool {
+	switch nodeVariant {
+	case "":
+		return true
+	case standardVariant:
+		return rel.qualifier == ""
+	}
+	return rel.qualifier == nodeVariant
+}
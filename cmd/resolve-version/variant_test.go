@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveNodeVariant(t *testing.T) {
+	defer func(original string) { nodeVariant = original }(nodeVariant)
+	defer func(original string) { platformOverride = original }(platformOverride)
+	platformOverride = "linux-x64"
+
+	fixture, err := ioutil.ReadFile("testdata/node-variants.xml")
+	if !assert.Nil(t, err) {
+		return
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fixture)
+	}))
+	defer server.Close()
+	src := s3Source{bucketName: "heroku-nodebin", endpoints: []string{server.URL}}
+
+	cases := []struct {
+		variant     string
+		requirement string
+		url         string
+	}{
+		// the standard build is preferred, but 22.2.0 only has a variant
+		{"", "22.x", "node-v22.2.0-linux-x64-pointer-compression.tar.gz"},
+		{"", "22.1.x", "node-v22.1.0-linux-x64.tar.gz"},
+		{"standard", "22.x", "node-v22.1.0-linux-x64.tar.gz"},
+		{"pointer-compression", "22.x", "node-v22.2.0-linux-x64-pointer-compression.tar.gz"},
+		{"pointer-compression", "22.0.0", "node-v22.0.0-linux-x64-pointer-compression.tar.gz"},
+		{"debug", "22.x", "node-v22.0.0-linux-x64-debug.tar.gz"},
+	}
+	for _, c := range cases {
+		nodeVariant = c.variant
+		result, err := resolveFromSources([]source{src}, "node", c.requirement)
+		if assert.Nil(t, err) && assert.True(t, result.matched, c.variant+" "+c.requirement) {
+			assert.Equal(t, result.release.url, "https://s3.amazonaws.com/heroku-nodebin/node/release/linux-x64/"+c.url)
+		}
+	}
+
+	nodeVariant = "debug"
+	result, err := resolveFromSources([]source{src}, "node", "22.1.x")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+
+	nodeVariant = "asan"
+	result, err = resolveFromSources([]source{src}, "node", "22.x")
+	assert.Nil(t, err)
+	assert.False(t, result.matched)
+}
@@ -1,8 +1,9 @@
 module github.com/heroku/heroku-buildpack-nodejs
 
-go 1.12
+go 1.13
 
 require (
 	github.com/jmorrell/semver v0.0.0-20190521202929-0d1a4bb09cfa
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 )